@@ -1,16 +1,30 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"go-chat-api/internal/audit"
 	"go-chat-api/internal/auth"
+	"go-chat-api/internal/blob"
 	"go-chat-api/internal/config"
 	"go-chat-api/internal/handlers"
+	"go-chat-api/internal/mailer"
 	"go-chat-api/internal/middleware"
+	"go-chat-api/internal/moderation"
+	"go-chat-api/internal/oidc"
+	"go-chat-api/internal/ratelimit"
+	"go-chat-api/internal/reaper"
 	"go-chat-api/internal/routes"
+	"go-chat-api/internal/scheduler"
 	"go-chat-api/internal/services"
 	"go-chat-api/internal/storage"
+	"go-chat-api/internal/unfurl"
 	"go-chat-api/internal/websocket"
 	"log"
 	"net/http"
+	"os"
+	"strings"
 )
 
 func main() {
@@ -28,30 +42,208 @@ func main() {
 	hub := websocket.NewHub()
 	go hub.Run() // Start the hub in a goroutine
 
+	// Initialize mailer: SMTP when configured, otherwise write emails to a log file or stdout
+	var mail mailer.Mailer
+	if cfg.SMTPHost != "" {
+		mail = mailer.NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom)
+	} else if cfg.MailLogFile != "" {
+		mailLog, err := os.OpenFile(cfg.MailLogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			log.Fatal("Failed to open mail log file:", err)
+		}
+		defer mailLog.Close()
+		mail = mailer.NewLogMailer(mailLog)
+	} else {
+		mail = mailer.NewLogMailer(os.Stdout)
+	}
+
 	// Initialize auth service
-	authService := auth.NewAuthService(cfg.JWTSecret, cfg.JWTExpiry)
+	authService := auth.NewAuthService(cfg.JWTSecret, cfg.JWTExpiry,
+		auth.WithSessionStore(db),
+		auth.WithUserStore(db),
+		auth.WithAPIKeyStore(db),
+		auth.WithTicketStore(db),
+	)
+
+	// Initialize brute-force protection and the audit trail
+	loginGuard := auth.NewLoginGuard(db, auth.LockoutPolicy{
+		MaxAttempts:      cfg.LoginMaxAttempts,
+		MaxAttemptsPerIP: cfg.LoginMaxAttemptsPerIP,
+		BaseLockout:      cfg.LoginLockout,
+		MaxLockout:       cfg.LoginMaxLockout,
+		ResetAfter:       cfg.LoginMaxLockout,
+	})
+	auditLog := audit.NewLogger(db)
+
+	// Initialize the content moderation pipeline run on every message before it is stored
+	pipeline, err := newModerationPipeline(cfg, db)
+	if err != nil {
+		log.Fatal("Invalid moderation configuration:", err)
+	}
+
+	// Initialize the blob store holding attachment files
+	blobs, err := newBlobStore(cfg)
+	if err != nil {
+		log.Fatal("Failed to initialize blob store:", err)
+	}
+
+	// Download links are signed with a key derived from the JWT secret unless one is configured
+	urlSecret := []byte(cfg.AttachmentURLSecret)
+	if len(urlSecret) == 0 {
+		derived := sha256.Sum256([]byte("attachment-urls:" + cfg.JWTSecret))
+		urlSecret = derived[:]
+	}
 
 	// Initialize services with dependency injection
-	chatService := services.NewChatService(db, db, db, authService)
+	opts := []services.Option{
+		services.WithTokenStore(db),
+		services.WithIdentityStore(db),
+		services.WithAPIKeyStore(db),
+		services.WithBlockStore(db),
+		services.WithModeration(pipeline, db),
+		services.WithReportStore(db),
+		services.WithMentions(db, hub, hub.SendEvent),
+		services.WithPins(db, cfg.MaxPinnedMessages, hub.SendEvent),
+		services.WithSavedMessages(db),
+		services.WithScheduling(db, hub.SendEvent),
+		services.WithPolls(db, hub.SendEvent),
+		services.WithAttachments(db, blobs, services.AttachmentPolicy{
+			MaxBytes:     cfg.AttachmentMaxBytes,
+			AllowedTypes: cfg.AttachmentAllowedTypes,
+			URLSecret:    urlSecret,
+			URLTTL:       cfg.AttachmentURLTTL,
+		}),
+		services.WithLoginGuard(loginGuard),
+		services.WithAuditLogger(auditLog),
+		services.WithMailer(mail, cfg.BaseURL),
+		services.WithVerifiedEmailRequired(cfg.RequireVerifiedEmail),
+	}
+
+	// Unfurl links in new messages and push the previews to connected clients
+	if cfg.LinkPreviewsEnabled {
+		fetcher := unfurl.NewFetcher(unfurl.Config{
+			Timeout:  cfg.LinkPreviewTimeout,
+			MaxBytes: cfg.LinkPreviewMaxBytes,
+		})
+		opts = append(opts, services.WithLinkPreviews(db, fetcher, services.PreviewPolicy{
+			MaxLinks: cfg.LinkPreviewMaxLinks,
+			CacheTTL: cfg.LinkPreviewCacheTTL,
+		}, hub.UpdateMessage))
+	}
+	chatService := services.NewChatService(db, db, db, authService, opts...)
+
+	// Grant the admin role to the administrators configured in ADMIN_USER_IDS
+	if err := chatService.BootstrapAdmins(cfg.AdminUserIDs); err != nil {
+		log.Fatal("Failed to bootstrap administrators:", err)
+	}
+
+	// Send scheduled messages and reminders when they are due
+	go scheduler.New(db, chatService, hub.DeliverMessage, scheduler.Config{
+		Interval:  cfg.SchedulerInterval,
+		BatchSize: cfg.SchedulerBatchSize,
+		Lease:     cfg.SchedulerLease,
+	}).Run(context.Background())
+
+	// Delete disappearing messages once they expire
+	go reaper.New(chatService, hub.ExpireMessage, reaper.Config{
+		Interval:  cfg.ReaperInterval,
+		BatchSize: cfg.ReaperBatchSize,
+	}).Run(context.Background())
 
 	// Initialize handlers with dependency injection
 	chatHandler := handlers.NewChatHandler(chatService, hub)
-	authHandler := handlers.NewAuthHandler(chatService)
-	wsHandler := handlers.NewWebSocketHandler(hub, chatService)
+	authHandler := handlers.NewAuthHandler(chatService, hub)
+	wsHandler := handlers.NewWebSocketHandler(hub, chatService, websocket.Settings{
+		Origins:            cfg.AllowedOrigins,
+		FrameLimit:         cfg.WSFrameLimit,
+		MaxThrottledFrames: cfg.WSMaxThrottledFrames,
+	})
+	adminHandler := handlers.NewAdminHandler(chatService, hub)
+	moderationHandler := handlers.NewModerationHandler(chatService, hub)
+	attachmentHandler := handlers.NewAttachmentHandler(chatService)
+
+	// Initialize OpenID Connect login when an identity provider is configured
+	var oidcHandler *handlers.OIDCHandler
+	if cfg.OIDCIssuerURL != "" {
+		provider := oidc.NewProvider(oidc.Config{
+			IssuerURL:    cfg.OIDCIssuerURL,
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+			Scopes:       cfg.OIDCScopes,
+		}, nil)
+		oidcHandler = handlers.NewOIDCHandler(chatService, provider)
+	}
+
+	// Rate limit requests per route class, per user and per client IP
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), cfg.RateLimits)
 
 	// Setup routes
-	router := routes.SetupRoutes(chatHandler, authHandler, wsHandler, authService)
+	router := routes.SetupRoutes(chatHandler, authHandler, wsHandler, adminHandler, oidcHandler, moderationHandler, attachmentHandler, authService, limiter)
 
 	// Add middleware
-	handler := middleware.LoggingMiddleware(middleware.CORSMiddleware(router))
+	handler := middleware.LoggingMiddleware(middleware.CORSMiddleware(cfg.AllowedOrigins)(router))
 
 	// Start server
 	log.Printf("Starting chat API server on port %s", cfg.Port)
 	log.Printf("Environment: %s", cfg.Environment)
+	log.Printf("Allowed origins: %s", strings.Join(cfg.AllowedOrigins.Origins(), ", "))
 	log.Printf("Database: Connected to PostgreSQL")
 	log.Printf("WebSocket: Hub initialized and running")
+	log.Printf("Attachments: %s blob store", cfg.BlobStore)
 
 	if err := http.ListenAndServe(":"+cfg.Port, handler); err != nil {
 		log.Fatal("Server failed to start:", err)
 	}
 }
+
+// newModerationPipeline builds the profanity, link and spam filters from the configuration
+func newModerationPipeline(cfg *config.Config, history moderation.History) (*moderation.Pipeline, error) {
+	profanityAction, err := moderation.ParseAction(cfg.ModerationProfanityAction)
+	if err != nil {
+		return nil, err
+	}
+	linkAction, err := moderation.ParseAction(cfg.ModerationLinkAction)
+	if err != nil {
+		return nil, err
+	}
+	spamAction, err := moderation.ParseAction(cfg.ModerationSpamAction)
+	if err != nil {
+		return nil, err
+	}
+
+	words, err := moderation.NewWordFilter(cfg.ModerationBlockedWords, cfg.ModerationBlockedPatterns, profanityAction)
+	if err != nil {
+		return nil, err
+	}
+	links := moderation.NewLinkFilter(cfg.ModerationBlockedDomains, linkAction)
+	spam := moderation.NewSpamFilter(history, moderation.SpamPolicy{
+		MaxMentions:  cfg.ModerationMaxMentions,
+		MaxRepeats:   cfg.ModerationMaxRepeats,
+		RepeatWindow: cfg.ModerationRepeatWindow,
+		Velocity:     cfg.ModerationVelocity,
+	}, spamAction)
+
+	return moderation.NewPipeline(words, links, spam), nil
+}
+
+// newBlobStore opens the configured blob store for attachment files
+func newBlobStore(cfg *config.Config) (storage.BlobStore, error) {
+	switch cfg.BlobStore {
+	case "local":
+		return blob.NewLocalStore(cfg.BlobDir)
+	case "s3":
+		if cfg.S3Endpoint == "" || cfg.S3Bucket == "" {
+			return nil, fmt.Errorf("S3_ENDPOINT and S3_BUCKET are required for the s3 blob store")
+		}
+		return blob.NewS3Store(blob.S3Config{
+			Endpoint:        cfg.S3Endpoint,
+			Region:          cfg.S3Region,
+			Bucket:          cfg.S3Bucket,
+			AccessKeyID:     cfg.S3AccessKeyID,
+			SecretAccessKey: cfg.S3SecretAccessKey,
+		}, nil), nil
+	default:
+		return nil, fmt.Errorf("unknown blob store %q", cfg.BlobStore)
+	}
+}
@@ -0,0 +1,119 @@
+package audit
+
+import (
+	"go-chat-api/internal/models"
+	"go-chat-api/internal/storage"
+	"testing"
+)
+
+func TestLogger_RecordChainsEvents(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	logger := NewLogger(store)
+
+	logger.Record(models.AuditEvent{Action: ActionLoginSucceeded, ActorID: "user-1", IP: "203.0.113.7"})
+	logger.Record(models.AuditEvent{Action: ActionRoleChanged, ActorID: "admin-1", TargetID: "user-1", Metadata: map[string]string{"to": "moderator"}})
+	logger.Record(models.AuditEvent{Action: ActionUserSuspended, ActorID: "admin-1", TargetID: "user-1"})
+
+	events, err := store.GetAuditEvents()
+	if err != nil {
+		t.Fatalf("GetAuditEvents() unexpected error = %v", err)
+	}
+	for i, event := range events {
+		if event.Seq != int64(i+1) {
+			t.Errorf("event %d seq = %d, want %d", i, event.Seq, i+1)
+		}
+		if i > 0 && event.PrevHash != events[i-1].Hash {
+			t.Errorf("event %d prev_hash = %q, want %q", i, event.PrevHash, events[i-1].Hash)
+		}
+	}
+
+	result, err := logger.Verify()
+	if err != nil {
+		t.Fatalf("Verify() unexpected error = %v", err)
+	}
+	if !result.Valid || result.Events != 3 {
+		t.Errorf("Verify() = %+v, want a valid chain of 3 events", result)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	logger := NewLogger(store)
+	for _, action := range []string{ActionLoginFailed, ActionLoginSucceeded, ActionTokenRefreshed, ActionMessageDeleted} {
+		logger.Record(models.AuditEvent{Action: action, ActorID: "user-1", Metadata: map[string]string{"k": "v"}})
+	}
+	chain, _ := store.GetAuditEvents()
+
+	// tamper returns a copy of the chain modified by fn
+	tamper := func(fn func([]models.AuditEvent) []models.AuditEvent) []models.AuditEvent {
+		events := make([]models.AuditEvent, len(chain))
+		copy(events, chain)
+		for i := range events {
+			events[i].Metadata = map[string]string{"k": "v"}
+		}
+		return fn(events)
+	}
+
+	tests := []struct {
+		name         string
+		events       []models.AuditEvent
+		wantValid    bool
+		wantBrokenAt int64
+	}{
+		{name: "intact chain", events: chain, wantValid: true},
+		{name: "empty chain", events: nil, wantValid: true},
+		{
+			name:      "legacy events are skipped",
+			events:    append([]models.AuditEvent{{ID: "legacy", Action: ActionLoginSucceeded}}, chain...),
+			wantValid: true,
+		},
+		{
+			name: "edited content",
+			events: tamper(func(events []models.AuditEvent) []models.AuditEvent {
+				events[1].ActorID = "someone-else"
+				return events
+			}),
+			wantBrokenAt: 2,
+		},
+		{
+			name: "edited metadata",
+			events: tamper(func(events []models.AuditEvent) []models.AuditEvent {
+				events[2].Metadata["k"] = "changed"
+				return events
+			}),
+			wantBrokenAt: 3,
+		},
+		{
+			name: "rehashed event breaks the link to its successor",
+			events: tamper(func(events []models.AuditEvent) []models.AuditEvent {
+				events[1].ActorID = "someone-else"
+				events[1].Hash = events[1].ComputeHash()
+				return events
+			}),
+			wantBrokenAt: 3,
+		},
+		{
+			name: "deleted event",
+			events: tamper(func(events []models.AuditEvent) []models.AuditEvent {
+				return append(events[:1], events[2:]...)
+			}),
+			wantBrokenAt: 3,
+		},
+		{
+			name: "truncated start",
+			events: tamper(func(events []models.AuditEvent) []models.AuditEvent {
+				return events[1:]
+			}),
+			wantBrokenAt: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := Verify(tt.events)
+			if result.Valid != tt.wantValid || result.BrokenAt != tt.wantBrokenAt {
+				t.Errorf("Verify() = %+v, want valid %v broken at %d", result, tt.wantValid, tt.wantBrokenAt)
+			}
+		})
+	}
+}
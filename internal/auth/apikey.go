@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"errors"
+	"go-chat-api/internal/models"
+	"go-chat-api/internal/storage"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// APIKeyPrefix marks a bearer token as an API key rather than a JWT
+const APIKeyPrefix = "chk_"
+
+// apiKeyTouchInterval limits how often the last-used time of a key is written
+const apiKeyTouchInterval = time.Minute
+
+// ErrInvalidAPIKey is returned for unknown, revoked or expired API keys
+var ErrInvalidAPIKey = errors.New("invalid or expired api key")
+
+// WithAPIKeyStore enables authentication with API keys. It requires WithUserStore to resolve key owners.
+func WithAPIKeyStore(store storage.APIKeyStore) Option {
+	return func(s *AuthService) {
+		s.apiKeys = store
+	}
+}
+
+// GenerateAPIKey generates a new API key and returns it with its visible prefix and storage hash
+func GenerateAPIKey() (key, prefix, hash string, err error) {
+	id, err := generateRandomHex(4)
+	if err != nil {
+		return "", "", "", err
+	}
+	secret, err := generateRandomHex(24)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	prefix = APIKeyPrefix + id
+	key = prefix + "_" + secret
+	return key, prefix, HashOpaqueToken(key), nil
+}
+
+// IsAPIKey reports whether a bearer token is an API key
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// Authenticate validates a bearer token, which may be a JWT or an API key
+func (s *AuthService) Authenticate(token string) (*models.Claims, error) {
+	if IsAPIKey(token) {
+		return s.ValidateAPIKey(token)
+	}
+	return s.ValidateToken(token)
+}
+
+// ValidateAPIKey validates an API key and returns claims limited to the key's scopes
+func (s *AuthService) ValidateAPIKey(key string) (*models.Claims, error) {
+	if s.apiKeys == nil || s.users == nil {
+		return nil, errors.New("api keys are not enabled")
+	}
+
+	apiKey, err := s.apiKeys.GetAPIKeyByHash(HashOpaqueToken(key))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if apiKey == nil || apiKey.RevokedAt != nil || now.After(apiKey.ExpiresAt) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	user, err := s.activeUser(apiKey.UserID)
+	if errors.Is(err, ErrAccountSuspended) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, ErrInvalidAPIKey
+	}
+
+	// Bots act for their owner, so they stop working when the owner is suspended or deleted
+	if user.IsBot && user.OwnerID != "" {
+		if _, err := s.activeUser(user.OwnerID); err != nil {
+			if errors.Is(err, ErrAccountSuspended) {
+				return nil, err
+			}
+			return nil, ErrInvalidAPIKey
+		}
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > apiKeyTouchInterval {
+		if err := s.apiKeys.TouchAPIKey(apiKey.ID, now); err != nil {
+			log.Printf("Failed to record use of api key %s: %v", apiKey.ID, err)
+		}
+	}
+
+	return &models.Claims{
+		UserID:   user.ID,
+		Username: user.Username,
+		Scopes:   apiKey.Scopes,
+		APIKeyID: apiKey.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(apiKey.ExpiresAt),
+		},
+	}, nil
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"errors"
+	"go-chat-api/internal/models"
+	"go-chat-api/internal/storage"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, prefix, hash, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("GenerateAPIKey() unexpected error = %v", err)
+	}
+
+	if !IsAPIKey(key) || !strings.HasPrefix(key, prefix+"_") {
+		t.Errorf("GenerateAPIKey() key %q does not start with prefix %q", key, prefix)
+	}
+	if hash != HashOpaqueToken(key) {
+		t.Error("GenerateAPIKey() hash does not match HashOpaqueToken(key)")
+	}
+	if strings.Contains(hash, key[len(prefix):]) {
+		t.Error("GenerateAPIKey() hash contains the key secret")
+	}
+
+	other, _, _, _ := GenerateAPIKey()
+	if other == key {
+		t.Error("GenerateAPIKey() generated the same key twice")
+	}
+}
+
+func TestAuthService_ValidateAPIKey(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := NewAuthService("test-secret", 24*time.Hour, WithUserStore(store), WithAPIKeyStore(store))
+	store.AddUser(models.User{ID: "bot-id", Username: "deploybot", IsBot: true})
+
+	addKey := func(id string, expiresAt time.Time) string {
+		key, prefix, hash, err := GenerateAPIKey()
+		if err != nil {
+			t.Fatalf("GenerateAPIKey() unexpected error = %v", err)
+		}
+		store.AddAPIKey(models.APIKey{
+			ID:        id,
+			UserID:    "bot-id",
+			Prefix:    prefix,
+			KeyHash:   hash,
+			Scopes:    []string{ScopeMessagesWrite},
+			CreatedAt: time.Now(),
+			ExpiresAt: expiresAt,
+		})
+		return key
+	}
+
+	validKey := addKey("valid", time.Now().Add(time.Hour))
+	expiredKey := addKey("expired", time.Now().Add(-time.Minute))
+	revokedKey := addKey("revoked", time.Now().Add(time.Hour))
+	store.RevokeAPIKey("revoked")
+
+	claims, err := authService.Authenticate(validKey)
+	if err != nil {
+		t.Fatalf("Authenticate() unexpected error = %v", err)
+	}
+	if claims.UserID != "bot-id" || claims.Username != "deploybot" || claims.APIKeyID != "valid" {
+		t.Errorf("Authenticate() claims = %+v", claims)
+	}
+	if len(claims.Scopes) != 1 || claims.Scopes[0] != ScopeMessagesWrite {
+		t.Errorf("Authenticate() scopes = %v, want [%s]", claims.Scopes, ScopeMessagesWrite)
+	}
+
+	key, _ := store.GetAPIKey("valid")
+	if key.LastUsedAt == nil {
+		t.Error("ValidateAPIKey() did not record the last use")
+	}
+
+	for name, key := range map[string]string{
+		"expired": expiredKey,
+		"revoked": revokedKey,
+		"unknown": APIKeyPrefix + "00000000_deadbeef",
+	} {
+		if _, err := authService.ValidateAPIKey(key); !errors.Is(err, ErrInvalidAPIKey) {
+			t.Errorf("ValidateAPIKey() %s key error = %v, want %v", name, err, ErrInvalidAPIKey)
+		}
+	}
+
+	// Without an API key store, keys are rejected
+	if _, err := NewAuthService("test-secret", time.Hour).Authenticate(validKey); err == nil {
+		t.Error("Authenticate() without api key store expected error, got nil")
+	}
+}
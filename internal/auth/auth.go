@@ -1,8 +1,12 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"go-chat-api/internal/models"
+	"go-chat-api/internal/storage"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -13,14 +17,42 @@ import (
 type AuthService struct {
 	jwtSecret []byte
 	jwtExpiry time.Duration
+	sessions  storage.SessionStore
+	apiKeys   storage.APIKeyStore
+	users     storage.UserStore
+	tickets   storage.TicketStore
+}
+
+// ErrAccountSuspended is returned when the owner of a token or API key has been suspended
+var ErrAccountSuspended = errors.New("account suspended")
+
+// Option configures optional AuthService dependencies
+type Option func(*AuthService)
+
+// WithSessionStore records every issued token as a session so it can be revoked
+func WithSessionStore(store storage.SessionStore) Option {
+	return func(s *AuthService) {
+		s.sessions = store
+	}
+}
+
+// WithUserStore checks on every request that the token's user still exists and is not suspended
+func WithUserStore(store storage.UserStore) Option {
+	return func(s *AuthService) {
+		s.users = store
+	}
 }
 
 // NewAuthService creates a new authentication service
-func NewAuthService(jwtSecret string, jwtExpiry time.Duration) *AuthService {
-	return &AuthService{
+func NewAuthService(jwtSecret string, jwtExpiry time.Duration, opts ...Option) *AuthService {
+	s := &AuthService{
 		jwtSecret: []byte(jwtSecret),
 		jwtExpiry: jwtExpiry,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // HashPassword hashes a password using bcrypt
@@ -39,14 +71,23 @@ func (s *AuthService) VerifyPassword(hashedPassword, password string) error {
 
 // GenerateToken generates a JWT token for a user
 func (s *AuthService) GenerateToken(user models.User) (string, int64, error) {
-	expirationTime := time.Now().Add(s.jwtExpiry)
+	now := time.Now()
+	expirationTime := now.Add(s.jwtExpiry)
+
+	sessionID, err := generateRandomHex(16)
+	if err != nil {
+		return "", 0, err
+	}
 
 	claims := &models.Claims{
 		UserID:   user.ID,
 		Username: user.Username,
+		Role:     user.Role,
+		Scopes:   RoleScopes(user.Role),
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        sessionID,
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			IssuedAt:  jwt.NewNumericDate(now),
 			Subject:   user.ID,
 		},
 	}
@@ -57,6 +98,18 @@ func (s *AuthService) GenerateToken(user models.User) (string, int64, error) {
 		return "", 0, err
 	}
 
+	if s.sessions != nil {
+		session := models.Session{
+			ID:        sessionID,
+			UserID:    user.ID,
+			CreatedAt: now,
+			ExpiresAt: expirationTime,
+		}
+		if err := s.sessions.CreateSession(session); err != nil {
+			return "", 0, err
+		}
+	}
+
 	return tokenString, expirationTime.Unix(), nil
 }
 
@@ -79,10 +132,63 @@ func (s *AuthService) ValidateToken(tokenString string) (*models.Claims, error)
 		return nil, errors.New("invalid token")
 	}
 
+	// Tokens issued before roles existed carry no scopes
+	if claims.Scopes == nil {
+		claims.Scopes = RoleScopes(claims.Role)
+	}
+
+	if s.sessions != nil {
+		session, err := s.sessions.GetSession(claims.ID)
+		if err != nil {
+			return nil, err
+		}
+		if session == nil || session.RevokedAt != nil {
+			return nil, errors.New("token has been revoked")
+		}
+	}
+
+	if s.users != nil {
+		if _, err := s.activeUser(claims.UserID); err != nil {
+			return nil, err
+		}
+	}
+
 	return claims, nil
 }
 
-// RefreshToken generates a new token from an existing valid token
+// activeUser loads a user and rejects deleted and suspended accounts
+func (s *AuthService) activeUser(userID string) (*models.User, error) {
+	user, err := s.users.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("user not found")
+	}
+	if user.IsSuspended() {
+		return nil, ErrAccountSuspended
+	}
+	return user, nil
+}
+
+// RevokeSession revokes the session backing a single token
+func (s *AuthService) RevokeSession(sessionID string) error {
+	if s.sessions == nil || sessionID == "" {
+		return nil
+	}
+	return s.sessions.RevokeSession(sessionID)
+}
+
+// RevokeUserSessions revokes all of a user's sessions except exceptSessionID (which may be empty)
+func (s *AuthService) RevokeUserSessions(userID, exceptSessionID string) error {
+	if s.sessions == nil {
+		return nil
+	}
+	return s.sessions.RevokeUserSessions(userID, exceptSessionID)
+}
+
+// RefreshToken generates a new token from an existing valid token and revokes the session
+// of the old one
 func (s *AuthService) RefreshToken(tokenString string) (string, int64, error) {
 	claims, err := s.ValidateToken(tokenString)
 	if err != nil {
@@ -98,7 +204,41 @@ func (s *AuthService) RefreshToken(tokenString string) (string, int64, error) {
 	user := models.User{
 		ID:       claims.UserID,
 		Username: claims.Username,
+		Role:     claims.Role,
 	}
 
-	return s.GenerateToken(user)
+	token, expiresAt, err := s.GenerateToken(user)
+	if err != nil {
+		return "", 0, err
+	}
+
+	// Rotate the session: the refreshed token stops working once it has been exchanged
+	if err := s.RevokeSession(claims.ID); err != nil {
+		return "", 0, err
+	}
+	return token, expiresAt, nil
+}
+
+// GenerateOpaqueToken generates a random single-use token and returns it with its storage hash
+func GenerateOpaqueToken() (string, string, error) {
+	token, err := generateRandomHex(32)
+	if err != nil {
+		return "", "", err
+	}
+	return token, HashOpaqueToken(token), nil
+}
+
+// HashOpaqueToken returns the SHA-256 hash used to store an opaque token at rest
+func HashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateRandomHex returns n random bytes encoded as hex
+func generateRandomHex(n int) (string, error) {
+	bytes := make([]byte, n)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
 }
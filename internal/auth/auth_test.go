@@ -2,8 +2,11 @@ package auth
 
 import (
 	"go-chat-api/internal/models"
+	"go-chat-api/internal/storage"
 	"testing"
 	"time"
+
+	"github.com/golang-jwt/jwt/v5"
 )
 
 func TestAuthService_HashPassword(t *testing.T) {
@@ -292,3 +295,108 @@ func TestAuthService_TokenSigning(t *testing.T) {
 		t.Errorf("Token should be valid with original service: %v", err)
 	}
 }
+
+func TestAuthService_SessionRevocation(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := NewAuthService("test-secret", 24*time.Hour, WithSessionStore(store))
+	user := models.User{ID: "user-1", Username: "testuser"}
+
+	first, _, err := authService.GenerateToken(user)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	second, _, err := authService.GenerateToken(user)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	firstClaims, err := authService.ValidateToken(first)
+	if err != nil {
+		t.Fatalf("ValidateToken() unexpected error = %v", err)
+	}
+	if firstClaims.ID == "" {
+		t.Fatal("GenerateToken() did not set a session ID")
+	}
+
+	// Revoke every session except the first one
+	if err := authService.RevokeUserSessions(user.ID, firstClaims.ID); err != nil {
+		t.Fatalf("RevokeUserSessions() unexpected error = %v", err)
+	}
+
+	if _, err := authService.ValidateToken(first); err != nil {
+		t.Errorf("ValidateToken() kept session should be valid, got %v", err)
+	}
+	if _, err := authService.ValidateToken(second); err == nil {
+		t.Error("ValidateToken() revoked session should be rejected")
+	}
+
+	if err := authService.RevokeSession(firstClaims.ID); err != nil {
+		t.Fatalf("RevokeSession() unexpected error = %v", err)
+	}
+	if _, err := authService.ValidateToken(first); err == nil {
+		t.Error("ValidateToken() should reject a token after RevokeSession()")
+	}
+
+	// Tokens signed correctly but never recorded as sessions are rejected too
+	unrecorded, _, err := NewAuthService("test-secret", 24*time.Hour).GenerateToken(user)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	if _, err := authService.ValidateToken(unrecorded); err == nil {
+		t.Error("ValidateToken() should reject tokens without a recorded session")
+	}
+}
+
+func TestHashOpaqueToken(t *testing.T) {
+	token, hash, err := GenerateOpaqueToken()
+	if err != nil {
+		t.Fatalf("GenerateOpaqueToken() unexpected error = %v", err)
+	}
+	if token == hash {
+		t.Error("GenerateOpaqueToken() hash should differ from token")
+	}
+	if HashOpaqueToken(token) != hash {
+		t.Error("HashOpaqueToken() should be deterministic")
+	}
+}
+
+func TestAuthService_RoleScopes(t *testing.T) {
+	authService := NewAuthService("test-secret", 10*time.Minute)
+
+	token, _, err := authService.GenerateToken(models.User{ID: "admin-id", Username: "admin", Role: models.RoleAdmin})
+	if err != nil {
+		t.Fatalf("GenerateToken() unexpected error = %v", err)
+	}
+
+	claims, err := authService.ValidateToken(token)
+	if err != nil {
+		t.Fatalf("ValidateToken() unexpected error = %v", err)
+	}
+	if claims.Role != models.RoleAdmin || !HasScope(claims.Scopes, ScopeAdmin) || !HasScope(claims.Scopes, ScopeMessagesWrite) {
+		t.Errorf("ValidateToken() role = %q, scopes = %v", claims.Role, claims.Scopes)
+	}
+
+	// Refreshed tokens keep the role
+	refreshed, _, err := authService.RefreshToken(token)
+	if err != nil {
+		t.Fatalf("RefreshToken() unexpected error = %v", err)
+	}
+	if claims, _ := authService.ValidateToken(refreshed); claims == nil || !HasScope(claims.Scopes, ScopeAdmin) {
+		t.Error("RefreshToken() dropped the admin scope")
+	}
+
+	// Tokens issued before roles existed get the scopes of a regular user
+	legacy := jwt.NewWithClaims(jwt.SigningMethodHS256, &models.Claims{
+		UserID:           "user-id",
+		Username:         "legacy",
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+	})
+	legacyToken, _ := legacy.SignedString([]byte("test-secret"))
+	claims, err = authService.ValidateToken(legacyToken)
+	if err != nil {
+		t.Fatalf("ValidateToken() legacy token unexpected error = %v", err)
+	}
+	if !HasScope(claims.Scopes, ScopeMessagesRead) || HasScope(claims.Scopes, ScopeModerate) {
+		t.Errorf("ValidateToken() legacy scopes = %v, want regular user scopes", claims.Scopes)
+	}
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"go-chat-api/internal/storage"
+	"strings"
+	"time"
+)
+
+// LockoutPolicy configures brute-force protection for password logins
+type LockoutPolicy struct {
+	// MaxAttempts is the number of consecutive failures per username before lockout
+	MaxAttempts int
+
+	// MaxAttemptsPerIP is the number of consecutive failures per client IP before lockout
+	MaxAttemptsPerIP int
+
+	// BaseLockout is the first lockout duration; it doubles with every further failure
+	BaseLockout time.Duration
+
+	// MaxLockout caps the lockout duration
+	MaxLockout time.Duration
+
+	// ResetAfter is how long without failures before a counter starts over
+	ResetAfter time.Duration
+}
+
+// LoginGuard tracks failed logins per username and per client IP and applies
+// exponential lockouts once the configured thresholds are reached
+type LoginGuard struct {
+	store  storage.LoginAttemptStore
+	policy LockoutPolicy
+	now    func() time.Time
+}
+
+// NewLoginGuard creates a new login guard
+func NewLoginGuard(store storage.LoginAttemptStore, policy LockoutPolicy) *LoginGuard {
+	return &LoginGuard{
+		store:  store,
+		policy: policy,
+		now:    time.Now,
+	}
+}
+
+// Check returns how long the caller must wait before another login attempt
+// for the username or IP is allowed. Zero means the attempt may proceed.
+func (g *LoginGuard) Check(username, ip string) (time.Duration, error) {
+	now := g.now()
+	var retryAfter time.Duration
+
+	for _, key := range g.keys(username, ip) {
+		throttle, err := g.store.GetLoginThrottle(key)
+		if err != nil {
+			return 0, err
+		}
+		if throttle != nil && throttle.LockedUntil.After(now) {
+			if wait := throttle.LockedUntil.Sub(now); wait > retryAfter {
+				retryAfter = wait
+			}
+		}
+	}
+
+	return retryAfter, nil
+}
+
+// RecordFailure counts a failed login and returns the lockout it triggered, if any
+func (g *LoginGuard) RecordFailure(username, ip string) (time.Duration, error) {
+	now := g.now()
+	var lockout time.Duration
+
+	for _, key := range g.keys(username, ip) {
+		throttle, err := g.store.IncrementLoginFailures(key, now, now.Add(-g.policy.ResetAfter))
+		if err != nil {
+			return 0, err
+		}
+
+		duration := g.lockoutFor(throttle.Failures, g.threshold(key))
+		if duration == 0 {
+			continue
+		}
+		if err := g.store.SetLoginLockout(key, now.Add(duration)); err != nil {
+			return 0, err
+		}
+		if duration > lockout {
+			lockout = duration
+		}
+	}
+
+	return lockout, nil
+}
+
+// RecordSuccess clears the failure counter of a username after a successful login.
+// The IP counter is left alone so one valid account cannot mask guessing against others.
+func (g *LoginGuard) RecordSuccess(username string) error {
+	return g.store.ClearLoginThrottle(usernameKey(username))
+}
+
+// Unlock clears any lockout and failure counter of a username
+func (g *LoginGuard) Unlock(username string) error {
+	return g.store.ClearLoginThrottle(usernameKey(username))
+}
+
+// lockoutFor returns the lockout duration after the given number of failures
+func (g *LoginGuard) lockoutFor(failures, threshold int) time.Duration {
+	if threshold <= 0 || failures < threshold {
+		return 0
+	}
+
+	duration := g.policy.BaseLockout
+	for i := threshold; i < failures && duration < g.policy.MaxLockout; i++ {
+		duration *= 2
+	}
+	if g.policy.MaxLockout > 0 && duration > g.policy.MaxLockout {
+		duration = g.policy.MaxLockout
+	}
+	return duration
+}
+
+// threshold returns the failure threshold that applies to a key
+func (g *LoginGuard) threshold(key string) int {
+	if strings.HasPrefix(key, "ip:") {
+		return g.policy.MaxAttemptsPerIP
+	}
+	return g.policy.MaxAttempts
+}
+
+// keys returns the throttle keys for a login attempt
+func (g *LoginGuard) keys(username, ip string) []string {
+	keys := []string{usernameKey(username)}
+	if ip != "" {
+		keys = append(keys, "ip:"+ip)
+	}
+	return keys
+}
+
+// usernameKey returns the throttle key for a username
+func usernameKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
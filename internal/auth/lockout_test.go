@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"go-chat-api/internal/storage"
+	"testing"
+	"time"
+)
+
+func newTestLoginGuard(now *time.Time) *LoginGuard {
+	guard := NewLoginGuard(storage.NewInMemoryStorage(), LockoutPolicy{
+		MaxAttempts:      3,
+		MaxAttemptsPerIP: 5,
+		BaseLockout:      time.Minute,
+		MaxLockout:       10 * time.Minute,
+		ResetAfter:       time.Hour,
+	})
+	guard.now = func() time.Time { return *now }
+	return guard
+}
+
+func TestLoginGuard_ExponentialLockout(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	guard := newTestLoginGuard(&now)
+
+	wantLockouts := []time.Duration{0, 0, time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 10 * time.Minute}
+	for i, want := range wantLockouts {
+		lockout, err := guard.RecordFailure("TestUser", "")
+		if err != nil {
+			t.Fatalf("RecordFailure() unexpected error = %v", err)
+		}
+		if lockout != want {
+			t.Errorf("RecordFailure() #%d lockout = %v, want %v", i+1, lockout, want)
+		}
+	}
+
+	// Usernames are matched case-insensitively
+	retryAfter, err := guard.Check("testuser", "")
+	if err != nil {
+		t.Fatalf("Check() unexpected error = %v", err)
+	}
+	if retryAfter != 10*time.Minute {
+		t.Errorf("Check() retryAfter = %v, want %v", retryAfter, 10*time.Minute)
+	}
+
+	now = now.Add(11 * time.Minute)
+	if retryAfter, _ := guard.Check("testuser", ""); retryAfter != 0 {
+		t.Errorf("Check() after lockout expired retryAfter = %v, want 0", retryAfter)
+	}
+}
+
+func TestLoginGuard_PerIPLockout(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	guard := newTestLoginGuard(&now)
+
+	// Spread failures across usernames so only the IP threshold is reached
+	for i, username := range []string{"a", "b", "c", "d", "e"} {
+		if _, err := guard.RecordFailure(username, "203.0.113.7"); err != nil {
+			t.Fatalf("RecordFailure() #%d unexpected error = %v", i+1, err)
+		}
+	}
+
+	if retryAfter, _ := guard.Check("someone-else", "203.0.113.7"); retryAfter != time.Minute {
+		t.Errorf("Check() locked IP retryAfter = %v, want %v", retryAfter, time.Minute)
+	}
+	if retryAfter, _ := guard.Check("someone-else", "198.51.100.1"); retryAfter != 0 {
+		t.Errorf("Check() other IP retryAfter = %v, want 0", retryAfter)
+	}
+}
+
+func TestLoginGuard_ResetAndUnlock(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	guard := newTestLoginGuard(&now)
+
+	guard.RecordFailure("testuser", "")
+	guard.RecordFailure("testuser", "")
+	if err := guard.RecordSuccess("testuser"); err != nil {
+		t.Fatalf("RecordSuccess() unexpected error = %v", err)
+	}
+	if lockout, _ := guard.RecordFailure("testuser", ""); lockout != 0 {
+		t.Errorf("RecordFailure() after success lockout = %v, want 0", lockout)
+	}
+
+	// Failures older than ResetAfter are forgotten
+	guard.RecordFailure("testuser", "")
+	now = now.Add(2 * time.Hour)
+	if lockout, _ := guard.RecordFailure("testuser", ""); lockout != 0 {
+		t.Errorf("RecordFailure() after reset window lockout = %v, want 0", lockout)
+	}
+
+	guard.RecordFailure("testuser", "")
+	if lockout, _ := guard.RecordFailure("testuser", ""); lockout == 0 {
+		t.Fatal("RecordFailure() expected lockout after threshold")
+	}
+	if err := guard.Unlock("testuser"); err != nil {
+		t.Fatalf("Unlock() unexpected error = %v", err)
+	}
+	if retryAfter, _ := guard.Check("testuser", ""); retryAfter != 0 {
+		t.Errorf("Check() after Unlock retryAfter = %v, want 0", retryAfter)
+	}
+}
@@ -0,0 +1,59 @@
+package auth
+
+import "go-chat-api/internal/models"
+
+// Scopes carried by tokens and API keys
+const (
+	ScopeMessagesRead  = "messages:read"
+	ScopeMessagesWrite = "messages:write"
+	ScopeRoomsRead     = "rooms:read"
+	ScopeRoomsWrite    = "rooms:write"
+	ScopeUsersRead     = "users:read"
+	ScopeModerate      = "moderate"
+	ScopeAdmin         = "admin"
+)
+
+// APIKeyScopes lists every scope that can be granted to an API key
+var APIKeyScopes = []string{
+	ScopeMessagesRead,
+	ScopeMessagesWrite,
+	ScopeRoomsRead,
+	ScopeRoomsWrite,
+	ScopeUsersRead,
+}
+
+// IsValidAPIKeyScope reports whether scope can be granted to an API key
+func IsValidAPIKeyScope(scope string) bool {
+	return HasScope(APIKeyScopes, scope)
+}
+
+// IsValidRole reports whether role is a known global role
+func IsValidRole(role string) bool {
+	switch role {
+	case models.RoleUser, models.RoleModerator, models.RoleAdmin:
+		return true
+	}
+	return false
+}
+
+// RoleScopes returns the scopes granted to a global role. Unknown roles get the scopes of a regular user.
+func RoleScopes(role string) []string {
+	scopes := append([]string{}, APIKeyScopes...)
+	switch role {
+	case models.RoleAdmin:
+		scopes = append(scopes, ScopeModerate, ScopeAdmin)
+	case models.RoleModerator:
+		scopes = append(scopes, ScopeModerate)
+	}
+	return scopes
+}
+
+// HasScope reports whether scope is among the granted scopes
+func HasScope(granted []string, scope string) bool {
+	for _, g := range granted {
+		if g == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"errors"
+	"go-chat-api/internal/models"
+	"go-chat-api/internal/storage"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TicketTTL is how long a WebSocket ticket can be redeemed after it was issued
+const TicketTTL = 30 * time.Second
+
+// ErrInvalidTicket is returned for unknown, used or expired WebSocket tickets
+var ErrInvalidTicket = errors.New("invalid or expired ticket")
+
+// WithTicketStore enables single-use tickets for authenticating WebSocket connections
+func WithTicketStore(store storage.TicketStore) Option {
+	return func(s *AuthService) {
+		s.tickets = store
+	}
+}
+
+// IssueTicket issues a single-use ticket that authenticates one WebSocket connection
+// with the identity and scopes of the given claims
+func (s *AuthService) IssueTicket(claims *models.Claims) (string, time.Time, error) {
+	if s.tickets == nil {
+		return "", time.Time{}, errors.New("websocket tickets are not enabled")
+	}
+
+	ticket, ticketHash, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(TicketTTL)
+	err = s.tickets.AddWebSocketTicket(models.WebSocketTicket{
+		TicketHash: ticketHash,
+		UserID:     claims.UserID,
+		Username:   claims.Username,
+		Role:       claims.Role,
+		SessionID:  claims.ID,
+		APIKeyID:   claims.APIKeyID,
+		Scopes:     claims.Scopes,
+		CreatedAt:  now,
+		ExpiresAt:  expiresAt,
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return ticket, expiresAt, nil
+}
+
+// RedeemTicket consumes a ticket and returns the claims it was issued for. The session or
+// API key that requested the ticket must still be valid.
+func (s *AuthService) RedeemTicket(ticket string) (*models.Claims, error) {
+	if s.tickets == nil {
+		return nil, errors.New("websocket tickets are not enabled")
+	}
+
+	stored, err := s.tickets.ConsumeWebSocketTicket(HashOpaqueToken(ticket))
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		return nil, ErrInvalidTicket
+	}
+
+	// The redeemed claims expire with the session or API key the ticket was issued for
+	var expiresAt *jwt.NumericDate
+	if stored.SessionID != "" && s.sessions != nil {
+		session, err := s.sessions.GetSession(stored.SessionID)
+		if err != nil {
+			return nil, err
+		}
+		if session == nil || session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+			return nil, ErrInvalidTicket
+		}
+		expiresAt = jwt.NewNumericDate(session.ExpiresAt)
+	}
+	if stored.APIKeyID != "" && s.apiKeys != nil {
+		apiKey, err := s.apiKeys.GetAPIKey(stored.APIKeyID)
+		if err != nil {
+			return nil, err
+		}
+		if apiKey == nil || apiKey.RevokedAt != nil || time.Now().After(apiKey.ExpiresAt) {
+			return nil, ErrInvalidTicket
+		}
+		expiresAt = jwt.NewNumericDate(apiKey.ExpiresAt)
+	}
+	if s.users != nil {
+		if _, err := s.activeUser(stored.UserID); err != nil {
+			return nil, err
+		}
+	}
+
+	return &models.Claims{
+		UserID:   stored.UserID,
+		Username: stored.Username,
+		Role:     stored.Role,
+		Scopes:   stored.Scopes,
+		APIKeyID: stored.APIKeyID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        stored.SessionID,
+			Subject:   stored.UserID,
+			ExpiresAt: expiresAt,
+		},
+	}, nil
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"errors"
+	"go-chat-api/internal/models"
+	"go-chat-api/internal/storage"
+	"testing"
+	"time"
+)
+
+func TestAuthService_WebSocketTickets(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := NewAuthService("test-secret", 24*time.Hour, WithSessionStore(store), WithUserStore(store), WithTicketStore(store))
+	user := models.User{ID: "user-1", Username: "testuser", Role: models.RoleUser}
+	store.AddUser(user)
+
+	// issue signs a token for the user and returns a ticket for its claims
+	issue := func(t *testing.T) (string, *models.Claims) {
+		token, _, err := authService.GenerateToken(user)
+		if err != nil {
+			t.Fatalf("GenerateToken() unexpected error = %v", err)
+		}
+		claims, err := authService.ValidateToken(token)
+		if err != nil {
+			t.Fatalf("ValidateToken() unexpected error = %v", err)
+		}
+		ticket, expiresAt, err := authService.IssueTicket(claims)
+		if err != nil {
+			t.Fatalf("IssueTicket() unexpected error = %v", err)
+		}
+		if ttl := time.Until(expiresAt); ttl <= 0 || ttl > TicketTTL {
+			t.Errorf("IssueTicket() expires in %v, want within %v", ttl, TicketTTL)
+		}
+		return ticket, claims
+	}
+
+	t.Run("single use", func(t *testing.T) {
+		ticket, claims := issue(t)
+
+		redeemed, err := authService.RedeemTicket(ticket)
+		if err != nil {
+			t.Fatalf("RedeemTicket() unexpected error = %v", err)
+		}
+		if redeemed.UserID != user.ID || redeemed.Username != user.Username || redeemed.ID != claims.ID {
+			t.Errorf("RedeemTicket() = %+v, want the claims of session %s", redeemed, claims.ID)
+		}
+		if len(redeemed.Scopes) != len(claims.Scopes) {
+			t.Errorf("RedeemTicket() scopes = %v, want %v", redeemed.Scopes, claims.Scopes)
+		}
+
+		if _, err := authService.RedeemTicket(ticket); !errors.Is(err, ErrInvalidTicket) {
+			t.Errorf("RedeemTicket() second use error = %v, want %v", err, ErrInvalidTicket)
+		}
+	})
+
+	t.Run("unknown ticket", func(t *testing.T) {
+		if _, err := authService.RedeemTicket("not-a-ticket"); !errors.Is(err, ErrInvalidTicket) {
+			t.Errorf("RedeemTicket() error = %v, want %v", err, ErrInvalidTicket)
+		}
+	})
+
+	t.Run("expired ticket", func(t *testing.T) {
+		ticket, hash, _ := GenerateOpaqueToken()
+		store.AddWebSocketTicket(models.WebSocketTicket{
+			TicketHash: hash,
+			UserID:     user.ID,
+			CreatedAt:  time.Now().Add(-time.Minute),
+			ExpiresAt:  time.Now().Add(-time.Second),
+		})
+		if _, err := authService.RedeemTicket(ticket); !errors.Is(err, ErrInvalidTicket) {
+			t.Errorf("RedeemTicket() error = %v, want %v", err, ErrInvalidTicket)
+		}
+	})
+
+	t.Run("revoked session", func(t *testing.T) {
+		ticket, claims := issue(t)
+		if err := authService.RevokeSession(claims.ID); err != nil {
+			t.Fatalf("RevokeSession() unexpected error = %v", err)
+		}
+		if _, err := authService.RedeemTicket(ticket); !errors.Is(err, ErrInvalidTicket) {
+			t.Errorf("RedeemTicket() error = %v, want %v", err, ErrInvalidTicket)
+		}
+	})
+
+	t.Run("suspended user", func(t *testing.T) {
+		ticket, _ := issue(t)
+		now := time.Now()
+		store.SetUserSuspended(user.ID, &now, "spam")
+		defer store.SetUserSuspended(user.ID, nil, "")
+
+		if _, err := authService.RedeemTicket(ticket); !errors.Is(err, ErrAccountSuspended) {
+			t.Errorf("RedeemTicket() error = %v, want %v", err, ErrAccountSuspended)
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package blob
+
+import (
+	"go-chat-api/internal/blob/s3test"
+	"go-chat-api/internal/storage"
+	"io"
+	"strings"
+	"testing"
+)
+
+// testBlobStore exercises the BlobStore contract shared by the implementations
+func testBlobStore(t *testing.T, store storage.BlobStore) {
+	t.Helper()
+
+	content := "hello, blob"
+	if err := store.PutBlob("attachments/abc/original", strings.NewReader(content), int64(len(content)), "text/plain"); err != nil {
+		t.Fatalf("PutBlob() unexpected error = %v", err)
+	}
+
+	body, err := store.GetBlob("attachments/abc/original")
+	if err != nil {
+		t.Fatalf("GetBlob() unexpected error = %v", err)
+	}
+	data, _ := io.ReadAll(body)
+	body.Close()
+	if string(data) != content {
+		t.Errorf("GetBlob() = %q, want %q", data, content)
+	}
+
+	if err := store.PutBlob("attachments/abc/short", strings.NewReader("abc"), 10, "text/plain"); err == nil {
+		t.Error("PutBlob() with a short body expected an error")
+	}
+
+	for _, key := range []string{"../escape", "attachments/../../escape", "/absolute", "", "a//b", "a/./b"} {
+		if err := store.PutBlob(key, strings.NewReader("x"), 1, ""); err == nil {
+			t.Errorf("PutBlob(%q) expected an invalid key error", key)
+		}
+	}
+
+	if err := store.DeleteBlob("attachments/abc/original"); err != nil {
+		t.Fatalf("DeleteBlob() unexpected error = %v", err)
+	}
+	if err := store.DeleteBlob("attachments/abc/original"); err != nil {
+		t.Errorf("DeleteBlob() of a missing blob unexpected error = %v", err)
+	}
+	if _, err := store.GetBlob("attachments/abc/original"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("GetBlob() after delete error = %v, want not found", err)
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore() unexpected error = %v", err)
+	}
+	testBlobStore(t, store)
+}
+
+func TestS3Store(t *testing.T) {
+	server := s3test.NewServer("chat", "eu-west-1", "access-key", "secret-key")
+	defer server.Close()
+
+	store := NewS3Store(S3Config{
+		Endpoint:        server.URL() + "/",
+		Region:          "eu-west-1",
+		Bucket:          "chat",
+		AccessKeyID:     "access-key",
+		SecretAccessKey: "secret-key",
+	}, nil)
+	testBlobStore(t, store)
+
+	content := "image bytes"
+	if err := store.PutBlob("attachments/def/original", strings.NewReader(content), int64(len(content)), "image/png"); err != nil {
+		t.Fatalf("PutBlob() unexpected error = %v", err)
+	}
+	if object, ok := server.Object("attachments/def/original"); !ok || object.ContentType != "image/png" {
+		t.Errorf("stored object = %+v, %v, want image/png", object, ok)
+	}
+
+	wrongSecret := NewS3Store(S3Config{
+		Endpoint:        server.URL(),
+		Region:          "eu-west-1",
+		Bucket:          "chat",
+		AccessKeyID:     "access-key",
+		SecretAccessKey: "wrong",
+	}, nil)
+	if _, err := wrongSecret.GetBlob("attachments/def/original"); err == nil || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Errorf("GetBlob() with a wrong secret error = %v, want a signature mismatch", err)
+	}
+}
@@ -2,8 +2,11 @@ package config
 
 import (
 	"fmt"
+	"go-chat-api/internal/ratelimit"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -21,15 +24,123 @@ type Config struct {
 	DatabaseUser    string
 	DatabasePass    string
 	DatabaseSSLMode string
+	BaseURL         string
+	SMTPHost        string
+	SMTPPort        string
+	SMTPUsername    string
+	SMTPPassword    string
+	MailFrom        string
+	MailLogFile     string
+
+	// RequireVerifiedEmail blocks users with unverified emails from sending messages
+	RequireVerifiedEmail bool
+
+	// AdminUserIDs lists users granted the admin role at startup
+	AdminUserIDs []string
+
+	// AllowedOrigins restricts which browser origins may call the API and open WebSocket connections
+	AllowedOrigins OriginPolicy
+
+	// OpenID Connect login, enabled when OIDCIssuerURL is set
+	OIDCIssuerURL    string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
+	OIDCScopes       []string
+
+	// Login brute-force protection
+	LoginMaxAttempts      int
+	LoginMaxAttemptsPerIP int
+	LoginLockout          time.Duration
+	LoginMaxLockout       time.Duration
+
+	// RateLimits holds the limits of each route class, applied per user and per client IP
+	RateLimits map[string]ratelimit.Rule
+
+	// WebSocket frame throttling: each connection may send WSFrameLimit frames, and is
+	// closed after WSMaxThrottledFrames throttled frames in a row
+	WSFrameLimit         ratelimit.Limit
+	WSMaxThrottledFrames int
+
+	// Content moderation: server-wide blocked words, patterns and link domains, the action
+	// ("allow", "mask", "hold" or "reject") each filter takes, and the spam thresholds
+	ModerationBlockedWords    []string
+	ModerationBlockedPatterns []string
+	ModerationBlockedDomains  []string
+	ModerationProfanityAction string
+	ModerationLinkAction      string
+	ModerationSpamAction      string
+	ModerationMaxMentions     int
+	ModerationMaxRepeats      int
+	ModerationRepeatWindow    time.Duration
+	ModerationVelocity        ratelimit.Limit
+
+	// Attachments: the blob store ("local" or "s3"), its location, and the upload limits.
+	// Download links are signed with AttachmentURLSecret and expire after AttachmentURLTTL.
+	BlobStore              string
+	BlobDir                string
+	S3Endpoint             string
+	S3Region               string
+	S3Bucket               string
+	S3AccessKeyID          string
+	S3SecretAccessKey      string
+	AttachmentMaxBytes     int64
+	AttachmentAllowedTypes []string
+	AttachmentURLSecret    string
+	AttachmentURLTTL       time.Duration
+
+	// Link previews: the limits on fetching linked pages and how long a fetched preview
+	// is reused
+	LinkPreviewsEnabled bool
+	LinkPreviewTimeout  time.Duration
+	LinkPreviewMaxBytes int64
+	LinkPreviewMaxLinks int
+	LinkPreviewCacheTTL time.Duration
+
+	// MaxPinnedMessages limits the pinned messages of each room
+	MaxPinnedMessages int
+
+	// Scheduled messages and reminders: how often due jobs are polled, how many are
+	// claimed at once, and how long a claimed job may take before it is claimed again
+	SchedulerInterval  time.Duration
+	SchedulerBatchSize int
+	SchedulerLease     time.Duration
+
+	// Disappearing messages: how often expired messages are deleted, and how many at once
+	ReaperInterval  time.Duration
+	ReaperBatchSize int
+}
+
+// defaultAttachmentTypes are the content types accepted for uploads, as detected from the file content
+var defaultAttachmentTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp", "application/pdf", "text/plain"}
+
+// defaultRateLimits are the per-user and per-IP limits of each route class, in the format
+// read by ratelimit.ParseLimit. Anonymous auth requests have no user to count against.
+var defaultRateLimits = map[string][2]string{
+	ratelimit.ClassAuth:     {"off", "20/1m"},
+	ratelimit.ClassAPI:      {"300/1m", "600/1m"},
+	ratelimit.ClassMessages: {"30/1m", "60/1m"},
 }
 
 // LoadConfig loads configuration from environment variables with defaults
 func LoadConfig() *Config {
 	jwtExpiryHours := getEnvAsInt("JWT_EXPIRY_HOURS", 24)
+	baseURL := strings.TrimRight(getEnv("BASE_URL", "http://localhost:8080"), "/")
+	environment := getEnv("ENVIRONMENT", "development")
+
+	allowedOrigins := getEnvAsList("ALLOWED_ORIGINS")
+	if len(allowedOrigins) == 0 {
+		allowedOrigins = defaultOrigins(environment, baseURL)
+	}
+
+	attachmentTypes := getEnvAsList("ATTACHMENT_ALLOWED_TYPES")
+	if len(attachmentTypes) == 0 {
+		attachmentTypes = defaultAttachmentTypes
+	}
 
 	return &Config{
 		Port:            getEnv("PORT", "8080"),
-		Environment:     getEnv("ENVIRONMENT", "development"),
+		Environment:     environment,
 		LogLevel:        getEnv("LOG_LEVEL", "info"),
 		JWTSecret:       getEnv("JWT_SECRET", "your-secret-key-change-this-in-production"),
 		JWTExpiry:       time.Duration(jwtExpiryHours) * time.Hour,
@@ -40,7 +151,98 @@ func LoadConfig() *Config {
 		DatabaseUser:    getEnv("DB_USER", "postgres"),
 		DatabasePass:    getEnv("DB_PASSWORD", "postgres"),
 		DatabaseSSLMode: getEnv("DB_SSLMODE", "disable"),
+		BaseURL:         baseURL,
+		SMTPHost:        getEnv("SMTP_HOST", ""),
+		SMTPPort:        getEnv("SMTP_PORT", "587"),
+		SMTPUsername:    getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:    getEnv("SMTP_PASSWORD", ""),
+		MailFrom:        getEnv("MAIL_FROM", "no-reply@localhost"),
+		MailLogFile:     getEnv("MAIL_LOG_FILE", ""),
+
+		RequireVerifiedEmail: getEnvAsBool("REQUIRE_VERIFIED_EMAIL", false),
+
+		AdminUserIDs: getEnvAsList("ADMIN_USER_IDS"),
+
+		AllowedOrigins: NewOriginPolicy(allowedOrigins),
+
+		OIDCIssuerURL:    getEnv("OIDC_ISSUER_URL", ""),
+		OIDCClientID:     getEnv("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret: getEnv("OIDC_CLIENT_SECRET", ""),
+		OIDCRedirectURL:  getEnv("OIDC_REDIRECT_URL", baseURL+"/api/auth/oidc/callback"),
+		OIDCScopes:       getEnvAsList("OIDC_SCOPES"),
+
+		LoginMaxAttempts:      getEnvAsInt("LOGIN_MAX_ATTEMPTS", 5),
+		LoginMaxAttemptsPerIP: getEnvAsInt("LOGIN_MAX_ATTEMPTS_PER_IP", 20),
+		LoginLockout:          time.Duration(getEnvAsInt("LOGIN_LOCKOUT_SECONDS", 60)) * time.Second,
+		LoginMaxLockout:       time.Duration(getEnvAsInt("LOGIN_MAX_LOCKOUT_MINUTES", 60)) * time.Minute,
+
+		RateLimits: loadRateLimits(),
+
+		WSFrameLimit:         getEnvAsLimit("WS_FRAME_LIMIT", "10/1s"),
+		WSMaxThrottledFrames: getEnvAsInt("WS_MAX_THROTTLED_FRAMES", 20),
+
+		ModerationBlockedWords:    getEnvAsList("MODERATION_BLOCKED_WORDS"),
+		ModerationBlockedPatterns: getEnvAsList("MODERATION_BLOCKED_PATTERNS"),
+		ModerationBlockedDomains:  getEnvAsList("MODERATION_BLOCKED_DOMAINS"),
+		ModerationProfanityAction: getEnv("MODERATION_PROFANITY_ACTION", "mask"),
+		ModerationLinkAction:      getEnv("MODERATION_LINK_ACTION", "reject"),
+		ModerationSpamAction:      getEnv("MODERATION_SPAM_ACTION", "hold"),
+		ModerationMaxMentions:     getEnvAsInt("MODERATION_MAX_MENTIONS", 5),
+		ModerationMaxRepeats:      getEnvAsInt("MODERATION_MAX_REPEATS", 3),
+		ModerationRepeatWindow:    time.Duration(getEnvAsInt("MODERATION_REPEAT_WINDOW_SECONDS", 60)) * time.Second,
+		ModerationVelocity:        getEnvAsLimit("MODERATION_MESSAGE_VELOCITY", "10/30s"),
+
+		BlobStore:              getEnv("BLOB_STORE", "local"),
+		BlobDir:                getEnv("BLOB_DIR", defaultBlobDir()),
+		S3Endpoint:             getEnv("S3_ENDPOINT", ""),
+		S3Region:               getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:               getEnv("S3_BUCKET", ""),
+		S3AccessKeyID:          getEnv("S3_ACCESS_KEY_ID", ""),
+		S3SecretAccessKey:      getEnv("S3_SECRET_ACCESS_KEY", ""),
+		AttachmentMaxBytes:     int64(getEnvAsInt("ATTACHMENT_MAX_BYTES", 10<<20)),
+		AttachmentAllowedTypes: attachmentTypes,
+		AttachmentURLSecret:    getEnv("ATTACHMENT_URL_SECRET", ""),
+		AttachmentURLTTL:       time.Duration(getEnvAsInt("ATTACHMENT_URL_TTL_SECONDS", 900)) * time.Second,
+
+		LinkPreviewsEnabled: getEnvAsBool("LINK_PREVIEWS_ENABLED", true),
+		LinkPreviewTimeout:  time.Duration(getEnvAsInt("LINK_PREVIEW_TIMEOUT_SECONDS", 5)) * time.Second,
+		LinkPreviewMaxBytes: int64(getEnvAsInt("LINK_PREVIEW_MAX_BYTES", 1<<20)),
+		LinkPreviewMaxLinks: getEnvAsInt("LINK_PREVIEW_MAX_LINKS", 3),
+		LinkPreviewCacheTTL: time.Duration(getEnvAsInt("LINK_PREVIEW_CACHE_TTL_SECONDS", 86400)) * time.Second,
+
+		MaxPinnedMessages: getEnvAsInt("MAX_PINNED_MESSAGES", 50),
+
+		SchedulerInterval:  time.Duration(getEnvAsInt("SCHEDULER_INTERVAL_SECONDS", 5)) * time.Second,
+		SchedulerBatchSize: getEnvAsInt("SCHEDULER_BATCH_SIZE", 100),
+		SchedulerLease:     time.Duration(getEnvAsInt("SCHEDULER_LEASE_SECONDS", 300)) * time.Second,
+
+		ReaperInterval:  time.Duration(getEnvAsInt("MESSAGE_REAPER_INTERVAL_SECONDS", 10)) * time.Second,
+		ReaperBatchSize: getEnvAsInt("MESSAGE_REAPER_BATCH_SIZE", 500),
+	}
+}
+
+// loadRateLimits reads RATE_LIMIT_<CLASS>_USER and RATE_LIMIT_<CLASS>_IP for every route class
+func loadRateLimits() map[string]ratelimit.Rule {
+	rules := make(map[string]ratelimit.Rule, len(ratelimit.Classes))
+	for _, class := range ratelimit.Classes {
+		prefix := "RATE_LIMIT_" + strings.ToUpper(class)
+		defaults := defaultRateLimits[class]
+		rules[class] = ratelimit.Rule{
+			PerUser: getEnvAsLimit(prefix+"_USER", defaults[0]),
+			PerIP:   getEnvAsLimit(prefix+"_IP", defaults[1]),
+		}
 	}
+	return rules
+}
+
+// defaultBlobDir keeps attachment files in the user's home directory. The blob directory
+// must not be inside the working directory, which is served as static files.
+func defaultBlobDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "chat-api", "blobs")
+	}
+	return filepath.Join(home, ".chat-api", "blobs")
 }
 
 // GetDatabaseConnectionString returns the database connection string
@@ -72,3 +274,36 @@ func getEnvAsInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+// getEnvAsBool gets an environment variable as boolean with a fallback default value
+func getEnvAsBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
+// getEnvAsLimit gets an environment variable as a rate limit ("requests/window" or "off")
+// with a fallback default value
+func getEnvAsLimit(key, defaultValue string) ratelimit.Limit {
+	if value := os.Getenv(key); value != "" {
+		if limit, err := ratelimit.ParseLimit(value); err == nil {
+			return limit
+		}
+	}
+	limit, _ := ratelimit.ParseLimit(defaultValue)
+	return limit
+}
+
+// getEnvAsList gets a comma-separated environment variable as a list, skipping empty entries
+func getEnvAsList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// OriginPolicy decides which browser origins may make cross-origin API calls and open
+// WebSocket connections. Entries are exact origins such as "https://chat.example.com" or
+// wildcard subdomain patterns such as "https://*.example.com", which match any subdomain
+// of example.com but not example.com itself.
+type OriginPolicy struct {
+	origins []string
+}
+
+// NewOriginPolicy creates an origin policy from a list of allowed origins
+func NewOriginPolicy(origins []string) OriginPolicy {
+	var normalized []string
+	for _, origin := range origins {
+		if origin = normalizeOrigin(origin); origin != "" {
+			normalized = append(normalized, origin)
+		}
+	}
+	return OriginPolicy{origins: normalized}
+}
+
+// Origins returns the allowed origins and patterns
+func (p OriginPolicy) Origins() []string {
+	return p.origins
+}
+
+// Allows reports whether an Origin header value is allowed
+func (p OriginPolicy) Allows(origin string) bool {
+	origin = normalizeOrigin(origin)
+	if origin == "" {
+		return false
+	}
+
+	for _, allowed := range p.origins {
+		if allowed == origin || matchesWildcard(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsRequest reports whether a request may be served given its Origin header.
+// Requests without an Origin header come from non-browser clients, and same-origin
+// requests cannot be cross-site, so both are always allowed.
+func (p OriginPolicy) AllowsRequest(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return p.Allows(origin)
+}
+
+// matchesWildcard reports whether origin matches a "scheme://*.domain[:port]" pattern
+func matchesWildcard(pattern, origin string) bool {
+	scheme, rest, ok := strings.Cut(pattern, "://*.")
+	if !ok {
+		return false
+	}
+	prefix := scheme + "://"
+	if !strings.HasPrefix(origin, prefix) {
+		return false
+	}
+
+	host := strings.TrimPrefix(origin, prefix)
+	subdomain, found := strings.CutSuffix(host, "."+rest)
+	return found && subdomain != "" && !strings.ContainsAny(subdomain, "/:@")
+}
+
+// normalizeOrigin lowercases an origin and strips any trailing slash
+func normalizeOrigin(origin string) string {
+	return strings.ToLower(strings.TrimRight(strings.TrimSpace(origin), "/"))
+}
+
+// defaultOrigins returns the origins allowed when ALLOWED_ORIGINS is not set: the public
+// URL of the API, plus the usual local front-end addresses outside of production
+func defaultOrigins(environment, baseURL string) []string {
+	origins := []string{baseURL}
+	if environment != "production" {
+		origins = append(origins,
+			"http://localhost:3000",
+			"http://localhost:8080",
+			"http://127.0.0.1:8080",
+		)
+	}
+	return origins
+}
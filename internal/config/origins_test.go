@@ -0,0 +1,76 @@
+package config
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOriginPolicy_Allows(t *testing.T) {
+	policy := NewOriginPolicy([]string{"https://chat.example.com/", "HTTPS://*.Example.org", "http://localhost:3000", " "})
+
+	tests := []struct {
+		name   string
+		origin string
+		want   bool
+	}{
+		{name: "exact origin", origin: "https://chat.example.com", want: true},
+		{name: "exact origin with different case", origin: "https://CHAT.example.com", want: true},
+		{name: "different scheme", origin: "http://chat.example.com", want: false},
+		{name: "different port", origin: "http://localhost:3001", want: false},
+		{name: "wildcard subdomain", origin: "https://app.example.org", want: true},
+		{name: "nested wildcard subdomain", origin: "https://eu.app.example.org", want: true},
+		{name: "wildcard apex", origin: "https://example.org", want: false},
+		{name: "suffix of another domain", origin: "https://evilexample.org", want: false},
+		{name: "wildcard with port", origin: "https://app.example.org:8443", want: false},
+		{name: "wildcard with wrong scheme", origin: "http://app.example.org", want: false},
+		{name: "empty origin", origin: "", want: false},
+		{name: "null origin", origin: "null", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.Allows(tt.origin); got != tt.want {
+				t.Errorf("Allows(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOriginPolicy_AllowsRequest(t *testing.T) {
+	policy := NewOriginPolicy([]string{"https://chat.example.com"})
+
+	tests := []struct {
+		name   string
+		origin string
+		want   bool
+	}{
+		{name: "no origin header", origin: "", want: true},
+		{name: "same origin", origin: "http://api.internal:8080", want: true},
+		{name: "allowed origin", origin: "https://chat.example.com", want: true},
+		{name: "cross-site origin", origin: "https://evil.example.org", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://api.internal:8080/api/ws/connect", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if got := policy.AllowsRequest(req); got != tt.want {
+				t.Errorf("AllowsRequest() with origin %q = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultOrigins(t *testing.T) {
+	production := NewOriginPolicy(defaultOrigins("production", "https://chat.example.com"))
+	if !production.Allows("https://chat.example.com") || production.Allows("http://localhost:3000") {
+		t.Errorf("production defaults = %v, want only the base URL", production.Origins())
+	}
+
+	development := NewOriginPolicy(defaultOrigins("development", "http://localhost:8080"))
+	if !development.Allows("http://localhost:3000") {
+		t.Errorf("development defaults = %v, want local front-end origins", development.Origins())
+	}
+}
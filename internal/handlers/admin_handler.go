@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"go-chat-api/internal/middleware"
+	"go-chat-api/internal/models"
+	"go-chat-api/internal/services"
+	"go-chat-api/internal/websocket"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// AdminHandler handles administrative HTTP requests
+type AdminHandler struct {
+	chatService *services.ChatService
+	hub         *websocket.Hub // WebSocket hub whose connections are closed when users are signed out
+}
+
+// NewAdminHandler creates a new admin handler with injected dependencies
+func NewAdminHandler(chatService *services.ChatService, hub *websocket.Hub) *AdminHandler {
+	return &AdminHandler{
+		chatService: chatService,
+		hub:         hub,
+	}
+}
+
+// ListUsers handles GET /api/admin/users
+func (h *AdminHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := models.UserFilter{
+		Query: query.Get("q"),
+		Role:  query.Get("role"),
+	}
+
+	var err error
+	if filter.Suspended, err = parseBoolParam(query.Get("suspended")); err != nil {
+		http.Error(w, "Invalid suspended parameter", http.StatusBadRequest)
+		return
+	}
+	if filter.IsBot, err = parseBoolParam(query.Get("bot")); err != nil {
+		http.Error(w, "Invalid bot parameter", http.StatusBadRequest)
+		return
+	}
+	if filter.Verified, err = parseBoolParam(query.Get("verified")); err != nil {
+		http.Error(w, "Invalid verified parameter", http.StatusBadRequest)
+		return
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 1 {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	if offset := query.Get("offset"); offset != "" {
+		if filter.Offset, err = strconv.Atoi(offset); err != nil || filter.Offset < 0 {
+			http.Error(w, "Invalid offset parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	users, total, err := h.chatService.SearchUsers(filter)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidRole) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.UserListResponse{Users: users, Total: total})
+}
+
+// SuspendUser handles POST /api/admin/users/{userId}/suspend
+func (h *AdminHandler) SuspendUser(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	// The reason is optional, so an empty body is accepted
+	var req models.SuspendUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	userID := mux.Vars(r)["userId"]
+
+	err := h.chatService.SuspendUser(actorID, userID, strings.TrimSpace(req.Reason), middleware.ClientIP(r))
+	if err != nil {
+		writeModerationError(w, err)
+		return
+	}
+	disconnectWithBots(h.hub, h.chatService, userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+// UnsuspendUser handles POST /api/admin/users/{userId}/unsuspend
+func (h *AdminHandler) UnsuspendUser(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	userID := mux.Vars(r)["userId"]
+
+	err := h.chatService.UnsuspendUser(actorID, userID, middleware.ClientIP(r))
+	if err != nil {
+		writeModerationError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+// ForceLogout handles POST /api/admin/users/{userId}/logout
+func (h *AdminHandler) ForceLogout(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	userID := mux.Vars(r)["userId"]
+
+	err := h.chatService.ForceLogout(actorID, userID, middleware.ClientIP(r))
+	if err != nil {
+		writeModerationError(w, err)
+		return
+	}
+	h.disconnect(userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+// ResetUserPassword handles POST /api/admin/users/{userId}/password-reset
+func (h *AdminHandler) ResetUserPassword(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	userID := mux.Vars(r)["userId"]
+
+	err := h.chatService.AdminResetPassword(actorID, userID, middleware.ClientIP(r))
+	if err != nil {
+		if strings.Contains(err.Error(), "no email address") {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeModerationError(w, err)
+		return
+	}
+	h.disconnect(userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+// DeleteUser handles DELETE /api/admin/users/{userId}
+func (h *AdminHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	userID := mux.Vars(r)["userId"]
+
+	// The user's bots are deleted with the account, so list them first
+	bots := ownedBotIDs(h.chatService, userID)
+
+	err := h.chatService.DeleteUser(actorID, userID, middleware.ClientIP(r))
+	if err != nil {
+		writeModerationError(w, err)
+		return
+	}
+	if h.hub != nil {
+		h.hub.DisconnectUser(userID)
+		for _, botID := range bots {
+			h.hub.DisconnectUser(botID)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListAuditEvents handles GET /api/admin/audit
+func (h *AdminHandler) ListAuditEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := models.AuditFilter{
+		Action:   query.Get("action"),
+		ActorID:  query.Get("actor"),
+		TargetID: query.Get("target"),
+		IP:       query.Get("ip"),
+	}
+
+	var err error
+	if since := query.Get("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			http.Error(w, "Invalid since parameter (expected RFC 3339 time)", http.StatusBadRequest)
+			return
+		}
+	}
+	if until := query.Get("until"); until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			http.Error(w, "Invalid until parameter (expected RFC 3339 time)", http.StatusBadRequest)
+			return
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 1 {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	if offset := query.Get("offset"); offset != "" {
+		if filter.Offset, err = strconv.Atoi(offset); err != nil || filter.Offset < 0 {
+			http.Error(w, "Invalid offset parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, total, err := h.chatService.SearchAuditEvents(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.AuditLogResponse{Events: events, Total: total})
+}
+
+// VerifyAuditLog handles GET /api/admin/audit/verify
+func (h *AdminHandler) VerifyAuditLog(w http.ResponseWriter, r *http.Request) {
+	result, err := h.chatService.VerifyAuditLog()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// disconnect closes the live WebSocket connections of a signed-out user
+func (h *AdminHandler) disconnect(userID string) {
+	if h.hub != nil {
+		h.hub.DisconnectUser(userID)
+	}
+}
+
+// disconnectWithBots closes the live WebSocket connections of a suspended user and of the
+// bots they manage
+func disconnectWithBots(hub *websocket.Hub, chatService *services.ChatService, userID string) {
+	if hub == nil {
+		return
+	}
+	hub.DisconnectUser(userID)
+
+	for _, botID := range ownedBotIDs(chatService, userID) {
+		hub.DisconnectUser(botID)
+	}
+}
+
+// ownedBotIDs returns the IDs of the bots a user manages, or none when they cannot be listed
+func ownedBotIDs(chatService *services.ChatService, userID string) []string {
+	bots, err := chatService.GetBots(userID)
+	if err != nil {
+		log.Printf("Failed to list bots of user %s: %v", userID, err)
+		return nil
+	}
+	ids := make([]string, len(bots))
+	for i, bot := range bots {
+		ids[i] = bot.ID
+	}
+	return ids
+}
+
+// writeModerationError maps errors from user moderation actions to HTTP responses
+func writeModerationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrOwnAccountModeration), errors.Is(err, services.ErrProtectedAccount):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case strings.Contains(err.Error(), "not found"):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// parseBoolParam parses an optional boolean query parameter
+func parseBoolParam(value string) (*bool, error) {
+	if value == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// UnlockUser handles POST /api/admin/users/{userId}/unlock
+func (h *AdminHandler) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	userID := mux.Vars(r)["userId"]
+
+	err := h.chatService.UnlockUser(actorID, userID, middleware.ClientIP(r))
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+// UpdateUserRole handles PUT /api/admin/users/{userId}/role
+func (h *AdminHandler) UpdateUserRole(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.UpdateRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	userID := mux.Vars(r)["userId"]
+
+	err := h.chatService.SetUserRole(actorID, userID, req.Role, middleware.ClientIP(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidRole), errors.Is(err, services.ErrOwnRoleChange):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case strings.Contains(err.Error(), "not found"):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case strings.Contains(err.Error(), "bots cannot"):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	// Connections opened with the old role's tokens are closed with their sessions
+	h.disconnect(userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success", "role": req.Role})
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"go-chat-api/internal/audit"
+	"go-chat-api/internal/auth"
+	"go-chat-api/internal/models"
+	"go-chat-api/internal/services"
+	"go-chat-api/internal/storage"
+	"go-chat-api/internal/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestAdminHandler(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour, auth.WithSessionStore(store), auth.WithUserStore(store))
+	chatService := services.NewChatService(store, store, store, authService, services.WithAuditLogger(audit.NewLogger(store)))
+	hub := websocket.NewHub()
+	go hub.Run()
+	handler := NewAdminHandler(chatService, hub)
+	authHandler := NewAuthHandler(chatService, nil)
+
+	admin, _ := chatService.RegisterUser(models.RegisterRequest{Username: "admin", Email: "admin@example.com", Password: "password123"})
+	user, _ := chatService.RegisterUser(models.RegisterRequest{Username: "testuser", Email: "test@example.com", Password: "password123"})
+	chatService.BootstrapAdmins([]string{admin.ID})
+
+	asAdmin := func(method, target, userID, body string) *http.Request {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"userId": userID})
+		return req.WithContext(context.WithValue(req.Context(), "userID", admin.ID))
+	}
+
+	t.Run("list users", func(t *testing.T) {
+		tests := []struct {
+			name           string
+			query          string
+			expectedStatus int
+			expectedTotal  int
+		}{
+			{name: "all users", query: "", expectedStatus: http.StatusOK, expectedTotal: 2},
+			{name: "search", query: "?q=test", expectedStatus: http.StatusOK, expectedTotal: 1},
+			{name: "role filter", query: "?role=admin", expectedStatus: http.StatusOK, expectedTotal: 1},
+			{name: "unknown role", query: "?role=superuser", expectedStatus: http.StatusBadRequest},
+			{name: "invalid boolean", query: "?suspended=maybe", expectedStatus: http.StatusBadRequest},
+			{name: "invalid limit", query: "?limit=0", expectedStatus: http.StatusBadRequest},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				rr := httptest.NewRecorder()
+				handler.ListUsers(rr, httptest.NewRequest(http.MethodGet, "/api/admin/users"+tt.query, nil))
+
+				if rr.Code != tt.expectedStatus {
+					t.Fatalf("ListUsers() status = %v, want %v: %s", rr.Code, tt.expectedStatus, rr.Body.String())
+				}
+				if rr.Code == http.StatusOK {
+					var response models.UserListResponse
+					json.NewDecoder(rr.Body).Decode(&response)
+					if response.Total != tt.expectedTotal || len(response.Users) != tt.expectedTotal {
+						t.Errorf("ListUsers() = %d users of %d, want %d", len(response.Users), response.Total, tt.expectedTotal)
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("suspend", func(t *testing.T) {
+		tests := []struct {
+			name           string
+			userID         string
+			body           string
+			expectedStatus int
+		}{
+			{name: "own account", userID: admin.ID, expectedStatus: http.StatusForbidden},
+			{name: "unknown user", userID: "missing", expectedStatus: http.StatusNotFound},
+			{name: "invalid body", userID: user.ID, body: "{", expectedStatus: http.StatusBadRequest},
+			{name: "without reason", userID: user.ID, expectedStatus: http.StatusOK},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				rr := httptest.NewRecorder()
+				handler.SuspendUser(rr, asAdmin(http.MethodPost, "/api/admin/users/"+tt.userID+"/suspend", tt.userID, tt.body))
+
+				if rr.Code != tt.expectedStatus {
+					t.Errorf("SuspendUser() status = %v, want %v: %s", rr.Code, tt.expectedStatus, rr.Body.String())
+				}
+			})
+		}
+
+		rr := httptest.NewRecorder()
+		authHandler.Login(rr, httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(`{"username":"testuser","password":"password123"}`)))
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("Login() while suspended status = %v, want %v", rr.Code, http.StatusForbidden)
+		}
+
+		rr = httptest.NewRecorder()
+		handler.UnsuspendUser(rr, asAdmin(http.MethodPost, "/api/admin/users/"+user.ID+"/unsuspend", user.ID, ""))
+		if rr.Code != http.StatusOK {
+			t.Errorf("UnsuspendUser() status = %v, want %v", rr.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("force logout", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ForceLogout(rr, asAdmin(http.MethodPost, "/api/admin/users/"+user.ID+"/logout", user.ID, ""))
+		if rr.Code != http.StatusOK {
+			t.Errorf("ForceLogout() status = %v, want %v", rr.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("audit log", func(t *testing.T) {
+		tests := []struct {
+			name           string
+			query          string
+			expectedStatus int
+			expectedTotal  int
+		}{
+			{name: "by action", query: "?action=" + audit.ActionUserSuspended, expectedStatus: http.StatusOK, expectedTotal: 1},
+			{name: "by actor", query: "?actor=" + admin.ID + "&target=" + user.ID, expectedStatus: http.StatusOK, expectedTotal: 3},
+			{name: "in the future", query: "?since=" + time.Now().Add(time.Hour).Format(time.RFC3339), expectedStatus: http.StatusOK, expectedTotal: 0},
+			{name: "invalid time", query: "?until=yesterday", expectedStatus: http.StatusBadRequest},
+			{name: "invalid offset", query: "?offset=-1", expectedStatus: http.StatusBadRequest},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				rr := httptest.NewRecorder()
+				handler.ListAuditEvents(rr, httptest.NewRequest(http.MethodGet, "/api/admin/audit"+tt.query, nil))
+
+				if rr.Code != tt.expectedStatus {
+					t.Fatalf("ListAuditEvents() status = %v, want %v: %s", rr.Code, tt.expectedStatus, rr.Body.String())
+				}
+				if rr.Code == http.StatusOK {
+					var response models.AuditLogResponse
+					json.NewDecoder(rr.Body).Decode(&response)
+					if response.Total != tt.expectedTotal {
+						t.Errorf("ListAuditEvents() total = %d, want %d", response.Total, tt.expectedTotal)
+					}
+				}
+			})
+		}
+
+		rr := httptest.NewRecorder()
+		handler.VerifyAuditLog(rr, httptest.NewRequest(http.MethodGet, "/api/admin/audit/verify", nil))
+		var result models.AuditVerification
+		json.NewDecoder(rr.Body).Decode(&result)
+		if rr.Code != http.StatusOK || !result.Valid {
+			t.Errorf("VerifyAuditLog() = %v %+v, want a valid chain", rr.Code, result)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.DeleteUser(rr, asAdmin(http.MethodDelete, "/api/admin/users/"+user.ID, user.ID, ""))
+		if rr.Code != http.StatusNoContent {
+			t.Errorf("DeleteUser() status = %v, want %v", rr.Code, http.StatusNoContent)
+		}
+
+		rr = httptest.NewRecorder()
+		handler.DeleteUser(rr, asAdmin(http.MethodDelete, "/api/admin/users/"+user.ID, user.ID, ""))
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("DeleteUser() twice status = %v, want %v", rr.Code, http.StatusNotFound)
+		}
+	})
+}
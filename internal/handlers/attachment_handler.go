@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"go-chat-api/internal/services"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// multipartOverhead allows for the multipart framing around an uploaded file
+const multipartOverhead = 64 << 10
+
+// AttachmentHandler handles file uploads and downloads
+type AttachmentHandler struct {
+	chatService *services.ChatService
+}
+
+// NewAttachmentHandler creates a new attachment handler with injected dependencies
+func NewAttachmentHandler(chatService *services.ChatService) *AttachmentHandler {
+	return &AttachmentHandler{
+		chatService: chatService,
+	}
+}
+
+// UploadAttachment handles POST /api/attachments, a multipart/form-data upload with the
+// file in the "file" field
+func (h *AttachmentHandler) UploadAttachment(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.chatService.AttachmentSizeLimit()+multipartOverhead)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Expected a multipart/form-data upload", http.StatusBadRequest)
+		return
+	}
+
+	// Stream the file part instead of buffering the whole form
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			http.Error(w, `Missing "file" field`, http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			writeAttachmentError(w, err)
+			return
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		attachment, err := h.chatService.UploadAttachment(userID, part.FileName(), part)
+		part.Close()
+		if err != nil {
+			writeAttachmentError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(attachment)
+		return
+	}
+}
+
+// GetAttachment handles GET /api/attachments/{attachmentId}, returning the attachment
+// with fresh download links
+func (h *AttachmentHandler) GetAttachment(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("userID").(string)
+
+	attachment, err := h.chatService.GetAttachment(userID, mux.Vars(r)["attachmentId"])
+	if err != nil {
+		writeAttachmentError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(attachment)
+}
+
+// DownloadAttachment handles GET /api/attachments/{attachmentId}/{variant}. The request
+// is authorized by the expiring signature in the link rather than by a token, so the
+// links work in image tags and browser downloads.
+func (h *AttachmentHandler) DownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	query := r.URL.Query()
+
+	attachment, content, err := h.chatService.OpenAttachment(vars["attachmentId"], vars["variant"], query.Get("expires"), query.Get("signature"))
+	if err != nil {
+		writeAttachmentError(w, err)
+		return
+	}
+	defer content.Close()
+
+	contentType := attachment.ContentType
+	size := attachment.Size
+	if vars["variant"] == services.AttachmentThumbnail {
+		contentType = "image/png"
+		size = -1
+	}
+
+	// Images are shown inline; anything else is always downloaded, and the browser
+	// must not second-guess the sniffed type
+	disposition := "attachment"
+	if strings.HasPrefix(contentType, "image/") {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	if size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+
+	if _, err := io.Copy(w, content); err != nil {
+		log.Printf("Failed to send attachment %s: %v", attachment.ID, err)
+	}
+}
+
+// writeAttachmentError maps attachment errors to HTTP status codes
+func writeAttachmentError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, services.ErrAttachmentTooLarge), errors.As(err, &maxBytesErr):
+		http.Error(w, services.ErrAttachmentTooLarge.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, services.ErrAttachmentType):
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+	case errors.Is(err, services.ErrInvalidAttachments):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrInvalidAttachmentURL):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, services.ErrAttachmentNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrAttachmentsDisabled):
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"go-chat-api/internal/auth"
+	"go-chat-api/internal/models"
+	"go-chat-api/internal/services"
+	"go-chat-api/internal/storage"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestAttachmentHandler(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour)
+	chatService := services.NewChatService(store, store, store, authService,
+		services.WithAttachments(store, store, services.AttachmentPolicy{
+			MaxBytes:     1024,
+			AllowedTypes: []string{"text/plain"},
+			URLSecret:    []byte("url-secret"),
+			URLTTL:       time.Minute,
+		}),
+	)
+	handler := NewAttachmentHandler(chatService)
+
+	user, _ := chatService.RegisterUser(models.RegisterRequest{Username: "testuser", Email: "test@example.com", Password: "password123"})
+
+	// upload posts a multipart form with the given file field
+	upload := func(field, filename string, content []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		form.WriteField("comment", "ignored")
+		part, _ := form.CreateFormFile(field, filename)
+		part.Write(content)
+		form.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/api/attachments", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		req = req.WithContext(context.WithValue(req.Context(), "userID", user.ID))
+		rr := httptest.NewRecorder()
+		handler.UploadAttachment(rr, req)
+		return rr
+	}
+
+	t.Run("upload", func(t *testing.T) {
+		tests := []struct {
+			name           string
+			field          string
+			content        []byte
+			expectedStatus int
+		}{
+			{name: "missing file field", field: "document", content: []byte("notes"), expectedStatus: http.StatusBadRequest},
+			{name: "too large", field: "file", content: bytes.Repeat([]byte("a"), 1025), expectedStatus: http.StatusRequestEntityTooLarge},
+			{name: "body over the limit", field: "file", content: bytes.Repeat([]byte("a"), 128<<10), expectedStatus: http.StatusRequestEntityTooLarge},
+			{name: "disallowed type", field: "file", content: []byte("%PDF-1.4 fake"), expectedStatus: http.StatusUnsupportedMediaType},
+			{name: "valid", field: "file", content: []byte("meeting notes"), expectedStatus: http.StatusCreated},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if rr := upload(tt.field, "notes.txt", tt.content); rr.Code != tt.expectedStatus {
+					t.Errorf("UploadAttachment() status = %v, want %v: %s", rr.Code, tt.expectedStatus, rr.Body.String())
+				}
+			})
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/api/attachments", strings.NewReader(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		req = req.WithContext(context.WithValue(req.Context(), "userID", user.ID))
+		rr := httptest.NewRecorder()
+		handler.UploadAttachment(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("UploadAttachment() of a JSON body status = %v, want %v", rr.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("download", func(t *testing.T) {
+		rr := upload("file", "notes.txt", []byte("meeting notes"))
+		var attachment models.Attachment
+		if err := json.NewDecoder(rr.Body).Decode(&attachment); err != nil {
+			t.Fatalf("UploadAttachment() response: %v", err)
+		}
+		link, _ := url.Parse(attachment.URL)
+
+		download := func(query string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, link.Path+"?"+query, nil)
+			req = mux.SetURLVars(req, map[string]string{"attachmentId": attachment.ID, "variant": services.AttachmentContent})
+			rr := httptest.NewRecorder()
+			handler.DownloadAttachment(rr, req)
+			return rr
+		}
+
+		if rr := download(strings.Replace(link.RawQuery, "signature=", "signature=0", 1)); rr.Code != http.StatusForbidden {
+			t.Errorf("DownloadAttachment() with a tampered signature status = %v, want %v", rr.Code, http.StatusForbidden)
+		}
+
+		rr = download(link.RawQuery)
+		if rr.Code != http.StatusOK || rr.Body.String() != "meeting notes" {
+			t.Fatalf("DownloadAttachment() = %v %q, want the content", rr.Code, rr.Body.String())
+		}
+		if got := rr.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+			t.Errorf("X-Content-Type-Options = %q, want nosniff", got)
+		}
+		if got := rr.Header().Get("Content-Disposition"); got != `attachment; filename=notes.txt` {
+			t.Errorf("Content-Disposition = %q, want a download", got)
+		}
+	})
+}
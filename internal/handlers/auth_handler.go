@@ -2,21 +2,32 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"go-chat-api/internal/auth"
+	"go-chat-api/internal/middleware"
 	"go-chat-api/internal/models"
 	"go-chat-api/internal/services"
+	"go-chat-api/internal/websocket"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+
+	"github.com/gorilla/mux"
 )
 
 // AuthHandler handles authentication-related HTTP requests
 type AuthHandler struct {
 	chatService *services.ChatService
+	hub         *websocket.Hub // WebSocket hub whose connections are closed when sessions end
 }
 
 // NewAuthHandler creates a new auth handler with injected dependencies
-func NewAuthHandler(chatService *services.ChatService) *AuthHandler {
+func NewAuthHandler(chatService *services.ChatService, hub *websocket.Hub) *AuthHandler {
 	return &AuthHandler{
 		chatService: chatService,
+		hub:         hub,
 	}
 }
 
@@ -41,6 +52,10 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 
 	user, err := h.chatService.RegisterUser(req)
 	if err != nil {
+		if errors.Is(err, services.ErrInvalidEmail) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		if strings.Contains(err.Error(), "already exists") {
 			http.Error(w, err.Error(), http.StatusConflict)
 			return
@@ -66,24 +81,27 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Username and password are required", http.StatusBadRequest)
 		return
 	}
+	req.ClientIP = middleware.ClientIP(r)
 
 	authResponse, err := h.chatService.AuthenticateUser(req)
 	if err != nil {
+		var lockedErr *services.LoginLockedError
+		if errors.As(err, &lockedErr) {
+			retryAfter := int(math.Ceil(lockedErr.RetryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		if errors.Is(err, services.ErrAccountSuspended) {
+			http.Error(w, "Account suspended", http.StatusForbidden)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
 
 	// Set JWT token as HTTP-only cookie
-	cookie := &http.Cookie{
-		Name:     "jwt_token",
-		Value:    authResponse.Token,
-		Path:     "/",
-		MaxAge:   24 * 60 * 60, // 24 hours in seconds
-		HttpOnly: true,         // Prevents XSS attacks
-		Secure:   false,        // Set to true in production with HTTPS
-		SameSite: http.SameSiteLaxMode,
-	}
-	http.SetCookie(w, cookie)
+	setAuthCookie(w, authResponse.Token)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(authResponse)
@@ -105,6 +123,12 @@ func (h *AuthHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		tokenString = cookie.Value
+
+		// The browser sends the cookie on its own, so it must be paired with the CSRF token
+		if !middleware.ValidCSRFToken(r) {
+			http.Error(w, "Invalid or missing CSRF token", http.StatusForbidden)
+			return
+		}
 	}
 
 	if tokenString == "" {
@@ -112,16 +136,34 @@ func (h *AuthHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	authResponse, err := h.chatService.RefreshToken(tokenString)
+	// The old token's session is revoked by the refresh, so note it first
+	claims, err := h.chatService.AuthenticateToken(tokenString)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
 
+	authResponse, err := h.chatService.RefreshToken(tokenString, middleware.ClientIP(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if h.hub != nil {
+		h.hub.DisconnectSession(claims.ID)
+	}
+
 	// Update the JWT cookie with the new token
+	setAuthCookie(w, authResponse.Token)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(authResponse)
+}
+
+// setAuthCookie stores a JWT in the HTTP-only cookie used by browser clients
+func setAuthCookie(w http.ResponseWriter, token string) {
 	cookie := &http.Cookie{
 		Name:     "jwt_token",
-		Value:    authResponse.Token,
+		Value:    token,
 		Path:     "/",
 		MaxAge:   24 * 60 * 60, // 24 hours in seconds
 		HttpOnly: true,         // Prevents XSS attacks
@@ -129,9 +171,6 @@ func (h *AuthHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 		SameSite: http.SameSiteLaxMode,
 	}
 	http.SetCookie(w, cookie)
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(authResponse)
 }
 
 // Logout handles POST /api/auth/logout
@@ -143,11 +182,16 @@ func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := h.chatService.LogoutUser(userID)
+	sessionID, _ := r.Context().Value("sessionID").(string)
+
+	err := h.chatService.LogoutUser(userID, sessionID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if h.hub != nil {
+		h.hub.DisconnectSession(sessionID)
+	}
 
 	// Clear the JWT cookie
 	cookie := &http.Cookie{
@@ -165,6 +209,33 @@ func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"message": "Logged out successfully"})
 }
 
+// CSRFToken handles GET /api/auth/csrf. It issues a new CSRF token in the csrf_token cookie
+// and returns it for cookie-authenticated clients to echo in the X-CSRF-Token header. An
+// existing cookie is never reused, since it may have been planted by another site.
+func (h *AuthHandler) CSRFToken(w http.ResponseWriter, r *http.Request) {
+	token, _, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		http.Error(w, "Failed to generate CSRF token", http.StatusInternalServerError)
+		return
+	}
+
+	// Readable by scripts so they can copy it into the header; other sites cannot read it
+	cookie := &http.Cookie{
+		Name:     middleware.CSRFCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   24 * 60 * 60, // 24 hours in seconds
+		HttpOnly: false,
+		Secure:   false, // Set to true in production with HTTPS
+		SameSite: http.SameSiteLaxMode,
+	}
+	http.SetCookie(w, cookie)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(models.CSRFTokenResponse{CSRFToken: token})
+}
+
 // GetProfile handles GET /api/auth/profile
 func (h *AuthHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
 	// Get user ID from context (set by auth middleware)
@@ -183,3 +254,288 @@ func (h *AuthHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(user)
 }
+
+// ChangePassword handles POST /api/auth/password
+func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+	sessionID, _ := r.Context().Value("sessionID").(string)
+
+	var req models.ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.CurrentPassword == "" || req.NewPassword == "" {
+		http.Error(w, "Current password and new password are required", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.NewPassword) < 6 {
+		http.Error(w, "Password must be at least 6 characters", http.StatusBadRequest)
+		return
+	}
+
+	err := h.chatService.ChangePassword(userID, sessionID, req)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidCurrentPassword) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if h.hub != nil {
+		// Other sessions were revoked along with the old password
+		h.hub.DisconnectUserSessions(userID, sessionID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password changed successfully"})
+}
+
+// ForgotPassword handles POST /api/auth/password/forgot
+func (h *AuthHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req models.ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Email == "" {
+		http.Error(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+
+	// Respond identically whether or not the email is registered, and whether or not the
+	// email could be sent, so the response never reveals which addresses have accounts
+	if err := h.chatService.RequestPasswordReset(req.Email); err != nil {
+		log.Printf("Failed to process password reset request: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"message": "If the email is registered, a reset link has been sent"})
+}
+
+// ResetPassword handles POST /api/auth/password/reset
+func (h *AuthHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req models.ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Token == "" || req.NewPassword == "" {
+		http.Error(w, "Token and new password are required", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.NewPassword) < 6 {
+		http.Error(w, "Password must be at least 6 characters", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := h.chatService.ResetPassword(req)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidResetToken) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if h.hub != nil {
+		h.hub.DisconnectUser(userID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password has been reset"})
+}
+
+// VerifyEmail handles GET /api/auth/verify?token=
+func (h *AuthHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "Token is required", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.chatService.VerifyEmail(token)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidVerificationToken) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Email verified successfully",
+		"user":    user,
+	})
+}
+
+// ResendVerification handles POST /api/auth/verify/resend
+func (h *AuthHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.chatService.SendVerificationEmail(userID); err != nil {
+		http.Error(w, "Failed to send verification email", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Verification email sent if the address is not yet verified"})
+}
+
+// CreateAPIKey handles POST /api/auth/keys
+func (h *AuthHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Name == "" || len(req.Scopes) == 0 {
+		http.Error(w, "Name and scopes are required", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := h.chatService.CreateAPIKey(userID, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidScope), errors.Is(err, services.ErrInvalidAPIKeyExpiry):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, services.ErrBotNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ListAPIKeys handles GET /api/auth/keys
+func (h *AuthHandler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	keys, err := h.chatService.ListAPIKeys(userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// RevokeAPIKey handles DELETE /api/auth/keys/{keyId}
+func (h *AuthHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	keyID := mux.Vars(r)["keyId"]
+
+	if err := h.chatService.RevokeAPIKey(userID, keyID); err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "API key revoked"})
+}
+
+// CreateBot handles POST /api/auth/keys/bots
+func (h *AuthHandler) CreateBot(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.CreateBotRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Username == "" {
+		http.Error(w, "Username is required", http.StatusBadRequest)
+		return
+	}
+
+	bot, err := h.chatService.CreateBot(userID, req)
+	if err != nil {
+		if strings.Contains(err.Error(), "already exists") {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(bot)
+}
+
+// ListBots handles GET /api/auth/keys/bots
+func (h *AuthHandler) ListBots(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	bots, err := h.chatService.GetBots(userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if bots == nil {
+		bots = []models.User{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bots)
+}
@@ -4,14 +4,22 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"go-chat-api/internal/auth"
+	"go-chat-api/internal/mailer"
+	"go-chat-api/internal/middleware"
 	"go-chat-api/internal/models"
 	"go-chat-api/internal/services"
 	"go-chat-api/internal/storage"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/gorilla/mux"
 )
 
 func setupTestAuthHandler() (*AuthHandler, *services.ChatService) {
@@ -25,7 +33,7 @@ func setupTestAuthHandler() (*AuthHandler, *services.ChatService) {
 	chatService := services.NewChatService(store, store, store, authService)
 
 	// Create auth handler
-	authHandler := NewAuthHandler(chatService)
+	authHandler := NewAuthHandler(chatService, nil)
 
 	return authHandler, chatService
 }
@@ -59,6 +67,16 @@ func TestAuthHandler_Register(t *testing.T) {
 			expectedStatus: http.StatusConflict,
 			expectUser:     false,
 		},
+		{
+			name: "invalid email",
+			requestBody: models.RegisterRequest{
+				Username: "bademail",
+				Email:    "not-an-email",
+				Password: "password123",
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectUser:     false,
+		},
 		{
 			name: "short password",
 			requestBody: models.RegisterRequest{
@@ -330,6 +348,73 @@ func TestAuthHandler_RefreshToken(t *testing.T) {
 	}
 }
 
+func TestAuthHandler_RefreshTokenCookie(t *testing.T) {
+	// Tokens are issued within the refresh window, so refreshing them succeeds
+	store := storage.NewInMemoryStorage()
+	chatService := services.NewChatService(store, store, store, auth.NewAuthService("test-secret", 10*time.Minute))
+	handler := NewAuthHandler(chatService, nil)
+
+	if _, err := chatService.RegisterUser(models.RegisterRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	}); err != nil {
+		t.Fatalf("Failed to register test user: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		csrfCookie     string
+		csrfHeader     string
+		expectedStatus int
+	}{
+		{
+			name:           "missing CSRF token",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "mismatched CSRF token",
+			csrfCookie:     "csrf-token",
+			csrfHeader:     "other-token",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "matching CSRF token",
+			csrfCookie:     "csrf-token",
+			csrfHeader:     "csrf-token",
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authResponse, err := chatService.AuthenticateUser(models.AuthRequest{
+				Username: "testuser",
+				Password: "password123",
+			})
+			if err != nil {
+				t.Fatalf("Failed to authenticate test user: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/auth/refresh", nil)
+			req.AddCookie(&http.Cookie{Name: "jwt_token", Value: authResponse.Token})
+			if tt.csrfCookie != "" {
+				req.AddCookie(&http.Cookie{Name: middleware.CSRFCookieName, Value: tt.csrfCookie})
+			}
+			if tt.csrfHeader != "" {
+				req.Header.Set(middleware.CSRFHeaderName, tt.csrfHeader)
+			}
+
+			rr := httptest.NewRecorder()
+			handler.RefreshToken(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("RefreshToken() status = %v, want %v: %s", rr.Code, tt.expectedStatus, rr.Body.String())
+			}
+		})
+	}
+}
+
 func TestAuthHandler_Logout(t *testing.T) {
 	handler, chatService := setupTestAuthHandler()
 
@@ -395,6 +480,51 @@ func TestAuthHandler_Logout(t *testing.T) {
 	}
 }
 
+func TestAuthHandler_CSRFToken(t *testing.T) {
+	handler, _ := setupTestAuthHandler()
+
+	// csrfCookie returns the csrf_token cookie set by the response
+	csrfCookie := func(rr *httptest.ResponseRecorder) *http.Cookie {
+		for _, cookie := range rr.Result().Cookies() {
+			if cookie.Name == middleware.CSRFCookieName {
+				return cookie
+			}
+		}
+		return nil
+	}
+
+	rr := httptest.NewRecorder()
+	handler.CSRFToken(rr, httptest.NewRequest(http.MethodGet, "/api/auth/csrf", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("CSRFToken() status = %v, want %v", rr.Code, http.StatusOK)
+	}
+
+	var response models.CSRFTokenResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	cookie := csrfCookie(rr)
+	if response.CSRFToken == "" || cookie == nil || cookie.Value != response.CSRFToken {
+		t.Fatalf("CSRFToken() token %q does not match cookie %v", response.CSRFToken, cookie)
+	}
+	if cookie.HttpOnly {
+		t.Error("CSRFToken() cookie must be readable by scripts")
+	}
+
+	// An existing cookie, which another site may have planted, is replaced
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/csrf", nil)
+	req.AddCookie(&http.Cookie{Name: middleware.CSRFCookieName, Value: "planted"})
+	rr = httptest.NewRecorder()
+	handler.CSRFToken(rr, req)
+
+	var again models.CSRFTokenResponse
+	json.NewDecoder(rr.Body).Decode(&again)
+	if again.CSRFToken == "" || again.CSRFToken == "planted" || again.CSRFToken == response.CSRFToken {
+		t.Errorf("CSRFToken() = %q, want a new token", again.CSRFToken)
+	}
+	if cookie := csrfCookie(rr); cookie == nil || cookie.Value != again.CSRFToken {
+		t.Errorf("CSRFToken() cookie %v does not match token %q", cookie, again.CSRFToken)
+	}
+}
+
 func TestAuthHandler_GetProfile(t *testing.T) {
 	handler, chatService := setupTestAuthHandler()
 
@@ -553,3 +683,332 @@ func TestAuthHandler_Integration_FullFlow(t *testing.T) {
 
 	t.Log("Integration test completed successfully")
 }
+
+func TestAuthHandler_ChangePassword(t *testing.T) {
+	handler, chatService := setupTestAuthHandler()
+
+	user, err := chatService.RegisterUser(models.RegisterRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	})
+	if err != nil {
+		t.Fatalf("Failed to register test user: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		userID         string
+		requestBody    models.ChangePasswordRequest
+		expectedStatus int
+	}{
+		{
+			name:           "missing user context",
+			requestBody:    models.ChangePasswordRequest{CurrentPassword: "password123", NewPassword: "newpassword456"},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "wrong current password",
+			userID:         user.ID,
+			requestBody:    models.ChangePasswordRequest{CurrentPassword: "wrongpassword", NewPassword: "newpassword456"},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "short new password",
+			userID:         user.ID,
+			requestBody:    models.ChangePasswordRequest{CurrentPassword: "password123", NewPassword: "123"},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "valid change",
+			userID:         user.ID,
+			requestBody:    models.ChangePasswordRequest{CurrentPassword: "password123", NewPassword: "newpassword456"},
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body bytes.Buffer
+			json.NewEncoder(&body).Encode(tt.requestBody)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/auth/password", &body)
+			if tt.userID != "" {
+				ctx := context.WithValue(req.Context(), "userID", tt.userID)
+				req = req.WithContext(ctx)
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ChangePassword(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("ChangePassword() status = %v, want %v", rr.Code, tt.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestAuthHandler_ForgotAndResetPassword(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour, auth.WithSessionStore(store))
+	mail := mailer.NewLogMailer(io.Discard)
+	chatService := services.NewChatService(store, store, store, authService,
+		services.WithTokenStore(store),
+		services.WithMailer(mail, "http://localhost:8080"),
+	)
+	handler := NewAuthHandler(chatService, nil)
+
+	_, err := chatService.RegisterUser(models.RegisterRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	})
+	if err != nil {
+		t.Fatalf("Failed to register test user: %v", err)
+	}
+
+	// Registration already sent a verification email
+	sentBefore := len(mail.Sent())
+
+	// Both registered and unknown emails get the same response
+	for _, email := range []string{"test@example.com", "unknown@example.com"} {
+		var body bytes.Buffer
+		json.NewEncoder(&body).Encode(models.ForgotPasswordRequest{Email: email})
+
+		rr := httptest.NewRecorder()
+		handler.ForgotPassword(rr, httptest.NewRequest(http.MethodPost, "/api/auth/password/forgot", &body))
+
+		if rr.Code != http.StatusAccepted {
+			t.Errorf("ForgotPassword(%s) status = %v, want %v", email, rr.Code, http.StatusAccepted)
+		}
+	}
+
+	sent := mail.Sent()[sentBefore:]
+	if len(sent) != 1 {
+		t.Fatalf("ForgotPassword() sent %d emails, want 1", len(sent))
+	}
+	token := sent[0].Body[strings.Index(sent[0].Body, "token=")+len("token="):]
+	token = strings.Fields(token)[0]
+
+	tests := []struct {
+		name           string
+		requestBody    models.ResetPasswordRequest
+		expectedStatus int
+	}{
+		{
+			name:           "invalid token",
+			requestBody:    models.ResetPasswordRequest{Token: "bogus", NewPassword: "newpassword456"},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "valid token",
+			requestBody:    models.ResetPasswordRequest{Token: token, NewPassword: "newpassword456"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "reused token",
+			requestBody:    models.ResetPasswordRequest{Token: token, NewPassword: "anotherpassword"},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body bytes.Buffer
+			json.NewEncoder(&body).Encode(tt.requestBody)
+
+			rr := httptest.NewRecorder()
+			handler.ResetPassword(rr, httptest.NewRequest(http.MethodPost, "/api/auth/password/reset", &body))
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("ResetPassword() status = %v, want %v", rr.Code, tt.expectedStatus)
+			}
+		})
+	}
+}
+
+// failingMailer is a mailer whose deliveries always fail
+type failingMailer struct{}
+
+func (failingMailer) Send(mailer.Message) error {
+	return errors.New("smtp unavailable")
+}
+
+func TestAuthHandler_ForgotPasswordMailFailure(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour, auth.WithSessionStore(store))
+	chatService := services.NewChatService(store, store, store, authService,
+		services.WithTokenStore(store),
+		services.WithMailer(failingMailer{}, "http://localhost:8080"),
+	)
+	handler := NewAuthHandler(chatService, nil)
+
+	chatService.RegisterUser(models.RegisterRequest{Username: "testuser", Email: "test@example.com", Password: "password123"})
+
+	// A failed delivery must not tell registered addresses apart from unknown ones
+	responses := make(map[string]string)
+	for _, email := range []string{"test@example.com", "unknown@example.com"} {
+		var body bytes.Buffer
+		json.NewEncoder(&body).Encode(models.ForgotPasswordRequest{Email: email})
+
+		rr := httptest.NewRecorder()
+		handler.ForgotPassword(rr, httptest.NewRequest(http.MethodPost, "/api/auth/password/forgot", &body))
+		if rr.Code != http.StatusAccepted {
+			t.Errorf("ForgotPassword(%s) status = %v, want %v", email, rr.Code, http.StatusAccepted)
+		}
+		responses[email] = rr.Body.String()
+	}
+	if responses["test@example.com"] != responses["unknown@example.com"] {
+		t.Errorf("ForgotPassword() responses = %v, want the same body for both addresses", responses)
+	}
+}
+
+func TestAuthHandler_VerifyEmail(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour)
+	mail := mailer.NewLogMailer(io.Discard)
+	chatService := services.NewChatService(store, store, store, authService,
+		services.WithTokenStore(store),
+		services.WithMailer(mail, "http://localhost:8080"),
+	)
+	handler := NewAuthHandler(chatService, nil)
+
+	_, err := chatService.RegisterUser(models.RegisterRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	})
+	if err != nil {
+		t.Fatalf("Failed to register test user: %v", err)
+	}
+
+	sent := mail.Sent()
+	if len(sent) != 1 {
+		t.Fatalf("RegisterUser() sent %d emails, want 1", len(sent))
+	}
+	token := strings.Fields(sent[0].Body[strings.Index(sent[0].Body, "token=")+len("token="):])[0]
+
+	tests := []struct {
+		name           string
+		token          string
+		expectedStatus int
+	}{
+		{name: "missing token", token: "", expectedStatus: http.StatusBadRequest},
+		{name: "invalid token", token: "bogus", expectedStatus: http.StatusBadRequest},
+		{name: "valid token", token: token, expectedStatus: http.StatusOK},
+		{name: "reused token", token: token, expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/auth/verify?token="+tt.token, nil)
+			rr := httptest.NewRecorder()
+			handler.VerifyEmail(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("VerifyEmail() status = %v, want %v", rr.Code, tt.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestAuthHandler_LoginLockout(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour)
+	guard := auth.NewLoginGuard(store, auth.LockoutPolicy{
+		MaxAttempts:      2,
+		MaxAttemptsPerIP: 10,
+		BaseLockout:      90 * time.Second,
+		MaxLockout:       time.Hour,
+		ResetAfter:       time.Hour,
+	})
+	chatService := services.NewChatService(store, store, store, authService, services.WithLoginGuard(guard))
+	handler := NewAuthHandler(chatService, nil)
+
+	_, err := chatService.RegisterUser(models.RegisterRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	})
+	if err != nil {
+		t.Fatalf("Failed to register test user: %v", err)
+	}
+
+	wantStatuses := []int{http.StatusUnauthorized, http.StatusTooManyRequests, http.StatusTooManyRequests}
+	for i, want := range wantStatuses {
+		var body bytes.Buffer
+		json.NewEncoder(&body).Encode(models.AuthRequest{Username: "testuser", Password: "wrongpassword"})
+
+		rr := httptest.NewRecorder()
+		handler.Login(rr, httptest.NewRequest(http.MethodPost, "/api/auth/login", &body))
+
+		if rr.Code != want {
+			t.Errorf("Login() attempt %d status = %v, want %v", i+1, rr.Code, want)
+		}
+		if want == http.StatusTooManyRequests {
+			retryAfter, err := strconv.Atoi(rr.Header().Get("Retry-After"))
+			if err != nil || retryAfter <= 0 || retryAfter > 90 {
+				t.Errorf("Login() attempt %d Retry-After = %q, want 1..90", i+1, rr.Header().Get("Retry-After"))
+			}
+		}
+	}
+}
+
+func TestAuthHandler_APIKeys(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour, auth.WithUserStore(store), auth.WithAPIKeyStore(store))
+	chatService := services.NewChatService(store, store, store, authService, services.WithAPIKeyStore(store))
+	handler := NewAuthHandler(chatService, nil)
+
+	user, err := chatService.RegisterUser(models.RegisterRequest{Username: "testuser", Email: "test@example.com", Password: "password123"})
+	if err != nil {
+		t.Fatalf("Failed to register test user: %v", err)
+	}
+	withUser := func(req *http.Request) *http.Request {
+		return req.WithContext(context.WithValue(req.Context(), "userID", user.ID))
+	}
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+	}{
+		{name: "valid key", body: `{"name":"ci","scopes":["messages:write"],"expires_in_days":30}`, expectedStatus: http.StatusCreated},
+		{name: "missing scopes", body: `{"name":"ci"}`, expectedStatus: http.StatusBadRequest},
+		{name: "unknown scope", body: `{"name":"ci","scopes":["everything"]}`, expectedStatus: http.StatusBadRequest},
+		{name: "unknown bot", body: `{"name":"ci","scopes":["rooms:read"],"bot_id":"missing"}`, expectedStatus: http.StatusNotFound},
+	}
+
+	var created models.CreateAPIKeyResponse
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.CreateAPIKey(rr, withUser(httptest.NewRequest(http.MethodPost, "/api/auth/keys", strings.NewReader(tt.body))))
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("CreateAPIKey() status = %v, want %v: %s", rr.Code, tt.expectedStatus, rr.Body.String())
+			}
+			if rr.Code == http.StatusCreated {
+				json.NewDecoder(rr.Body).Decode(&created)
+			}
+		})
+	}
+
+	if !strings.HasPrefix(created.Key, auth.APIKeyPrefix) {
+		t.Fatalf("CreateAPIKey() key = %q, want prefix %q", created.Key, auth.APIKeyPrefix)
+	}
+
+	// Listing never exposes the key or its hash
+	rr := httptest.NewRecorder()
+	handler.ListAPIKeys(rr, withUser(httptest.NewRequest(http.MethodGet, "/api/auth/keys", nil)))
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), created.Key) || !strings.Contains(rr.Body.String(), created.APIKey.Prefix) {
+		t.Errorf("ListAPIKeys() = %v %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	req := mux.SetURLVars(httptest.NewRequest(http.MethodDelete, "/api/auth/keys/missing", nil), map[string]string{"keyId": "missing"})
+	handler.RevokeAPIKey(rr, withUser(req))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("RevokeAPIKey() unknown key status = %v, want %v", rr.Code, http.StatusNotFound)
+	}
+}
@@ -2,10 +2,18 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"go-chat-api/internal/auth"
+	"go-chat-api/internal/middleware"
 	"go-chat-api/internal/models"
 	"go-chat-api/internal/services"
 	"go-chat-api/internal/websocket"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -32,9 +40,14 @@ func (h *ChatHandler) SendMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Messages are always sent as the authenticated user (or bot)
+	if username, ok := r.Context().Value("username").(string); ok {
+		req.Sender = username
+	}
+
 	message, err := h.chatService.SendMessage(req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeSendError(w, err)
 		return
 	}
 
@@ -57,6 +70,74 @@ func (h *ChatHandler) SendMessage(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(message)
 }
 
+// writeSendError maps the errors of sending a message to HTTP status codes. Messages held
+// for review are reported as accepted.
+func writeSendError(w http.ResponseWriter, err error) {
+	var slowModeErr *services.SlowModeError
+	var mutedErr *services.MutedError
+	var heldErr *services.MessageHeldError
+	var rejectedErr *services.MessageRejectedError
+	switch {
+	case errors.As(err, &heldErr):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status": "held",
+			"id":     heldErr.Held.ID,
+			"reason": heldErr.Held.Reason,
+		})
+	case errors.As(err, &rejectedErr):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.As(err, &slowModeErr):
+		retryAfter := int(math.Ceil(slowModeErr.RetryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errors.As(err, &mutedErr):
+		if mutedErr.Until != nil {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(*mutedErr.Until).Seconds()))))
+		}
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, services.ErrEmailNotVerified), errors.Is(err, services.ErrRoomReadOnly), errors.Is(err, services.ErrBlocked):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, services.ErrRoomNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrInvalidAttachments), errors.Is(err, services.ErrInvalidMessageFormat),
+		errors.Is(err, services.ErrInvalidMessageTTL):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrAttachmentsDisabled):
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// DeleteMessage handles DELETE /api/messages/{messageId}
+func (h *ChatHandler) DeleteMessage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+	scopes, _ := r.Context().Value("scopes").([]string)
+
+	messageID := mux.Vars(r)["messageId"]
+
+	err := h.chatService.DeleteMessage(userID, messageID, auth.HasScope(scopes, auth.ScopeModerate), middleware.ClientIP(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrMessageNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, services.ErrNotMessageSender):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // GetMessages handles GET /api/messages
 func (h *ChatHandler) GetMessages(w http.ResponseWriter, r *http.Request) {
 	messages, err := h.chatService.GetMessages()
@@ -73,8 +154,9 @@ func (h *ChatHandler) GetMessages(w http.ResponseWriter, r *http.Request) {
 func (h *ChatHandler) GetMessagesByRoom(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	roomID := vars["roomId"]
+	viewerID, _ := r.Context().Value("userID").(string)
 
-	messages, err := h.chatService.GetMessagesByRoom(roomID)
+	messages, err := h.chatService.GetMessagesByRoom(viewerID, roomID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -89,8 +171,9 @@ func (h *ChatHandler) GetMessagesBetweenUsers(w http.ResponseWriter, r *http.Req
 	vars := mux.Vars(r)
 	user1 := vars["user1"]
 	user2 := vars["user2"]
+	viewerID, _ := r.Context().Value("userID").(string)
 
-	messages, err := h.chatService.GetMessagesBetweenUsers(user1, user2)
+	messages, err := h.chatService.GetMessagesBetweenUsers(viewerID, user1, user2)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -100,6 +183,441 @@ func (h *ChatHandler) GetMessagesBetweenUsers(w http.ResponseWriter, r *http.Req
 	json.NewEncoder(w).Encode(messages)
 }
 
+// GetMentions handles GET /api/mentions, listing the caller's unread mentions
+func (h *ChatHandler) GetMentions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	mentions, err := h.chatService.GetMentions(userID)
+	if err != nil {
+		writeMentionError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(mentions)
+}
+
+// MarkMentionsRead handles POST /api/mentions/read. An empty or missing "ids" list marks
+// every unread mention as read.
+func (h *ChatHandler) MarkMentionsRead(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.MarkMentionsReadRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	marked, err := h.chatService.MarkMentionsRead(userID, req.IDs)
+	if err != nil {
+		writeMentionError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"marked": marked})
+}
+
+// writeMentionError maps mention errors to HTTP status codes
+func writeMentionError(w http.ResponseWriter, err error) {
+	if errors.Is(err, services.ErrMentionsDisabled) {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// GetPins handles GET /api/rooms/{roomId}/pins
+func (h *ChatHandler) GetPins(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	pins, err := h.chatService.GetPins(userID, mux.Vars(r)["roomId"])
+	if err != nil {
+		writePinError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pins)
+}
+
+// PinMessage handles PUT /api/rooms/{roomId}/pins/{messageId}
+func (h *ChatHandler) PinMessage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	actorID, _ := r.Context().Value("userID").(string)
+
+	pin, err := h.chatService.PinMessage(actorID, vars["roomId"], vars["messageId"], middleware.ClientIP(r))
+	if err != nil {
+		writePinError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pin)
+}
+
+// UnpinMessage handles DELETE /api/rooms/{roomId}/pins/{messageId}
+func (h *ChatHandler) UnpinMessage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	actorID, _ := r.Context().Value("userID").(string)
+
+	if err := h.chatService.UnpinMessage(actorID, vars["roomId"], vars["messageId"], middleware.ClientIP(r)); err != nil {
+		writePinError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writePinError maps pin errors to HTTP status codes
+func writePinError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrPinsDisabled):
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+	case errors.Is(err, services.ErrNotRoomMember):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, services.ErrPinLimitReached):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, services.ErrRoomNotFound), errors.Is(err, services.ErrMessageNotFound), errors.Is(err, services.ErrPinNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// GetSavedMessages handles GET /api/users/me/saved
+func (h *ChatHandler) GetSavedMessages(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	saved, err := h.chatService.GetSavedMessages(userID)
+	if err != nil {
+		writeSavedError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(saved)
+}
+
+// SaveMessage handles PUT /api/users/me/saved/{messageId}. The body, with an optional
+// note, may be omitted.
+func (h *ChatHandler) SaveMessage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.SaveMessageRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	saved, err := h.chatService.SaveMessage(userID, mux.Vars(r)["messageId"], req.Note)
+	if err != nil {
+		writeSavedError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(saved)
+}
+
+// UnsaveMessage handles DELETE /api/users/me/saved/{messageId}
+func (h *ChatHandler) UnsaveMessage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.chatService.UnsaveMessage(userID, mux.Vars(r)["messageId"]); err != nil {
+		writeSavedError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeSavedError maps saved message errors to HTTP status codes
+func writeSavedError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrSavedMessagesDisabled):
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+	case errors.Is(err, services.ErrNoteTooLong):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrMessageNotFound), errors.Is(err, services.ErrSavedMessageNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// ScheduleMessage handles POST /api/messages/scheduled
+func (h *ChatHandler) ScheduleMessage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.ScheduleMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	scheduled, err := h.chatService.ScheduleMessage(userID, req)
+	if err != nil {
+		writeScheduleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(scheduled)
+}
+
+// GetScheduledMessages handles GET /api/messages/scheduled
+func (h *ChatHandler) GetScheduledMessages(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	messages, err := h.chatService.GetScheduledMessages(userID)
+	if err != nil {
+		writeScheduleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
+
+// CancelScheduledMessage handles DELETE /api/messages/scheduled/{scheduledId}
+func (h *ChatHandler) CancelScheduledMessage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.chatService.CancelScheduledMessage(userID, mux.Vars(r)["scheduledId"]); err != nil {
+		writeScheduleError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetReminder handles POST /api/messages/{messageId}/reminders
+func (h *ChatHandler) SetReminder(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.ReminderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	reminder, err := h.chatService.SetReminder(userID, mux.Vars(r)["messageId"], req)
+	if err != nil {
+		writeScheduleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(reminder)
+}
+
+// GetReminders handles GET /api/reminders
+func (h *ChatHandler) GetReminders(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	reminders, err := h.chatService.GetReminders(userID)
+	if err != nil {
+		writeScheduleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reminders)
+}
+
+// CancelReminder handles DELETE /api/reminders/{reminderId}
+func (h *ChatHandler) CancelReminder(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.chatService.CancelReminder(userID, mux.Vars(r)["reminderId"]); err != nil {
+		writeScheduleError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeScheduleError maps scheduled message and reminder errors to HTTP status codes
+func writeScheduleError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrSchedulingDisabled):
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+	case errors.Is(err, services.ErrInvalidSchedule), errors.Is(err, services.ErrInvalidMessageFormat), errors.Is(err, services.ErrNoteTooLong):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrBlocked), errors.Is(err, services.ErrNotRoomMember):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, services.ErrRoomNotFound), errors.Is(err, services.ErrMessageNotFound),
+		errors.Is(err, services.ErrScheduledMessageNotFound), errors.Is(err, services.ErrReminderNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// CreatePoll handles POST /api/rooms/{roomId}/polls
+func (h *ChatHandler) CreatePoll(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.CreatePollRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	message, err := h.chatService.CreatePoll(userID, mux.Vars(r)["roomId"], req)
+	if err != nil {
+		writePollError(w, err)
+		return
+	}
+
+	if h.hub != nil {
+		h.hub.SendToRoom(message.RoomID, message)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(message)
+}
+
+// GetPoll handles GET /api/polls/{pollId}
+func (h *ChatHandler) GetPoll(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	poll, err := h.chatService.GetPoll(userID, mux.Vars(r)["pollId"])
+	if err != nil {
+		writePollError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(poll)
+}
+
+// VotePoll handles POST /api/polls/{pollId}/votes
+func (h *ChatHandler) VotePoll(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.VoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	poll, err := h.chatService.Vote(userID, mux.Vars(r)["pollId"], req.Options)
+	if err != nil {
+		writePollError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(poll)
+}
+
+// ClosePoll handles POST /api/polls/{pollId}/close
+func (h *ChatHandler) ClosePoll(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+	scopes, _ := r.Context().Value("scopes").([]string)
+
+	poll, err := h.chatService.ClosePoll(userID, mux.Vars(r)["pollId"], auth.HasScope(scopes, auth.ScopeModerate))
+	if err != nil {
+		writePollError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(poll)
+}
+
+// writePollError maps poll errors to HTTP status codes, falling back to the errors of
+// sending the question
+func writePollError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrPollsDisabled):
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+	case errors.Is(err, services.ErrInvalidPoll), errors.Is(err, services.ErrInvalidVote):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrNotRoomMember), errors.Is(err, services.ErrNotPollCreator):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, services.ErrPollNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrPollClosed):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		writeSendError(w, err)
+	}
+}
+
 // CreateUser handles POST /api/users
 func (h *ChatHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	var req struct {
@@ -202,9 +720,14 @@ func (h *ChatHandler) AddUserToRoom(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	roomID := vars["roomId"]
 	userID := vars["userId"]
+	actorID, _ := r.Context().Value("userID").(string)
 
-	err := h.chatService.AddUserToRoom(roomID, userID)
+	err := h.chatService.AddUserToRoom(actorID, roomID, userID, middleware.ClientIP(r))
 	if err != nil {
+		if errors.Is(err, services.ErrBlocked) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -218,8 +741,9 @@ func (h *ChatHandler) RemoveUserFromRoom(w http.ResponseWriter, r *http.Request)
 	vars := mux.Vars(r)
 	roomID := vars["roomId"]
 	userID := vars["userId"]
+	actorID, _ := r.Context().Value("userID").(string)
 
-	err := h.chatService.RemoveUserFromRoom(roomID, userID)
+	err := h.chatService.RemoveUserFromRoom(actorID, roomID, userID, middleware.ClientIP(r))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -228,3 +752,178 @@ func (h *ChatHandler) RemoveUserFromRoom(w http.ResponseWriter, r *http.Request)
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
 }
+
+// UpdateRoomSettings handles PUT /api/rooms/{roomId}/settings
+func (h *ChatHandler) UpdateRoomSettings(w http.ResponseWriter, r *http.Request) {
+	var req models.RoomSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	roomID := mux.Vars(r)["roomId"]
+	actorID, _ := r.Context().Value("userID").(string)
+
+	room, err := h.chatService.UpdateRoomSettings(actorID, roomID, req, middleware.ClientIP(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidSlowMode), errors.Is(err, services.ErrInvalidMessageTTL):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, services.ErrRoomNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(room)
+}
+
+// GetRoomMutes handles GET /api/rooms/{roomId}/mutes
+func (h *ChatHandler) GetRoomMutes(w http.ResponseWriter, r *http.Request) {
+	roomID := mux.Vars(r)["roomId"]
+
+	mutes, err := h.chatService.GetRoomMutes(roomID)
+	if err != nil {
+		if errors.Is(err, services.ErrRoomNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(mutes)
+}
+
+// MuteRoomMember handles PUT /api/rooms/{roomId}/mutes/{userId}
+func (h *ChatHandler) MuteRoomMember(w http.ResponseWriter, r *http.Request) {
+	var req models.MuteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	vars := mux.Vars(r)
+	actorID, _ := r.Context().Value("userID").(string)
+
+	mute, err := h.chatService.MuteRoomMember(actorID, vars["roomId"], vars["userId"], req, middleware.ClientIP(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidMuteDuration):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, services.ErrProtectedMember):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case errors.Is(err, services.ErrRoomNotFound), strings.Contains(err.Error(), "not found"):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(mute)
+}
+
+// UnmuteRoomMember handles DELETE /api/rooms/{roomId}/mutes/{userId}
+func (h *ChatHandler) UnmuteRoomMember(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	actorID, _ := r.Context().Value("userID").(string)
+
+	err := h.chatService.UnmuteRoomMember(actorID, vars["roomId"], vars["userId"], middleware.ClientIP(r))
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetBlockedUsers handles GET /api/users/me/blocks
+func (h *ChatHandler) GetBlockedUsers(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	blocks, err := h.chatService.GetBlockedUsers(userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(blocks)
+}
+
+// BlockUser handles PUT /api/users/me/blocks/{userId}
+func (h *ChatHandler) BlockUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	block, err := h.chatService.BlockUser(userID, mux.Vars(r)["userId"])
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrOwnBlock):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case strings.Contains(err.Error(), "not found"):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.refreshBlockList(userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(block)
+}
+
+// UnblockUser handles DELETE /api/users/me/blocks/{userId}
+func (h *ChatHandler) UnblockUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.chatService.UnblockUser(userID, mux.Vars(r)["userId"]); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.refreshBlockList(userID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// refreshBlockList pushes a user's changed block list to their live connections
+func (h *ChatHandler) refreshBlockList(userID string) {
+	if h.hub == nil {
+		return
+	}
+
+	usernames, err := h.chatService.GetBlockedUsernames(userID)
+	if err != nil {
+		log.Printf("Failed to reload block list of user %s: %v", userID, err)
+		return
+	}
+	h.hub.SetBlockedUsers(userID, usernames)
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"go-chat-api/internal/auth"
+	"go-chat-api/internal/models"
+	"go-chat-api/internal/services"
+	"go-chat-api/internal/storage"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestChatHandler_RoomRestrictions(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour)
+	chatService := services.NewChatService(store, store, store, authService)
+	handler := NewChatHandler(chatService, nil)
+
+	moderator, _ := chatService.RegisterUser(models.RegisterRequest{Username: "moderator", Email: "moderator@example.com", Password: "password123"})
+	user, _ := chatService.RegisterUser(models.RegisterRequest{Username: "testuser", Email: "test@example.com", Password: "password123"})
+	room, _ := chatService.CreateRoom(models.CreateRoomRequest{Name: "general"})
+
+	// withUser runs a request as the given user
+	withUser := func(req *http.Request, u *models.User) *http.Request {
+		ctx := context.WithValue(req.Context(), "userID", u.ID)
+		ctx = context.WithValue(ctx, "username", u.Username)
+		return req.WithContext(ctx)
+	}
+
+	send := func(roomID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/messages", strings.NewReader(`{"content":"hello","room_id":"`+roomID+`"}`))
+		rr := httptest.NewRecorder()
+		handler.SendMessage(rr, withUser(req, user))
+		return rr
+	}
+
+	t.Run("settings", func(t *testing.T) {
+		tests := []struct {
+			name           string
+			roomID         string
+			body           string
+			expectedStatus int
+		}{
+			{name: "invalid body", roomID: room.ID, body: `{`, expectedStatus: http.StatusBadRequest},
+			{name: "interval too long", roomID: room.ID, body: `{"slow_mode_seconds":86400}`, expectedStatus: http.StatusBadRequest},
+			{name: "negative message TTL", roomID: room.ID, body: `{"message_ttl_seconds":-1}`, expectedStatus: http.StatusBadRequest},
+			{name: "unknown room", roomID: "missing", body: `{"slow_mode_seconds":30}`, expectedStatus: http.StatusNotFound},
+			{name: "slow mode", roomID: room.ID, body: `{"slow_mode_seconds":30}`, expectedStatus: http.StatusOK},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				req := httptest.NewRequest(http.MethodPut, "/api/rooms/"+tt.roomID+"/settings", strings.NewReader(tt.body))
+				req = mux.SetURLVars(req, map[string]string{"roomId": tt.roomID})
+				rr := httptest.NewRecorder()
+				handler.UpdateRoomSettings(rr, withUser(req, moderator))
+				if rr.Code != tt.expectedStatus {
+					t.Errorf("UpdateRoomSettings() status = %v, want %v: %s", rr.Code, tt.expectedStatus, rr.Body.String())
+				}
+			})
+		}
+	})
+
+	t.Run("slow mode", func(t *testing.T) {
+		if rr := send(room.ID); rr.Code != http.StatusOK {
+			t.Fatalf("SendMessage() status = %v, want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+		}
+
+		rr := send(room.ID)
+		if rr.Code != http.StatusTooManyRequests {
+			t.Fatalf("SendMessage() during cooldown status = %v, want %v", rr.Code, http.StatusTooManyRequests)
+		}
+		if got := rr.Header().Get("Retry-After"); got != "30" {
+			t.Errorf("SendMessage() Retry-After = %q, want %q", got, "30")
+		}
+	})
+
+	t.Run("mute", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPut, "/api/rooms/"+room.ID+"/mutes/"+user.ID, strings.NewReader(`{"duration_seconds":600,"reason":"spam"}`))
+		req = mux.SetURLVars(req, map[string]string{"roomId": room.ID, "userId": user.ID})
+		rr := httptest.NewRecorder()
+		handler.MuteRoomMember(rr, withUser(req, moderator))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("MuteRoomMember() status = %v, want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+		}
+
+		if rr := send(room.ID); rr.Code != http.StatusForbidden || rr.Header().Get("Retry-After") == "" {
+			t.Errorf("SendMessage() while muted status = %v, Retry-After = %q, want %v with Retry-After",
+				rr.Code, rr.Header().Get("Retry-After"), http.StatusForbidden)
+		}
+
+		req = httptest.NewRequest(http.MethodDelete, "/api/rooms/"+room.ID+"/mutes/"+user.ID, nil)
+		req = mux.SetURLVars(req, map[string]string{"roomId": room.ID, "userId": user.ID})
+		rr = httptest.NewRecorder()
+		handler.UnmuteRoomMember(rr, withUser(req, moderator))
+		if rr.Code != http.StatusNoContent {
+			t.Errorf("UnmuteRoomMember() status = %v, want %v", rr.Code, http.StatusNoContent)
+		}
+
+		rr = httptest.NewRecorder()
+		handler.UnmuteRoomMember(rr, withUser(req, moderator))
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("UnmuteRoomMember() when not muted status = %v, want %v", rr.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("read-only", func(t *testing.T) {
+		chatService.UpdateRoomSettings(moderator.ID, room.ID, models.RoomSettingsRequest{ReadOnly: true}, "")
+		if rr := send(room.ID); rr.Code != http.StatusForbidden {
+			t.Errorf("SendMessage() in read-only room status = %v, want %v", rr.Code, http.StatusForbidden)
+		}
+	})
+
+	if rr := send("missing"); rr.Code != http.StatusNotFound {
+		t.Errorf("SendMessage() to unknown room status = %v, want %v", rr.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"go-chat-api/internal/auth"
+	"go-chat-api/internal/middleware"
+	"go-chat-api/internal/models"
+	"go-chat-api/internal/services"
+	"go-chat-api/internal/websocket"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// ModerationHandler handles the moderator review queue and room filter settings
+type ModerationHandler struct {
+	chatService *services.ChatService
+	hub         *websocket.Hub // WebSocket hub that delivers approved messages
+}
+
+// NewModerationHandler creates a new moderation handler with injected dependencies
+func NewModerationHandler(chatService *services.ChatService, hub *websocket.Hub) *ModerationHandler {
+	return &ModerationHandler{
+		chatService: chatService,
+		hub:         hub,
+	}
+}
+
+// GetHeldMessages handles GET /api/moderation/held
+func (h *ModerationHandler) GetHeldMessages(w http.ResponseWriter, r *http.Request) {
+	messages, err := h.chatService.GetHeldMessages(r.URL.Query().Get("room_id"))
+	if err != nil {
+		writeReviewError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
+
+// ApproveHeldMessage handles POST /api/moderation/held/{messageId}/approve
+func (h *ModerationHandler) ApproveHeldMessage(w http.ResponseWriter, r *http.Request) {
+	actorID, _ := r.Context().Value("userID").(string)
+	messageID := mux.Vars(r)["messageId"]
+
+	message, err := h.chatService.ApproveHeldMessage(actorID, messageID, middleware.ClientIP(r))
+	if err != nil {
+		writeReviewError(w, err)
+		return
+	}
+
+	// Deliver the message the way it would have been delivered when it was sent
+	if h.hub != nil {
+		h.hub.DeliverMessage(message)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(message)
+}
+
+// RejectHeldMessage handles POST /api/moderation/held/{messageId}/reject
+func (h *ModerationHandler) RejectHeldMessage(w http.ResponseWriter, r *http.Request) {
+	actorID, _ := r.Context().Value("userID").(string)
+	messageID := mux.Vars(r)["messageId"]
+
+	if err := h.chatService.RejectHeldMessage(actorID, messageID, middleware.ClientIP(r)); err != nil {
+		writeReviewError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetRoomModeration handles GET /api/rooms/{roomId}/moderation
+func (h *ModerationHandler) GetRoomModeration(w http.ResponseWriter, r *http.Request) {
+	settings, err := h.chatService.GetRoomModeration(mux.Vars(r)["roomId"])
+	if err != nil {
+		writeReviewError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+// UpdateRoomModeration handles PUT /api/rooms/{roomId}/moderation
+func (h *ModerationHandler) UpdateRoomModeration(w http.ResponseWriter, r *http.Request) {
+	var req models.RoomModeration
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	actorID, _ := r.Context().Value("userID").(string)
+	roomID := mux.Vars(r)["roomId"]
+
+	settings, err := h.chatService.UpdateRoomModeration(actorID, roomID, req, middleware.ClientIP(r))
+	if err != nil {
+		writeReviewError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+// CreateReport handles POST /api/reports
+func (h *ModerationHandler) CreateReport(w http.ResponseWriter, r *http.Request) {
+	reporterID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.ReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	report, err := h.chatService.CreateReport(reporterID, req)
+	if err != nil {
+		writeReportError(w, err)
+		return
+	}
+
+	// Let the moderators who are online know a new report arrived
+	if h.hub != nil {
+		moderators, err := h.chatService.GetReportModerators(report)
+		if err != nil {
+			log.Printf("Failed to look up moderators for report %s: %v", report.ID, err)
+		}
+		h.hub.SendEvent(moderators, map[string]interface{}{
+			"type":   "report",
+			"report": report,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(report)
+}
+
+// GetReports handles GET /api/moderation/reports
+func (h *ModerationHandler) GetReports(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := models.ReportFilter{
+		Status: query.Get("status"),
+		RoomID: query.Get("room_id"),
+	}
+
+	var err error
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 1 {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	if offset := query.Get("offset"); offset != "" {
+		if filter.Offset, err = strconv.Atoi(offset); err != nil || filter.Offset < 0 {
+			http.Error(w, "Invalid offset parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	reports, total, err := h.chatService.GetReports(filter)
+	if err != nil {
+		writeReportError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.ReportListResponse{Reports: reports, Total: total})
+}
+
+// GetReport handles GET /api/moderation/reports/{reportId}
+func (h *ModerationHandler) GetReport(w http.ResponseWriter, r *http.Request) {
+	report, err := h.chatService.GetReport(mux.Vars(r)["reportId"])
+	if err != nil {
+		writeReportError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// ActOnReport handles POST /api/moderation/reports/{reportId}/action
+func (h *ModerationHandler) ActOnReport(w http.ResponseWriter, r *http.Request) {
+	var req models.ReportActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	actorID, _ := r.Context().Value("userID").(string)
+	scopes, _ := r.Context().Value("scopes").([]string)
+	reportID := mux.Vars(r)["reportId"]
+
+	report, err := h.chatService.ActOnReport(actorID, reportID, req, auth.HasScope(scopes, auth.ScopeAdmin), middleware.ClientIP(r))
+	if err != nil {
+		writeReportError(w, err)
+		return
+	}
+
+	// Suspended users lose their live connections as well as their sessions
+	if report.Action == models.ReportActionSuspend {
+		disconnectWithBots(h.hub, h.chatService, report.ReportedUserID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// DismissReport handles POST /api/moderation/reports/{reportId}/dismiss
+func (h *ModerationHandler) DismissReport(w http.ResponseWriter, r *http.Request) {
+	// The note is optional, so an empty body is accepted
+	var req models.ReportDismissRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	actorID, _ := r.Context().Value("userID").(string)
+	reportID := mux.Vars(r)["reportId"]
+
+	report, err := h.chatService.DismissReport(actorID, reportID, req.Note, middleware.ClientIP(r))
+	if err != nil {
+		writeReportError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// writeReportError maps report errors, including those of the actions taken on reports,
+// to HTTP responses
+func writeReportError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidReport), errors.Is(err, services.ErrInvalidReportAction),
+		errors.Is(err, services.ErrInvalidMuteDuration):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrOwnReport), errors.Is(err, services.ErrAdminRequired),
+		errors.Is(err, services.ErrProtectedMember), errors.Is(err, services.ErrProtectedAccount),
+		errors.Is(err, services.ErrOwnAccountModeration):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, services.ErrReportResolved):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, services.ErrReportNotFound), errors.Is(err, services.ErrMessageNotFound),
+		errors.Is(err, services.ErrRoomNotFound), strings.Contains(err.Error(), "not found"):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// writeReviewError maps review queue and room filter errors to HTTP responses
+func writeReviewError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidModeration):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrRoomNotFound), errors.Is(err, services.ErrHeldMessageNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrInvalidAttachments):
+		// The attachments were deleted or sent since the message was held
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, services.ErrModerationDisabled):
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
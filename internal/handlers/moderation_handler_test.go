@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"go-chat-api/internal/auth"
+	"go-chat-api/internal/models"
+	"go-chat-api/internal/moderation"
+	"go-chat-api/internal/services"
+	"go-chat-api/internal/storage"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestModerationHandler(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour)
+	pipeline := moderation.NewPipeline(
+		moderation.NewLinkFilter([]string{"spam.example"}, moderation.Reject),
+		moderation.NewSpamFilter(store, moderation.SpamPolicy{MaxMentions: 1}, moderation.Hold),
+	)
+	chatService := services.NewChatService(store, store, store, authService, services.WithModeration(pipeline, store))
+	chatHandler := NewChatHandler(chatService, nil)
+	handler := NewModerationHandler(chatService, nil)
+
+	moderator, _ := chatService.RegisterUser(models.RegisterRequest{Username: "moderator", Email: "moderator@example.com", Password: "password123"})
+	user, _ := chatService.RegisterUser(models.RegisterRequest{Username: "testuser", Email: "test@example.com", Password: "password123"})
+	room, _ := chatService.CreateRoom(models.CreateRoomRequest{Name: "general"})
+
+	// withUser runs a request as the given user
+	withUser := func(req *http.Request, u *models.User) *http.Request {
+		ctx := context.WithValue(req.Context(), "userID", u.ID)
+		ctx = context.WithValue(ctx, "username", u.Username)
+		return req.WithContext(ctx)
+	}
+
+	send := func(content string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(models.MessageRequest{Content: content, RoomID: room.ID})
+		req := httptest.NewRequest(http.MethodPost, "/api/messages", strings.NewReader(string(body)))
+		rr := httptest.NewRecorder()
+		chatHandler.SendMessage(rr, withUser(req, user))
+		return rr
+	}
+
+	t.Run("send", func(t *testing.T) {
+		tests := []struct {
+			name           string
+			content        string
+			expectedStatus int
+		}{
+			{name: "clean", content: "hello", expectedStatus: http.StatusOK},
+			{name: "rejected", content: "see spam.example", expectedStatus: http.StatusUnprocessableEntity},
+			{name: "held", content: "@a @b", expectedStatus: http.StatusAccepted},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if rr := send(tt.content); rr.Code != tt.expectedStatus {
+					t.Errorf("SendMessage() status = %v, want %v: %s", rr.Code, tt.expectedStatus, rr.Body.String())
+				}
+			})
+		}
+	})
+
+	t.Run("review", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.GetHeldMessages(rr, withUser(httptest.NewRequest(http.MethodGet, "/api/moderation/held?room_id="+room.ID, nil), moderator))
+		var held []models.HeldMessage
+		if err := json.NewDecoder(rr.Body).Decode(&held); err != nil || len(held) != 1 {
+			t.Fatalf("GetHeldMessages() = %d messages, %v, want 1", len(held), err)
+		}
+
+		review := func(action func(http.ResponseWriter, *http.Request), id string) int {
+			req := httptest.NewRequest(http.MethodPost, "/api/moderation/held/"+id, nil)
+			req = mux.SetURLVars(req, map[string]string{"messageId": id})
+			rr := httptest.NewRecorder()
+			action(rr, withUser(req, moderator))
+			return rr.Code
+		}
+
+		if code := review(handler.ApproveHeldMessage, held[0].ID); code != http.StatusOK {
+			t.Errorf("ApproveHeldMessage() status = %v, want %v", code, http.StatusOK)
+		}
+		if code := review(handler.RejectHeldMessage, held[0].ID); code != http.StatusNotFound {
+			t.Errorf("RejectHeldMessage() of a reviewed message status = %v, want %v", code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("room settings", func(t *testing.T) {
+		tests := []struct {
+			name           string
+			roomID         string
+			body           string
+			expectedStatus int
+		}{
+			{name: "invalid body", roomID: room.ID, body: `{`, expectedStatus: http.StatusBadRequest},
+			{name: "unknown filter", roomID: room.ID, body: `{"disabled_filters":["profanity"]}`, expectedStatus: http.StatusBadRequest},
+			{name: "unknown room", roomID: "missing", body: `{}`, expectedStatus: http.StatusNotFound},
+			{name: "valid", roomID: room.ID, body: `{"actions":{"links":"hold"}}`, expectedStatus: http.StatusOK},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				req := httptest.NewRequest(http.MethodPut, "/api/rooms/"+tt.roomID+"/moderation", strings.NewReader(tt.body))
+				req = mux.SetURLVars(req, map[string]string{"roomId": tt.roomID})
+				rr := httptest.NewRecorder()
+				handler.UpdateRoomModeration(rr, withUser(req, moderator))
+				if rr.Code != tt.expectedStatus {
+					t.Errorf("UpdateRoomModeration() status = %v, want %v: %s", rr.Code, tt.expectedStatus, rr.Body.String())
+				}
+			})
+		}
+
+		req := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/api/rooms/"+room.ID+"/moderation", nil), map[string]string{"roomId": room.ID})
+		rr := httptest.NewRecorder()
+		handler.GetRoomModeration(rr, withUser(req, moderator))
+		var settings models.RoomModeration
+		if err := json.NewDecoder(rr.Body).Decode(&settings); err != nil || settings.Actions[moderation.FilterLinks] != "hold" {
+			t.Errorf("GetRoomModeration() = %+v, %v, want the links action overridden", settings, err)
+		}
+
+		if rr := send("see spam.example"); rr.Code != http.StatusAccepted {
+			t.Errorf("SendMessage() with overridden link action status = %v, want %v", rr.Code, http.StatusAccepted)
+		}
+	})
+}
+
+func TestModerationHandler_Reports(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour)
+	chatService := services.NewChatService(store, store, store, authService, services.WithReportStore(store))
+	handler := NewModerationHandler(chatService, nil)
+
+	admin, _ := chatService.RegisterUser(models.RegisterRequest{Username: "admin", Email: "admin@example.com", Password: "password123"})
+	moderator, _ := chatService.RegisterUser(models.RegisterRequest{Username: "moderator", Email: "moderator@example.com", Password: "password123"})
+	user, _ := chatService.RegisterUser(models.RegisterRequest{Username: "testuser", Email: "test@example.com", Password: "password123"})
+	chatService.BootstrapAdmins([]string{admin.ID})
+	chatService.SetUserRole(admin.ID, moderator.ID, models.RoleModerator, "")
+
+	// withUser runs a request as the given user with the scopes of their role
+	withUser := func(req *http.Request, u *models.User, role string) *http.Request {
+		ctx := context.WithValue(req.Context(), "userID", u.ID)
+		ctx = context.WithValue(ctx, "username", u.Username)
+		ctx = context.WithValue(ctx, "scopes", auth.RoleScopes(role))
+		return req.WithContext(ctx)
+	}
+
+	t.Run("create", func(t *testing.T) {
+		tests := []struct {
+			name           string
+			body           string
+			expectedStatus int
+		}{
+			{name: "invalid body", body: `{`, expectedStatus: http.StatusBadRequest},
+			{name: "missing reason", body: `{"user_id":"` + admin.ID + `"}`, expectedStatus: http.StatusBadRequest},
+			{name: "self", body: `{"user_id":"` + user.ID + `","reason":"me"}`, expectedStatus: http.StatusForbidden},
+			{name: "unknown user", body: `{"user_id":"missing","reason":"abuse"}`, expectedStatus: http.StatusNotFound},
+			{name: "valid", body: `{"user_id":"` + admin.ID + `","reason":"abuse"}`, expectedStatus: http.StatusCreated},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				req := httptest.NewRequest(http.MethodPost, "/api/reports", strings.NewReader(tt.body))
+				rr := httptest.NewRecorder()
+				handler.CreateReport(rr, withUser(req, user, models.RoleUser))
+				if rr.Code != tt.expectedStatus {
+					t.Errorf("CreateReport() status = %v, want %v: %s", rr.Code, tt.expectedStatus, rr.Body.String())
+				}
+			})
+		}
+	})
+
+	t.Run("review", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.GetReports(rr, withUser(httptest.NewRequest(http.MethodGet, "/api/moderation/reports?status=open", nil), moderator, models.RoleModerator))
+		var list models.ReportListResponse
+		if err := json.NewDecoder(rr.Body).Decode(&list); err != nil || list.Total != 1 {
+			t.Fatalf("GetReports() = %+v, %v, want 1 report", list, err)
+		}
+		reportID := list.Reports[0].ID
+
+		resolve := func(action func(http.ResponseWriter, *http.Request), body string) int {
+			req := httptest.NewRequest(http.MethodPost, "/api/moderation/reports/"+reportID, strings.NewReader(body))
+			req = mux.SetURLVars(req, map[string]string{"reportId": reportID})
+			rr := httptest.NewRecorder()
+			action(rr, withUser(req, moderator, models.RoleModerator))
+			return rr.Code
+		}
+
+		if code := resolve(handler.ActOnReport, `{"action":"delete_message"}`); code != http.StatusBadRequest {
+			t.Errorf("ActOnReport() delete on a user report status = %v, want %v", code, http.StatusBadRequest)
+		}
+		if code := resolve(handler.ActOnReport, `{"action":"suspend"}`); code != http.StatusForbidden {
+			t.Errorf("ActOnReport() suspend as moderator status = %v, want %v", code, http.StatusForbidden)
+		}
+		if code := resolve(handler.DismissReport, ``); code != http.StatusOK {
+			t.Errorf("DismissReport() status = %v, want %v", code, http.StatusOK)
+		}
+		if code := resolve(handler.DismissReport, `{"note":"again"}`); code != http.StatusConflict {
+			t.Errorf("DismissReport() twice status = %v, want %v", code, http.StatusConflict)
+		}
+	})
+}
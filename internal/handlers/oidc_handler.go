@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"go-chat-api/internal/middleware"
+	"go-chat-api/internal/models"
+	"go-chat-api/internal/oidc"
+	"go-chat-api/internal/services"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	// oidcStateCookie holds the state, nonce and PKCE verifier of a login in progress
+	oidcStateCookie = "oidc_state"
+
+	// oidcStateMaxAge is how long a user has to complete a login at the provider, in seconds
+	oidcStateMaxAge = 10 * 60
+)
+
+// OIDCHandler handles OpenID Connect login requests
+type OIDCHandler struct {
+	chatService *services.ChatService
+	provider    *oidc.Provider
+}
+
+// NewOIDCHandler creates a new OIDC handler with injected dependencies
+func NewOIDCHandler(chatService *services.ChatService, provider *oidc.Provider) *OIDCHandler {
+	return &OIDCHandler{
+		chatService: chatService,
+		provider:    provider,
+	}
+}
+
+// Login handles GET /api/auth/oidc/login by redirecting to the identity provider
+func (h *OIDCHandler) Login(w http.ResponseWriter, r *http.Request) {
+	state, err := oidc.GenerateState()
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := oidc.GenerateState()
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	verifier, err := oidc.GenerateCodeVerifier()
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	authURL, err := h.provider.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Printf("OIDC login failed: %v", err)
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    strings.Join([]string{state, nonce, verifier}, "."),
+		Path:     "/api/auth/oidc",
+		MaxAge:   oidcStateMaxAge,
+		HttpOnly: true,
+		Secure:   false, // Set to true in production with HTTPS
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// Callback handles GET /api/auth/oidc/callback after the user signs in at the identity provider
+func (h *OIDCHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		http.Error(w, "Login failed: "+providerErr, http.StatusUnauthorized)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		http.Error(w, "Login session expired, please try again", http.StatusBadRequest)
+		return
+	}
+	// The login state is single-use
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    "",
+		Path:     "/api/auth/oidc",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   false,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 || subtle.ConstantTimeCompare([]byte(parts[0]), []byte(query.Get("state"))) != 1 {
+		http.Error(w, "Invalid login state", http.StatusBadRequest)
+		return
+	}
+	nonce, verifier := parts[1], parts[2]
+
+	code := query.Get("code")
+	if code == "" {
+		http.Error(w, "Authorization code required", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := h.provider.Exchange(r.Context(), code, verifier)
+	if err != nil {
+		log.Printf("OIDC code exchange failed: %v", err)
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := h.provider.VerifyIDToken(r.Context(), tokens.IDToken, nonce)
+	if err != nil {
+		log.Printf("OIDC token verification failed: %v", err)
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+
+	authResponse, err := h.chatService.AuthenticateExternal(models.ExternalProfile{
+		Provider:      h.provider.Issuer(),
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Username:      claims.PreferredUsername,
+		ClientIP:      middleware.ClientIP(r),
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrIdentityConflict):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, services.ErrExternalEmailRequired), errors.Is(err, services.ErrInvalidEmail):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, services.ErrAccountSuspended):
+			http.Error(w, "Account suspended", http.StatusForbidden)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	setAuthCookie(w, authResponse.Token)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(authResponse)
+}
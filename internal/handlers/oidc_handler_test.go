@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"encoding/json"
+	"go-chat-api/internal/auth"
+	"go-chat-api/internal/models"
+	"go-chat-api/internal/oidc"
+	"go-chat-api/internal/oidc/oidctest"
+	"go-chat-api/internal/services"
+	"go-chat-api/internal/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOIDCHandler_LoginFlow(t *testing.T) {
+	idp := oidctest.NewProvider("chat-api", "secret")
+	defer idp.Close()
+	idp.SetUser(oidctest.User{Subject: "user-123", Email: "alice@example.com", EmailVerified: true, PreferredUsername: "alice"})
+
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour)
+	chatService := services.NewChatService(store, store, store, authService, services.WithIdentityStore(store))
+	handler := NewOIDCHandler(chatService, oidc.NewProvider(oidc.Config{
+		IssuerURL:    idp.Issuer(),
+		ClientID:     "chat-api",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8080/api/auth/oidc/callback",
+	}, nil))
+
+	// Starting the login redirects to the provider and stores the login state in a cookie
+	rr := httptest.NewRecorder()
+	handler.Login(rr, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/login", nil))
+	if rr.Code != http.StatusFound {
+		t.Fatalf("Login() status = %v, want %v", rr.Code, http.StatusFound)
+	}
+	var stateCookie *http.Cookie
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == oidcStateCookie {
+			stateCookie = cookie
+		}
+	}
+	if stateCookie == nil || !stateCookie.HttpOnly {
+		t.Fatalf("Login() did not set an HTTP-only %s cookie", oidcStateCookie)
+	}
+
+	redirect, err := idp.Authorize(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("Authorize() unexpected error = %v", err)
+	}
+
+	t.Run("rejects mismatched state", func(t *testing.T) {
+		query := redirect.Query()
+		query.Set("state", "forged")
+		req := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/callback?"+query.Encode(), nil)
+		req.AddCookie(stateCookie)
+
+		rr := httptest.NewRecorder()
+		handler.Callback(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Callback() status = %v, want %v", rr.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("rejects missing state cookie", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.Callback(rr, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/callback?"+redirect.RawQuery, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Callback() status = %v, want %v", rr.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("completes login", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/callback?"+redirect.RawQuery, nil)
+		req.AddCookie(stateCookie)
+
+		rr := httptest.NewRecorder()
+		handler.Callback(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Callback() status = %v, want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+		}
+
+		var resp models.AuthResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if resp.User.Username != "alice" || resp.User.Email != "alice@example.com" || !resp.User.Verified {
+			t.Errorf("Callback() user = %+v", resp.User)
+		}
+		if _, err := authService.ValidateToken(resp.Token); err != nil {
+			t.Errorf("Callback() returned invalid token: %v", err)
+		}
+
+		var jwtCookie bool
+		for _, cookie := range rr.Result().Cookies() {
+			if cookie.Name == "jwt_token" && cookie.Value == resp.Token {
+				jwtCookie = true
+			}
+		}
+		if !jwtCookie {
+			t.Error("Callback() did not set jwt_token cookie")
+		}
+	})
+
+	t.Run("authorization code is single-use", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/callback?"+redirect.RawQuery, nil)
+		req.AddCookie(stateCookie)
+
+		rr := httptest.NewRecorder()
+		handler.Callback(rr, req)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("Callback() status = %v, want %v", rr.Code, http.StatusUnauthorized)
+		}
+	})
+}
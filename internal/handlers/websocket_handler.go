@@ -2,42 +2,95 @@ package handlers
 
 import (
 	"encoding/json"
+	"go-chat-api/internal/models"
 	"go-chat-api/internal/services"
 	"go-chat-api/internal/websocket"
 	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
 )
 
 // WebSocketHandler handles WebSocket connections
 type WebSocketHandler struct {
 	hub         *websocket.Hub
 	chatService *services.ChatService
+	settings    websocket.Settings // Allowed origins and frame throttling of new connections
 }
 
 // NewWebSocketHandler creates a new WebSocket handler
-func NewWebSocketHandler(hub *websocket.Hub, chatService *services.ChatService) *WebSocketHandler {
+func NewWebSocketHandler(hub *websocket.Hub, chatService *services.ChatService, settings websocket.Settings) *WebSocketHandler {
 	return &WebSocketHandler{
 		hub:         hub,
 		chatService: chatService,
+		settings:    settings,
 	}
 }
 
 // HandleWebSocket handles WebSocket connection requests
 func (h *WebSocketHandler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
-	// Get user info from context (set by auth middleware)
-	userID, ok := r.Context().Value("userID").(string)
-	if !ok {
+	// Reject cross-site upgrades before a ticket is spent on them
+	if !h.settings.Origins.AllowsRequest(r) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	// Use the user info from context (set by the optional auth middleware), falling
+	// back to a ticket. Without either the client must send an "auth" frame.
+	claims := claimsFromContext(r)
+	if claims == nil {
+		if ticket := r.URL.Query().Get("ticket"); ticket != "" {
+			var err error
+			claims, err = h.chatService.RedeemWebSocketTicket(ticket)
+			if err != nil {
+				http.Error(w, "Invalid or expired ticket", http.StatusUnauthorized)
+				return
+			}
+		}
+	}
+
+	// Upgrade the HTTP connection to WebSocket
+	websocket.ServeWS(h.hub, h.chatService, h.settings, w, r, claims)
+}
+
+// IssueTicket handles POST /api/ws/ticket
+func (h *WebSocketHandler) IssueTicket(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromContext(r)
+	if claims == nil {
 		http.Error(w, "User not authenticated", http.StatusUnauthorized)
 		return
 	}
 
-	username, ok := r.Context().Value("username").(string)
-	if !ok {
-		http.Error(w, "Username not found", http.StatusUnauthorized)
+	ticket, expiresAt, err := h.chatService.IssueWebSocketTicket(claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Upgrade the HTTP connection to WebSocket
-	websocket.ServeWS(h.hub, h.chatService, w, r, userID, username)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.WebSocketTicketResponse{Ticket: ticket, ExpiresAt: expiresAt})
+}
+
+// claimsFromContext rebuilds the authenticated identity set by the auth middleware,
+// returning nil for unauthenticated requests
+func claimsFromContext(r *http.Request) *models.Claims {
+	ctx := r.Context()
+	userID, ok := ctx.Value("userID").(string)
+	if !ok {
+		return nil
+	}
+
+	claims := &models.Claims{UserID: userID}
+	claims.Username, _ = ctx.Value("username").(string)
+	claims.ID, _ = ctx.Value("sessionID").(string)
+	claims.Scopes, _ = ctx.Value("scopes").([]string)
+	claims.APIKeyID, _ = ctx.Value("apiKeyID").(string)
+	if expiresAt, ok := ctx.Value("expiresAt").(time.Time); ok {
+		claims.ExpiresAt = jwt.NewNumericDate(expiresAt)
+	}
+	return claims
 }
 
 // GetConnectedUsers returns currently connected users
@@ -0,0 +1,427 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-chat-api/internal/auth"
+	"go-chat-api/internal/config"
+	"go-chat-api/internal/middleware"
+	"go-chat-api/internal/models"
+	"go-chat-api/internal/ratelimit"
+	"go-chat-api/internal/services"
+	"go-chat-api/internal/storage"
+	"go-chat-api/internal/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	gorillaws "github.com/gorilla/websocket"
+)
+
+func TestWebSocketHandler_Authentication(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour,
+		auth.WithSessionStore(store), auth.WithUserStore(store), auth.WithTicketStore(store))
+	chatService := services.NewChatService(store, store, store, authService, services.WithBlockStore(store))
+	hub := websocket.NewHub()
+	go hub.Run()
+	handler := NewWebSocketHandler(hub, chatService, websocket.Settings{
+		Origins: config.NewOriginPolicy([]string{"https://chat.example.com"}),
+	})
+
+	user, _ := chatService.RegisterUser(models.RegisterRequest{Username: "testuser", Email: "test@example.com", Password: "password123"})
+	login, err := chatService.AuthenticateUser(models.AuthRequest{Username: "testuser", Password: "password123"})
+	if err != nil {
+		t.Fatalf("AuthenticateUser() unexpected error = %v", err)
+	}
+	claims, _ := authService.ValidateToken(login.Token)
+
+	// Connections to /api/ws/throttled may send two frames per minute
+	throttledHandler := NewWebSocketHandler(hub, chatService, websocket.Settings{
+		FrameLimit:         ratelimit.Limit{Requests: 2, Window: time.Minute},
+		MaxThrottledFrames: 3,
+	})
+
+	router := mux.NewRouter()
+	router.Handle("/api/ws/connect", middleware.OptionalAuthMiddleware(authService)(http.HandlerFunc(handler.HandleWebSocket)))
+	router.Handle("/api/ws/throttled", middleware.OptionalAuthMiddleware(authService)(http.HandlerFunc(throttledHandler.HandleWebSocket)))
+	server := httptest.NewServer(router)
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/ws/connect"
+
+	// readFrame reads the next JSON frame sent by the server
+	readFrame := func(t *testing.T, conn *gorillaws.Conn) map[string]interface{} {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var frame map[string]interface{}
+		if err := conn.ReadJSON(&frame); err != nil {
+			t.Fatalf("ReadJSON() unexpected error = %v", err)
+		}
+		return frame
+	}
+
+	t.Run("issue ticket", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/api/ws/ticket", nil)
+		rr := httptest.NewRecorder()
+		handler.IssueTicket(rr, req)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("IssueTicket() without user status = %v, want %v", rr.Code, http.StatusUnauthorized)
+		}
+
+		ctx := context.WithValue(req.Context(), "userID", user.ID)
+		ctx = context.WithValue(ctx, "username", user.Username)
+		ctx = context.WithValue(ctx, "sessionID", claims.ID)
+		ctx = context.WithValue(ctx, "scopes", claims.Scopes)
+		rr = httptest.NewRecorder()
+		handler.IssueTicket(rr, req.WithContext(ctx))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("IssueTicket() status = %v, want %v: %s", rr.Code, http.StatusCreated, rr.Body.String())
+		}
+		if rr.Header().Get("Cache-Control") != "no-store" {
+			t.Errorf("IssueTicket() Cache-Control = %q, want no-store", rr.Header().Get("Cache-Control"))
+		}
+
+		var response models.WebSocketTicketResponse
+		json.NewDecoder(rr.Body).Decode(&response)
+		if response.Ticket == "" {
+			t.Fatal("IssueTicket() returned an empty ticket")
+		}
+
+		conn, _, err := gorillaws.DefaultDialer.Dial(wsURL+"?ticket="+response.Ticket, nil)
+		if err != nil {
+			t.Fatalf("Dial() with ticket unexpected error = %v", err)
+		}
+		defer conn.Close()
+		if frame := readFrame(t, conn); frame["type"] != "connection" {
+			t.Errorf("first frame = %v, want a connection confirmation", frame)
+		}
+
+		// Tickets are single use
+		_, resp, err := gorillaws.DefaultDialer.Dial(wsURL+"?ticket="+response.Ticket, nil)
+		if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Dial() with a used ticket = %v, want status %v", err, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("origin policy", func(t *testing.T) {
+		tests := []struct {
+			name           string
+			origin         string
+			expectedStatus int
+		}{
+			{name: "allowed origin", origin: "https://chat.example.com", expectedStatus: http.StatusSwitchingProtocols},
+			{name: "same origin", origin: server.URL, expectedStatus: http.StatusSwitchingProtocols},
+			{name: "cross-site origin", origin: "https://evil.example.org", expectedStatus: http.StatusForbidden},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				header := http.Header{"Origin": {tt.origin}}
+				header.Set("Cookie", "jwt_token="+login.Token)
+				conn, resp, err := gorillaws.DefaultDialer.Dial(wsURL, header)
+				if conn != nil {
+					conn.Close()
+				}
+				if resp == nil {
+					t.Fatalf("Dial() error = %v, want an HTTP response", err)
+				}
+				if resp.StatusCode != tt.expectedStatus {
+					t.Errorf("Dial() status = %v, want %v", resp.StatusCode, tt.expectedStatus)
+				}
+			})
+		}
+	})
+
+	t.Run("bearer token", func(t *testing.T) {
+		conn, _, err := gorillaws.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Bearer " + login.Token}})
+		if err != nil {
+			t.Fatalf("Dial() unexpected error = %v", err)
+		}
+		defer conn.Close()
+		if frame := readFrame(t, conn); frame["type"] != "connection" {
+			t.Errorf("first frame = %v, want a connection confirmation", frame)
+		}
+	})
+
+	// expectClose reads until the server closes the connection and checks the close code
+	expectClose := func(t *testing.T, conn *gorillaws.Conn, code int) {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			_, _, err := conn.ReadMessage()
+			if err == nil {
+				continue
+			}
+			if !gorillaws.IsCloseError(err, code) {
+				t.Errorf("ReadMessage() error = %v, want close code %d", err, code)
+			}
+			return
+		}
+	}
+
+	// shortLived signs tokens that expire two seconds after they are issued
+	shortLived := auth.NewAuthService("test-secret", 2*time.Second, auth.WithSessionStore(store), auth.WithUserStore(store))
+
+	t.Run("token expiry", func(t *testing.T) {
+		token, _, _ := shortLived.GenerateToken(*user)
+		conn, _, err := gorillaws.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Bearer " + token}})
+		if err != nil {
+			t.Fatalf("Dial() unexpected error = %v", err)
+		}
+		defer conn.Close()
+		readFrame(t, conn)
+
+		expectClose(t, conn, websocket.CloseTokenExpired)
+	})
+
+	t.Run("reauth", func(t *testing.T) {
+		token, _, _ := shortLived.GenerateToken(*user)
+		conn, _, err := gorillaws.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Bearer " + token}})
+		if err != nil {
+			t.Fatalf("Dial() unexpected error = %v", err)
+		}
+		defer conn.Close()
+		readFrame(t, conn)
+
+		other, _ := chatService.RegisterUser(models.RegisterRequest{Username: "otheruser", Email: "other@example.com", Password: "password123"})
+		otherToken, _, _ := authService.GenerateToken(*other)
+		conn.WriteJSON(map[string]string{"type": "reauth", "token": otherToken})
+		if frame := readFrame(t, conn); frame["type"] != "error" {
+			t.Errorf("reauth as another user = %v, want an error frame", frame)
+		}
+
+		conn.WriteJSON(map[string]string{"type": "reauth", "token": login.Token})
+		if frame := readFrame(t, conn); frame["type"] != "reauth" || frame["status"] != "ok" {
+			t.Fatalf("reauth = %v, want a reauth confirmation", frame)
+		}
+
+		// The connection outlives the original token
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		if _, _, err := conn.ReadMessage(); !strings.Contains(fmt.Sprint(err), "timeout") {
+			t.Errorf("ReadMessage() after reauth error = %v, want a read timeout", err)
+		}
+	})
+
+	t.Run("logout", func(t *testing.T) {
+		login, _ := chatService.AuthenticateUser(models.AuthRequest{Username: "testuser", Password: "password123"})
+		claims, _ := authService.ValidateToken(login.Token)
+		conn, _, err := gorillaws.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Bearer " + login.Token}})
+		if err != nil {
+			t.Fatalf("Dial() unexpected error = %v", err)
+		}
+		defer conn.Close()
+		readFrame(t, conn)
+
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/logout", nil)
+		ctx := context.WithValue(req.Context(), "userID", user.ID)
+		ctx = context.WithValue(ctx, "sessionID", claims.ID)
+		rr := httptest.NewRecorder()
+		NewAuthHandler(chatService, hub).Logout(rr, req.WithContext(ctx))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Logout() status = %v, want %v", rr.Code, http.StatusOK)
+		}
+
+		expectClose(t, conn, websocket.CloseSessionRevoked)
+		if _, err := authService.ValidateToken(login.Token); err == nil {
+			t.Error("ValidateToken() should reject the token after logout")
+		}
+	})
+
+	t.Run("token refresh", func(t *testing.T) {
+		token, _, _ := shortLived.GenerateToken(*user)
+		conn, _, err := gorillaws.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Bearer " + token}})
+		if err != nil {
+			t.Fatalf("Dial() unexpected error = %v", err)
+		}
+		defer conn.Close()
+		readFrame(t, conn)
+
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/refresh", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		NewAuthHandler(chatService, hub).RefreshToken(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("RefreshToken() status = %v, want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+		}
+
+		// The refreshed token's session is rotated out, along with its connections
+		expectClose(t, conn, websocket.CloseSessionRevoked)
+		if _, err := authService.ValidateToken(token); err == nil {
+			t.Error("ValidateToken() should reject the token after it was refreshed")
+		}
+	})
+
+	t.Run("role change", func(t *testing.T) {
+		member, _ := chatService.RegisterUser(models.RegisterRequest{Username: "promoted", Email: "promoted@example.com", Password: "password123"})
+		token, _, _ := authService.GenerateToken(*member)
+		conn, _, err := gorillaws.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Bearer " + token}})
+		if err != nil {
+			t.Fatalf("Dial() unexpected error = %v", err)
+		}
+		defer conn.Close()
+		readFrame(t, conn)
+
+		req := httptest.NewRequest(http.MethodPut, "/api/admin/users/"+member.ID+"/role", strings.NewReader(`{"role":"moderator"}`))
+		req = mux.SetURLVars(req, map[string]string{"userId": member.ID})
+		rr := httptest.NewRecorder()
+		NewAdminHandler(chatService, hub).UpdateUserRole(rr, req.WithContext(context.WithValue(req.Context(), "userID", user.ID)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("UpdateUserRole() status = %v, want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+		}
+
+		expectClose(t, conn, websocket.CloseSessionRevoked)
+	})
+
+	t.Run("owner deleted", func(t *testing.T) {
+		owner, _ := chatService.RegisterUser(models.RegisterRequest{Username: "botowner", Email: "botowner@example.com", Password: "password123"})
+		bot, err := chatService.CreateBot(owner.ID, models.CreateBotRequest{Username: "ownedbot"})
+		if err != nil {
+			t.Fatalf("CreateBot() unexpected error = %v", err)
+		}
+		token, _, _ := authService.GenerateToken(*bot)
+		conn, _, err := gorillaws.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Bearer " + token}})
+		if err != nil {
+			t.Fatalf("Dial() unexpected error = %v", err)
+		}
+		defer conn.Close()
+		readFrame(t, conn)
+
+		req := httptest.NewRequest(http.MethodDelete, "/api/admin/users/"+owner.ID, nil)
+		req = mux.SetURLVars(req, map[string]string{"userId": owner.ID})
+		rr := httptest.NewRecorder()
+		NewAdminHandler(chatService, hub).DeleteUser(rr, req.WithContext(context.WithValue(req.Context(), "userID", user.ID)))
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("DeleteUser() status = %v, want %v: %s", rr.Code, http.StatusNoContent, rr.Body.String())
+		}
+
+		// The bot was deleted with its owner
+		expectClose(t, conn, websocket.CloseSessionRevoked)
+	})
+
+	t.Run("frame throttling", func(t *testing.T) {
+		conn, _, err := gorillaws.DefaultDialer.Dial(strings.Replace(wsURL, "/connect", "/throttled", 1), http.Header{"Authorization": {"Bearer " + login.Token}})
+		if err != nil {
+			t.Fatalf("Dial() unexpected error = %v", err)
+		}
+		defer conn.Close()
+		readFrame(t, conn)
+
+		// Two pings fit the limit, the next two are answered with errors
+		for i, expected := range []string{"pong", "pong", "error", "error"} {
+			conn.WriteJSON(map[string]string{"type": "ping"})
+			if frame := readFrame(t, conn); frame["type"] != expected {
+				t.Errorf("frame %d = %v, want type %q", i+1, frame, expected)
+			}
+		}
+
+		// The third throttled frame in a row closes the connection
+		conn.WriteJSON(map[string]string{"type": "ping"})
+		expectClose(t, conn, gorillaws.ClosePolicyViolation)
+	})
+
+	t.Run("blocked sender", func(t *testing.T) {
+		blocked, _ := chatService.RegisterUser(models.RegisterRequest{Username: "blockeduser", Email: "blocked@example.com", Password: "password123"})
+		blockedToken, _, _ := authService.GenerateToken(*blocked)
+
+		blocker, _, err := gorillaws.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Bearer " + login.Token}})
+		if err != nil {
+			t.Fatalf("Dial() unexpected error = %v", err)
+		}
+		defer blocker.Close()
+		readFrame(t, blocker)
+		sender, _, err := gorillaws.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Bearer " + blockedToken}})
+		if err != nil {
+			t.Fatalf("Dial() unexpected error = %v", err)
+		}
+		defer sender.Close()
+		readFrame(t, sender)
+
+		// Blocking while connected updates the live connection
+		req := httptest.NewRequest(http.MethodPut, "/api/users/me/blocks/"+blocked.ID, nil)
+		req = mux.SetURLVars(req, map[string]string{"userId": blocked.ID})
+		rr := httptest.NewRecorder()
+		NewChatHandler(chatService, hub).BlockUser(rr, req.WithContext(context.WithValue(req.Context(), "userID", user.ID)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("BlockUser() status = %v, want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+		}
+
+		// The blocked user's broadcast reaches them but not the blocker, whose next
+		// frame is their own message
+		sender.WriteJSON(map[string]string{"type": "message", "content": "spam"})
+		readFrame(t, sender)
+		blocker.WriteJSON(map[string]string{"type": "message", "content": "hello"})
+		frame := readFrame(t, blocker)
+		if message, _ := frame["message"].(map[string]interface{}); message["sender"] != user.Username {
+			t.Errorf("blocker received %v, want their own message", frame)
+		}
+	})
+
+	t.Run("auth frame", func(t *testing.T) {
+		tests := []struct {
+			name          string
+			frame         interface{}
+			expectedFrame string
+		}{
+			{name: "valid token", frame: map[string]string{"type": "auth", "token": login.Token}, expectedFrame: "connection"},
+			{name: "invalid token", frame: map[string]string{"type": "auth", "token": "not-a-token"}, expectedFrame: "error"},
+			{name: "message before auth", frame: map[string]string{"type": "message", "content": "hello"}, expectedFrame: "error"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				conn, _, err := gorillaws.DefaultDialer.Dial(wsURL, nil)
+				if err != nil {
+					t.Fatalf("Dial() unexpected error = %v", err)
+				}
+				defer conn.Close()
+
+				if err := conn.WriteJSON(tt.frame); err != nil {
+					t.Fatalf("WriteJSON() unexpected error = %v", err)
+				}
+				if frame := readFrame(t, conn); frame["type"] != tt.expectedFrame {
+					t.Errorf("response frame = %v, want type %q", frame, tt.expectedFrame)
+				}
+			})
+		}
+	})
+
+	t.Run("admin token", func(t *testing.T) {
+		// Admin tokens carry every scope, and with a long username their frames outgrow
+		// the limit on chat frames
+		admin, _ := chatService.RegisterUser(models.RegisterRequest{Username: "administrator_of_the_chat_server", Email: "admin@example.com", Password: "password123"})
+		store.SetUserRole(admin.ID, models.RoleAdmin)
+		admin, _ = store.GetUser(admin.ID)
+		token, _, err := authService.GenerateToken(*admin)
+		if err != nil {
+			t.Fatalf("GenerateToken() unexpected error = %v", err)
+		}
+		frame, _ := json.Marshal(map[string]string{"type": "auth", "token": token})
+		if len(frame) <= 512 {
+			t.Fatalf("auth frame is %d bytes, want a frame over the 512 byte chat frame limit", len(frame))
+		}
+
+		conn, _, err := gorillaws.DefaultDialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatalf("Dial() unexpected error = %v", err)
+		}
+		defer conn.Close()
+
+		conn.WriteMessage(gorillaws.TextMessage, frame)
+		if frame := readFrame(t, conn); frame["type"] != "connection" {
+			t.Fatalf("auth with an admin token = %v, want a connection frame", frame)
+		}
+
+		conn.WriteJSON(map[string]string{"type": "reauth", "token": token})
+		if frame := readFrame(t, conn); frame["type"] != "reauth" || frame["status"] != "ok" {
+			t.Fatalf("reauth with an admin token = %v, want a reauth confirmation", frame)
+		}
+
+		// Other frames are still held to the chat frame limit
+		conn.WriteJSON(map[string]string{"type": "message", "content": strings.Repeat("a", 600)})
+		expectClose(t, conn, gorillaws.CloseMessageTooBig)
+	})
+}
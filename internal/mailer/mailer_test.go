@@ -0,0 +1,52 @@
+package mailer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLogMailer_Send(t *testing.T) {
+	var out bytes.Buffer
+	m := NewLogMailer(&out)
+
+	msg := Message{To: "user@example.com", Subject: "Hello", Body: "Test body"}
+	if err := m.Send(msg); err != nil {
+		t.Fatalf("Send() unexpected error = %v", err)
+	}
+
+	sent := m.Sent()
+	if len(sent) != 1 {
+		t.Fatalf("Sent() returned %d messages, want 1", len(sent))
+	}
+	if sent[0] != msg {
+		t.Errorf("Sent()[0] = %+v, want %+v", sent[0], msg)
+	}
+
+	for _, want := range []string{"To: user@example.com", "Subject: Hello", "Test body"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("LogMailer output missing %q", want)
+		}
+	}
+}
+
+func TestSMTPMailer_Format(t *testing.T) {
+	m := NewSMTPMailer("smtp.example.com", "587", "", "", "no-reply@example.com")
+
+	data := string(m.format(Message{To: "user@example.com", Subject: "Hi", Body: "line1\nline2"}))
+
+	for _, want := range []string{
+		"From: no-reply@example.com\r\n",
+		"To: user@example.com\r\n",
+		"Subject: Hi\r\n",
+		"\r\n\r\nline1\r\nline2",
+	} {
+		if !strings.Contains(data, want) {
+			t.Errorf("format() output missing %q", want)
+		}
+	}
+
+	if m.auth != nil {
+		t.Error("NewSMTPMailer() should not configure auth without a username")
+	}
+}
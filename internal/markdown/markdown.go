@@ -0,0 +1,273 @@
+// Package markdown renders the restricted Markdown subset allowed in messages to safe
+// HTML. Only paragraphs, line breaks, block quotes, fenced code blocks, inline code,
+// bold, italics and links are recognized. Everything else, including raw HTML, is
+// escaped and shown as text, so the output can be inserted into a page as is.
+package markdown
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// maxDepth limits the nesting of quotes and inline emphasis
+const maxDepth = 8
+
+var (
+	// autolinkPattern matches bare http and https links
+	autolinkPattern = regexp.MustCompile(`^https?://[^\s<>"]+`)
+
+	// languagePattern restricts the language names of code blocks used in class attributes
+	languagePattern = regexp.MustCompile(`^[A-Za-z0-9_+#.-]{1,32}$`)
+)
+
+// Render converts Markdown source to sanitized HTML
+func Render(source string) string {
+	source = strings.ReplaceAll(source, "\r\n", "\n")
+	source = strings.ReplaceAll(source, "\r", "\n")
+
+	var out strings.Builder
+	renderBlocks(&out, strings.Split(source, "\n"), 0)
+	return strings.TrimSuffix(out.String(), "\n")
+}
+
+// renderBlocks renders lines as code blocks, quotes and paragraphs
+func renderBlocks(out *strings.Builder, lines []string, depth int) {
+	var paragraph []string
+	flush := func() {
+		if len(paragraph) == 0 {
+			return
+		}
+		out.WriteString("<p>")
+		for i, line := range paragraph {
+			if i > 0 {
+				out.WriteString("<br>\n")
+			}
+			out.WriteString(renderInline(strings.TrimSpace(line), depth, true))
+		}
+		out.WriteString("</p>\n")
+		paragraph = nil
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(trimmed, "```"):
+			flush()
+			language := strings.TrimSpace(strings.TrimPrefix(trimmed, "```"))
+			var code []string
+			for i++; i < len(lines) && strings.TrimSpace(lines[i]) != "```"; i++ {
+				code = append(code, lines[i])
+			}
+			out.WriteString("<pre><code")
+			if languagePattern.MatchString(language) {
+				out.WriteString(` class="language-` + html.EscapeString(language) + `"`)
+			}
+			out.WriteString(">" + html.EscapeString(strings.Join(code, "\n")) + "</code></pre>\n")
+
+		case strings.HasPrefix(trimmed, ">") && depth < maxDepth:
+			flush()
+			var quoted []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+				quote := strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")
+				quoted = append(quoted, strings.TrimPrefix(quote, " "))
+			}
+			i--
+			out.WriteString("<blockquote>\n")
+			renderBlocks(out, quoted, depth+1)
+			out.WriteString("</blockquote>\n")
+
+		case trimmed == "":
+			flush()
+
+		default:
+			paragraph = append(paragraph, line)
+		}
+	}
+	flush()
+}
+
+// renderInline renders emphasis, code spans and links in a line of text. Links are not
+// recognized inside link text.
+func renderInline(text string, depth int, links bool) string {
+	var out strings.Builder
+	for i := 0; i < len(text); {
+		c := text[i]
+		rest := text[i:]
+
+		switch {
+		case c == '\\' && i+1 < len(text) && isPunctuation(text[i+1]):
+			out.WriteString(html.EscapeString(text[i+1 : i+2]))
+			i += 2
+			continue
+
+		case c == '`':
+			if end := strings.IndexByte(text[i+1:], '`'); end > 0 {
+				out.WriteString("<code>" + html.EscapeString(text[i+1:i+1+end]) + "</code>")
+				i += end + 2
+				continue
+			}
+
+		case strings.HasPrefix(rest, "***"):
+			// Runs of three or more asterisks are text, such as words masked by moderation
+			run := len(rest) - len(strings.TrimLeft(rest, "*"))
+			out.WriteString(rest[:run])
+			i += run
+			continue
+
+		case strings.HasPrefix(rest, "**") && depth < maxDepth:
+			if end := closingDelimiter(text, i+2, "**"); end > 0 {
+				out.WriteString("<strong>" + renderInline(text[i+2:end], depth+1, links) + "</strong>")
+				i = end + 2
+				continue
+			}
+
+		case (c == '*' || c == '_') && depth < maxDepth && opensEmphasis(text, i):
+			if end := closingDelimiter(text, i+1, string(c)); end > 0 {
+				out.WriteString("<em>" + renderInline(text[i+1:end], depth+1, links) + "</em>")
+				i = end + 1
+				continue
+			}
+
+		case c == '[' && links:
+			if label, target, n, ok := parseLink(rest); ok {
+				if href, safe := safeURL(target); safe {
+					out.WriteString(`<a href="` + html.EscapeString(href) + `" rel="nofollow noopener noreferrer">` +
+						renderInline(label, depth+1, false) + "</a>")
+				} else {
+					out.WriteString(renderInline(label, depth+1, false))
+				}
+				i += n
+				continue
+			}
+
+		case c == 'h' && links && (i == 0 || !isWordByte(text[i-1])):
+			if match := autolinkPattern.FindString(rest); match != "" {
+				link := trimLink(match)
+				if href, safe := safeURL(link); safe {
+					out.WriteString(`<a href="` + html.EscapeString(href) + `" rel="nofollow noopener noreferrer">` +
+						html.EscapeString(link) + "</a>")
+					i += len(link)
+					continue
+				}
+			}
+		}
+
+		out.WriteString(html.EscapeString(text[i : i+1]))
+		i++
+	}
+	return out.String()
+}
+
+// opensEmphasis reports whether a single * or _ at i can start emphasis. Underscores
+// inside words, as in snake_case, never do.
+func opensEmphasis(text string, i int) bool {
+	if i+1 >= len(text) || text[i+1] == ' ' {
+		return false
+	}
+	return text[i] == '*' || i == 0 || !isWordByte(text[i-1])
+}
+
+// closingDelimiter finds the delimiter closing emphasis whose content starts at from,
+// or returns -1. The content must not be empty or end with a space.
+func closingDelimiter(text string, from int, delimiter string) int {
+	for i := from + 1; i+len(delimiter) <= len(text); i++ {
+		if text[i-1] == '\\' {
+			continue
+		}
+		if text[i:i+len(delimiter)] != delimiter || text[i-1] == ' ' {
+			continue
+		}
+		// A single delimiter must not be half of a double one
+		if len(delimiter) == 1 && i+1 < len(text) && text[i+1] == delimiter[0] {
+			i++
+			continue
+		}
+		if delimiter == "_" && i+1 < len(text) && isWordByte(text[i+1]) {
+			continue
+		}
+		return i
+	}
+	return -1
+}
+
+// parseLink parses [label](target) at the start of text, returning the number of bytes consumed
+func parseLink(text string) (label, target string, n int, ok bool) {
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				if i+1 >= len(text) || text[i+1] != '(' {
+					return "", "", 0, false
+				}
+				// The target ends at the first unbalanced closing parenthesis
+				parens := 0
+				for j := i + 2; j < len(text); j++ {
+					switch text[j] {
+					case '(':
+						parens++
+					case ')':
+						if parens == 0 {
+							return text[1:i], strings.TrimSpace(text[i+2 : j]), j + 1, true
+						}
+						parens--
+					}
+				}
+				return "", "", 0, false
+			}
+		}
+	}
+	return "", "", 0, false
+}
+
+// safeURL accepts absolute http, https and mailto links
+func safeURL(target string) (string, bool) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		if u.Host == "" {
+			return "", false
+		}
+	case "mailto":
+		if u.Opaque == "" {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	return u.String(), true
+}
+
+// trimLink removes punctuation that commonly follows a bare link in prose
+func trimLink(link string) string {
+	for len(link) > 0 {
+		last := link[len(link)-1]
+		switch {
+		case strings.IndexByte(".,;:!?'*_", last) >= 0:
+			link = link[:len(link)-1]
+		case last == ')' && strings.Count(link, "(") < strings.Count(link, ")"):
+			link = link[:len(link)-1]
+		default:
+			return link
+		}
+	}
+	return link
+}
+
+func isPunctuation(c byte) bool {
+	return strings.IndexByte("\\`*_[]()>#+-.!~|", c) >= 0
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
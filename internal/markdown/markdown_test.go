@@ -0,0 +1,69 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{name: "plain text", source: "hello", want: "<p>hello</p>"},
+		{name: "emphasis", source: "**bold** and *italic* and _also_", want: "<p><strong>bold</strong> and <em>italic</em> and <em>also</em></p>"},
+		{name: "nested emphasis", source: "**bold _and italic_**", want: "<p><strong>bold <em>and italic</em></strong></p>"},
+		{name: "snake case", source: "use some_var_name here", want: "<p>use some_var_name here</p>"},
+		{name: "unclosed", source: "2 * 3 and **open", want: "<p>2 * 3 and **open</p>"},
+		{name: "masked words", source: "**** it, *really* ****", want: "<p>**** it, <em>really</em> ****</p>"},
+		{name: "escaped", source: `\*not italic\*`, want: "<p>*not italic*</p>"},
+		{name: "inline code", source: "run `a <b> **c**`", want: "<p>run <code>a &lt;b&gt; **c**</code></p>"},
+		{name: "line breaks and paragraphs", source: "one\ntwo\n\nthree", want: "<p>one<br>\ntwo</p>\n<p>three</p>"},
+		{name: "link with parentheses", source: "[Go](https://en.wikipedia.org/wiki/Go_(language))", want: `<p><a href="https://en.wikipedia.org/wiki/Go_(language)" rel="nofollow noopener noreferrer">Go</a></p>`},
+		{name: "link", source: "[the **docs**](https://go.dev/doc?a=1&b=2)", want: `<p><a href="https://go.dev/doc?a=1&amp;b=2" rel="nofollow noopener noreferrer">the <strong>docs</strong></a></p>`},
+		{name: "autolink", source: "see https://go.dev/doc.", want: `<p>see <a href="https://go.dev/doc" rel="nofollow noopener noreferrer">https://go.dev/doc</a>.</p>`},
+		{name: "mailto", source: "[mail](mailto:team@example.com)", want: `<p><a href="mailto:team@example.com" rel="nofollow noopener noreferrer">mail</a></p>`},
+		{name: "quote", source: "> quoted **text**\n> more\n\nafter", want: "<blockquote>\n<p>quoted <strong>text</strong><br>\nmore</p>\n</blockquote>\n<p>after</p>"},
+		{name: "code block", source: "```go\nfmt.Println(\"<hi>\")\n```", want: "<pre><code class=\"language-go\">fmt.Println(&#34;&lt;hi&gt;&#34;)</code></pre>"},
+		{name: "unterminated code block", source: "```\n**raw**", want: "<pre><code>**raw**</code></pre>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Render(tt.source); got != tt.want {
+				t.Errorf("Render(%q) = %q, want %q", tt.source, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRender_Sanitizes(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{name: "raw html", source: `<img src=x onerror="alert(1)">`, want: "<p>&lt;img src=x onerror=&#34;alert(1)&#34;&gt;</p>"},
+		{name: "javascript link", source: "[click](javascript:alert(1))", want: "<p>click</p>"},
+		{name: "data link", source: "[click](data:text/html;base64,PHNjcmlwdD4=)", want: "<p>click</p>"},
+		{name: "relative link", source: "[click](/api/admin)", want: "<p>click</p>"},
+		{name: "attribute breakout", source: `[x](https://example.com/"onmouseover="alert(1))`, want: `<p><a href="https://example.com/%22onmouseover=%22alert%281%29" rel="nofollow noopener noreferrer">x</a></p>`},
+		{name: "code block language", source: "```\"><script>\nx\n```", want: "<pre><code>x</code></pre>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Render(tt.source); got != tt.want {
+				t.Errorf("Render(%q) = %q, want %q", tt.source, got, tt.want)
+			}
+		})
+	}
+
+	// Deeply nested input is rendered without unbounded recursion
+	source := strings.Repeat(">", 1000) + strings.Repeat("*_", 1000) + "x" + strings.Repeat("_*", 1000)
+	got := Render(source)
+	if strings.Count(got, "<blockquote>")+strings.Count(got, "<em>") > maxDepth {
+		t.Errorf("Render() nested deeper than %d levels", maxDepth)
+	}
+}
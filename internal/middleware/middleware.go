@@ -3,14 +3,28 @@ package middleware
 import (
 	"bufio"
 	"context"
+	"crypto/subtle"
+	"errors"
 	"go-chat-api/internal/auth"
+	"go-chat-api/internal/config"
+	"go-chat-api/internal/models"
+	"go-chat-api/internal/ratelimit"
 	"log"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
 
+const (
+	// CSRFCookieName is the cookie holding the CSRF token of cookie-authenticated clients
+	CSRFCookieName = "csrf_token"
+
+	// CSRFHeaderName is the header in which cookie-authenticated clients echo the CSRF token
+	CSRFHeaderName = "X-CSRF-Token"
+)
+
 // LoggingMiddleware logs HTTP requests
 func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -43,44 +57,40 @@ func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	return nil, nil, http.ErrNotSupported
 }
 
-// CORSMiddleware handles CORS headers
-func CORSMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Allow multiple origins for development
-		origin := r.Header.Get("Origin")
-		allowedOrigins := []string{
-			"http://localhost:3000",
-			"http://localhost:8080",
-			"http://127.0.0.1:8080",
-		}
+// CORSMiddleware handles CORS headers for the origins allowed by the policy
+func CORSMiddleware(origins config.OriginPolicy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			w.Header().Add("Vary", "Origin")
 
-		for _, allowedOrigin := range allowedOrigins {
-			if origin == allowedOrigin {
+			if origin == "" {
+				// If no origin header (same-origin requests), allow it
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else if origins.Allows(origin) {
 				w.Header().Set("Access-Control-Allow-Origin", origin)
-				break
+				w.Header().Set("Access-Control-Allow-Credentials", "true") // Allow cookies
+			} else if r.Method == "OPTIONS" {
+				// Reject preflights from unknown origins so the browser never sends the request
+				http.Error(w, "Origin not allowed", http.StatusForbidden)
+				return
 			}
-		}
-
-		// If no origin header (same-origin requests), allow it
-		if origin == "" {
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-		}
 
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		w.Header().Set("Access-Control-Allow-Credentials", "true") // Allow cookies
-		w.Header().Set("Access-Control-Max-Age", "3600")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+CSRFHeaderName)
+			w.Header().Set("Access-Control-Max-Age", "3600")
 
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+			if r.Method == "OPTIONS" {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
 
-		next.ServeHTTP(w, r)
-	})
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
-// AuthMiddleware validates JWT tokens and adds user context
+// AuthMiddleware validates JWT tokens or API keys and adds user context
 func AuthMiddleware(authService *auth.AuthService) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -96,9 +106,11 @@ func AuthMiddleware(authService *auth.AuthService) func(http.Handler) http.Handl
 			}
 
 			// If no valid token in header, try to get from cookie
+			fromCookie := false
 			if tokenString == "" {
 				if cookie, err := r.Cookie("jwt_token"); err == nil {
 					tokenString = cookie.Value
+					fromCookie = true
 				}
 			}
 
@@ -108,22 +120,61 @@ func AuthMiddleware(authService *auth.AuthService) func(http.Handler) http.Handl
 				return
 			}
 
-			claims, err := authService.ValidateToken(tokenString)
+			claims, err := authService.Authenticate(tokenString)
+			if errors.Is(err, auth.ErrAccountSuspended) {
+				http.Error(w, "Account suspended", http.StatusForbidden)
+				return
+			}
 			if err != nil {
 				http.Error(w, "Invalid token", http.StatusUnauthorized)
 				return
 			}
 
 			// Add user information to request context
-			ctx := context.WithValue(r.Context(), "userID", claims.UserID)
-			ctx = context.WithValue(ctx, "username", claims.Username)
-			r = r.WithContext(ctx)
+			r = r.WithContext(context.WithValue(withClaims(r.Context(), claims), "cookieAuth", fromCookie))
 
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
+// CSRFMiddleware enforces the double-submit CSRF token on state-changing requests that were
+// authenticated with the jwt_token cookie: the X-CSRF-Token header must match the csrf_token
+// cookie. Bearer tokens and API keys are never sent by the browser on its own, so requests
+// using them pass through. It must run after AuthMiddleware.
+func CSRFMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if cookieAuth, _ := r.Context().Value("cookieAuth").(bool); !cookieAuth {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !ValidCSRFToken(r) {
+			http.Error(w, "Invalid or missing CSRF token", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ValidCSRFToken reports whether the X-CSRF-Token header of a request matches its
+// csrf_token cookie
+func ValidCSRFToken(r *http.Request) bool {
+	cookie, err := r.Cookie(CSRFCookieName)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	header := r.Header.Get(CSRFHeaderName)
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) == 1
+}
+
 // OptionalAuthMiddleware validates JWT tokens but doesn't require them
 func OptionalAuthMiddleware(authService *auth.AuthService) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -148,12 +199,43 @@ func OptionalAuthMiddleware(authService *auth.AuthService) func(http.Handler) ht
 
 			// If token found, validate it and add to context
 			if tokenString != "" {
-				claims, err := authService.ValidateToken(tokenString)
+				claims, err := authService.Authenticate(tokenString)
 				if err == nil {
 					// Add user information to request context
-					ctx := context.WithValue(r.Context(), "userID", claims.UserID)
-					ctx = context.WithValue(ctx, "username", claims.Username)
-					r = r.WithContext(ctx)
+					r = r.WithContext(withClaims(r.Context(), claims))
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// withClaims adds the authenticated user's information to a request context
+func withClaims(ctx context.Context, claims *models.Claims) context.Context {
+	ctx = context.WithValue(ctx, "userID", claims.UserID)
+	ctx = context.WithValue(ctx, "username", claims.Username)
+	ctx = context.WithValue(ctx, "sessionID", claims.ID)
+	ctx = context.WithValue(ctx, "scopes", claims.Scopes)
+	if claims.APIKeyID != "" {
+		ctx = context.WithValue(ctx, "apiKeyID", claims.APIKeyID)
+	}
+	if claims.ExpiresAt != nil {
+		ctx = context.WithValue(ctx, "expiresAt", claims.ExpiresAt.Time)
+	}
+	return ctx
+}
+
+// RequireScope restricts access to tokens and API keys holding all of the given scopes.
+// User tokens carry the scopes of the user's role. It must run after AuthMiddleware.
+func RequireScope(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			granted, _ := r.Context().Value("scopes").([]string)
+			for _, scope := range scopes {
+				if !auth.HasScope(granted, scope) {
+					http.Error(w, "Missing required scope: "+scope, http.StatusForbidden)
+					return
 				}
 			}
 
@@ -161,3 +243,63 @@ func OptionalAuthMiddleware(authService *auth.AuthService) func(http.Handler) ht
 		})
 	}
 }
+
+// RequireUserSession rejects requests authenticated with an API key, for account
+// management endpoints that integrations must not reach. It must run after AuthMiddleware.
+func RequireUserSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if apiKeyID, _ := r.Context().Value("apiKeyID").(string); apiKeyID != "" {
+			http.Error(w, "This endpoint cannot be used with an API key", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RateLimit limits the requests of a route class per user and per client IP. Every
+// response carries the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers,
+// and rejected requests get 429 with Retry-After. It must run after AuthMiddleware for
+// per-user limits to apply; anonymous requests are limited per IP only.
+func RateLimit(limiter *ratelimit.Limiter, class string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, _ := r.Context().Value("userID").(string)
+
+			result, err := limiter.Allow(class, userID, ClientIP(r))
+			if err != nil {
+				// Fail open: an unavailable limiter backend must not take the API down
+				log.Printf("Rate limiter error: %v", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if result.Limit > 0 {
+				w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+				w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+				w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+			}
+			if !result.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+				http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ceilSeconds rounds a duration up to whole seconds
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// ClientIP returns the IP address of the client that sent the request
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -1,8 +1,12 @@
 package middleware
 
 import (
+	"context"
 	"go-chat-api/internal/auth"
+	"go-chat-api/internal/config"
 	"go-chat-api/internal/models"
+	"go-chat-api/internal/ratelimit"
+	"go-chat-api/internal/storage"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -207,37 +211,79 @@ func TestCORSMiddleware(t *testing.T) {
 		w.WriteHeader(http.StatusOK)
 	})
 
-	corsHandler := CORSMiddleware(testHandler)
+	corsHandler := CORSMiddleware(config.NewOriginPolicy([]string{"http://localhost:3000", "https://*.example.com"}))(testHandler)
 
 	tests := []struct {
 		name           string
 		method         string
+		origin         string
 		expectedStatus int
+		expectedOrigin string
 		checkHeaders   bool
 	}{
 		{
 			name:           "OPTIONS request",
 			method:         http.MethodOptions,
 			expectedStatus: http.StatusOK,
+			expectedOrigin: "*",
 			checkHeaders:   true,
 		},
 		{
 			name:           "GET request",
 			method:         http.MethodGet,
 			expectedStatus: http.StatusOK,
+			expectedOrigin: "*",
 			checkHeaders:   true,
 		},
 		{
 			name:           "POST request",
 			method:         http.MethodPost,
 			expectedStatus: http.StatusOK,
+			expectedOrigin: "*",
 			checkHeaders:   true,
 		},
+		{
+			name:           "preflight from allowed origin",
+			method:         http.MethodOptions,
+			origin:         "http://localhost:3000",
+			expectedStatus: http.StatusOK,
+			expectedOrigin: "http://localhost:3000",
+			checkHeaders:   true,
+		},
+		{
+			name:           "preflight from wildcard subdomain",
+			method:         http.MethodOptions,
+			origin:         "https://app.example.com",
+			expectedStatus: http.StatusOK,
+			expectedOrigin: "https://app.example.com",
+			checkHeaders:   true,
+		},
+		{
+			name:           "preflight from unknown origin",
+			method:         http.MethodOptions,
+			origin:         "https://evil.example.org",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "preflight from wildcard apex",
+			method:         http.MethodOptions,
+			origin:         "https://example.com",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "request from unknown origin",
+			method:         http.MethodGet,
+			origin:         "https://evil.example.org",
+			expectedStatus: http.StatusOK,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			req := httptest.NewRequest(tt.method, "/test", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
 			rr := httptest.NewRecorder()
 
 			corsHandler.ServeHTTP(rr, req)
@@ -246,11 +292,14 @@ func TestCORSMiddleware(t *testing.T) {
 				t.Errorf("CORSMiddleware status = %v, want %v", rr.Code, tt.expectedStatus)
 			}
 
+			if got := rr.Header().Get("Access-Control-Allow-Origin"); got != tt.expectedOrigin {
+				t.Errorf("CORSMiddleware Access-Control-Allow-Origin = %v, want %v", got, tt.expectedOrigin)
+			}
+
 			if tt.checkHeaders {
 				expectedHeaders := map[string]string{
-					"Access-Control-Allow-Origin":  "*",
 					"Access-Control-Allow-Methods": "GET, POST, PUT, DELETE, OPTIONS",
-					"Access-Control-Allow-Headers": "Content-Type, Authorization",
+					"Access-Control-Allow-Headers": "Content-Type, Authorization, X-CSRF-Token",
 				}
 
 				for header, expectedValue := range expectedHeaders {
@@ -264,6 +313,112 @@ func TestCORSMiddleware(t *testing.T) {
 	}
 }
 
+func TestCSRFMiddleware(t *testing.T) {
+	authService := auth.NewAuthService("test-secret", 24*time.Hour)
+	token, _, err := authService.GenerateToken(models.User{ID: "test-user-id", Username: "testuser"})
+	if err != nil {
+		t.Fatalf("Failed to generate test token: %v", err)
+	}
+
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	protectedHandler := AuthMiddleware(authService)(CSRFMiddleware(testHandler))
+
+	tests := []struct {
+		name           string
+		method         string
+		bearer         bool
+		csrfCookie     string
+		csrfHeader     string
+		expectedStatus int
+	}{
+		{name: "cookie auth with matching token", method: http.MethodPost, csrfCookie: "csrf-123", csrfHeader: "csrf-123", expectedStatus: http.StatusOK},
+		{name: "cookie auth without token", method: http.MethodPost, expectedStatus: http.StatusForbidden},
+		{name: "cookie auth without header", method: http.MethodDelete, csrfCookie: "csrf-123", expectedStatus: http.StatusForbidden},
+		{name: "cookie auth with mismatched token", method: http.MethodPut, csrfCookie: "csrf-123", csrfHeader: "csrf-456", expectedStatus: http.StatusForbidden},
+		{name: "cookie auth with header but no cookie", method: http.MethodPost, csrfHeader: "csrf-123", expectedStatus: http.StatusForbidden},
+		{name: "cookie auth safe method", method: http.MethodGet, expectedStatus: http.StatusOK},
+		{name: "bearer auth without token", method: http.MethodPost, bearer: true, expectedStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/test", nil)
+			if tt.bearer {
+				req.Header.Set("Authorization", "Bearer "+token)
+			} else {
+				req.AddCookie(&http.Cookie{Name: "jwt_token", Value: token})
+			}
+			if tt.csrfCookie != "" {
+				req.AddCookie(&http.Cookie{Name: CSRFCookieName, Value: tt.csrfCookie})
+			}
+			if tt.csrfHeader != "" {
+				req.Header.Set(CSRFHeaderName, tt.csrfHeader)
+			}
+			rr := httptest.NewRecorder()
+
+			protectedHandler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("CSRFMiddleware status = %v, want %v", rr.Code, tt.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), map[string]ratelimit.Rule{
+		ratelimit.ClassAuth: {PerIP: ratelimit.Limit{Requests: 2, Window: time.Minute}},
+		ratelimit.ClassAPI:  {PerUser: ratelimit.Limit{Requests: 1, Window: time.Minute}},
+	})
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name              string
+		class             string
+		userID            string
+		remoteAddr        string
+		expectedStatus    int
+		expectedRemaining string
+	}{
+		{name: "first request", class: ratelimit.ClassAuth, remoteAddr: "203.0.113.1:1234", expectedStatus: http.StatusOK, expectedRemaining: "1"},
+		{name: "second request", class: ratelimit.ClassAuth, remoteAddr: "203.0.113.1:5678", expectedStatus: http.StatusOK, expectedRemaining: "0"},
+		{name: "limit exceeded", class: ratelimit.ClassAuth, remoteAddr: "203.0.113.1:1234", expectedStatus: http.StatusTooManyRequests, expectedRemaining: "0"},
+		{name: "other IP", class: ratelimit.ClassAuth, remoteAddr: "203.0.113.2:1234", expectedStatus: http.StatusOK, expectedRemaining: "1"},
+		{name: "per user", class: ratelimit.ClassAPI, userID: "user-1", remoteAddr: "203.0.113.3:1234", expectedStatus: http.StatusOK, expectedRemaining: "0"},
+		{name: "per user from another IP", class: ratelimit.ClassAPI, userID: "user-1", remoteAddr: "203.0.113.4:1234", expectedStatus: http.StatusTooManyRequests, expectedRemaining: "0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/test", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.userID != "" {
+				req = req.WithContext(context.WithValue(req.Context(), "userID", tt.userID))
+			}
+			rr := httptest.NewRecorder()
+
+			RateLimit(limiter, tt.class)(testHandler).ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("RateLimit status = %v, want %v", rr.Code, tt.expectedStatus)
+			}
+			if got := rr.Header().Get("RateLimit-Remaining"); got != tt.expectedRemaining {
+				t.Errorf("RateLimit-Remaining = %q, want %q", got, tt.expectedRemaining)
+			}
+			if rr.Header().Get("RateLimit-Limit") == "" || rr.Header().Get("RateLimit-Reset") == "" {
+				t.Error("RateLimit did not set the RateLimit-Limit and RateLimit-Reset headers")
+			}
+			if retryAfter := rr.Header().Get("Retry-After"); (rr.Code == http.StatusTooManyRequests) != (retryAfter != "") {
+				t.Errorf("Retry-After = %q with status %v", retryAfter, rr.Code)
+			}
+		})
+	}
+}
+
 func TestLoggingMiddleware(t *testing.T) {
 	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -292,3 +447,147 @@ func generateExpiredToken(authService *auth.AuthService, user models.User) strin
 	}
 	return token
 }
+
+func TestRequireScope(t *testing.T) {
+	authService := auth.NewAuthService("test-secret", 24*time.Hour)
+
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name           string
+		role           string
+		scope          string
+		expectedStatus int
+	}{
+		{name: "admin has admin scope", role: models.RoleAdmin, scope: auth.ScopeAdmin, expectedStatus: http.StatusOK},
+		{name: "admin has moderate scope", role: models.RoleAdmin, scope: auth.ScopeModerate, expectedStatus: http.StatusOK},
+		{name: "moderator has moderate scope", role: models.RoleModerator, scope: auth.ScopeModerate, expectedStatus: http.StatusOK},
+		{name: "moderator lacks admin scope", role: models.RoleModerator, scope: auth.ScopeAdmin, expectedStatus: http.StatusForbidden},
+		{name: "user has messages scope", role: models.RoleUser, scope: auth.ScopeMessagesWrite, expectedStatus: http.StatusOK},
+		{name: "user lacks admin scope", role: models.RoleUser, scope: auth.ScopeAdmin, expectedStatus: http.StatusForbidden},
+		{name: "user without role lacks admin scope", role: "", scope: auth.ScopeAdmin, expectedStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, _, err := authService.GenerateToken(models.User{ID: "user-id", Username: "testuser", Role: tt.role})
+			if err != nil {
+				t.Fatalf("Failed to generate test token: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/admin/test", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+
+			rr := httptest.NewRecorder()
+			AuthMiddleware(authService)(RequireScope(tt.scope)(testHandler)).ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("RequireScope status = %v, want %v", rr.Code, tt.expectedStatus)
+			}
+		})
+	}
+
+	t.Run("no auth context", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		RequireScope(auth.ScopeAdmin)(testHandler).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/admin/test", nil))
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("RequireScope status = %v, want %v", rr.Code, http.StatusForbidden)
+		}
+	})
+}
+
+func TestAuthMiddleware_APIKey(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour, auth.WithUserStore(store), auth.WithAPIKeyStore(store))
+
+	store.AddUser(models.User{ID: "bot-id", Username: "deploybot", IsBot: true})
+	key, prefix, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("GenerateAPIKey() unexpected error = %v", err)
+	}
+	store.AddAPIKey(models.APIKey{
+		ID:        "key-id",
+		UserID:    "bot-id",
+		Prefix:    prefix,
+		KeyHash:   hash,
+		Scopes:    []string{auth.ScopeMessagesWrite},
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+
+	okHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, _ := r.Context().Value("username").(string); username != "deploybot" {
+			t.Errorf("AuthMiddleware username = %v, want deploybot", username)
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	authenticate := AuthMiddleware(authService)
+
+	tests := []struct {
+		name           string
+		handler        http.Handler
+		expectedStatus int
+	}{
+		{name: "granted scope", handler: RequireScope(auth.ScopeMessagesWrite)(okHandler), expectedStatus: http.StatusOK},
+		{name: "missing scope", handler: RequireScope(auth.ScopeRoomsWrite)(okHandler), expectedStatus: http.StatusForbidden},
+		{name: "one of several scopes missing", handler: RequireScope(auth.ScopeMessagesWrite, auth.ScopeMessagesRead)(okHandler), expectedStatus: http.StatusForbidden},
+		{name: "user session required", handler: RequireUserSession(okHandler), expectedStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.Header.Set("Authorization", "Bearer "+key)
+
+			rr := httptest.NewRecorder()
+			authenticate(tt.handler).ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("status = %v, want %v", rr.Code, tt.expectedStatus)
+			}
+		})
+	}
+
+	store.AddUser(models.User{ID: "user-id", Username: "deploybot-owner", Role: models.RoleUser})
+	userToken, _, _ := authService.GenerateToken(models.User{ID: "user-id", Username: "deploybot"})
+
+	t.Run("user sessions carry the scopes of their role", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set("Authorization", "Bearer "+userToken)
+
+		rr := httptest.NewRecorder()
+		authenticate(RequireScope(auth.ScopeRoomsWrite)(RequireUserSession(okHandler))).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("status = %v, want %v", rr.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("suspended users are rejected", func(t *testing.T) {
+		suspendedAt := time.Now()
+		store.SetUserSuspended("user-id", &suspendedAt, "spam")
+		store.SetUserSuspended("bot-id", &suspendedAt, "spam")
+
+		for name, token := range map[string]string{"token": userToken, "api key": key} {
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+
+			rr := httptest.NewRecorder()
+			authenticate(okHandler).ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusForbidden {
+				t.Errorf("%s status = %v, want %v", name, rr.Code, http.StatusForbidden)
+			}
+		}
+	})
+}
+
+func TestClientIP(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.RemoteAddr = "203.0.113.7:54321"
+
+	if ip := ClientIP(req); ip != "203.0.113.7" {
+		t.Errorf("ClientIP() = %v, want %v", ip, "203.0.113.7")
+	}
+}
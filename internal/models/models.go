@@ -1,6 +1,9 @@
 package models
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -8,14 +11,227 @@ import (
 
 // Message represents a chat message
 type Message struct {
-	ID        string    `json:"id"`
-	Sender    string    `json:"sender"`
-	Recipient string    `json:"recipient"`
+	ID          string        `json:"id"`
+	Sender      string        `json:"sender"`
+	Recipient   string        `json:"recipient"`
+	Content     string        `json:"content"`
+	Format      string        `json:"format"`
+	HTML        string        `json:"html,omitempty"` // sanitized rendering of Markdown content
+	Timestamp   time.Time     `json:"timestamp"`
+	RoomID      string        `json:"room_id,omitempty"`
+	Attachments []Attachment  `json:"attachments,omitempty"`
+	Previews    []LinkPreview `json:"previews,omitempty"`
+	ExpiresAt   *time.Time    `json:"expires_at,omitempty"` // when a disappearing message is deleted
+	Poll        *Poll         `json:"poll,omitempty"`       // set on the messages that ask poll questions
+}
+
+// Message formats. Plain content is shown as text; Markdown content is also rendered to
+// sanitized HTML when the message is sent.
+const (
+	MessageFormatPlain    = "plain"
+	MessageFormatMarkdown = "markdown"
+)
+
+// Mention kinds: a user mentioned by name, or a room member reached through @room or @here
+const (
+	MentionUser = "user"
+	MentionRoom = "room"
+	MentionHere = "here"
+)
+
+// Mention notifies a user that a message mentioned them
+type Mention struct {
+	ID        string     `json:"id"`
+	UserID    string     `json:"user_id"`
+	MessageID string     `json:"message_id"`
+	Kind      string     `json:"kind"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReadAt    *time.Time `json:"read_at,omitempty"`
+
+	// Message is the mentioning message, so clients can jump to it
+	Message *Message `json:"message,omitempty"`
+}
+
+// MarkMentionsReadRequest lists the mentions to mark as read; an empty list marks them all
+type MarkMentionsReadRequest struct {
+	IDs []string `json:"ids,omitempty"`
+}
+
+// PinnedMessage is a message pinned to the top of a room by a moderator
+type PinnedMessage struct {
+	RoomID    string    `json:"room_id"`
+	MessageID string    `json:"message_id"`
+	PinnedBy  string    `json:"pinned_by"`
+	PinnedAt  time.Time `json:"pinned_at"`
+	Message   *Message  `json:"message,omitempty"`
+}
+
+// SavedMessage is a message a user bookmarked for later, with an optional private note
+type SavedMessage struct {
+	UserID    string    `json:"user_id"`
+	MessageID string    `json:"message_id"`
+	Note      string    `json:"note"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Message   *Message  `json:"message,omitempty"`
+}
+
+// SaveMessageRequest represents a request to bookmark a message or change its note
+type SaveMessageRequest struct {
+	Note string `json:"note"`
+}
+
+// Statuses of scheduled messages and reminders. Jobs are claimed by the scheduler while
+// they are being sent.
+const (
+	SchedulePending   = "pending"
+	ScheduleSending   = "sending"
+	ScheduleSent      = "sent"
+	ScheduleFailed    = "failed"
+	ScheduleCancelled = "cancelled"
+)
+
+// ScheduledMessage is a message a user scheduled to be sent to a room or conversation later
+type ScheduledMessage struct {
+	ID        string     `json:"id"`
+	UserID    string     `json:"user_id"`
+	Recipient string     `json:"recipient,omitempty"`
+	RoomID    string     `json:"room_id,omitempty"`
+	Content   string     `json:"content"`
+	Format    string     `json:"format"`
+	SendAt    time.Time  `json:"send_at"`
+	Status    string     `json:"status"`
+	MessageID string     `json:"message_id,omitempty"` // the sent message
+	Error     string     `json:"error,omitempty"`      // why sending failed
+	CreatedAt time.Time  `json:"created_at"`
+	ClaimedAt *time.Time `json:"-"`
+}
+
+// ScheduleMessageRequest represents a request to send a message at a later time
+type ScheduleMessageRequest struct {
 	Content   string    `json:"content"`
-	Timestamp time.Time `json:"timestamp"`
+	Recipient string    `json:"recipient,omitempty"`
 	RoomID    string    `json:"room_id,omitempty"`
+	Format    string    `json:"format,omitempty"`
+	SendAt    time.Time `json:"send_at"`
+}
+
+// Reminder brings a message back to a user's attention at a chosen time
+type Reminder struct {
+	ID        string     `json:"id"`
+	UserID    string     `json:"user_id"`
+	MessageID string     `json:"message_id"`
+	Note      string     `json:"note,omitempty"`
+	RemindAt  time.Time  `json:"remind_at"`
+	Status    string     `json:"status"`
+	CreatedAt time.Time  `json:"created_at"`
+	ClaimedAt *time.Time `json:"-"`
+	Message   *Message   `json:"message,omitempty"`
+}
+
+// ReminderRequest represents a request to be reminded of a message
+type ReminderRequest struct {
+	Note     string    `json:"note,omitempty"`
+	RemindAt time.Time `json:"remind_at"`
+}
+
+// Poll is a question with fixed options that room members vote on. It is posted as a room
+// message whose content is the question.
+type Poll struct {
+	ID             string       `json:"id"`
+	MessageID      string       `json:"message_id"`
+	RoomID         string       `json:"room_id"`
+	CreatedBy      string       `json:"created_by"`
+	Question       string       `json:"question"`
+	Options        []PollOption `json:"options"`
+	MultipleChoice bool         `json:"multiple_choice"`
+	Anonymous      bool         `json:"anonymous"` // votes are counted without showing who cast them
+	ClosesAt       *time.Time   `json:"closes_at,omitempty"`
+	ClosedAt       *time.Time   `json:"closed_at,omitempty"`
+	CreatedAt      time.Time    `json:"created_at"`
+
+	// Voters is the number of users who voted
+	Voters int `json:"voters"`
+
+	// Voted lists the options chosen by the user the poll is returned to
+	Voted []int `json:"voted,omitempty"`
+}
+
+// IsClosed reports whether the poll stopped accepting votes at the given time
+func (p *Poll) IsClosed(now time.Time) bool {
+	return p.ClosedAt != nil || (p.ClosesAt != nil && !p.ClosesAt.After(now))
+}
+
+// PollOption is an answer to a poll and its tally
+type PollOption struct {
+	Text   string   `json:"text"`
+	Votes  int      `json:"votes"`
+	Voters []string `json:"voters,omitempty"` // usernames of the voters, in public polls only
+}
+
+// PollVote is the set of options a user chose in a poll
+type PollVote struct {
+	PollID  string    `json:"poll_id"`
+	UserID  string    `json:"user_id"`
+	Options []int     `json:"options"`
+	VotedAt time.Time `json:"voted_at"`
+}
+
+// CreatePollRequest represents a request to post a poll in a room
+type CreatePollRequest struct {
+	Question       string     `json:"question"`
+	Options        []string   `json:"options"`
+	MultipleChoice bool       `json:"multiple_choice,omitempty"`
+	Anonymous      bool       `json:"anonymous,omitempty"`
+	ClosesAt       *time.Time `json:"closes_at,omitempty"`
+}
+
+// VoteRequest lists the options a user chooses in a poll, by position; an empty list
+// withdraws the vote
+type VoteRequest struct {
+	Options []int `json:"options"`
+}
+
+// LinkPreview is the OpenGraph or Twitter card metadata of a page linked in a message.
+// Previews are cached by URL and shared by the messages linking to the page.
+type LinkPreview struct {
+	URL         string    `json:"url"`
+	Title       string    `json:"title,omitempty"`
+	Description string    `json:"description,omitempty"`
+	SiteName    string    `json:"site_name,omitempty"`
+	ImageURL    string    `json:"image_url,omitempty"`
+	FetchedAt   time.Time `json:"fetched_at"`
+}
+
+// Attachment is a file uploaded for a message. Files are kept in a blob store and
+// downloaded through signed, expiring URLs.
+type Attachment struct {
+	ID          string `json:"id"`
+	UploaderID  string `json:"uploader_id"`
+	MessageID   string `json:"message_id,omitempty"` // empty until the attachment is sent with a message
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"` // sniffed from the content, not taken from the client
+	Size        int64  `json:"size"`
+
+	// Width and Height are the dimensions of images; images also get a thumbnail
+	Width        int       `json:"width,omitempty"`
+	Height       int       `json:"height,omitempty"`
+	HasThumbnail bool      `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+
+	// Signed download links, filled in when the attachment is returned to a client
+	URL          string     `json:"url,omitempty"`
+	ThumbnailURL string     `json:"thumbnail_url,omitempty"`
+	URLExpiresAt *time.Time `json:"url_expires_at,omitempty"`
 }
 
+// Global user roles
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
 // User represents a chat user
 type User struct {
 	ID           string    `json:"id"`
@@ -23,7 +239,30 @@ type User struct {
 	Email        string    `json:"email"`
 	PasswordHash string    `json:"-"` // Don't include in JSON responses
 	IsOnline     bool      `json:"is_online"`
+	Verified     bool      `json:"verified"`
+	Role         string    `json:"role"`
+	IsBot        bool      `json:"is_bot"`
+	OwnerID      string    `json:"owner_id,omitempty"` // User who manages a bot account
 	CreatedAt    time.Time `json:"created_at"`
+
+	SuspendedAt      *time.Time `json:"suspended_at,omitempty"`
+	SuspensionReason string     `json:"suspension_reason,omitempty"`
+}
+
+// IsSuspended reports whether an administrator has suspended the user
+func (u *User) IsSuspended() bool {
+	return u.SuspendedAt != nil
+}
+
+// UserFilter narrows an administrative user search. Nil fields are not filtered on.
+type UserFilter struct {
+	Query     string // Case-insensitive match on username or email
+	Role      string
+	Suspended *bool
+	IsBot     *bool
+	Verified  *bool
+	Limit     int
+	Offset    int
 }
 
 // ChatRoom represents a chat room
@@ -33,6 +272,171 @@ type ChatRoom struct {
 	Description string    `json:"description"`
 	Members     []string  `json:"members"`
 	CreatedAt   time.Time `json:"created_at"`
+
+	// SlowModeSeconds limits each member to one message per interval (0 disables slow mode)
+	SlowModeSeconds int `json:"slow_mode_seconds"`
+
+	// ReadOnly turns the room into an announcement channel where only users with the global
+	// admin role post; rooms have no owners or room moderators who could post instead
+	ReadOnly bool `json:"read_only"`
+
+	// MessageTTLSeconds makes messages disappear this long after they are sent (0 keeps them)
+	MessageTTLSeconds int `json:"message_ttl_seconds"`
+}
+
+// RoomSettingsRequest represents the request payload for changing a room's posting restrictions
+type RoomSettingsRequest struct {
+	SlowModeSeconds   int  `json:"slow_mode_seconds"`
+	ReadOnly          bool `json:"read_only"` // only global admins can post while set
+	MessageTTLSeconds int  `json:"message_ttl_seconds"`
+}
+
+// RoomMute stops a member from posting in a room until it expires or is lifted
+type RoomMute struct {
+	RoomID    string     `json:"room_id"`
+	UserID    string     `json:"user_id"`
+	MutedBy   string     `json:"muted_by,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // nil mutes until lifted
+}
+
+// IsActive reports whether the mute still applies at the given time
+func (m *RoomMute) IsActive(now time.Time) bool {
+	return m.ExpiresAt == nil || now.Before(*m.ExpiresAt)
+}
+
+// UserBlock records that a user blocked another user. Blocked users cannot message the
+// blocker or add them to rooms, and their messages are hidden from the blocker.
+type UserBlock struct {
+	UserID          string    `json:"user_id"`
+	BlockedID       string    `json:"blocked_id"`
+	BlockedUsername string    `json:"blocked_username"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// RoomModeration configures the content filters of a room on top of the server-wide rules
+type RoomModeration struct {
+	RoomID string `json:"room_id"`
+
+	// DisabledFilters lists filters that do not run in the room
+	DisabledFilters []string `json:"disabled_filters"`
+
+	// Words, regular expressions and link domains blocked in addition to the server-wide lists
+	BlockedWords    []string `json:"blocked_words"`
+	BlockedPatterns []string `json:"blocked_patterns"`
+	BlockedDomains  []string `json:"blocked_domains"`
+
+	// Actions overrides the action of a filter ("allow", "mask", "hold" or "reject"), by filter name
+	Actions map[string]string `json:"actions"`
+
+	UpdatedBy string     `json:"updated_by,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// Held message review states
+const (
+	HeldMessagePending  = "pending"
+	HeldMessageApproved = "approved"
+	HeldMessageRejected = "rejected"
+)
+
+// HeldMessage is a message a moderation filter held for review. Approving it stores and
+// delivers it as a regular message with the same ID.
+type HeldMessage struct {
+	ID            string     `json:"id"`
+	Sender        string     `json:"sender"`
+	Recipient     string     `json:"recipient,omitempty"`
+	RoomID        string     `json:"room_id,omitempty"`
+	Content       string     `json:"content"`
+	Format        string     `json:"format"`
+	TTLSeconds    int        `json:"ttl_seconds,omitempty"`    // applied from when the message is approved
+	AttachmentIDs []string   `json:"attachment_ids,omitempty"` // attached when the message is approved
+	Filter        string     `json:"filter"`
+	Reason        string     `json:"reason"`
+	Status        string     `json:"status"`
+	CreatedAt     time.Time  `json:"created_at"`
+	ReviewedBy    string     `json:"reviewed_by,omitempty"`
+	ReviewedAt    *time.Time `json:"reviewed_at,omitempty"`
+}
+
+// Report review states
+const (
+	ReportOpen      = "open"
+	ReportActioned  = "actioned"
+	ReportDismissed = "dismissed"
+)
+
+// Actions a moderator can take on a report
+const (
+	ReportActionDeleteMessage = "delete_message"
+	ReportActionMute          = "mute"
+	ReportActionSuspend       = "suspend"
+)
+
+// Report is a user's complaint about a message or another user, reviewed by moderators
+type Report struct {
+	ID         string `json:"id"`
+	ReporterID string `json:"reporter_id"`
+
+	// MessageID is empty for reports about a user; MessageContent keeps the reported
+	// content in case the message is deleted
+	MessageID      string `json:"message_id,omitempty"`
+	MessageContent string `json:"message_content,omitempty"`
+
+	ReportedUserID string    `json:"reported_user_id"`
+	RoomID         string    `json:"room_id,omitempty"`
+	Reason         string    `json:"reason"`
+	Status         string    `json:"status"`
+	CreatedAt      time.Time `json:"created_at"`
+
+	// Action is the action taken on an actioned report, and Note the moderator's comment
+	Action     string     `json:"action,omitempty"`
+	Note       string     `json:"note,omitempty"`
+	ResolvedBy string     `json:"resolved_by,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// ReportRequest represents the request payload for reporting a message or a user. Exactly
+// one of MessageID and UserID must be set.
+type ReportRequest struct {
+	MessageID string `json:"message_id"`
+	UserID    string `json:"user_id"`
+	Reason    string `json:"reason"`
+}
+
+// ReportActionRequest represents the request payload for acting on a report
+type ReportActionRequest struct {
+	Action string `json:"action"`
+
+	// DurationSeconds is the length of a mute (0 mutes until lifted)
+	DurationSeconds int    `json:"duration_seconds"`
+	Note            string `json:"note"`
+}
+
+// ReportDismissRequest represents the request payload for dismissing a report
+type ReportDismissRequest struct {
+	Note string `json:"note"`
+}
+
+// ReportFilter narrows a search of the report queue. Empty fields are not filtered on.
+type ReportFilter struct {
+	Status string
+	RoomID string
+	Limit  int
+	Offset int
+}
+
+// ReportListResponse is a page of reports with the total number of matches
+type ReportListResponse struct {
+	Reports []Report `json:"reports"`
+	Total   int      `json:"total"`
+}
+
+// MuteRequest represents the request payload for muting a room member
+type MuteRequest struct {
+	DurationSeconds int    `json:"duration_seconds"` // 0 mutes until lifted
+	Reason          string `json:"reason"`
 }
 
 // MessageRequest represents the request payload for sending a message
@@ -41,6 +445,15 @@ type MessageRequest struct {
 	Recipient string `json:"recipient"`
 	Content   string `json:"content" validate:"required"`
 	RoomID    string `json:"room_id,omitempty"`
+
+	// Format is "plain" (the default) or "markdown"
+	Format string `json:"format,omitempty"`
+
+	// AttachmentIDs are attachments uploaded by the sender to send with the message
+	AttachmentIDs []string `json:"attachment_ids,omitempty"`
+
+	// TTLSeconds makes the message disappear this long after it is sent (0 uses the room default)
+	TTLSeconds int `json:"ttl_seconds,omitempty"`
 }
 
 // CreateRoomRequest represents the request payload for creating a room
@@ -54,6 +467,7 @@ type CreateRoomRequest struct {
 type AuthRequest struct {
 	Username string `json:"username" validate:"required"`
 	Password string `json:"password" validate:"required"`
+	ClientIP string `json:"-"` // Set by the handler, used for brute-force protection
 }
 
 // RegisterRequest represents registration request
@@ -72,7 +486,220 @@ type AuthResponse struct {
 
 // Claims represents JWT claims
 type Claims struct {
-	UserID   string `json:"user_id"`
-	Username string `json:"username"`
+	UserID   string   `json:"user_id"`
+	Username string   `json:"username"`
+	Role     string   `json:"role,omitempty"`
+	Scopes   []string `json:"scopes,omitempty"`
+	APIKeyID string   `json:"-"` // Set when authenticated with an API key instead of a JWT
 	jwt.RegisteredClaims
 }
+
+// Session represents an issued authentication token that can be revoked
+type Session struct {
+	ID        string     `json:"id"`
+	UserID    string     `json:"user_id"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Token purposes for single-use user tokens
+const (
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailVerification = "email_verification"
+)
+
+// UserToken represents a single-use token sent to a user (only its hash is stored)
+type UserToken struct {
+	TokenHash string     `json:"-"`
+	UserID    string     `json:"user_id"`
+	Purpose   string     `json:"purpose"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+}
+
+// ChangePasswordRequest represents a password change request for the current user
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,min=6"`
+}
+
+// ForgotPasswordRequest represents a request to send a password reset email
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// ResetPasswordRequest represents a request to set a new password using a reset token
+type ResetPasswordRequest struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required,min=6"`
+}
+
+// LoginThrottle tracks consecutive failed logins for a username or client IP
+type LoginThrottle struct {
+	Key           string    `json:"key"`
+	Failures      int       `json:"failures"`
+	LastFailureAt time.Time `json:"last_failure_at"`
+	LockedUntil   time.Time `json:"locked_until"`
+}
+
+// AuditEvent represents a security-relevant event recorded in the audit trail
+type AuditEvent struct {
+	ID        string            `json:"id"`
+	Action    string            `json:"action"`
+	ActorID   string            `json:"actor_id,omitempty"`
+	TargetID  string            `json:"target_id,omitempty"`
+	IP        string            `json:"ip,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+
+	// Position in the hash chain and the hashes linking the event to its predecessor
+	Seq      int64  `json:"seq"`
+	PrevHash string `json:"prev_hash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// ComputeHash returns the SHA-256 hash that seals the event's content, sequence
+// number and predecessor hash into the audit chain
+func (e AuditEvent) ComputeHash() string {
+	metadata := e.Metadata
+	if len(metadata) == 0 {
+		metadata = nil
+	}
+
+	// Encoding a struct keeps the field order fixed, and map keys are sorted
+	payload, _ := json.Marshal(struct {
+		Seq       int64             `json:"seq"`
+		PrevHash  string            `json:"prev_hash"`
+		ID        string            `json:"id"`
+		Action    string            `json:"action"`
+		ActorID   string            `json:"actor_id"`
+		TargetID  string            `json:"target_id"`
+		IP        string            `json:"ip"`
+		Metadata  map[string]string `json:"metadata"`
+		CreatedAt string            `json:"created_at"`
+	}{e.Seq, e.PrevHash, e.ID, e.Action, e.ActorID, e.TargetID, e.IP, metadata, e.CreatedAt.UTC().Format(time.RFC3339Nano)})
+
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditFilter narrows an audit trail search. Empty fields are not filtered on.
+type AuditFilter struct {
+	Action   string
+	ActorID  string
+	TargetID string
+	IP       string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+	Offset   int
+}
+
+// AuditLogResponse represents a page of audit events, newest first
+type AuditLogResponse struct {
+	Events []AuditEvent `json:"events"`
+	Total  int          `json:"total"`
+}
+
+// AuditVerification reports whether the audit hash chain is intact
+type AuditVerification struct {
+	Valid    bool   `json:"valid"`
+	Events   int    `json:"events"`
+	BrokenAt int64  `json:"broken_at,omitempty"` // Sequence number of the first event that fails verification
+	Error    string `json:"error,omitempty"`
+}
+
+// UserIdentity links a user to an account at an external identity provider
+type UserIdentity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	UserID    string    `json:"user_id"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ExternalProfile is the identity asserted by an external identity provider at login
+type ExternalProfile struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+	ClientIP      string
+}
+
+// APIKey represents a long-lived, scoped credential for integrations (only its hash is stored)
+type APIKey struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // Leading characters of the key, shown to identify it
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// CreateAPIKeyRequest represents the request payload for creating an API key
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name" validate:"required"`
+	Scopes        []string `json:"scopes" validate:"required"`
+	ExpiresInDays int      `json:"expires_in_days"`
+	BotID         string   `json:"bot_id,omitempty"` // Issue the key for a bot owned by the caller
+}
+
+// CreateAPIKeyResponse contains a newly created API key. The key itself is only returned once.
+type CreateAPIKeyResponse struct {
+	Key    string `json:"key"`
+	APIKey APIKey `json:"api_key"`
+}
+
+// CreateBotRequest represents the request payload for creating a bot account
+type CreateBotRequest struct {
+	Username string `json:"username" validate:"required"`
+}
+
+// UpdateRoleRequest represents the request payload for changing a user's global role
+type UpdateRoleRequest struct {
+	Role string `json:"role" validate:"required"`
+}
+
+// SuspendUserRequest represents the request payload for suspending a user
+type SuspendUserRequest struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// UserListResponse represents a page of users returned by an administrative search
+type UserListResponse struct {
+	Users []User `json:"users"`
+	Total int    `json:"total"`
+}
+
+// WebSocketTicket is a short-lived, single-use credential that authenticates one WebSocket
+// connection on behalf of the token or API key that requested it
+type WebSocketTicket struct {
+	TicketHash string    `json:"-"`
+	UserID     string    `json:"user_id"`
+	Username   string    `json:"username"`
+	Role       string    `json:"role,omitempty"`
+	SessionID  string    `json:"session_id,omitempty"`
+	APIKeyID   string    `json:"api_key_id,omitempty"`
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// WebSocketTicketResponse represents the response payload for a newly issued WebSocket ticket
+type WebSocketTicketResponse struct {
+	Ticket    string    `json:"ticket"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CSRFTokenResponse represents the response payload of the CSRF token endpoint
+type CSRFTokenResponse struct {
+	CSRFToken string `json:"csrf_token"`
+}
@@ -0,0 +1,237 @@
+package moderation
+
+import (
+	"fmt"
+	"go-chat-api/internal/models"
+	"go-chat-api/internal/ratelimit"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Names of the built-in filters
+const (
+	FilterProfanity = "profanity"
+	FilterLinks     = "links"
+	FilterSpam      = "spam"
+)
+
+// WordFilter flags blocked words, matched whole and case-insensitively, and regular
+// expressions. Masking replaces each match with asterisks.
+type WordFilter struct {
+	words    *regexp.Regexp // nil without blocked words
+	patterns []*regexp.Regexp
+	action   Action
+}
+
+// NewWordFilter creates a filter for the blocked words and patterns
+func NewWordFilter(words, patterns []string, action Action) (*WordFilter, error) {
+	f := &WordFilter{
+		words:  wordsPattern(words),
+		action: action,
+	}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid blocked pattern %q: %w", pattern, err)
+		}
+		f.patterns = append(f.patterns, re)
+	}
+	return f, nil
+}
+
+// Name returns FilterProfanity
+func (f *WordFilter) Name() string {
+	return FilterProfanity
+}
+
+// Check masks blocked words and patterns, including those the room adds. Invalid room
+// patterns are skipped; they are validated when the room settings are saved.
+func (f *WordFilter) Check(msg Message, room models.RoomModeration) (Result, error) {
+	matchers := append([]*regexp.Regexp{}, f.patterns...)
+	if f.words != nil {
+		matchers = append(matchers, f.words)
+	}
+	if re := wordsPattern(room.BlockedWords); re != nil {
+		matchers = append(matchers, re)
+	}
+	for _, pattern := range room.BlockedPatterns {
+		if re, err := regexp.Compile(pattern); err == nil {
+			matchers = append(matchers, re)
+		}
+	}
+
+	masked := msg.Content
+	for _, re := range matchers {
+		masked = re.ReplaceAllStringFunc(masked, func(match string) string {
+			return strings.Repeat("*", utf8.RuneCountInString(match))
+		})
+	}
+	if masked == msg.Content {
+		return Result{Action: Allow}, nil
+	}
+	return Result{Action: f.action, Masked: masked, Reason: "message contains blocked words"}, nil
+}
+
+// wordsPattern builds a case-insensitive whole-word pattern matching any of the words
+func wordsPattern(words []string) *regexp.Regexp {
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		if word = strings.TrimSpace(word); word != "" {
+			quoted = append(quoted, regexp.QuoteMeta(word))
+		}
+	}
+	if len(quoted) == 0 {
+		return nil
+	}
+	return regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+}
+
+// linkPattern matches links with or without a scheme; the first group is the host
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://)?((?:[a-z0-9](?:[a-z0-9-]*[a-z0-9])?\.)+[a-z]{2,})(?::\d+)?(?:/\S*)?`)
+
+// LinkFilter flags links to blocked domains and their subdomains. Masking replaces
+// each blocked link with "[link removed]".
+type LinkFilter struct {
+	domains []string
+	action  Action
+}
+
+// NewLinkFilter creates a filter for links to the blocked domains
+func NewLinkFilter(domains []string, action Action) *LinkFilter {
+	return &LinkFilter{domains: normalizeDomains(domains), action: action}
+}
+
+// Name returns FilterLinks
+func (f *LinkFilter) Name() string {
+	return FilterLinks
+}
+
+// Check masks links to blocked domains, including those the room adds
+func (f *LinkFilter) Check(msg Message, room models.RoomModeration) (Result, error) {
+	domains := append(normalizeDomains(room.BlockedDomains), f.domains...)
+	if len(domains) == 0 {
+		return Result{Action: Allow}, nil
+	}
+
+	found := false
+	masked := linkPattern.ReplaceAllStringFunc(msg.Content, func(link string) string {
+		host := strings.ToLower(linkPattern.FindStringSubmatch(link)[1])
+		for _, domain := range domains {
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				found = true
+				return "[link removed]"
+			}
+		}
+		return link
+	})
+	if !found {
+		return Result{Action: Allow}, nil
+	}
+	return Result{Action: f.action, Masked: masked, Reason: "message links to a blocked domain"}, nil
+}
+
+// normalizeDomains lowercases domains and drops empty entries and leading dots
+func normalizeDomains(domains []string) []string {
+	normalized := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		if domain = strings.Trim(strings.ToLower(strings.TrimSpace(domain)), "."); domain != "" {
+			normalized = append(normalized, domain)
+		}
+	}
+	return normalized
+}
+
+// mentionPattern matches @username mentions
+var mentionPattern = regexp.MustCompile(`(?:^|\s)@[A-Za-z0-9._-]+`)
+
+// History gives the spam filter the recent messages of a sender
+type History interface {
+	// GetMessagesBySender returns the messages sent by a user since the given time
+	GetMessagesBySender(sender string, since time.Time) ([]models.Message, error)
+}
+
+// SpamPolicy sets the thresholds of the spam filter. Zero values disable a check.
+type SpamPolicy struct {
+	// MaxMentions is how many users one message may mention
+	MaxMentions int
+
+	// MaxRepeats is how many times the same content may be sent within RepeatWindow
+	MaxRepeats   int
+	RepeatWindow time.Duration
+
+	// Velocity limits how many messages a user may send, across all rooms and conversations
+	Velocity ratelimit.Limit
+}
+
+// SpamFilter flags mention bombs, repeated content and users sending messages too
+// quickly. It cannot mask what it finds.
+type SpamFilter struct {
+	history History
+	policy  SpamPolicy
+	action  Action
+	now     func() time.Time
+}
+
+// NewSpamFilter creates a spam filter that looks up recent messages in history
+func NewSpamFilter(history History, policy SpamPolicy, action Action) *SpamFilter {
+	return &SpamFilter{
+		history: history,
+		policy:  policy,
+		action:  action,
+		now:     time.Now,
+	}
+}
+
+// Name returns FilterSpam
+func (f *SpamFilter) Name() string {
+	return FilterSpam
+}
+
+// Check applies the spam policy to a message
+func (f *SpamFilter) Check(msg Message, room models.RoomModeration) (Result, error) {
+	if f.policy.MaxMentions > 0 && len(mentionPattern.FindAllString(msg.Content, -1)) > f.policy.MaxMentions {
+		return Result{Action: f.action, Reason: "message mentions too many users"}, nil
+	}
+
+	checkRepeats := f.policy.MaxRepeats > 0 && f.policy.RepeatWindow > 0
+	checkVelocity := f.policy.Velocity.Enabled()
+	if !checkRepeats && !checkVelocity {
+		return Result{Action: Allow}, nil
+	}
+
+	lookback := f.policy.RepeatWindow
+	if checkVelocity && f.policy.Velocity.Window > lookback {
+		lookback = f.policy.Velocity.Window
+	}
+	now := f.now()
+	recent, err := f.history.GetMessagesBySender(msg.Sender, now.Add(-lookback))
+	if err != nil {
+		return Result{}, err
+	}
+
+	content := normalizeContent(msg.Content)
+	repeats, sent := 0, 0
+	for _, m := range recent {
+		if checkRepeats && m.Timestamp.After(now.Add(-f.policy.RepeatWindow)) && normalizeContent(m.Content) == content {
+			repeats++
+		}
+		if checkVelocity && m.Timestamp.After(now.Add(-f.policy.Velocity.Window)) {
+			sent++
+		}
+	}
+
+	if checkRepeats && repeats >= f.policy.MaxRepeats {
+		return Result{Action: f.action, Reason: "message repeats recent messages"}, nil
+	}
+	if checkVelocity && sent >= f.policy.Velocity.Requests {
+		return Result{Action: f.action, Reason: "messages are being sent too quickly"}, nil
+	}
+	return Result{Action: Allow}, nil
+}
+
+// normalizeContent makes repeated messages compare equal regardless of case and spacing
+func normalizeContent(content string) string {
+	return strings.ToLower(strings.Join(strings.Fields(content), " "))
+}
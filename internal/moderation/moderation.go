@@ -0,0 +1,169 @@
+package moderation
+
+import (
+	"fmt"
+	"go-chat-api/internal/models"
+	"time"
+)
+
+// Action is what a filter decides to do with a message
+type Action string
+
+// Moderation actions, from least to most severe
+const (
+	// Allow delivers the message unchanged
+	Allow Action = "allow"
+
+	// Mask delivers the message with the offending parts replaced
+	Mask Action = "mask"
+
+	// Hold keeps the message out of the chat until a moderator approves it
+	Hold Action = "hold"
+
+	// Reject refuses the message
+	Reject Action = "reject"
+)
+
+// ParseAction parses the name of an action
+func ParseAction(s string) (Action, error) {
+	switch action := Action(s); action {
+	case Allow, Mask, Hold, Reject:
+		return action, nil
+	}
+	return "", fmt.Errorf("invalid moderation action %q", s)
+}
+
+// severity orders actions so the pipeline can keep the most severe one
+func (a Action) severity() int {
+	switch a {
+	case Mask:
+		return 1
+	case Hold:
+		return 2
+	case Reject:
+		return 3
+	}
+	return 0
+}
+
+// Message is a message being checked before it is stored
+type Message struct {
+	Sender    string // username
+	Recipient string // username, for direct messages
+	RoomID    string
+	Content   string
+	SentAt    time.Time
+}
+
+// Result is the decision of a single filter
+type Result struct {
+	// Action is Allow when the filter found nothing
+	Action Action
+
+	// Masked is the content with the offending parts replaced, or empty when the filter
+	// cannot mask what it found
+	Masked string
+
+	// Reason explains the decision to the sender and to moderators
+	Reason string
+}
+
+// Filter checks messages. Rooms can add to a filter's rules through their moderation
+// settings, disable it, or override the action it takes.
+type Filter interface {
+	// Name identifies the filter in room settings and held messages
+	Name() string
+
+	// Check inspects a message with the moderation settings of its room (zero for direct
+	// and global messages)
+	Check(msg Message, room models.RoomModeration) (Result, error)
+}
+
+// Verdict is the outcome of running a message through the pipeline
+type Verdict struct {
+	Action Action
+
+	// Content is the message content after masking
+	Content string
+
+	// Filter and Reason describe the filter behind the action (empty for Allow)
+	Filter string
+	Reason string
+}
+
+// Pipeline runs messages through a chain of filters
+type Pipeline struct {
+	filters []Filter
+}
+
+// NewPipeline creates a pipeline that runs the filters in order
+func NewPipeline(filters ...Filter) *Pipeline {
+	return &Pipeline{filters: filters}
+}
+
+// Has reports whether the pipeline has a filter with the given name
+func (p *Pipeline) Has(name string) bool {
+	if p == nil {
+		return false
+	}
+	for _, f := range p.filters {
+		if f.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Run checks a message against every filter the room has not disabled. Masks accumulate,
+// so later filters see the masked content, and the most severe action wins; a rejection
+// stops the chain. A filter asked to mask something it cannot mask holds the message
+// instead. A nil pipeline allows everything.
+func (p *Pipeline) Run(msg Message, room models.RoomModeration) (Verdict, error) {
+	verdict := Verdict{Action: Allow, Content: msg.Content}
+	if p == nil {
+		return verdict, nil
+	}
+
+	disabled := make(map[string]bool, len(room.DisabledFilters))
+	for _, name := range room.DisabledFilters {
+		disabled[name] = true
+	}
+
+	for _, f := range p.filters {
+		if disabled[f.Name()] {
+			continue
+		}
+
+		msg.Content = verdict.Content
+		result, err := f.Check(msg, room)
+		if err != nil {
+			return Verdict{}, fmt.Errorf("%s filter: %w", f.Name(), err)
+		}
+		if result.Action == Allow || result.Action == "" {
+			continue
+		}
+
+		action := result.Action
+		if override, ok := room.Actions[f.Name()]; ok {
+			action = Action(override)
+		}
+		if action == Mask {
+			if result.Masked != "" {
+				verdict.Content = result.Masked
+			} else {
+				action = Hold
+			}
+		}
+
+		if action.severity() > verdict.Action.severity() {
+			verdict.Action = action
+			verdict.Filter = f.Name()
+			verdict.Reason = result.Reason
+		}
+		if action == Reject {
+			break
+		}
+	}
+
+	return verdict, nil
+}
@@ -0,0 +1,196 @@
+package moderation
+
+import (
+	"go-chat-api/internal/models"
+	"go-chat-api/internal/ratelimit"
+	"testing"
+	"time"
+)
+
+type fakeHistory []models.Message
+
+func (h fakeHistory) GetMessagesBySender(sender string, since time.Time) ([]models.Message, error) {
+	var messages []models.Message
+	for _, m := range h {
+		if m.Sender == sender && !m.Timestamp.Before(since) {
+			messages = append(messages, m)
+		}
+	}
+	return messages, nil
+}
+
+func TestPipeline_Run(t *testing.T) {
+	words, err := NewWordFilter([]string{"darn"}, []string{`\d{4}-\d{4}-\d{4}-\d{4}`}, Mask)
+	if err != nil {
+		t.Fatalf("NewWordFilter() unexpected error = %v", err)
+	}
+	links := NewLinkFilter([]string{"spam.example"}, Reject)
+	spam := NewSpamFilter(fakeHistory{}, SpamPolicy{MaxMentions: 2}, Hold)
+	pipeline := NewPipeline(words, links, spam)
+
+	tests := []struct {
+		name        string
+		content     string
+		room        models.RoomModeration
+		wantAction  Action
+		wantContent string
+		wantFilter  string
+	}{
+		{
+			name:        "clean message",
+			content:     "hello there",
+			wantAction:  Allow,
+			wantContent: "hello there",
+		},
+		{
+			name:        "blocked word is masked case-insensitively",
+			content:     "Darn it",
+			wantAction:  Mask,
+			wantContent: "**** it",
+			wantFilter:  FilterProfanity,
+		},
+		{
+			name:        "words only match whole",
+			content:     "darned socks",
+			wantAction:  Allow,
+			wantContent: "darned socks",
+		},
+		{
+			name:        "pattern is masked",
+			content:     "card 1234-5678-9012-3456",
+			wantAction:  Mask,
+			wantContent: "card *******************",
+			wantFilter:  FilterProfanity,
+		},
+		{
+			name:       "blocked subdomain link is rejected",
+			content:    "darn, see https://www.spam.example/offer",
+			wantAction: Reject,
+			wantFilter: FilterLinks,
+		},
+		{
+			name:        "other links pass",
+			content:     "docs at https://example.org/spam.example",
+			wantAction:  Allow,
+			wantContent: "docs at https://example.org/spam.example",
+		},
+		{
+			name:        "spam cannot be masked",
+			content:     "@a @b @c",
+			wantAction:  Hold,
+			wantContent: "@a @b @c",
+			wantFilter:  FilterSpam,
+		},
+		{
+			name:        "room words and action override",
+			content:     "no pineapple",
+			room:        models.RoomModeration{BlockedWords: []string{"pineapple"}, Actions: map[string]string{FilterProfanity: "hold"}},
+			wantAction:  Hold,
+			wantContent: "no pineapple",
+			wantFilter:  FilterProfanity,
+		},
+		{
+			name:        "room disables a filter",
+			content:     "see spam.example",
+			room:        models.RoomModeration{DisabledFilters: []string{FilterLinks}},
+			wantAction:  Allow,
+			wantContent: "see spam.example",
+		},
+		{
+			name:        "room link mask override",
+			content:     "see spam.example now",
+			room:        models.RoomModeration{Actions: map[string]string{FilterLinks: "mask"}},
+			wantAction:  Mask,
+			wantContent: "see [link removed] now",
+			wantFilter:  FilterLinks,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verdict, err := pipeline.Run(Message{Sender: "alice", Content: tt.content}, tt.room)
+			if err != nil {
+				t.Fatalf("Run() unexpected error = %v", err)
+			}
+			if verdict.Action != tt.wantAction || verdict.Filter != tt.wantFilter {
+				t.Errorf("Run() = %s by %q, want %s by %q", verdict.Action, verdict.Filter, tt.wantAction, tt.wantFilter)
+			}
+			if tt.wantAction != Reject && verdict.Content != tt.wantContent {
+				t.Errorf("Run() content = %q, want %q", verdict.Content, tt.wantContent)
+			}
+		})
+	}
+
+	var nilPipeline *Pipeline
+	if verdict, err := nilPipeline.Run(Message{Content: "darn"}, models.RoomModeration{}); err != nil || verdict.Action != Allow {
+		t.Errorf("nil Pipeline.Run() = %+v, %v, want allow", verdict, err)
+	}
+}
+
+func TestSpamFilter_Check(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	message := func(content string, ago time.Duration) models.Message {
+		return models.Message{Sender: "alice", Content: content, Timestamp: now.Add(-ago)}
+	}
+
+	tests := []struct {
+		name    string
+		history fakeHistory
+		content string
+		want    Action
+	}{
+		{
+			name:    "first message",
+			content: "hello",
+			want:    Allow,
+		},
+		{
+			name:    "repeated content",
+			history: fakeHistory{message("Hello ", 10*time.Second), message("hello", 20*time.Second)},
+			content: "HELLO",
+			want:    Reject,
+		},
+		{
+			name:    "repeats outside the window",
+			history: fakeHistory{message("hello", 2*time.Minute), message("hello", 3*time.Minute)},
+			content: "hello",
+			want:    Allow,
+		},
+		{
+			name:    "too many messages",
+			history: fakeHistory{message("a", time.Second), message("b", 2*time.Second), message("c", 3*time.Second)},
+			content: "d",
+			want:    Reject,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := NewSpamFilter(tt.history, SpamPolicy{
+				MaxRepeats:   2,
+				RepeatWindow: time.Minute,
+				Velocity:     ratelimit.Limit{Requests: 3, Window: 10 * time.Second},
+			}, Reject)
+			filter.now = func() time.Time { return now }
+
+			result, err := filter.Check(Message{Sender: "alice", Content: tt.content}, models.RoomModeration{})
+			if err != nil {
+				t.Fatalf("Check() unexpected error = %v", err)
+			}
+			if result.Action != tt.want {
+				t.Errorf("Check() = %s (%s), want %s", result.Action, result.Reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAction(t *testing.T) {
+	for _, name := range []string{"allow", "mask", "hold", "reject"} {
+		if action, err := ParseAction(name); err != nil || string(action) != name {
+			t.Errorf("ParseAction(%q) = %q, %v", name, action, err)
+		}
+	}
+	if _, err := ParseAction("ban"); err == nil {
+		t.Error("ParseAction(\"ban\") expected an error")
+	}
+}
@@ -0,0 +1,142 @@
+package oidc
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"go-chat-api/internal/oidc/oidctest"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newTestProvider(t *testing.T) (*Provider, *oidctest.Provider) {
+	t.Helper()
+	idp := oidctest.NewProvider("chat-api", "secret")
+	t.Cleanup(idp.Close)
+
+	provider := NewProvider(Config{
+		IssuerURL:    idp.Issuer(),
+		ClientID:     "chat-api",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8080/api/auth/oidc/callback",
+	}, nil)
+	return provider, idp
+}
+
+func TestProvider_AuthorizationCodeFlow(t *testing.T) {
+	provider, idp := newTestProvider(t)
+	idp.SetUser(oidctest.User{Subject: "user-123", Email: "alice@example.com", EmailVerified: true, PreferredUsername: "alice"})
+	ctx := context.Background()
+
+	verifier, _ := GenerateCodeVerifier()
+	state, _ := GenerateState()
+	nonce, _ := GenerateState()
+
+	authURL, err := provider.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL() unexpected error = %v", err)
+	}
+
+	redirect, err := idp.Authorize(authURL)
+	if err != nil {
+		t.Fatalf("Authorize() unexpected error = %v", err)
+	}
+	if redirect.Query().Get("state") != state {
+		t.Errorf("redirect state = %q, want %q", redirect.Query().Get("state"), state)
+	}
+	code := redirect.Query().Get("code")
+
+	// A wrong verifier must be rejected by the provider
+	if _, err := provider.Exchange(ctx, code, "wrong-verifier"); err == nil {
+		t.Fatal("Exchange() with wrong verifier expected error, got nil")
+	}
+
+	// Codes are single-use, so authorize again
+	redirect, _ = idp.Authorize(authURL)
+	tokens, err := provider.Exchange(ctx, redirect.Query().Get("code"), verifier)
+	if err != nil {
+		t.Fatalf("Exchange() unexpected error = %v", err)
+	}
+
+	claims, err := provider.VerifyIDToken(ctx, tokens.IDToken, nonce)
+	if err != nil {
+		t.Fatalf("VerifyIDToken() unexpected error = %v", err)
+	}
+	if claims.Subject != "user-123" || claims.Email != "alice@example.com" || !claims.EmailVerified || claims.PreferredUsername != "alice" {
+		t.Errorf("VerifyIDToken() claims = %+v", claims)
+	}
+
+	if _, err := provider.VerifyIDToken(ctx, tokens.IDToken, "other-nonce"); err == nil {
+		t.Error("VerifyIDToken() with wrong nonce expected error, got nil")
+	}
+}
+
+func TestProvider_VerifyIDToken(t *testing.T) {
+	provider, idp := newTestProvider(t)
+	now := time.Now()
+
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":   idp.Issuer(),
+			"sub":   "user-123",
+			"aud":   "chat-api",
+			"exp":   now.Add(time.Minute).Unix(),
+			"nonce": "nonce",
+		}
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(jwt.MapClaims)
+		wantErr bool
+	}{
+		{name: "valid token", modify: func(jwt.MapClaims) {}},
+		{name: "wrong issuer", modify: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }, wantErr: true},
+		{name: "wrong audience", modify: func(c jwt.MapClaims) { c["aud"] = "other-client" }, wantErr: true},
+		{name: "expired", modify: func(c jwt.MapClaims) { c["exp"] = now.Add(-time.Hour).Unix() }, wantErr: true},
+		{name: "missing expiry", modify: func(c jwt.MapClaims) { delete(c, "exp") }, wantErr: true},
+		{name: "missing subject", modify: func(c jwt.MapClaims) { delete(c, "sub") }, wantErr: true},
+		{name: "missing nonce", modify: func(c jwt.MapClaims) { delete(c, "nonce") }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			tt.modify(claims)
+
+			_, err := provider.VerifyIDToken(context.Background(), idp.SignIDToken(claims), "nonce")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyIDToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("unsigned token", func(t *testing.T) {
+		token := jwt.NewWithClaims(jwt.SigningMethodNone, valid())
+		raw, _ := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+		if _, err := provider.VerifyIDToken(context.Background(), raw, "nonce"); err == nil {
+			t.Error("VerifyIDToken() with alg none expected error, got nil")
+		}
+	})
+}
+
+func TestProvider_DiscoveryIssuerMismatch(t *testing.T) {
+	idp := oidctest.NewProvider("chat-api", "")
+	defer idp.Close()
+
+	provider := NewProvider(Config{IssuerURL: strings.Replace(idp.Issuer(), "127.0.0.1", "localhost", 1), ClientID: "chat-api"}, nil)
+	if _, err := provider.Discover(context.Background()); err == nil {
+		t.Error("Discover() with mismatched issuer expected error, got nil")
+	}
+}
+
+func TestCodeChallengeS256(t *testing.T) {
+	// Test vector from RFC 7636 Appendix B
+	got := CodeChallengeS256("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	if got != want {
+		t.Errorf("CodeChallengeS256() = %q, want %q", got, want)
+	}
+}
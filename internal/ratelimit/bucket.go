@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// Bucket is a token bucket. It is not safe for concurrent use; stores guard their
+// buckets, and a WebSocket connection only touches its own bucket from one goroutine.
+type Bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewBucket creates a full bucket for the limit
+func NewBucket(limit Limit, now time.Time) *Bucket {
+	return &Bucket{
+		tokens:  float64(limit.Requests),
+		updated: now,
+	}
+}
+
+// Take refills the bucket for the time since it was last used and removes one token if
+// one is available
+func (b *Bucket) Take(limit Limit, now time.Time) Result {
+	b.refill(limit, now)
+
+	result := Result{Limit: limit.Requests}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - b.tokens) / limit.rate())
+	}
+
+	result.Remaining = int(math.Floor(b.tokens))
+	result.Reset = seconds((float64(limit.Requests) - b.tokens) / limit.rate())
+	return result
+}
+
+// Full reports whether the bucket has refilled completely, after which dropping it is
+// indistinguishable from keeping it
+func (b *Bucket) Full(limit Limit, now time.Time) bool {
+	b.refill(limit, now)
+	return b.tokens >= float64(limit.Requests)
+}
+
+// refill adds the tokens earned since the bucket was last updated
+func (b *Bucket) refill(limit Limit, now time.Time) {
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(float64(limit.Requests), b.tokens+elapsed.Seconds()*limit.rate())
+		b.updated = now
+	}
+}
+
+// seconds converts a number of seconds to a duration
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
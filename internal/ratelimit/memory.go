@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often the memory store drops buckets that have refilled completely
+const sweepInterval = time.Minute
+
+// MemoryStore keeps token buckets in memory. Limits are enforced per process, so every
+// instance of a horizontally scaled deployment grants the full limit.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+// memoryBucket is a bucket along with the limit it was last used with
+type memoryBucket struct {
+	bucket *Bucket
+	limit  Limit
+}
+
+// NewMemoryStore creates a new in-memory bucket store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*memoryBucket),
+	}
+}
+
+// Take counts one request against the bucket identified by key
+func (s *MemoryStore) Take(key string, limit Limit, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		s.sweep(now)
+	}
+
+	entry, exists := s.buckets[key]
+	if !exists {
+		entry = &memoryBucket{bucket: NewBucket(limit, now)}
+		s.buckets[key] = entry
+	}
+	entry.limit = limit
+	return entry.bucket.Take(limit, now), nil
+}
+
+// sweep drops full buckets so idle clients do not accumulate
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, entry := range s.buckets {
+		if entry.bucket.Full(entry.limit, now) {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
@@ -0,0 +1,177 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Route classes with their own rate limits
+const (
+	// ClassAuth covers the public authentication endpoints (login, registration, password reset)
+	ClassAuth = "auth"
+
+	// ClassAPI covers every authenticated endpoint
+	ClassAPI = "api"
+
+	// ClassMessages covers sending messages, on top of ClassAPI
+	ClassMessages = "messages"
+)
+
+// Classes lists every route class
+var Classes = []string{ClassAuth, ClassAPI, ClassMessages}
+
+// Limit allows Requests requests per Window. Requests also sets the burst size: a client
+// that has been idle for a full window can send Requests requests at once.
+type Limit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// Enabled reports whether the limit restricts anything
+func (l Limit) Enabled() bool {
+	return l.Requests > 0 && l.Window > 0
+}
+
+// String formats the limit the way ParseLimit reads it
+func (l Limit) String() string {
+	if !l.Enabled() {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s", l.Requests, l.Window)
+}
+
+// rate returns how many tokens the limit refills per second
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Window.Seconds()
+}
+
+// ParseLimit parses a limit written as "requests/window", such as "30/1m" or "10/1s".
+// "off" and "0" disable the limit.
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "off" || s == "0" {
+		return Limit{}, nil
+	}
+
+	requests, window, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: expected requests/window", s)
+	}
+	n, err := strconv.Atoi(requests)
+	if err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: requests must be a positive number", s)
+	}
+	d, err := time.ParseDuration(window)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: window must be a positive duration", s)
+	}
+	return Limit{Requests: n, Window: d}, nil
+}
+
+// Rule holds the limits of a route class. Authenticated requests are counted against the
+// user and the client IP, anonymous requests against the client IP only.
+type Rule struct {
+	PerUser Limit
+	PerIP   Limit
+}
+
+// Result describes the state of a bucket after a request was counted
+type Result struct {
+	// Allowed is false when the bucket was empty and the request must be rejected
+	Allowed bool
+
+	// Limit is the bucket size
+	Limit int
+
+	// Remaining is how many requests can be made right away
+	Remaining int
+
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+
+	// RetryAfter is how long until the next request is allowed (zero when allowed)
+	RetryAfter time.Duration
+}
+
+// Store keeps token buckets. Implementations must be safe for concurrent use.
+type Store interface {
+	// Take counts one request against the bucket identified by key, creating the bucket
+	// full if it does not exist yet
+	Take(key string, limit Limit, now time.Time) (Result, error)
+}
+
+// ErrNoStore is returned by a limiter created without a store
+var ErrNoStore = errors.New("rate limiter has no store")
+
+// Limiter applies the rules of each route class per user and per client IP
+type Limiter struct {
+	store Store
+	rules map[string]Rule
+	now   func() time.Time
+}
+
+// NewLimiter creates a new limiter. Classes without a rule are not limited.
+func NewLimiter(store Store, rules map[string]Rule) *Limiter {
+	return &Limiter{
+		store: store,
+		rules: rules,
+		now:   time.Now,
+	}
+}
+
+// Allow counts a request of the class from the user (empty for anonymous requests) and
+// client IP, and returns the most restrictive result. A nil limiter allows everything.
+func (l *Limiter) Allow(class, userID, ip string) (Result, error) {
+	result := Result{Allowed: true}
+	if l == nil {
+		return result, nil
+	}
+	if l.store == nil {
+		return result, ErrNoStore
+	}
+
+	rule := l.rules[class]
+	now := l.now()
+	checked := false
+
+	take := func(key string, limit Limit) error {
+		if !limit.Enabled() {
+			return nil
+		}
+		r, err := l.store.Take(key, limit, now)
+		if err != nil {
+			return err
+		}
+		if !checked || restricts(r, result) {
+			result = r
+		}
+		checked = true
+		return nil
+	}
+
+	if userID != "" {
+		if err := take("user:"+class+":"+userID, rule.PerUser); err != nil {
+			return Result{Allowed: true}, err
+		}
+	}
+	if ip != "" {
+		if err := take("ip:"+class+":"+ip, rule.PerIP); err != nil {
+			return Result{Allowed: true}, err
+		}
+	}
+	return result, nil
+}
+
+// restricts reports whether a is more restrictive than b
+func restricts(a, b Result) bool {
+	if a.Allowed != b.Allowed {
+		return !a.Allowed
+	}
+	if !a.Allowed {
+		return a.RetryAfter > b.RetryAfter
+	}
+	return a.Remaining < b.Remaining
+}
@@ -0,0 +1,132 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    Limit
+		wantErr bool
+	}{
+		{name: "per minute", input: "30/1m", want: Limit{Requests: 30, Window: time.Minute}},
+		{name: "per second", input: " 10/1s ", want: Limit{Requests: 10, Window: time.Second}},
+		{name: "off", input: "off", want: Limit{}},
+		{name: "zero", input: "0", want: Limit{}},
+		{name: "missing window", input: "30", wantErr: true},
+		{name: "negative requests", input: "-1/1m", wantErr: true},
+		{name: "invalid window", input: "30/minute", wantErr: true},
+		{name: "zero window", input: "30/0s", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLimit(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLimit(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseLimit(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBucket_Take(t *testing.T) {
+	limit := Limit{Requests: 3, Window: 3 * time.Second}
+	now := time.Now()
+	bucket := NewBucket(limit, now)
+
+	for i := 0; i < 3; i++ {
+		result := bucket.Take(limit, now)
+		if !result.Allowed || result.Remaining != 2-i {
+			t.Fatalf("Take() #%d = %+v, want allowed with %d remaining", i+1, result, 2-i)
+		}
+	}
+
+	result := bucket.Take(limit, now)
+	if result.Allowed {
+		t.Fatal("Take() on an empty bucket should be rejected")
+	}
+	if result.RetryAfter != time.Second || result.Reset != 3*time.Second {
+		t.Errorf("Take() retry after %v and reset %v, want 1s and 3s", result.RetryAfter, result.Reset)
+	}
+
+	// One token refills per second
+	if result := bucket.Take(limit, now.Add(time.Second)); !result.Allowed {
+		t.Errorf("Take() after a second = %+v, want allowed", result)
+	}
+	if bucket.Full(limit, now.Add(2*time.Second)) {
+		t.Error("Full() = true before the window has passed")
+	}
+	if !bucket.Full(limit, now.Add(time.Hour)) {
+		t.Error("Full() = false after an idle hour")
+	}
+}
+
+func TestLimiter_Allow(t *testing.T) {
+	store := NewMemoryStore()
+	limiter := NewLimiter(store, map[string]Rule{
+		ClassMessages: {
+			PerUser: Limit{Requests: 2, Window: time.Minute},
+			PerIP:   Limit{Requests: 3, Window: time.Minute},
+		},
+		ClassAuth: {
+			PerIP: Limit{Requests: 1, Window: time.Minute},
+		},
+	})
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	tests := []struct {
+		name          string
+		class         string
+		userID        string
+		ip            string
+		wantAllowed   bool
+		wantRemaining int
+	}{
+		{name: "first user request", class: ClassMessages, userID: "alice", ip: "203.0.113.1", wantAllowed: true, wantRemaining: 1},
+		{name: "second user request", class: ClassMessages, userID: "alice", ip: "203.0.113.1", wantAllowed: true, wantRemaining: 0},
+		{name: "user limit reached", class: ClassMessages, userID: "alice", ip: "203.0.113.2", wantAllowed: false},
+		{name: "other user on the same IP", class: ClassMessages, userID: "bob", ip: "203.0.113.1", wantAllowed: true, wantRemaining: 0},
+		{name: "IP limit reached", class: ClassMessages, userID: "carol", ip: "203.0.113.1", wantAllowed: false},
+		{name: "anonymous request", class: ClassAuth, ip: "203.0.113.1", wantAllowed: true, wantRemaining: 0},
+		{name: "anonymous limit reached", class: ClassAuth, ip: "203.0.113.1", wantAllowed: false},
+		{name: "classes are separate", class: ClassAuth, ip: "203.0.113.9", wantAllowed: true, wantRemaining: 0},
+		{name: "unlimited class", class: ClassAPI, userID: "alice", ip: "203.0.113.1", wantAllowed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := limiter.Allow(tt.class, tt.userID, tt.ip)
+			if err != nil {
+				t.Fatalf("Allow() unexpected error = %v", err)
+			}
+			if result.Allowed != tt.wantAllowed {
+				t.Fatalf("Allow() = %+v, want allowed %v", result, tt.wantAllowed)
+			}
+			if result.Allowed && result.Remaining != tt.wantRemaining {
+				t.Errorf("Allow() remaining = %d, want %d", result.Remaining, tt.wantRemaining)
+			}
+			if !result.Allowed && result.RetryAfter <= 0 {
+				t.Errorf("Allow() retry after = %v, want a positive wait", result.RetryAfter)
+			}
+		})
+	}
+
+	// Idle buckets are dropped once they have refilled
+	now = now.Add(time.Hour)
+	limiter.Allow(ClassAuth, "", "203.0.113.1")
+	if len(store.buckets) != 1 {
+		t.Errorf("MemoryStore kept %d buckets after sweeping, want 1", len(store.buckets))
+	}
+
+	var disabled *Limiter
+	if result, err := disabled.Allow(ClassAuth, "", "203.0.113.1"); err != nil || !result.Allowed {
+		t.Errorf("nil Limiter.Allow() = %+v, %v, want allowed", result, err)
+	}
+}
@@ -0,0 +1,87 @@
+// Package reaper deletes disappearing messages once their TTL has passed and tells
+// connected clients to remove them.
+package reaper
+
+import (
+	"context"
+	"go-chat-api/internal/models"
+	"go-chat-api/internal/services"
+	"log"
+	"time"
+)
+
+// Config controls how the reaper polls for expired messages
+type Config struct {
+	// Interval is the time between polls
+	Interval time.Duration
+	// BatchSize is the number of messages deleted at once
+	BatchSize int
+	// Now returns the current time; tests replace it to expire messages without waiting
+	Now func() time.Time
+}
+
+// Reaper deletes expired messages
+type Reaper struct {
+	chat    *services.ChatService
+	expired func(*models.Message)
+	config  Config
+}
+
+// New creates a reaper that deletes expired messages through chat and passes each of them
+// to expired, such as to send message_expired events
+func New(chat *services.ChatService, expired func(*models.Message), config Config) *Reaper {
+	if config.Interval <= 0 {
+		config.Interval = 10 * time.Second
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 500
+	}
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+	return &Reaper{
+		chat:    chat,
+		expired: expired,
+		config:  config,
+	}
+}
+
+// Run polls for expired messages until ctx is cancelled
+func (r *Reaper) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		r.Reap()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reap deletes the messages that have expired and returns how many were deleted
+func (r *Reaper) Reap() int {
+	now := r.config.Now()
+	reaped := 0
+
+	for {
+		messages, err := r.chat.ExpireMessages(now, r.config.BatchSize)
+		if err != nil {
+			log.Printf("Failed to delete expired messages: %v", err)
+			break
+		}
+		if r.expired != nil {
+			for i := range messages {
+				r.expired(&messages[i])
+			}
+		}
+		reaped += len(messages)
+		if len(messages) < r.config.BatchSize {
+			break
+		}
+	}
+
+	return reaped
+}
@@ -0,0 +1,80 @@
+package reaper
+
+import (
+	"go-chat-api/internal/auth"
+	"go-chat-api/internal/models"
+	"go-chat-api/internal/services"
+	"go-chat-api/internal/storage"
+	"testing"
+	"time"
+)
+
+func TestReaper_Reap(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour)
+	chat := services.NewChatService(store, store, store, authService)
+
+	alice, _ := chat.RegisterUser(models.RegisterRequest{Username: "alice", Email: "alice@example.com", Password: "password123"})
+	chat.RegisterUser(models.RegisterRequest{Username: "bob", Email: "bob@example.com", Password: "password123"})
+	room, _ := chat.CreateRoom(models.CreateRoomRequest{Name: "general"})
+	store.AddUserToRoom(room.ID, alice.ID)
+
+	now := time.Now()
+	var expired []*models.Message
+	reaper := New(chat, func(message *models.Message) {
+		expired = append(expired, message)
+	}, Config{BatchSize: 2, Now: func() time.Time { return now }})
+
+	send := func(req models.MessageRequest) *models.Message {
+		req.Sender = "alice"
+		req.Content = "hello"
+		message, err := chat.SendMessage(req)
+		if err != nil {
+			t.Fatalf("SendMessage() unexpected error = %v", err)
+		}
+		return message
+	}
+	kept := send(models.MessageRequest{RoomID: room.ID})
+	for i := 0; i < 3; i++ {
+		send(models.MessageRequest{RoomID: room.ID, TTLSeconds: 60})
+	}
+	direct := send(models.MessageRequest{Recipient: "bob", TTLSeconds: 3600})
+
+	if reaped := reaper.Reap(); reaped != 0 || len(expired) != 0 {
+		t.Fatalf("Reap() before any TTL passed = %d, want 0", reaped)
+	}
+
+	// Deletes every expired message, in batches
+	now = now.Add(2 * time.Minute)
+	if reaped := reaper.Reap(); reaped != 3 || len(expired) != 3 {
+		t.Fatalf("Reap() after a minute = %d, %d events, want 3", reaped, len(expired))
+	}
+	for _, message := range expired {
+		if message.RoomID != room.ID || message.ExpiresAt == nil || message.ExpiresAt.After(now) {
+			t.Errorf("expired message = %+v, want an expired room message", message)
+		}
+	}
+	messages, _ := store.GetMessagesByRoom(room.ID)
+	if len(messages) != 1 || messages[0].ID != kept.ID {
+		t.Errorf("room messages = %+v, want only the message without a TTL", messages)
+	}
+	if reaped := reaper.Reap(); reaped != 0 {
+		t.Errorf("Reap() again = %d, want 0", reaped)
+	}
+
+	expired = nil
+	now = now.Add(time.Hour)
+	if reaped := reaper.Reap(); reaped != 1 || len(expired) != 1 || expired[0].ID != direct.ID {
+		t.Errorf("Reap() after an hour = %d, %v, want the direct message", reaped, expired)
+	}
+	if message, _ := store.GetMessage(kept.ID); message == nil {
+		t.Errorf("GetMessage() of the message without a TTL = nil, want it kept")
+	}
+}
+
+func TestNew_Defaults(t *testing.T) {
+	reaper := New(nil, nil, Config{})
+	if reaper.config.Interval != 10*time.Second || reaper.config.BatchSize != 500 || reaper.config.Now == nil {
+		t.Errorf("New() config = %+v, want the defaults", reaper.config)
+	}
+}
@@ -4,35 +4,154 @@ import (
 	"go-chat-api/internal/auth"
 	"go-chat-api/internal/handlers"
 	"go-chat-api/internal/middleware"
+	"go-chat-api/internal/ratelimit"
 	"net/http"
 
 	"github.com/gorilla/mux"
 )
 
 // SetupRoutes configures all API routes
-func SetupRoutes(chatHandler *handlers.ChatHandler, authHandler *handlers.AuthHandler, wsHandler *handlers.WebSocketHandler, authService *auth.AuthService) *mux.Router {
+func SetupRoutes(chatHandler *handlers.ChatHandler, authHandler *handlers.AuthHandler, wsHandler *handlers.WebSocketHandler, adminHandler *handlers.AdminHandler, oidcHandler *handlers.OIDCHandler, moderationHandler *handlers.ModerationHandler, attachmentHandler *handlers.AttachmentHandler, authService *auth.AuthService, limiter *ratelimit.Limiter) *mux.Router {
 	router := mux.NewRouter()
 
-	// API prefix
+	// API prefix. Each route group below is rate limited per user and per client IP with
+	// the limits of its route class; sending messages also counts against ClassMessages.
 	api := router.PathPrefix("/api").Subrouter()
 
 	// Public auth routes (no authentication required)
-	auth := api.PathPrefix("/auth").Subrouter()
-	auth.HandleFunc("/register", authHandler.Register).Methods("POST")
-	auth.HandleFunc("/login", authHandler.Login).Methods("POST")
-	auth.HandleFunc("/refresh", authHandler.RefreshToken).Methods("POST")
+	authPublic := api.PathPrefix("/auth").Subrouter()
+	authPublic.Use(middleware.RateLimit(limiter, ratelimit.ClassAuth))
+	authPublic.HandleFunc("/register", authHandler.Register).Methods("POST")
+	authPublic.HandleFunc("/login", authHandler.Login).Methods("POST")
+	authPublic.HandleFunc("/refresh", authHandler.RefreshToken).Methods("POST")
+	authPublic.HandleFunc("/password/forgot", authHandler.ForgotPassword).Methods("POST")
+	authPublic.HandleFunc("/password/reset", authHandler.ResetPassword).Methods("POST")
+	authPublic.HandleFunc("/verify", authHandler.VerifyEmail).Methods("GET")
+	authPublic.HandleFunc("/csrf", authHandler.CSRFToken).Methods("GET")
+
+	// OpenID Connect login routes (only when an identity provider is configured)
+	if oidcHandler != nil {
+		authPublic.HandleFunc("/oidc/login", oidcHandler.Login).Methods("GET")
+		authPublic.HandleFunc("/oidc/callback", oidcHandler.Callback).Methods("GET")
+	}
+
+	// Protected routes authenticated with the jwt_token cookie must send the CSRF token
+	// from /api/auth/csrf in the X-CSRF-Token header on POST, PUT and DELETE requests.
 
 	// Protected auth routes (authentication required)
 	authProtected := api.PathPrefix("/auth").Subrouter()
 	authProtected.Use(middleware.AuthMiddleware(authService))
+	authProtected.Use(middleware.RateLimit(limiter, ratelimit.ClassAPI))
+	authProtected.Use(middleware.CSRFMiddleware)
 	authProtected.HandleFunc("/logout", authHandler.Logout).Methods("POST")
 	authProtected.HandleFunc("/profile", authHandler.GetProfile).Methods("GET")
 
+	// Account management routes (user session required, API keys are rejected)
+	account := api.PathPrefix("/auth").Subrouter()
+	account.Use(middleware.AuthMiddleware(authService))
+	account.Use(middleware.RateLimit(limiter, ratelimit.ClassAPI))
+	account.Use(middleware.CSRFMiddleware)
+	account.Use(middleware.RequireUserSession)
+	account.HandleFunc("/password", authHandler.ChangePassword).Methods("POST")
+	account.HandleFunc("/verify/resend", authHandler.ResendVerification).Methods("POST")
+	account.HandleFunc("/keys", authHandler.CreateAPIKey).Methods("POST")
+	account.HandleFunc("/keys", authHandler.ListAPIKeys).Methods("GET")
+	account.HandleFunc("/keys/bots", authHandler.CreateBot).Methods("POST")
+	account.HandleFunc("/keys/bots", authHandler.ListBots).Methods("GET")
+	account.HandleFunc("/keys/{keyId}", authHandler.RevokeAPIKey).Methods("DELETE")
+
+	// WebSocket connect route. Clients authenticate with the jwt_token cookie, a Bearer token,
+	// a ticket from /api/ws/ticket or an "auth" frame sent right after the upgrade.
+	wsConnect := api.PathPrefix("/ws").Subrouter()
+	wsConnect.Use(middleware.OptionalAuthMiddleware(authService))
+	wsConnect.Use(middleware.RateLimit(limiter, ratelimit.ClassAPI))
+	wsConnect.HandleFunc("/connect", wsHandler.HandleWebSocket).Methods("GET")
+
 	// WebSocket routes (authentication required)
 	ws := api.PathPrefix("/ws").Subrouter()
 	ws.Use(middleware.AuthMiddleware(authService))
-	ws.HandleFunc("/connect", wsHandler.HandleWebSocket).Methods("GET")
-	ws.HandleFunc("/users", wsHandler.GetConnectedUsers).Methods("GET")
+	ws.Use(middleware.RateLimit(limiter, ratelimit.ClassAPI))
+	ws.Use(middleware.CSRFMiddleware)
+	ws.Handle("/ticket", scoped(wsHandler.IssueTicket, auth.ScopeMessagesRead, auth.ScopeMessagesWrite)).Methods("POST")
+	ws.Handle("/users", scoped(wsHandler.GetConnectedUsers, auth.ScopeUsersRead)).Methods("GET")
+
+	// Admin routes (authentication and the admin scope required)
+	admin := api.PathPrefix("/admin").Subrouter()
+	admin.Use(middleware.AuthMiddleware(authService))
+	admin.Use(middleware.RateLimit(limiter, ratelimit.ClassAPI))
+	admin.Use(middleware.CSRFMiddleware)
+	admin.Use(middleware.RequireUserSession)
+	admin.Use(middleware.RequireScope(auth.ScopeAdmin))
+	admin.HandleFunc("/audit", adminHandler.ListAuditEvents).Methods("GET")
+	admin.HandleFunc("/audit/verify", adminHandler.VerifyAuditLog).Methods("GET")
+	admin.HandleFunc("/users", adminHandler.ListUsers).Methods("GET")
+	admin.HandleFunc("/users/{userId}", adminHandler.DeleteUser).Methods("DELETE")
+	admin.HandleFunc("/users/{userId}/suspend", adminHandler.SuspendUser).Methods("POST")
+	admin.HandleFunc("/users/{userId}/unsuspend", adminHandler.UnsuspendUser).Methods("POST")
+	admin.HandleFunc("/users/{userId}/logout", adminHandler.ForceLogout).Methods("POST")
+	admin.HandleFunc("/users/{userId}/password-reset", adminHandler.ResetUserPassword).Methods("POST")
+	admin.HandleFunc("/users/{userId}/unlock", adminHandler.UnlockUser).Methods("POST")
+	admin.HandleFunc("/users/{userId}/role", adminHandler.UpdateUserRole).Methods("PUT")
+
+	// Moderation review queues for held messages and reports (authentication and the moderate scope required)
+	moderation := api.PathPrefix("/moderation").Subrouter()
+	moderation.Use(middleware.AuthMiddleware(authService))
+	moderation.Use(middleware.RateLimit(limiter, ratelimit.ClassAPI))
+	moderation.Use(middleware.CSRFMiddleware)
+	moderation.Use(middleware.RequireScope(auth.ScopeModerate))
+	moderation.HandleFunc("/held", moderationHandler.GetHeldMessages).Methods("GET")
+	moderation.HandleFunc("/held/{messageId}/approve", moderationHandler.ApproveHeldMessage).Methods("POST")
+	moderation.HandleFunc("/held/{messageId}/reject", moderationHandler.RejectHeldMessage).Methods("POST")
+	moderation.HandleFunc("/reports", moderationHandler.GetReports).Methods("GET")
+	moderation.HandleFunc("/reports/{reportId}", moderationHandler.GetReport).Methods("GET")
+	moderation.HandleFunc("/reports/{reportId}/action", moderationHandler.ActOnReport).Methods("POST")
+	moderation.HandleFunc("/reports/{reportId}/dismiss", moderationHandler.DismissReport).Methods("POST")
+
+	// User reports (requires a user session, API keys are rejected)
+	reports := api.PathPrefix("/reports").Subrouter()
+	reports.Use(middleware.AuthMiddleware(authService))
+	reports.Use(middleware.RateLimit(limiter, ratelimit.ClassAPI))
+	reports.Use(middleware.CSRFMiddleware)
+	reports.Use(middleware.RequireUserSession)
+	reports.HandleFunc("", moderationHandler.CreateReport).Methods("POST")
+
+	// Attachment downloads are authorized by the expiring signature in the link
+	attachmentDownloads := api.PathPrefix("/attachments").Subrouter()
+	attachmentDownloads.Use(middleware.RateLimit(limiter, ratelimit.ClassAPI))
+	attachmentDownloads.HandleFunc("/{attachmentId}/{variant:content|thumbnail}", attachmentHandler.DownloadAttachment).Methods("GET")
+
+	// Attachment uploads (authentication required)
+	attachments := api.PathPrefix("/attachments").Subrouter()
+	attachments.Use(middleware.AuthMiddleware(authService))
+	attachments.Use(middleware.RateLimit(limiter, ratelimit.ClassAPI))
+	attachments.Use(middleware.CSRFMiddleware)
+	attachments.Handle("", scoped(attachmentHandler.UploadAttachment, auth.ScopeMessagesWrite)).Methods("POST")
+	attachments.Handle("/{attachmentId}", scoped(attachmentHandler.GetAttachment, auth.ScopeMessagesRead)).Methods("GET")
+
+	// Mention inbox (authentication required)
+	mentions := api.PathPrefix("/mentions").Subrouter()
+	mentions.Use(middleware.AuthMiddleware(authService))
+	mentions.Use(middleware.RateLimit(limiter, ratelimit.ClassAPI))
+	mentions.Use(middleware.CSRFMiddleware)
+	mentions.Handle("", scoped(chatHandler.GetMentions, auth.ScopeMessagesRead)).Methods("GET")
+	mentions.Handle("/read", scoped(chatHandler.MarkMentionsRead, auth.ScopeMessagesRead)).Methods("POST")
+
+	// Reminder routes (authentication required)
+	reminders := api.PathPrefix("/reminders").Subrouter()
+	reminders.Use(middleware.AuthMiddleware(authService))
+	reminders.Use(middleware.RateLimit(limiter, ratelimit.ClassAPI))
+	reminders.Use(middleware.CSRFMiddleware)
+	reminders.Handle("", scoped(chatHandler.GetReminders, auth.ScopeMessagesRead)).Methods("GET")
+	reminders.Handle("/{reminderId}", scoped(chatHandler.CancelReminder, auth.ScopeMessagesRead)).Methods("DELETE")
+
+	// Poll routes (authentication required)
+	polls := api.PathPrefix("/polls").Subrouter()
+	polls.Use(middleware.AuthMiddleware(authService))
+	polls.Use(middleware.RateLimit(limiter, ratelimit.ClassAPI))
+	polls.Use(middleware.CSRFMiddleware)
+	polls.Handle("/{pollId}", scoped(chatHandler.GetPoll, auth.ScopeMessagesRead)).Methods("GET")
+	polls.Handle("/{pollId}/votes", scoped(chatHandler.VotePoll, auth.ScopeMessagesWrite)).Methods("POST")
+	polls.Handle("/{pollId}/close", scoped(chatHandler.ClosePoll, auth.ScopeMessagesWrite)).Methods("POST")
 
 	// Serve static files (test client)
 	router.PathPrefix("/").Handler(http.FileServer(http.Dir("./"))).Methods("GET")
@@ -40,25 +159,72 @@ func SetupRoutes(chatHandler *handlers.ChatHandler, authHandler *handlers.AuthHa
 	// Protected message routes (authentication required)
 	messages := api.PathPrefix("/messages").Subrouter()
 	messages.Use(middleware.AuthMiddleware(authService))
-	messages.HandleFunc("", chatHandler.SendMessage).Methods("POST")
-	messages.HandleFunc("", chatHandler.GetMessages).Methods("GET")
-	messages.HandleFunc("/between/{user1}/{user2}", chatHandler.GetMessagesBetweenUsers).Methods("GET")
+	messages.Use(middleware.RateLimit(limiter, ratelimit.ClassAPI))
+	messages.Use(middleware.CSRFMiddleware)
+	messages.Handle("", middleware.RateLimit(limiter, ratelimit.ClassMessages)(scoped(chatHandler.SendMessage, auth.ScopeMessagesWrite))).Methods("POST")
+	messages.Handle("", scoped(chatHandler.GetMessages, auth.ScopeMessagesRead)).Methods("GET")
+	messages.Handle("/scheduled", scoped(chatHandler.ScheduleMessage, auth.ScopeMessagesWrite)).Methods("POST")
+	messages.Handle("/scheduled", scoped(chatHandler.GetScheduledMessages, auth.ScopeMessagesRead)).Methods("GET")
+	messages.Handle("/scheduled/{scheduledId}", scoped(chatHandler.CancelScheduledMessage, auth.ScopeMessagesWrite)).Methods("DELETE")
+	messages.Handle("/{messageId}/reminders", scoped(chatHandler.SetReminder, auth.ScopeMessagesRead)).Methods("POST")
+	messages.Handle("/{messageId}", scoped(chatHandler.DeleteMessage, auth.ScopeMessagesWrite)).Methods("DELETE")
+	messages.Handle("/between/{user1}/{user2}", scoped(chatHandler.GetMessagesBetweenUsers, auth.ScopeMessagesRead)).Methods("GET")
+
+	// Block list routes (user session required, API keys are rejected)
+	blocks := api.PathPrefix("/users/me/blocks").Subrouter()
+	blocks.Use(middleware.AuthMiddleware(authService))
+	blocks.Use(middleware.RateLimit(limiter, ratelimit.ClassAPI))
+	blocks.Use(middleware.CSRFMiddleware)
+	blocks.Use(middleware.RequireUserSession)
+	blocks.HandleFunc("", chatHandler.GetBlockedUsers).Methods("GET")
+	blocks.HandleFunc("/{userId}", chatHandler.BlockUser).Methods("PUT")
+	blocks.HandleFunc("/{userId}", chatHandler.UnblockUser).Methods("DELETE")
+
+	// Saved message routes (authentication required)
+	saved := api.PathPrefix("/users/me/saved").Subrouter()
+	saved.Use(middleware.AuthMiddleware(authService))
+	saved.Use(middleware.RateLimit(limiter, ratelimit.ClassAPI))
+	saved.Use(middleware.CSRFMiddleware)
+	saved.Handle("", scoped(chatHandler.GetSavedMessages, auth.ScopeMessagesRead)).Methods("GET")
+	saved.Handle("/{messageId}", scoped(chatHandler.SaveMessage, auth.ScopeMessagesRead)).Methods("PUT")
+	saved.Handle("/{messageId}", scoped(chatHandler.UnsaveMessage, auth.ScopeMessagesRead)).Methods("DELETE")
 
 	// Protected user routes (authentication required)
 	users := api.PathPrefix("/users").Subrouter()
 	users.Use(middleware.AuthMiddleware(authService))
-	users.HandleFunc("", chatHandler.GetAllUsers).Methods("GET")
-	users.HandleFunc("/{userId}", chatHandler.GetUser).Methods("GET")
-	users.HandleFunc("/{userId}/rooms", chatHandler.GetRoomsByUser).Methods("GET")
+	users.Use(middleware.RateLimit(limiter, ratelimit.ClassAPI))
+	users.Use(middleware.CSRFMiddleware)
+	users.Handle("", scoped(chatHandler.GetAllUsers, auth.ScopeUsersRead)).Methods("GET")
+	users.Handle("/{userId}", scoped(chatHandler.GetUser, auth.ScopeUsersRead)).Methods("GET")
+	users.Handle("/{userId}/rooms", scoped(chatHandler.GetRoomsByUser, auth.ScopeUsersRead, auth.ScopeRoomsRead)).Methods("GET")
 
 	// Protected room routes (authentication required)
 	rooms := api.PathPrefix("/rooms").Subrouter()
 	rooms.Use(middleware.AuthMiddleware(authService))
-	rooms.HandleFunc("", chatHandler.CreateRoom).Methods("POST")
-	rooms.HandleFunc("/{roomId}", chatHandler.GetRoom).Methods("GET")
-	rooms.HandleFunc("/{roomId}/messages", chatHandler.GetMessagesByRoom).Methods("GET")
-	rooms.HandleFunc("/{roomId}/members/{userId}", chatHandler.AddUserToRoom).Methods("POST")
-	rooms.HandleFunc("/{roomId}/members/{userId}", chatHandler.RemoveUserFromRoom).Methods("DELETE")
+	rooms.Use(middleware.RateLimit(limiter, ratelimit.ClassAPI))
+	rooms.Use(middleware.CSRFMiddleware)
+	rooms.Handle("", scoped(chatHandler.CreateRoom, auth.ScopeRoomsWrite)).Methods("POST")
+	rooms.Handle("/{roomId}", scoped(chatHandler.GetRoom, auth.ScopeRoomsRead)).Methods("GET")
+	rooms.Handle("/{roomId}/messages", scoped(chatHandler.GetMessagesByRoom, auth.ScopeRoomsRead, auth.ScopeMessagesRead)).Methods("GET")
+	rooms.Handle("/{roomId}/members/{userId}", scoped(chatHandler.AddUserToRoom, auth.ScopeRoomsWrite)).Methods("POST")
+	rooms.Handle("/{roomId}/members/{userId}", scoped(chatHandler.RemoveUserFromRoom, auth.ScopeRoomsWrite)).Methods("DELETE")
+	rooms.Handle("/{roomId}/pins", scoped(chatHandler.GetPins, auth.ScopeRoomsRead, auth.ScopeMessagesRead)).Methods("GET")
+	rooms.Handle("/{roomId}/polls", middleware.RateLimit(limiter, ratelimit.ClassMessages)(scoped(chatHandler.CreatePoll, auth.ScopeMessagesWrite))).Methods("POST")
+
+	// Room moderation routes (moderators and administrators)
+	rooms.Handle("/{roomId}/settings", scoped(chatHandler.UpdateRoomSettings, auth.ScopeRoomsWrite, auth.ScopeModerate)).Methods("PUT")
+	rooms.Handle("/{roomId}/mutes", scoped(chatHandler.GetRoomMutes, auth.ScopeRoomsRead, auth.ScopeModerate)).Methods("GET")
+	rooms.Handle("/{roomId}/mutes/{userId}", scoped(chatHandler.MuteRoomMember, auth.ScopeRoomsWrite, auth.ScopeModerate)).Methods("PUT")
+	rooms.Handle("/{roomId}/mutes/{userId}", scoped(chatHandler.UnmuteRoomMember, auth.ScopeRoomsWrite, auth.ScopeModerate)).Methods("DELETE")
+	rooms.Handle("/{roomId}/moderation", scoped(moderationHandler.GetRoomModeration, auth.ScopeRoomsRead, auth.ScopeModerate)).Methods("GET")
+	rooms.Handle("/{roomId}/moderation", scoped(moderationHandler.UpdateRoomModeration, auth.ScopeRoomsWrite, auth.ScopeModerate)).Methods("PUT")
+	rooms.Handle("/{roomId}/pins/{messageId}", scoped(chatHandler.PinMessage, auth.ScopeRoomsWrite, auth.ScopeModerate)).Methods("PUT")
+	rooms.Handle("/{roomId}/pins/{messageId}", scoped(chatHandler.UnpinMessage, auth.ScopeRoomsWrite, auth.ScopeModerate)).Methods("DELETE")
 
 	return router
 }
+
+// scoped wraps a handler with the scopes an API key needs to call it
+func scoped(handler http.HandlerFunc, scopes ...string) http.Handler {
+	return middleware.RequireScope(scopes...)(handler)
+}
@@ -0,0 +1,137 @@
+// Package scheduler sends scheduled messages and reminders when they are due. Jobs live
+// in the database, so they survive restarts, and every poll claims the due jobs before
+// firing them, so each job is fired by only one of several running instances.
+package scheduler
+
+import (
+	"context"
+	"go-chat-api/internal/models"
+	"go-chat-api/internal/services"
+	"go-chat-api/internal/storage"
+	"log"
+	"time"
+)
+
+// Config controls how the scheduler polls for due jobs
+type Config struct {
+	// Interval is the time between polls
+	Interval time.Duration
+	// BatchSize is the number of jobs claimed at once
+	BatchSize int
+	// Lease is how long a claimed job may take to complete before another poll claims it
+	// again, such as after the instance that claimed it stopped
+	Lease time.Duration
+}
+
+// Scheduler fires due scheduled messages and reminders
+type Scheduler struct {
+	store   storage.ScheduleStore
+	chat    *services.ChatService
+	deliver func(*models.Message)
+	config  Config
+}
+
+// New creates a scheduler that sends due messages through chat and passes them to
+// deliver for live delivery
+func New(store storage.ScheduleStore, chat *services.ChatService, deliver func(*models.Message), config Config) *Scheduler {
+	if config.Interval <= 0 {
+		config.Interval = 5 * time.Second
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 100
+	}
+	if config.Lease <= 0 {
+		config.Lease = 5 * time.Minute
+	}
+	return &Scheduler{
+		store:   store,
+		chat:    chat,
+		deliver: deliver,
+		config:  config,
+	}
+}
+
+// Run polls for due jobs until ctx is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.RunDue(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunDue fires the jobs due at now and returns how many were fired
+func (s *Scheduler) RunDue(now time.Time) int {
+	staleBefore := now.Add(-s.config.Lease)
+	fired := 0
+
+	for {
+		messages, err := s.store.ClaimDueScheduledMessages(now, staleBefore, s.config.BatchSize)
+		if err != nil {
+			log.Printf("Failed to claim scheduled messages: %v", err)
+			break
+		}
+		for _, message := range messages {
+			s.sendMessage(message)
+		}
+		fired += len(messages)
+		if len(messages) < s.config.BatchSize {
+			break
+		}
+	}
+
+	for {
+		reminders, err := s.store.ClaimDueReminders(now, staleBefore, s.config.BatchSize)
+		if err != nil {
+			log.Printf("Failed to claim reminders: %v", err)
+			break
+		}
+		for _, reminder := range reminders {
+			s.sendReminder(reminder)
+		}
+		fired += len(reminders)
+		if len(reminders) < s.config.BatchSize {
+			break
+		}
+	}
+
+	return fired
+}
+
+// sendMessage sends a claimed scheduled message and records the outcome. Messages that
+// cannot be sent, such as those rejected by moderation, are marked failed with the reason.
+func (s *Scheduler) sendMessage(scheduled models.ScheduledMessage) {
+	message, err := s.chat.SendScheduledMessage(scheduled)
+	if err != nil {
+		log.Printf("Failed to send scheduled message %s: %v", scheduled.ID, err)
+		if err := s.store.CompleteScheduledMessage(scheduled.ID, models.ScheduleFailed, "", err.Error()); err != nil {
+			log.Printf("Failed to record scheduled message %s as failed: %v", scheduled.ID, err)
+		}
+		return
+	}
+
+	if err := s.store.CompleteScheduledMessage(scheduled.ID, models.ScheduleSent, message.ID, ""); err != nil {
+		log.Printf("Failed to record scheduled message %s as sent: %v", scheduled.ID, err)
+	}
+	if s.deliver != nil {
+		s.deliver(message)
+	}
+}
+
+// sendReminder notifies the user of a claimed reminder and records the outcome
+func (s *Scheduler) sendReminder(reminder models.Reminder) {
+	status := models.ScheduleSent
+	if err := s.chat.SendReminder(reminder); err != nil {
+		log.Printf("Failed to send reminder %s: %v", reminder.ID, err)
+		status = models.ScheduleFailed
+	}
+	if err := s.store.CompleteReminder(reminder.ID, status); err != nil {
+		log.Printf("Failed to record reminder %s as %s: %v", reminder.ID, status, err)
+	}
+}
@@ -0,0 +1,156 @@
+package scheduler
+
+import (
+	"go-chat-api/internal/auth"
+	"go-chat-api/internal/models"
+	"go-chat-api/internal/services"
+	"go-chat-api/internal/storage"
+	"testing"
+	"time"
+)
+
+func TestScheduler_RunDue(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour)
+
+	var events []map[string]interface{}
+	chat := services.NewChatService(store, store, store, authService,
+		services.WithScheduling(store, func(userIDs []string, event map[string]interface{}) {
+			events = append(events, event)
+		}),
+	)
+	var delivered []*models.Message
+	scheduler := New(store, chat, func(message *models.Message) {
+		delivered = append(delivered, message)
+	}, Config{Lease: time.Minute})
+
+	register := func(name string) *models.User {
+		user, err := chat.RegisterUser(models.RegisterRequest{Username: name, Email: name + "@example.com", Password: "password123"})
+		if err != nil {
+			t.Fatalf("RegisterUser(%s) unexpected error = %v", name, err)
+		}
+		return user
+	}
+	alice, bob := register("alice"), register("bob")
+
+	room, _ := chat.CreateRoom(models.CreateRoomRequest{Name: "general"})
+	store.AddUserToRoom(room.ID, alice.ID)
+	store.AddUserToRoom(room.ID, bob.ID)
+
+	now := time.Now()
+	schedule := func(userID, content string, sendAt time.Time) *models.ScheduledMessage {
+		scheduled, err := chat.ScheduleMessage(userID, models.ScheduleMessageRequest{Content: content, RoomID: room.ID, SendAt: sendAt})
+		if err != nil {
+			t.Fatalf("ScheduleMessage() unexpected error = %v", err)
+		}
+		return scheduled
+	}
+
+	t.Run("fires due messages once", func(t *testing.T) {
+		delivered = nil
+		schedule(alice.ID, "soon", now.Add(time.Minute))
+		schedule(alice.ID, "later", now.Add(time.Hour))
+
+		if fired := scheduler.RunDue(now); fired != 0 {
+			t.Errorf("RunDue() before the messages are due = %d, want 0", fired)
+		}
+		if fired := scheduler.RunDue(now.Add(2 * time.Minute)); fired != 1 {
+			t.Fatalf("RunDue() = %d, want 1", fired)
+		}
+		if len(delivered) != 1 || delivered[0].Content != "soon" || delivered[0].Sender != "alice" {
+			t.Errorf("delivered = %+v, want the due message from alice", delivered)
+		}
+		if fired := scheduler.RunDue(now.Add(2 * time.Minute)); fired != 0 {
+			t.Errorf("RunDue() again = %d, want 0", fired)
+		}
+
+		messages, _ := store.GetMessagesByRoom(room.ID)
+		if len(messages) != 1 || messages[0].Content != "soon" {
+			t.Errorf("room messages = %+v, want the sent message", messages)
+		}
+		pending, _ := chat.GetScheduledMessages(alice.ID)
+		if len(pending) != 1 || pending[0].Content != "later" {
+			t.Errorf("GetScheduledMessages() = %+v, want only the message not yet due", pending)
+		}
+		if err := chat.CancelScheduledMessage(alice.ID, pending[0].ID); err != nil {
+			t.Fatalf("CancelScheduledMessage() unexpected error = %v", err)
+		}
+	})
+
+	t.Run("does not fire cancelled messages", func(t *testing.T) {
+		delivered = nil
+		scheduled := schedule(alice.ID, "cancelled", now.Add(time.Minute))
+		if err := chat.CancelScheduledMessage(alice.ID, scheduled.ID); err != nil {
+			t.Fatalf("CancelScheduledMessage() unexpected error = %v", err)
+		}
+		if fired := scheduler.RunDue(now.Add(2 * time.Hour)); fired != 0 || len(delivered) != 0 {
+			t.Errorf("RunDue() = %d, delivered %d, want nothing", fired, len(delivered))
+		}
+	})
+
+	t.Run("records failures", func(t *testing.T) {
+		delivered = nil
+		schedule(bob.ID, "after leaving", now.Add(time.Minute))
+		store.RemoveUserFromRoom(room.ID, bob.ID)
+		defer store.AddUserToRoom(room.ID, bob.ID)
+
+		if fired := scheduler.RunDue(now.Add(2 * time.Minute)); fired != 1 {
+			t.Fatalf("RunDue() = %d, want 1", fired)
+		}
+		if len(delivered) != 0 {
+			t.Errorf("delivered = %+v, want nothing", delivered)
+		}
+		// Failed messages are not retried
+		if fired := scheduler.RunDue(now.Add(time.Hour)); fired != 0 {
+			t.Errorf("RunDue() after the failure = %d, want 0", fired)
+		}
+	})
+
+	t.Run("reclaims expired claims", func(t *testing.T) {
+		delivered = nil
+		schedule(alice.ID, "orphaned", now.Add(time.Minute))
+
+		// Another instance claims the message and stops before sending it
+		claimedAt := now.Add(2 * time.Minute)
+		if claimed, _ := store.ClaimDueScheduledMessages(claimedAt, claimedAt.Add(-time.Minute), 10); len(claimed) != 1 {
+			t.Fatalf("ClaimDueScheduledMessages() = %d messages, want 1", len(claimed))
+		}
+		if fired := scheduler.RunDue(claimedAt.Add(30 * time.Second)); fired != 0 {
+			t.Errorf("RunDue() within the lease = %d, want 0", fired)
+		}
+		if fired := scheduler.RunDue(claimedAt.Add(2 * time.Minute)); fired != 1 || len(delivered) != 1 {
+			t.Errorf("RunDue() after the lease = %d, delivered %d, want 1", fired, len(delivered))
+		}
+	})
+
+	t.Run("sends reminders", func(t *testing.T) {
+		events = nil
+		message, _ := chat.SendMessage(models.MessageRequest{Sender: "alice", RoomID: room.ID, Content: "remember me"})
+		if _, err := chat.SetReminder(bob.ID, message.ID, models.ReminderRequest{RemindAt: now.Add(time.Minute)}); err != nil {
+			t.Fatalf("SetReminder() unexpected error = %v", err)
+		}
+
+		if fired := scheduler.RunDue(now); fired != 0 {
+			t.Errorf("RunDue() before the reminder is due = %d, want 0", fired)
+		}
+		if fired := scheduler.RunDue(now.Add(2 * time.Minute)); fired != 1 {
+			t.Fatalf("RunDue() = %d, want 1", fired)
+		}
+		if len(events) != 1 || events[0]["type"] != "reminder" {
+			t.Fatalf("events = %v, want one reminder event", events)
+		}
+		if reminder, ok := events[0]["reminder"].(models.Reminder); !ok || reminder.Message == nil || reminder.Message.ID != message.ID {
+			t.Errorf("reminder event = %+v, want the reminder with its message", events[0]["reminder"])
+		}
+		if reminders, _ := chat.GetReminders(bob.ID); len(reminders) != 0 {
+			t.Errorf("GetReminders() after sending = %d reminders, want none", len(reminders))
+		}
+	})
+}
+
+func TestNew_Defaults(t *testing.T) {
+	scheduler := New(storage.NewInMemoryStorage(), nil, nil, Config{})
+	if scheduler.config.Interval != 5*time.Second || scheduler.config.BatchSize != 100 || scheduler.config.Lease != 5*time.Minute {
+		t.Errorf("New() config = %+v, want the defaults", scheduler.config)
+	}
+}
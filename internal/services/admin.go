@@ -0,0 +1,262 @@
+package services
+
+import (
+	"errors"
+	"go-chat-api/internal/audit"
+	"go-chat-api/internal/auth"
+	"go-chat-api/internal/models"
+	"time"
+)
+
+const (
+	// defaultUserPageSize is used when a user search does not specify a limit
+	defaultUserPageSize = 50
+
+	// maxUserPageSize is the largest page of users a search can return
+	maxUserPageSize = 200
+
+	// defaultAuditPageSize is used when an audit search does not specify a limit
+	defaultAuditPageSize = 50
+
+	// maxAuditPageSize is the largest page of audit events a search can return
+	maxAuditPageSize = 500
+)
+
+var (
+	// ErrOwnAccountModeration is returned when an administrator tries to suspend or delete their own account
+	ErrOwnAccountModeration = errors.New("administrators cannot suspend or delete their own account")
+
+	// ErrProtectedAccount is returned when an administrator tries to suspend or delete another administrator
+	ErrProtectedAccount = errors.New("administrators must be demoted before they can be suspended or deleted")
+)
+
+// SearchUsers returns a page of users matching the filter and the total number of matches
+func (s *ChatService) SearchUsers(filter models.UserFilter) ([]models.User, int, error) {
+	if filter.Role != "" && !auth.IsValidRole(filter.Role) {
+		return nil, 0, ErrInvalidRole
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = defaultUserPageSize
+	}
+	if filter.Limit > maxUserPageSize {
+		filter.Limit = maxUserPageSize
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	users, total, err := s.userStore.SearchUsers(filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	if users == nil {
+		users = []models.User{}
+	}
+	return users, total, nil
+}
+
+// SuspendUser blocks a user from signing in and revokes all of their sessions and the API
+// keys of the bots they manage
+func (s *ChatService) SuspendUser(actorID, userID, reason, ip string) error {
+	user, err := s.moderatedUser(actorID, userID)
+	if err != nil {
+		return err
+	}
+	if user.IsSuspended() {
+		return nil
+	}
+
+	now := time.Now()
+	if err := s.userStore.SetUserSuspended(userID, &now, reason); err != nil {
+		return err
+	}
+	if err := s.endUserSessions(userID); err != nil {
+		return err
+	}
+	if err := s.revokeBotKeys(userID); err != nil {
+		return err
+	}
+
+	s.auditLog.Record(models.AuditEvent{
+		Action:   audit.ActionUserSuspended,
+		ActorID:  actorID,
+		TargetID: userID,
+		IP:       ip,
+		Metadata: map[string]string{"reason": reason},
+	})
+	return nil
+}
+
+// UnsuspendUser lifts the suspension of a user
+func (s *ChatService) UnsuspendUser(actorID, userID, ip string) error {
+	user, err := s.userStore.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return errors.New("user not found")
+	}
+	if !user.IsSuspended() {
+		return nil
+	}
+
+	if err := s.userStore.SetUserSuspended(userID, nil, ""); err != nil {
+		return err
+	}
+
+	s.auditLog.Record(models.AuditEvent{
+		Action:   audit.ActionUserUnsuspended,
+		ActorID:  actorID,
+		TargetID: userID,
+		IP:       ip,
+	})
+	return nil
+}
+
+// ForceLogout revokes all sessions of a user on behalf of an administrator
+func (s *ChatService) ForceLogout(actorID, userID, ip string) error {
+	user, err := s.userStore.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return errors.New("user not found")
+	}
+
+	if err := s.endUserSessions(userID); err != nil {
+		return err
+	}
+
+	s.auditLog.Record(models.AuditEvent{
+		Action:   audit.ActionUserLoggedOut,
+		ActorID:  actorID,
+		TargetID: userID,
+		IP:       ip,
+	})
+	return nil
+}
+
+// AdminResetPassword replaces a user's password with an unusable one, revokes their
+// sessions and emails them a link to choose a new password
+func (s *ChatService) AdminResetPassword(actorID, userID, ip string) error {
+	if s.tokenStore == nil || s.mailer == nil {
+		return errors.New("password reset is not configured")
+	}
+
+	user, err := s.userStore.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return errors.New("user not found")
+	}
+	if user.IsBot || user.Email == "" {
+		return errors.New("user has no email address to send a reset link to")
+	}
+
+	// Lock out the current password until the user picks a new one
+	unusable, _, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return err
+	}
+	if err := s.setPassword(userID, unusable); err != nil {
+		return err
+	}
+	if err := s.endUserSessions(userID); err != nil {
+		return err
+	}
+
+	s.auditLog.Record(models.AuditEvent{
+		Action:   audit.ActionPasswordReset,
+		ActorID:  actorID,
+		TargetID: userID,
+		IP:       ip,
+	})
+
+	return s.sendPasswordResetEmail(user,
+		"An administrator has reset your password.",
+		"Your previous password no longer works and you have been signed out everywhere.")
+}
+
+// DeleteUser permanently deletes a user along with their bots, messages and credentials
+func (s *ChatService) DeleteUser(actorID, userID, ip string) error {
+	user, err := s.moderatedUser(actorID, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.userStore.DeleteUser(userID); err != nil {
+		return err
+	}
+
+	s.auditLog.Record(models.AuditEvent{
+		Action:   audit.ActionUserDeleted,
+		ActorID:  actorID,
+		TargetID: userID,
+		IP:       ip,
+		Metadata: map[string]string{"username": user.Username, "email": user.Email},
+	})
+	return nil
+}
+
+// moderatedUser loads the target of a suspension or deletion, refusing the
+// administrator's own account and other administrators
+func (s *ChatService) moderatedUser(actorID, userID string) (*models.User, error) {
+	if actorID == userID {
+		return nil, ErrOwnAccountModeration
+	}
+
+	user, err := s.userStore.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("user not found")
+	}
+	if user.Role == models.RoleAdmin {
+		return nil, ErrProtectedAccount
+	}
+	return user, nil
+}
+
+// endUserSessions revokes all sessions of a user and marks them offline
+func (s *ChatService) endUserSessions(userID string) error {
+	if err := s.authService.RevokeUserSessions(userID, ""); err != nil {
+		return err
+	}
+	return s.userStore.UpdateUserStatus(userID, false)
+}
+
+// SearchAuditEvents returns a page of audit events matching the filter, newest first,
+// and the total number of matches
+func (s *ChatService) SearchAuditEvents(filter models.AuditFilter) ([]models.AuditEvent, int, error) {
+	if s.auditLog == nil {
+		return nil, 0, errors.New("audit log is not enabled")
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = defaultAuditPageSize
+	}
+	if filter.Limit > maxAuditPageSize {
+		filter.Limit = maxAuditPageSize
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	events, total, err := s.auditLog.Search(filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	if events == nil {
+		events = []models.AuditEvent{}
+	}
+	return events, total, nil
+}
+
+// VerifyAuditLog checks that the audit trail's hash chain has not been tampered with
+func (s *ChatService) VerifyAuditLog() (models.AuditVerification, error) {
+	if s.auditLog == nil {
+		return models.AuditVerification{}, errors.New("audit log is not enabled")
+	}
+	return s.auditLog.Verify()
+}
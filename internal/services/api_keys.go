@@ -0,0 +1,249 @@
+package services
+
+import (
+	"errors"
+	"go-chat-api/internal/audit"
+	"go-chat-api/internal/auth"
+	"go-chat-api/internal/models"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultAPIKeyExpiryDays is used when a key is created without an explicit expiry
+	defaultAPIKeyExpiryDays = 90
+
+	// maxAPIKeyExpiryDays is the longest lifetime an API key can be created with
+	maxAPIKeyExpiryDays = 365
+)
+
+var (
+	// ErrInvalidScope is returned when an API key is requested with an unknown scope
+	ErrInvalidScope = errors.New("invalid api key scope")
+
+	// ErrInvalidAPIKeyExpiry is returned when an API key lifetime is out of range
+	ErrInvalidAPIKeyExpiry = errors.New("api key expiry must be between 1 and 365 days")
+
+	// ErrAPIKeyNotFound is returned for keys that do not exist or belong to someone else
+	ErrAPIKeyNotFound = errors.New("api key not found")
+
+	// ErrBotNotFound is returned for bots that do not exist or are managed by someone else
+	ErrBotNotFound = errors.New("bot not found")
+)
+
+// CreateBot creates a bot account managed by ownerID. Bots have no password and
+// can only authenticate with API keys.
+func (s *ChatService) CreateBot(ownerID string, req models.CreateBotRequest) (*models.User, error) {
+	owner, err := s.userStore.GetUser(ownerID)
+	if err != nil {
+		return nil, err
+	}
+	if owner == nil || owner.IsBot {
+		return nil, errors.New("only users can create bots")
+	}
+
+	req.Username = strings.TrimSpace(req.Username)
+	if req.Username == "" {
+		return nil, errors.New("username is required")
+	}
+	if existingUser, err := s.userStore.GetUserByUsername(req.Username); err != nil {
+		return nil, err
+	} else if existingUser != nil {
+		return nil, errors.New("username already exists")
+	}
+
+	id, err := generateID()
+	if err != nil {
+		return nil, err
+	}
+
+	bot := models.User{
+		ID:        id,
+		Username:  req.Username,
+		Role:      models.RoleUser,
+		IsBot:     true,
+		OwnerID:   ownerID,
+		CreatedAt: time.Now(),
+	}
+	if err := s.userStore.AddUser(bot); err != nil {
+		return nil, err
+	}
+
+	s.auditLog.Record(models.AuditEvent{
+		Action:   audit.ActionBotCreated,
+		ActorID:  ownerID,
+		TargetID: bot.ID,
+		Metadata: map[string]string{"username": bot.Username},
+	})
+	return &bot, nil
+}
+
+// GetBots returns the bot accounts managed by a user
+func (s *ChatService) GetBots(ownerID string) ([]models.User, error) {
+	return s.userStore.GetBotsByOwner(ownerID)
+}
+
+// CreateAPIKey issues an API key for the user or for one of the user's bots.
+// The returned key is shown once; only its hash is stored.
+func (s *ChatService) CreateAPIKey(userID string, req models.CreateAPIKeyRequest) (*models.CreateAPIKeyResponse, error) {
+	if s.apiKeys == nil {
+		return nil, errors.New("api keys are not enabled")
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return nil, errors.New("name is required")
+	}
+	if len(req.Scopes) == 0 {
+		return nil, ErrInvalidScope
+	}
+	for _, scope := range req.Scopes {
+		if !auth.IsValidAPIKeyScope(scope) {
+			return nil, ErrInvalidScope
+		}
+	}
+	if req.ExpiresInDays == 0 {
+		req.ExpiresInDays = defaultAPIKeyExpiryDays
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > maxAPIKeyExpiryDays {
+		return nil, ErrInvalidAPIKeyExpiry
+	}
+
+	keyOwner := userID
+	if req.BotID != "" {
+		if _, err := s.ownedBot(userID, req.BotID); err != nil {
+			return nil, err
+		}
+		keyOwner = req.BotID
+	}
+
+	id, err := generateID()
+	if err != nil {
+		return nil, err
+	}
+	key, prefix, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	apiKey := models.APIKey{
+		ID:        id,
+		UserID:    keyOwner,
+		Name:      req.Name,
+		Prefix:    prefix,
+		KeyHash:   hash,
+		Scopes:    req.Scopes,
+		CreatedAt: now,
+		ExpiresAt: now.AddDate(0, 0, req.ExpiresInDays),
+	}
+	if err := s.apiKeys.AddAPIKey(apiKey); err != nil {
+		return nil, err
+	}
+
+	s.auditLog.Record(models.AuditEvent{
+		Action:   audit.ActionAPIKeyCreated,
+		ActorID:  userID,
+		TargetID: keyOwner,
+		Metadata: map[string]string{"key_id": apiKey.ID, "prefix": prefix, "scopes": strings.Join(req.Scopes, " ")},
+	})
+
+	return &models.CreateAPIKeyResponse{Key: key, APIKey: apiKey}, nil
+}
+
+// ListAPIKeys returns the API keys of a user and of the bots the user manages
+func (s *ChatService) ListAPIKeys(userID string) ([]models.APIKey, error) {
+	if s.apiKeys == nil {
+		return nil, errors.New("api keys are not enabled")
+	}
+
+	keys, err := s.apiKeys.GetAPIKeysByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	bots, err := s.userStore.GetBotsByOwner(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, bot := range bots {
+		botKeys, err := s.apiKeys.GetAPIKeysByUser(bot.ID)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, botKeys...)
+	}
+
+	if keys == nil {
+		keys = []models.APIKey{}
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey revokes an API key belonging to the user or to one of the user's bots
+func (s *ChatService) RevokeAPIKey(userID, keyID string) error {
+	if s.apiKeys == nil {
+		return errors.New("api keys are not enabled")
+	}
+
+	key, err := s.apiKeys.GetAPIKey(keyID)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return ErrAPIKeyNotFound
+	}
+	if key.UserID != userID {
+		if _, err := s.ownedBot(userID, key.UserID); err != nil {
+			return ErrAPIKeyNotFound
+		}
+	}
+
+	if err := s.apiKeys.RevokeAPIKey(keyID); err != nil {
+		return err
+	}
+
+	s.auditLog.Record(models.AuditEvent{
+		Action:   audit.ActionAPIKeyRevoked,
+		ActorID:  userID,
+		TargetID: key.UserID,
+		Metadata: map[string]string{"key_id": key.ID, "prefix": key.Prefix},
+	})
+	return nil
+}
+
+// revokeBotKeys revokes the active API keys of the bots managed by ownerID
+func (s *ChatService) revokeBotKeys(ownerID string) error {
+	if s.apiKeys == nil {
+		return nil
+	}
+
+	bots, err := s.userStore.GetBotsByOwner(ownerID)
+	if err != nil {
+		return err
+	}
+	for _, bot := range bots {
+		keys, err := s.apiKeys.GetAPIKeysByUser(bot.ID)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if key.RevokedAt != nil {
+				continue
+			}
+			if err := s.apiKeys.RevokeAPIKey(key.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ownedBot returns the bot with the given ID if it is managed by ownerID
+func (s *ChatService) ownedBot(ownerID, botID string) (*models.User, error) {
+	bot, err := s.userStore.GetUser(botID)
+	if err != nil || bot == nil || !bot.IsBot || bot.OwnerID != ownerID {
+		return nil, ErrBotNotFound
+	}
+	return bot, nil
+}
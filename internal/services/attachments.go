@@ -0,0 +1,462 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go-chat-api/internal/models"
+	"go-chat-api/internal/storage"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	// Register the decoders used for image dimensions and thumbnails
+	_ "image/gif"
+	_ "image/jpeg"
+)
+
+const (
+	// maxMessageAttachments limits the attachments sent with a single message
+	maxMessageAttachments = 10
+
+	// maxAttachmentFilenameLength limits stored attachment filenames
+	maxAttachmentFilenameLength = 255
+
+	// maxThumbnailSize is the largest width or height of an image thumbnail
+	maxThumbnailSize = 320
+
+	// maxImagePixels guards against decompression bombs: larger images are stored without a thumbnail
+	maxImagePixels = 40_000_000
+
+	// AttachmentContent and AttachmentThumbnail are the downloadable variants of an attachment
+	AttachmentContent   = "content"
+	AttachmentThumbnail = "thumbnail"
+)
+
+var (
+	// ErrAttachmentsDisabled is returned when no attachment storage is configured
+	ErrAttachmentsDisabled = errors.New("attachments are not enabled")
+
+	// ErrAttachmentTooLarge is returned for uploads over the configured size limit
+	ErrAttachmentTooLarge = errors.New("attachment is too large")
+
+	// ErrAttachmentType is returned for uploads whose detected content type is not allowed
+	ErrAttachmentType = errors.New("attachment type is not allowed")
+
+	// ErrAttachmentNotFound is returned for attachments that do not exist or that the viewer cannot see
+	ErrAttachmentNotFound = errors.New("attachment not found")
+
+	// ErrInvalidAttachments is wrapped by the errors returned for unusable attachment IDs on a message
+	ErrInvalidAttachments = errors.New("invalid attachments")
+
+	// ErrInvalidAttachmentURL is returned for download links with a bad signature or past their expiry
+	ErrInvalidAttachmentURL = errors.New("invalid or expired attachment link")
+)
+
+// AttachmentPolicy limits uploads and configures download links
+type AttachmentPolicy struct {
+	// MaxBytes is the largest accepted upload
+	MaxBytes int64
+
+	// AllowedTypes lists the accepted content types, as detected from the file content
+	AllowedTypes []string
+
+	// URLSecret signs download links, which stay valid for URLTTL
+	URLSecret []byte
+	URLTTL    time.Duration
+}
+
+// WithAttachments enables file attachments, keeping their metadata in store and their content in blobs
+func WithAttachments(store storage.AttachmentStore, blobs storage.BlobStore, policy AttachmentPolicy) Option {
+	return func(s *ChatService) {
+		s.attachments = store
+		s.blobs = blobs
+		s.attachmentPolicy = policy
+	}
+}
+
+// AttachmentSizeLimit returns the largest accepted upload in bytes
+func (s *ChatService) AttachmentSizeLimit() int64 {
+	return s.attachmentPolicy.MaxBytes
+}
+
+// UploadAttachment stores a file uploaded by uploaderID. The content type is sniffed
+// from the content and checked against the allow-list; images get their dimensions
+// recorded and a PNG thumbnail.
+func (s *ChatService) UploadAttachment(uploaderID, filename string, r io.Reader) (*models.Attachment, error) {
+	if s.attachments == nil {
+		return nil, ErrAttachmentsDisabled
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, s.attachmentPolicy.MaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > s.attachmentPolicy.MaxBytes {
+		return nil, ErrAttachmentTooLarge
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: the file is empty", ErrInvalidAttachments)
+	}
+
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	if !s.attachmentTypeAllowed(contentType) {
+		return nil, fmt.Errorf("%w: %s", ErrAttachmentType, contentType)
+	}
+
+	id, err := generateID()
+	if err != nil {
+		return nil, err
+	}
+	attachment := models.Attachment{
+		ID:          id,
+		UploaderID:  uploaderID,
+		Filename:    sanitizeFilename(filename),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		CreatedAt:   time.Now(),
+	}
+
+	if err := s.blobs.PutBlob(attachmentKey(id, AttachmentContent), bytes.NewReader(data), attachment.Size, contentType); err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(contentType, "image/") {
+		width, height, thumbnail := makeThumbnail(data)
+		attachment.Width, attachment.Height = width, height
+		if thumbnail != nil {
+			err := s.blobs.PutBlob(attachmentKey(id, AttachmentThumbnail), bytes.NewReader(thumbnail), int64(len(thumbnail)), "image/png")
+			if err != nil {
+				log.Printf("Failed to store thumbnail for attachment %s: %v", id, err)
+			} else {
+				attachment.HasThumbnail = true
+			}
+		}
+	}
+
+	if err := s.attachments.AddAttachment(attachment); err != nil {
+		s.deleteAttachmentBlobs(attachment)
+		return nil, err
+	}
+
+	s.signAttachment(&attachment)
+	return &attachment, nil
+}
+
+// GetAttachment returns an attachment with fresh download links. Viewers can see their
+// own uploads and the attachments of messages they can read.
+func (s *ChatService) GetAttachment(viewerID, id string) (*models.Attachment, error) {
+	if s.attachments == nil {
+		return nil, ErrAttachmentsDisabled
+	}
+
+	attachment, err := s.attachments.GetAttachment(id)
+	if err != nil {
+		return nil, err
+	}
+	if attachment == nil {
+		return nil, ErrAttachmentNotFound
+	}
+
+	if attachment.UploaderID != viewerID {
+		visible, err := s.canViewMessage(viewerID, attachment.MessageID)
+		if err != nil {
+			return nil, err
+		}
+		if !visible {
+			return nil, ErrAttachmentNotFound
+		}
+	}
+
+	s.signAttachment(attachment)
+	return attachment, nil
+}
+
+// OpenAttachment verifies a signed download link and returns the attachment and the
+// requested variant's content. The caller must close the content.
+func (s *ChatService) OpenAttachment(id, variant, expires, signature string) (*models.Attachment, io.ReadCloser, error) {
+	if s.attachments == nil {
+		return nil, nil, ErrAttachmentsDisabled
+	}
+	if variant != AttachmentContent && variant != AttachmentThumbnail {
+		return nil, nil, ErrAttachmentNotFound
+	}
+
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return nil, nil, ErrInvalidAttachmentURL
+	}
+	expected := s.attachmentSignature(id, variant, expiresAt)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return nil, nil, ErrInvalidAttachmentURL
+	}
+
+	attachment, err := s.attachments.GetAttachment(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if attachment == nil || (variant == AttachmentThumbnail && !attachment.HasThumbnail) {
+		return nil, nil, ErrAttachmentNotFound
+	}
+
+	content, err := s.blobs.GetBlob(attachmentKey(id, variant))
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return nil, nil, ErrAttachmentNotFound
+		}
+		return nil, nil, err
+	}
+	return attachment, content, nil
+}
+
+// checkMessageAttachments checks the attachment IDs of a message request before it is
+// sent and returns the sender's user ID, which owns the attachments
+func (s *ChatService) checkMessageAttachments(req models.MessageRequest) (string, error) {
+	if len(req.AttachmentIDs) == 0 {
+		return "", nil
+	}
+	if s.attachments == nil {
+		return "", ErrAttachmentsDisabled
+	}
+	if len(req.AttachmentIDs) > maxMessageAttachments {
+		return "", fmt.Errorf("%w: a message can have at most %d attachments", ErrInvalidAttachments, maxMessageAttachments)
+	}
+
+	sender, err := s.userStore.GetUserByUsername(req.Sender)
+	if err != nil {
+		return "", err
+	}
+	if sender == nil {
+		return "", errors.New("user not found")
+	}
+
+	seen := make(map[string]bool, len(req.AttachmentIDs))
+	for _, id := range req.AttachmentIDs {
+		if seen[id] {
+			return "", fmt.Errorf("%w: attachment %s is listed twice", ErrInvalidAttachments, id)
+		}
+		seen[id] = true
+
+		attachment, err := s.attachments.GetAttachment(id)
+		if err != nil {
+			return "", err
+		}
+		if attachment == nil || attachment.UploaderID != sender.ID {
+			return "", fmt.Errorf("%w: attachment %s not found", ErrInvalidAttachments, id)
+		}
+		if attachment.MessageID != "" {
+			return "", fmt.Errorf("%w: attachment %s was already sent", ErrInvalidAttachments, id)
+		}
+	}
+	return sender.ID, nil
+}
+
+// populateAttachments adds the attachments, with download links, to messages
+func (s *ChatService) populateAttachments(messages []models.Message) ([]models.Message, error) {
+	if s.attachments == nil || len(messages) == 0 {
+		return messages, nil
+	}
+
+	ids := make([]string, len(messages))
+	for i, message := range messages {
+		ids[i] = message.ID
+	}
+	attachments, err := s.attachments.GetMessageAttachments(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range messages {
+		list := attachments[messages[i].ID]
+		for j := range list {
+			s.signAttachment(&list[j])
+		}
+		messages[i].Attachments = list
+	}
+	return messages, nil
+}
+
+// canViewMessage reports whether viewerID can read the message with the given ID
+func (s *ChatService) canViewMessage(viewerID, messageID string) (bool, error) {
+	if messageID == "" {
+		return false, nil
+	}
+	message, err := s.messageStore.GetMessage(messageID)
+	if err != nil || message == nil {
+		return false, err
+	}
+	return s.canReadMessage(viewerID, *message)
+}
+
+// canReadMessage reports whether viewerID can read a message: room messages are visible
+// to room members, direct messages to the two participants and global messages to everyone
+func (s *ChatService) canReadMessage(viewerID string, message models.Message) (bool, error) {
+	if message.RoomID == "" && message.Recipient == "" {
+		return true, nil
+	}
+
+	if message.RoomID != "" {
+		room, err := s.roomStore.GetRoom(message.RoomID)
+		if err != nil || room == nil {
+			return false, err
+		}
+		for _, member := range room.Members {
+			if member == viewerID {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	viewer, err := s.userStore.GetUser(viewerID)
+	if err != nil || viewer == nil {
+		return false, err
+	}
+	return message.Sender == viewer.Username || message.Recipient == viewer.Username, nil
+}
+
+// deleteAttachmentBlobs removes the stored content of an attachment, logging failures
+func (s *ChatService) deleteAttachmentBlobs(attachment models.Attachment) {
+	variants := []string{AttachmentContent}
+	if attachment.HasThumbnail {
+		variants = append(variants, AttachmentThumbnail)
+	}
+	for _, variant := range variants {
+		if err := s.blobs.DeleteBlob(attachmentKey(attachment.ID, variant)); err != nil {
+			log.Printf("Failed to delete %s of attachment %s: %v", variant, attachment.ID, err)
+		}
+	}
+}
+
+// signAttachment fills in the download links of an attachment
+func (s *ChatService) signAttachment(attachment *models.Attachment) {
+	expiresAt := time.Now().Add(s.attachmentPolicy.URLTTL).Truncate(time.Second)
+	attachment.URL = s.attachmentURL(attachment.ID, AttachmentContent, expiresAt)
+	attachment.ThumbnailURL = ""
+	if attachment.HasThumbnail {
+		attachment.ThumbnailURL = s.attachmentURL(attachment.ID, AttachmentThumbnail, expiresAt)
+	}
+	attachment.URLExpiresAt = &expiresAt
+}
+
+// attachmentURL returns a signed download link for a variant of an attachment
+func (s *ChatService) attachmentURL(id, variant string, expiresAt time.Time) string {
+	expires := expiresAt.Unix()
+	return fmt.Sprintf("%s/api/attachments/%s/%s?expires=%d&signature=%s",
+		s.baseURL, id, variant, expires, s.attachmentSignature(id, variant, expires))
+}
+
+// attachmentSignature signs the attachment, variant and expiry of a download link
+func (s *ChatService) attachmentSignature(id, variant string, expires int64) string {
+	mac := hmac.New(sha256.New, s.attachmentPolicy.URLSecret)
+	fmt.Fprintf(mac, "%s\n%s\n%d", id, variant, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// attachmentTypeAllowed reports whether a detected content type is on the allow-list
+func (s *ChatService) attachmentTypeAllowed(contentType string) bool {
+	for _, allowed := range s.attachmentPolicy.AllowedTypes {
+		if strings.EqualFold(allowed, contentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// attachmentKey returns the blob key of a variant of an attachment
+func attachmentKey(id, variant string) string {
+	if variant == AttachmentThumbnail {
+		return "attachments/" + id + "/thumbnail"
+	}
+	return "attachments/" + id + "/original"
+}
+
+// sanitizeFilename keeps the base name of an uploaded file without control characters
+func sanitizeFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == "/" {
+		name = "attachment"
+	}
+	if len(name) > maxAttachmentFilenameLength {
+		name = strings.ToValidUTF8(name[:maxAttachmentFilenameLength], "")
+	}
+	return name
+}
+
+// makeThumbnail returns the dimensions of an image and a PNG thumbnail of at most
+// maxThumbnailSize pixels per side. Formats without a decoder and oversized images
+// get no thumbnail.
+func makeThumbnail(data []byte) (int, int, []byte) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, nil
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxImagePixels {
+		return config.Width, config.Height, nil
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return config.Width, config.Height, nil
+	}
+
+	width, height := config.Width, config.Height
+	if width > maxThumbnailSize || height > maxThumbnailSize {
+		if width >= height {
+			width, height = maxThumbnailSize, max(1, height*maxThumbnailSize/config.Width)
+		} else {
+			width, height = max(1, width*maxThumbnailSize/config.Height), maxThumbnailSize
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, scaleImage(src, width, height)); err != nil {
+		return config.Width, config.Height, nil
+	}
+	return config.Width, config.Height, buf.Bytes()
+}
+
+// scaleImage downscales an image by averaging the source pixels covered by each target pixel
+func scaleImage(src image.Image, width, height int) image.Image {
+	bounds := src.Bounds()
+	dst := image.NewRGBA64(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*bounds.Dy()/height)
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := max(x0+1, bounds.Min.X+(x+1)*bounds.Dx()/width)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
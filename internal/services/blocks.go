@@ -0,0 +1,156 @@
+package services
+
+import (
+	"errors"
+	"go-chat-api/internal/models"
+	"time"
+)
+
+var (
+	// ErrBlocked is returned when a user messages, or adds to a room, someone who has blocked them
+	ErrBlocked = errors.New("this user is not accepting messages or invitations from you")
+
+	// ErrOwnBlock is returned when a user tries to block themselves
+	ErrOwnBlock = errors.New("you cannot block yourself")
+)
+
+// BlockUser adds blockedID to the block list of userID
+func (s *ChatService) BlockUser(userID, blockedID string) (*models.UserBlock, error) {
+	if s.blocks == nil {
+		return nil, errors.New("blocking is not enabled")
+	}
+	if userID == blockedID {
+		return nil, ErrOwnBlock
+	}
+
+	blocked, err := s.userStore.GetUser(blockedID)
+	if err != nil {
+		return nil, err
+	}
+	if blocked == nil {
+		return nil, errors.New("user not found")
+	}
+
+	block := models.UserBlock{
+		UserID:          userID,
+		BlockedID:       blockedID,
+		BlockedUsername: blocked.Username,
+		CreatedAt:       time.Now(),
+	}
+	if err := s.blocks.AddUserBlock(block); err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+// UnblockUser removes blockedID from the block list of userID
+func (s *ChatService) UnblockUser(userID, blockedID string) error {
+	if s.blocks == nil {
+		return errors.New("blocking is not enabled")
+	}
+	return s.blocks.DeleteUserBlock(userID, blockedID)
+}
+
+// GetBlockedUsers returns the block list of a user
+func (s *ChatService) GetBlockedUsers(userID string) ([]models.UserBlock, error) {
+	if s.blocks == nil {
+		return []models.UserBlock{}, nil
+	}
+
+	blocks, err := s.blocks.GetUserBlocks(userID)
+	if err != nil {
+		return nil, err
+	}
+	if blocks == nil {
+		blocks = []models.UserBlock{}
+	}
+	return blocks, nil
+}
+
+// GetBlockedUsernames returns the usernames on the block list of a user, which is how
+// message senders are identified
+func (s *ChatService) GetBlockedUsernames(userID string) ([]string, error) {
+	blocks, err := s.GetBlockedUsers(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	usernames := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		usernames = append(usernames, block.BlockedUsername)
+	}
+	return usernames, nil
+}
+
+// hasBlocked reports whether userID has blocked blockedID
+func (s *ChatService) hasBlocked(userID, blockedID string) (bool, error) {
+	if s.blocks == nil {
+		return false, nil
+	}
+	return s.blocks.IsUserBlocked(userID, blockedID)
+}
+
+// checkDirectMessage refuses direct messages to a recipient who has blocked the sender.
+// Both are identified by username.
+func (s *ChatService) checkDirectMessage(senderName, recipientName string) error {
+	if s.blocks == nil {
+		return nil
+	}
+
+	sender, err := s.userStore.GetUserByUsername(senderName)
+	if err != nil {
+		return err
+	}
+	recipient, err := s.userStore.GetUserByUsername(recipientName)
+	if err != nil {
+		return err
+	}
+	if sender == nil || recipient == nil {
+		return nil
+	}
+
+	blocked, err := s.hasBlocked(recipient.ID, sender.ID)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return ErrBlocked
+	}
+	return nil
+}
+
+// blockedUsernames returns the set of usernames a user has blocked
+func (s *ChatService) blockedUsernames(userID string) (map[string]bool, error) {
+	blocked := make(map[string]bool)
+	if s.blocks == nil || userID == "" {
+		return blocked, nil
+	}
+
+	usernames, err := s.GetBlockedUsernames(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, username := range usernames {
+		blocked[username] = true
+	}
+	return blocked, nil
+}
+
+// filterBlocked drops the messages sent by users the viewer has blocked
+func (s *ChatService) filterBlocked(viewerID string, messages []models.Message) ([]models.Message, error) {
+	blocked, err := s.blockedUsernames(viewerID)
+	if err != nil {
+		return nil, err
+	}
+	if len(blocked) == 0 {
+		return messages, nil
+	}
+
+	filtered := make([]models.Message, 0, len(messages))
+	for _, message := range messages {
+		if !blocked[message.Sender] {
+			filtered = append(filtered, message)
+		}
+	}
+	return filtered, nil
+}
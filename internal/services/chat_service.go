@@ -4,32 +4,287 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
+	"fmt"
+	"go-chat-api/internal/audit"
 	"go-chat-api/internal/auth"
+	"go-chat-api/internal/mailer"
+	"go-chat-api/internal/markdown"
 	"go-chat-api/internal/models"
+	"go-chat-api/internal/moderation"
 	"go-chat-api/internal/storage"
+	"go-chat-api/internal/unfurl"
+	"log"
+	"net/mail"
+	"strings"
+	"sync"
 	"time"
 )
 
+const (
+	// passwordResetExpiry is how long a password reset token stays valid
+	passwordResetExpiry = time.Hour
+
+	// emailVerificationExpiry is how long an email verification token stays valid
+	emailVerificationExpiry = 48 * time.Hour
+
+	// maxExternalUsernameLength limits usernames derived from external identities
+	maxExternalUsernameLength = 32
+)
+
+var (
+	// ErrInvalidEmail is returned when an email address is not syntactically valid
+	ErrInvalidEmail = errors.New("invalid email address")
+
+	// ErrEmailNotVerified is returned when an unverified user attempts an action that requires verification
+	ErrEmailNotVerified = errors.New("email address has not been verified")
+
+	// ErrInvalidVerificationToken is returned for unknown, used or expired verification tokens
+	ErrInvalidVerificationToken = errors.New("invalid or expired verification token")
+
+	// ErrInvalidCurrentPassword is returned when a password change supplies the wrong current password
+	ErrInvalidCurrentPassword = errors.New("current password is incorrect")
+
+	// ErrInvalidResetToken is returned for unknown, used or expired password reset tokens
+	ErrInvalidResetToken = errors.New("invalid or expired reset token")
+
+	// ErrIdentityConflict is returned when an external login matches a local account that
+	// cannot be linked automatically because one of the email addresses is not verified
+	ErrIdentityConflict = errors.New("an account with this email already exists, sign in with your password instead")
+
+	// ErrInvalidRole is returned when a role change names an unknown role
+	ErrInvalidRole = errors.New("invalid role")
+
+	// ErrOwnRoleChange is returned when an administrator tries to change their own role
+	ErrOwnRoleChange = errors.New("administrators cannot change their own role")
+
+	// ErrExternalEmailRequired is returned when an identity provider does not supply an email address
+	ErrExternalEmailRequired = errors.New("identity provider did not supply an email address")
+
+	// ErrAccountSuspended is returned when a suspended user attempts to sign in
+	ErrAccountSuspended = auth.ErrAccountSuspended
+
+	// ErrMessageNotFound is returned for messages that do not exist
+	ErrMessageNotFound = errors.New("message not found")
+
+	// ErrInvalidMessageFormat is returned for message formats other than plain and markdown
+	ErrInvalidMessageFormat = errors.New("invalid message format, expected plain or markdown")
+
+	// ErrNotMessageSender is returned when a user without moderation rights deletes someone else's message
+	ErrNotMessageSender = errors.New("only the sender or a moderator can delete this message")
+)
+
+// LoginLockedError is returned when too many failed logins have temporarily locked
+// the username or the client IP
+type LoginLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginLockedError) Error() string {
+	return "too many failed login attempts, try again later"
+}
+
 // ChatService handles business logic for chat operations
 type ChatService struct {
 	messageStore storage.MessageStore
 	userStore    storage.UserStore
 	roomStore    storage.RoomStore
+	tokenStore   storage.TokenStore
+	identities   storage.IdentityStore
+	apiKeys      storage.APIKeyStore
+	blocks       storage.BlockStore
+	moderation   storage.ModerationStore
+	pipeline     *moderation.Pipeline
+	reports      storage.ReportStore
+	attachments  storage.AttachmentStore
+	blobs        storage.BlobStore
+	previews     storage.PreviewStore
+	mentions     storage.MentionStore
+	presence     Presence
+	unfurler     *unfurl.Fetcher
 	authService  *auth.AuthService
+	loginGuard   *auth.LoginGuard
+	auditLog     *audit.Logger
+	mailer       mailer.Mailer
+	baseURL      string
+
+	// requireVerifiedEmail blocks unverified users from sending messages
+	requireVerifiedEmail bool
+
+	// postingLocks serialize the slow-mode check and insert of room messages, striped by
+	// room and sender
+	postingLocks [postingLockStripes]sync.Mutex
+
+	// attachmentPolicy limits uploads and signs download links
+	attachmentPolicy AttachmentPolicy
+
+	// previewPolicy limits link previews; previewSlots holds a token per running unfurl and
+	// notifyUpdate delivers messages whose previews became available
+	previewPolicy PreviewPolicy
+	previewSlots  chan struct{}
+	notifyUpdate  func(*models.Message)
+
+	// notifyMention delivers mention events to the mentioned users
+	notifyMention func(userIDs []string, event map[string]interface{})
+
+	// pins holds pinned room messages, at most maxPins per room; notifyPin delivers pin
+	// events to room members
+	pins      storage.PinStore
+	maxPins   int
+	notifyPin func(userIDs []string, event map[string]interface{})
+
+	// saved holds the messages users bookmarked
+	saved storage.SavedMessageStore
+
+	// schedules holds scheduled messages and reminders; notifyReminder delivers due reminders
+	schedules      storage.ScheduleStore
+	notifyReminder func(userIDs []string, event map[string]interface{})
+
+	// polls holds room polls and their votes; notifyPoll delivers updated tallies to room members
+	polls      storage.PollStore
+	notifyPoll func(userIDs []string, event map[string]interface{})
+}
+
+// Option configures optional ChatService dependencies
+type Option func(*ChatService)
+
+// WithTokenStore sets the store used for single-use tokens such as password resets
+func WithTokenStore(store storage.TokenStore) Option {
+	return func(s *ChatService) {
+		s.tokenStore = store
+	}
+}
+
+// WithIdentityStore enables login through external identity providers
+func WithIdentityStore(store storage.IdentityStore) Option {
+	return func(s *ChatService) {
+		s.identities = store
+	}
+}
+
+// WithAPIKeyStore enables bot accounts and API key management
+func WithAPIKeyStore(store storage.APIKeyStore) Option {
+	return func(s *ChatService) {
+		s.apiKeys = store
+	}
+}
+
+// WithBlockStore enables user block lists
+func WithBlockStore(store storage.BlockStore) Option {
+	return func(s *ChatService) {
+		s.blocks = store
+	}
+}
+
+// WithModeration runs messages through a moderation pipeline before they are stored and
+// keeps held messages and per-room filter settings in store
+func WithModeration(pipeline *moderation.Pipeline, store storage.ModerationStore) Option {
+	return func(s *ChatService) {
+		s.pipeline = pipeline
+		s.moderation = store
+	}
+}
+
+// WithReportStore enables user reports and the moderator report queue
+func WithReportStore(store storage.ReportStore) Option {
+	return func(s *ChatService) {
+		s.reports = store
+	}
+}
+
+// WithMailer sets the mailer used for account emails and the public base URL used in their links
+func WithMailer(m mailer.Mailer, baseURL string) Option {
+	return func(s *ChatService) {
+		s.mailer = m
+		s.baseURL = strings.TrimRight(baseURL, "/")
+	}
+}
+
+// WithVerifiedEmailRequired blocks users from sending messages until their email is verified
+func WithVerifiedEmailRequired(required bool) Option {
+	return func(s *ChatService) {
+		s.requireVerifiedEmail = required
+	}
+}
+
+// WithLoginGuard enables brute-force protection for password logins
+func WithLoginGuard(guard *auth.LoginGuard) Option {
+	return func(s *ChatService) {
+		s.loginGuard = guard
+	}
+}
+
+// WithAuditLogger records security-relevant events to the audit trail
+func WithAuditLogger(logger *audit.Logger) Option {
+	return func(s *ChatService) {
+		s.auditLog = logger
+	}
 }
 
 // NewChatService creates a new chat service with injected dependencies
-func NewChatService(messageStore storage.MessageStore, userStore storage.UserStore, roomStore storage.RoomStore, authService *auth.AuthService) *ChatService {
-	return &ChatService{
+func NewChatService(messageStore storage.MessageStore, userStore storage.UserStore, roomStore storage.RoomStore, authService *auth.AuthService, opts ...Option) *ChatService {
+	s := &ChatService{
 		messageStore: messageStore,
 		userStore:    userStore,
 		roomStore:    roomStore,
 		authService:  authService,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // SendMessage handles sending a message
 func (s *ChatService) SendMessage(req models.MessageRequest) (*models.Message, error) {
+	format, err := messageFormat(req.Format)
+	if err != nil {
+		return nil, err
+	}
+	req.Format = format
+
+	if s.requireVerifiedEmail {
+		sender, err := s.userStore.GetUserByUsername(req.Sender)
+		if err != nil {
+			return nil, err
+		}
+		// Bots have no email address to verify
+		if sender == nil || (!sender.Verified && !sender.IsBot) {
+			return nil, ErrEmailNotVerified
+		}
+	}
+
+	// Room messages must respect the room's read-only mode, mutes and slow mode. The check
+	// holds the sender's posting lock until the message is stored.
+	if req.RoomID != "" {
+		unlock := s.lockRoomPosting(req.RoomID, req.Sender)
+		defer unlock()
+
+		if err := s.checkRoomPosting(req.RoomID, req.Sender); err != nil {
+			return nil, err
+		}
+	} else if req.Recipient != "" {
+		if err := s.checkDirectMessage(req.Sender, req.Recipient); err != nil {
+			return nil, err
+		}
+	}
+
+	ttl, err := s.messageTTL(req)
+	if err != nil {
+		return nil, err
+	}
+	req.TTLSeconds = ttl
+
+	uploaderID, err := s.checkMessageAttachments(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Content filters may mask the message, hold it for review or reject it
+	content, err := s.moderate(req)
+	if err != nil {
+		return nil, err
+	}
+
 	// Generate unique ID for the message
 	id, err := generateID()
 	if err != nil {
@@ -46,32 +301,128 @@ func (s *ChatService) SendMessage(req models.MessageRequest) (*models.Message, e
 		ID:        id,
 		Sender:    req.Sender,
 		Recipient: recipient,
-		Content:   req.Content,
+		Content:   content,
+		Format:    req.Format,
+		HTML:      renderContent(req.Format, content),
 		RoomID:    req.RoomID,
 		Timestamp: time.Now(),
 	}
+	message.ExpiresAt = messageExpiry(message.Timestamp, req.TTLSeconds)
 
 	err = s.messageStore.AddMessage(message)
 	if err != nil {
 		return nil, err
 	}
 
+	if len(req.AttachmentIDs) > 0 {
+		if err := s.attachments.AttachToMessage(message.ID, uploaderID, req.AttachmentIDs); err != nil {
+			// Another message claimed an attachment since it was checked
+			if deleteErr := s.messageStore.DeleteMessage(message.ID); deleteErr != nil {
+				log.Printf("Failed to remove message %s after attaching failed: %v", message.ID, deleteErr)
+			}
+			return nil, fmt.Errorf("%w: %v", ErrInvalidAttachments, err)
+		}
+		messages, err := s.populateAttachments([]models.Message{message})
+		if err != nil {
+			return nil, err
+		}
+		message = messages[0]
+	}
+
+	s.recordMentions(message)
+	s.schedulePreviews(message)
+
 	return &message, nil
 }
 
 // GetMessages retrieves all messages
 func (s *ChatService) GetMessages() ([]models.Message, error) {
-	return s.messageStore.GetMessages()
+	messages, err := s.messageStore.GetMessages()
+	if err != nil {
+		return nil, err
+	}
+	return s.populateMessages(messages)
 }
 
-// GetMessagesByRoom retrieves messages for a specific room
-func (s *ChatService) GetMessagesByRoom(roomID string) ([]models.Message, error) {
-	return s.messageStore.GetMessagesByRoom(roomID)
+// GetMessagesByRoom retrieves messages for a specific room as seen by viewerID, without
+// the messages of users the viewer has blocked
+func (s *ChatService) GetMessagesByRoom(viewerID, roomID string) ([]models.Message, error) {
+	messages, err := s.messageStore.GetMessagesByRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+	messages, err = s.filterBlocked(viewerID, messages)
+	if err != nil {
+		return nil, err
+	}
+	return s.populateMessages(messages)
 }
 
-// GetMessagesBetweenUsers retrieves messages between two users
-func (s *ChatService) GetMessagesBetweenUsers(user1, user2 string) ([]models.Message, error) {
-	return s.messageStore.GetMessagesBetweenUsers(user1, user2)
+// GetMessagesBetweenUsers retrieves messages between two users as seen by viewerID,
+// without the messages of users the viewer has blocked
+func (s *ChatService) GetMessagesBetweenUsers(viewerID, user1, user2 string) ([]models.Message, error) {
+	messages, err := s.messageStore.GetMessagesBetweenUsers(user1, user2)
+	if err != nil {
+		return nil, err
+	}
+	messages, err = s.filterBlocked(viewerID, messages)
+	if err != nil {
+		return nil, err
+	}
+	return s.populateMessages(messages)
+}
+
+// DeleteMessage deletes a message on behalf of actorID. Users may delete their own
+// messages; moderators may delete any message.
+func (s *ChatService) DeleteMessage(actorID, messageID string, moderator bool, ip string) error {
+	message, err := s.messageStore.GetMessage(messageID)
+	if err != nil {
+		return err
+	}
+	if message == nil {
+		return ErrMessageNotFound
+	}
+
+	actor, err := s.userStore.GetUser(actorID)
+	if err != nil {
+		return err
+	}
+	if actor == nil {
+		return errors.New("user not found")
+	}
+	if message.Sender != actor.Username && !moderator {
+		return ErrNotMessageSender
+	}
+
+	// Look up the attachments first: their records go with the message
+	var attachments []models.Attachment
+	if s.attachments != nil {
+		byMessage, err := s.attachments.GetMessageAttachments([]string{messageID})
+		if err != nil {
+			return err
+		}
+		attachments = byMessage[messageID]
+	}
+
+	if err := s.messageStore.DeleteMessage(messageID); err != nil {
+		return err
+	}
+	for _, attachment := range attachments {
+		s.deleteAttachmentBlobs(attachment)
+	}
+
+	metadata := map[string]string{"sender": message.Sender}
+	if message.RoomID != "" {
+		metadata["room_id"] = message.RoomID
+	}
+	s.auditLog.Record(models.AuditEvent{
+		Action:   audit.ActionMessageDeleted,
+		ActorID:  actorID,
+		TargetID: messageID,
+		IP:       ip,
+		Metadata: metadata,
+	})
+	return nil
 }
 
 // CreateUser creates a new user
@@ -99,6 +450,10 @@ func (s *ChatService) CreateUser(username, email string) (*models.User, error) {
 
 // RegisterUser creates a new user with authentication
 func (s *ChatService) RegisterUser(req models.RegisterRequest) (*models.User, error) {
+	if err := validateEmail(req.Email); err != nil {
+		return nil, err
+	}
+
 	// Check if username already exists
 	if existingUser, err := s.userStore.GetUserByUsername(req.Username); err != nil {
 		return nil, err
@@ -131,6 +486,7 @@ func (s *ChatService) RegisterUser(req models.RegisterRequest) (*models.User, er
 		Email:        req.Email,
 		PasswordHash: hashedPassword,
 		IsOnline:     false,
+		Role:         models.RoleUser,
 		CreatedAt:    time.Now(),
 	}
 
@@ -139,24 +495,122 @@ func (s *ChatService) RegisterUser(req models.RegisterRequest) (*models.User, er
 		return nil, err
 	}
 
+	// Registration succeeds even if the email cannot be delivered; it can be resent later
+	if err := s.SendVerificationEmail(user.ID); err != nil {
+		log.Printf("Failed to send verification email to user %s: %v", user.ID, err)
+	}
+
 	return &user, nil
 }
 
+// SendVerificationEmail emails a single-use verification link to a user who has not verified yet
+func (s *ChatService) SendVerificationEmail(userID string) error {
+	if s.tokenStore == nil || s.mailer == nil {
+		return nil
+	}
+
+	user, err := s.userStore.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return errors.New("user not found")
+	}
+	if user.Verified {
+		return nil
+	}
+
+	token, err := s.issueUserToken(user.ID, models.TokenPurposeEmailVerification, emailVerificationExpiry)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\n"+
+		"Please confirm your email address by opening the link below within %d hours:\n\n"+
+		"%s/api/auth/verify?token=%s\n",
+		user.Username, int(emailVerificationExpiry.Hours()), s.baseURL, token)
+
+	return s.mailer.Send(mailer.Message{To: user.Email, Subject: "Verify your email address", Body: body})
+}
+
+// VerifyEmail marks the owner of a verification token as verified
+func (s *ChatService) VerifyEmail(tokenString string) (*models.User, error) {
+	if s.tokenStore == nil {
+		return nil, errors.New("email verification is not configured")
+	}
+
+	token, err := s.tokenStore.ConsumeUserToken(auth.HashOpaqueToken(tokenString), models.TokenPurposeEmailVerification)
+	if err != nil {
+		return nil, err
+	}
+	if token == nil {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	if err := s.userStore.SetUserVerified(token.UserID, true); err != nil {
+		return nil, err
+	}
+
+	return s.userStore.GetUser(token.UserID)
+}
+
 // AuthenticateUser authenticates a user and returns a token
 func (s *ChatService) AuthenticateUser(req models.AuthRequest) (*models.AuthResponse, error) {
+	// Reject attempts while the username or client IP is locked out
+	if s.loginGuard != nil {
+		retryAfter, err := s.loginGuard.Check(req.Username, req.ClientIP)
+		if err != nil {
+			return nil, err
+		}
+		if retryAfter > 0 {
+			return nil, &LoginLockedError{RetryAfter: retryAfter}
+		}
+	}
+
 	// Find user by username
 	user, err := s.userStore.GetUserByUsername(req.Username)
 	if err != nil {
-		return nil, errors.New("invalid credentials")
+		return nil, s.loginFailed(req, "")
 	}
 	if user == nil {
-		return nil, errors.New("invalid credentials")
+		return nil, s.loginFailed(req, "")
 	}
 
 	// Verify password
 	err = s.authService.VerifyPassword(user.PasswordHash, req.Password)
 	if err != nil {
-		return nil, errors.New("invalid credentials")
+		return nil, s.loginFailed(req, user.ID)
+	}
+
+	// Suspended users are told so only once they have proven they own the account
+	if user.IsSuspended() {
+		s.auditLog.Record(models.AuditEvent{
+			Action:   audit.ActionLoginFailed,
+			TargetID: user.ID,
+			IP:       req.ClientIP,
+			Metadata: map[string]string{"username": req.Username, "reason": "suspended"},
+		})
+		return nil, ErrAccountSuspended
+	}
+
+	if s.loginGuard != nil {
+		if err := s.loginGuard.RecordSuccess(req.Username); err != nil {
+			log.Printf("Failed to reset login failures for %s: %v", req.Username, err)
+		}
+	}
+	s.auditLog.Record(models.AuditEvent{
+		Action:  audit.ActionLoginSucceeded,
+		ActorID: user.ID,
+		IP:      req.ClientIP,
+	})
+
+	return s.startSession(user)
+}
+
+// startSession issues a token for an authenticated user and marks them online
+func (s *ChatService) startSession(user *models.User) (*models.AuthResponse, error) {
+	if user.IsSuspended() {
+		return nil, ErrAccountSuspended
 	}
 
 	// Generate token
@@ -184,8 +638,282 @@ func (s *ChatService) AuthenticateUser(req models.AuthRequest) (*models.AuthResp
 	}, nil
 }
 
+// AuthenticateExternal signs in a user asserted by an external identity provider.
+// A previously linked account is used if one exists. Otherwise a local account with the
+// same email is linked when both the provider and the local account have verified it,
+// and if no account uses the email a new one is provisioned.
+func (s *ChatService) AuthenticateExternal(profile models.ExternalProfile) (*models.AuthResponse, error) {
+	if s.identities == nil {
+		return nil, errors.New("external login is not configured")
+	}
+
+	identity, err := s.identities.GetUserIdentity(profile.Provider, profile.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	var user *models.User
+	if identity != nil {
+		user, err = s.userStore.GetUser(identity.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil {
+			return nil, errors.New("user not found")
+		}
+	} else {
+		user, err = s.linkExternalIdentity(profile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.auditLog.Record(models.AuditEvent{
+		Action:   audit.ActionLoginSucceeded,
+		ActorID:  user.ID,
+		IP:       profile.ClientIP,
+		Metadata: map[string]string{"method": "oidc", "provider": profile.Provider},
+	})
+
+	return s.startSession(user)
+}
+
+// linkExternalIdentity links a new external identity to an existing account with the
+// same verified email, or provisions a new account for it
+func (s *ChatService) linkExternalIdentity(profile models.ExternalProfile) (*models.User, error) {
+	if profile.Email == "" {
+		return nil, ErrExternalEmailRequired
+	}
+
+	user, err := s.userStore.GetUserByEmail(profile.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	action := audit.ActionIdentityLinked
+	if user != nil {
+		// Linking on an unverified address would let anyone who controls either side
+		// of the match take over the other account
+		if !profile.EmailVerified || !user.Verified {
+			return nil, ErrIdentityConflict
+		}
+	} else {
+		user, err = s.provisionExternalUser(profile)
+		if err != nil {
+			return nil, err
+		}
+		action = audit.ActionUserProvisioned
+	}
+
+	err = s.identities.AddUserIdentity(models.UserIdentity{
+		Provider:  profile.Provider,
+		Subject:   profile.Subject,
+		UserID:    user.ID,
+		Email:     profile.Email,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.auditLog.Record(models.AuditEvent{
+		Action:   action,
+		ActorID:  user.ID,
+		IP:       profile.ClientIP,
+		Metadata: map[string]string{"provider": profile.Provider, "subject": profile.Subject},
+	})
+	return user, nil
+}
+
+// provisionExternalUser creates an account without a password for an external identity
+func (s *ChatService) provisionExternalUser(profile models.ExternalProfile) (*models.User, error) {
+	if err := validateEmail(profile.Email); err != nil {
+		return nil, err
+	}
+
+	username, err := s.availableUsername(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := generateID()
+	if err != nil {
+		return nil, err
+	}
+
+	user := models.User{
+		ID:        id,
+		Username:  username,
+		Email:     profile.Email,
+		Verified:  profile.EmailVerified,
+		Role:      models.RoleUser,
+		CreatedAt: time.Now(),
+	}
+
+	if err := s.userStore.AddUser(user); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// availableUsername derives an unused username from the provider's preferred username
+// or the local part of the email address
+func (s *ChatService) availableUsername(profile models.ExternalProfile) (string, error) {
+	base := sanitizeUsername(profile.Username)
+	if base == "" {
+		base = sanitizeUsername(strings.SplitN(profile.Email, "@", 2)[0])
+	}
+	if base == "" {
+		base = "user"
+	}
+
+	candidate := base
+	for attempt := 0; attempt < 5; attempt++ {
+		existing, err := s.userStore.GetUserByUsername(candidate)
+		if err != nil {
+			return "", err
+		}
+		if existing == nil {
+			return candidate, nil
+		}
+
+		suffix, err := generateID()
+		if err != nil {
+			return "", err
+		}
+		candidate = base + "_" + suffix[:6]
+	}
+
+	return "", errors.New("failed to find an available username")
+}
+
+// loginFailed records a failed login attempt and returns the error to report to the caller
+func (s *ChatService) loginFailed(req models.AuthRequest, userID string) error {
+	s.auditLog.Record(models.AuditEvent{
+		Action:   audit.ActionLoginFailed,
+		TargetID: userID,
+		IP:       req.ClientIP,
+		Metadata: map[string]string{"username": req.Username},
+	})
+
+	if s.loginGuard == nil {
+		return errors.New("invalid credentials")
+	}
+
+	lockout, err := s.loginGuard.RecordFailure(req.Username, req.ClientIP)
+	if err != nil {
+		log.Printf("Failed to record login failure for %s: %v", req.Username, err)
+		return errors.New("invalid credentials")
+	}
+	if lockout > 0 {
+		s.auditLog.Record(models.AuditEvent{
+			Action:   audit.ActionLoginLocked,
+			TargetID: userID,
+			IP:       req.ClientIP,
+			Metadata: map[string]string{"username": req.Username, "lockout": lockout.String()},
+		})
+		return &LoginLockedError{RetryAfter: lockout}
+	}
+
+	return errors.New("invalid credentials")
+}
+
+// UnlockUser clears the login lockout of a user on behalf of an administrator
+func (s *ChatService) UnlockUser(actorID, userID, ip string) error {
+	if s.loginGuard == nil {
+		return errors.New("login lockout is not enabled")
+	}
+
+	user, err := s.userStore.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return errors.New("user not found")
+	}
+
+	if err := s.loginGuard.Unlock(user.Username); err != nil {
+		return err
+	}
+
+	s.auditLog.Record(models.AuditEvent{
+		Action:   audit.ActionAccountUnlocked,
+		ActorID:  actorID,
+		TargetID: user.ID,
+		IP:       ip,
+	})
+	return nil
+}
+
+// SetUserRole changes the global role of a user on behalf of an administrator.
+// The user's sessions are revoked so that tokens carrying the old scopes stop working.
+func (s *ChatService) SetUserRole(actorID, userID, role, ip string) error {
+	if !auth.IsValidRole(role) {
+		return ErrInvalidRole
+	}
+	if actorID == userID {
+		return ErrOwnRoleChange
+	}
+
+	user, err := s.userStore.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return errors.New("user not found")
+	}
+	if user.IsBot && role != models.RoleUser {
+		return errors.New("bots cannot be given elevated roles")
+	}
+	if user.Role == role {
+		return nil
+	}
+
+	if err := s.userStore.SetUserRole(userID, role); err != nil {
+		return err
+	}
+	if err := s.authService.RevokeUserSessions(userID, ""); err != nil {
+		return err
+	}
+
+	s.auditLog.Record(models.AuditEvent{
+		Action:   audit.ActionRoleChanged,
+		ActorID:  actorID,
+		TargetID: userID,
+		IP:       ip,
+		Metadata: map[string]string{"from": user.Role, "to": role},
+	})
+	return nil
+}
+
+// BootstrapAdmins grants the admin role to the given users, so that a fresh
+// deployment has administrators who can manage roles through the API
+func (s *ChatService) BootstrapAdmins(userIDs []string) error {
+	for _, userID := range userIDs {
+		user, err := s.userStore.GetUser(userID)
+		if err != nil || user == nil {
+			log.Printf("Skipping unknown bootstrap administrator %s", userID)
+			continue
+		}
+		if user.Role == models.RoleAdmin {
+			continue
+		}
+
+		if err := s.userStore.SetUserRole(userID, models.RoleAdmin); err != nil {
+			return err
+		}
+		s.auditLog.Record(models.AuditEvent{
+			Action:   audit.ActionRoleChanged,
+			TargetID: userID,
+			Metadata: map[string]string{"from": user.Role, "to": models.RoleAdmin, "source": "ADMIN_USER_IDS"},
+		})
+	}
+	return nil
+}
+
 // RefreshToken refreshes a user's authentication token
-func (s *ChatService) RefreshToken(tokenString string) (*models.AuthResponse, error) {
+func (s *ChatService) RefreshToken(tokenString, ip string) (*models.AuthResponse, error) {
 	newToken, expiresAt, err := s.authService.RefreshToken(tokenString)
 	if err != nil {
 		return nil, err
@@ -202,6 +930,12 @@ func (s *ChatService) RefreshToken(tokenString string) (*models.AuthResponse, er
 		return nil, err
 	}
 
+	s.auditLog.Record(models.AuditEvent{
+		Action:  audit.ActionTokenRefreshed,
+		ActorID: user.ID,
+		IP:      ip,
+	})
+
 	return &models.AuthResponse{
 		Token:     newToken,
 		User:      *user,
@@ -209,9 +943,148 @@ func (s *ChatService) RefreshToken(tokenString string) (*models.AuthResponse, er
 	}, nil
 }
 
-// LogoutUser logs out a user by updating their online status
-func (s *ChatService) LogoutUser(userID string) error {
-	return s.userStore.UpdateUserStatus(userID, false)
+// AuthenticateToken validates a JWT or API key presented outside of an HTTP request,
+// such as in the "auth" frame of a WebSocket connection
+func (s *ChatService) AuthenticateToken(token string) (*models.Claims, error) {
+	return s.authService.Authenticate(token)
+}
+
+// IssueWebSocketTicket issues a single-use ticket for opening a WebSocket connection as the holder of claims
+func (s *ChatService) IssueWebSocketTicket(claims *models.Claims) (string, time.Time, error) {
+	return s.authService.IssueTicket(claims)
+}
+
+// RedeemWebSocketTicket consumes a WebSocket ticket and returns the claims it was issued for
+func (s *ChatService) RedeemWebSocketTicket(ticket string) (*models.Claims, error) {
+	return s.authService.RedeemTicket(ticket)
+}
+
+// LogoutUser logs out a user by updating their online status and revoking the session
+// they logged out of (sessionID may be empty for API keys)
+func (s *ChatService) LogoutUser(userID, sessionID string) error {
+	if err := s.userStore.UpdateUserStatus(userID, false); err != nil {
+		return err
+	}
+	return s.authService.RevokeSession(sessionID)
+}
+
+// ChangePassword changes a user's password after verifying the current one.
+// All other sessions of the user are revoked; currentSessionID stays valid.
+func (s *ChatService) ChangePassword(userID, currentSessionID string, req models.ChangePasswordRequest) error {
+	user, err := s.userStore.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return errors.New("user not found")
+	}
+
+	if err := s.authService.VerifyPassword(user.PasswordHash, req.CurrentPassword); err != nil {
+		return ErrInvalidCurrentPassword
+	}
+
+	if err := s.setPassword(user.ID, req.NewPassword); err != nil {
+		return err
+	}
+
+	return s.authService.RevokeUserSessions(user.ID, currentSessionID)
+}
+
+// RequestPasswordReset emails a single-use reset token to the owner of the given address.
+// Unknown addresses are ignored so callers cannot probe which emails are registered.
+func (s *ChatService) RequestPasswordReset(email string) error {
+	if s.tokenStore == nil || s.mailer == nil {
+		return errors.New("password reset is not configured")
+	}
+
+	user, err := s.userStore.GetUserByEmail(email)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return nil
+	}
+
+	return s.sendPasswordResetEmail(user,
+		"We received a request to reset your password.",
+		"If you did not request a password reset, you can ignore this email.")
+}
+
+// sendPasswordResetEmail issues a password reset token and emails its link to the user
+func (s *ChatService) sendPasswordResetEmail(user *models.User, intro, outro string) error {
+	token, err := s.issueUserToken(user.ID, models.TokenPurposePasswordReset, passwordResetExpiry)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\n"+
+		"%s Use the link below within %d minutes:\n\n"+
+		"%s/reset-password?token=%s\n\n"+
+		"%s\n",
+		user.Username, intro, int(passwordResetExpiry.Minutes()), s.baseURL, token, outro)
+
+	if err := s.mailer.Send(mailer.Message{To: user.Email, Subject: "Reset your password", Body: body}); err != nil {
+		log.Printf("Failed to send password reset email to user %s: %v", user.ID, err)
+		return err
+	}
+
+	return nil
+}
+
+// ResetPassword sets a new password using a reset token and revokes all sessions of the
+// user, whose ID is returned so their live connections can be closed
+func (s *ChatService) ResetPassword(req models.ResetPasswordRequest) (string, error) {
+	if s.tokenStore == nil {
+		return "", errors.New("password reset is not configured")
+	}
+
+	token, err := s.tokenStore.ConsumeUserToken(auth.HashOpaqueToken(req.Token), models.TokenPurposePasswordReset)
+	if err != nil {
+		return "", err
+	}
+	if token == nil {
+		return "", ErrInvalidResetToken
+	}
+
+	if err := s.setPassword(token.UserID, req.NewPassword); err != nil {
+		return "", err
+	}
+
+	if err := s.authService.RevokeUserSessions(token.UserID, ""); err != nil {
+		return "", err
+	}
+	return token.UserID, nil
+}
+
+// issueUserToken stores the hash of a new single-use token and returns the raw token
+func (s *ChatService) issueUserToken(userID, purpose string, expiry time.Duration) (string, error) {
+	token, tokenHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	err = s.tokenStore.AddUserToken(models.UserToken{
+		TokenHash: tokenHash,
+		UserID:    userID,
+		Purpose:   purpose,
+		CreatedAt: now,
+		ExpiresAt: now.Add(expiry),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// setPassword hashes and stores a new password for a user
+func (s *ChatService) setPassword(userID, password string) error {
+	hashedPassword, err := s.authService.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return s.userStore.UpdateUserPassword(userID, hashedPassword)
 }
 
 // GetUser retrieves a user by ID
@@ -267,14 +1140,96 @@ func (s *ChatService) GetRoomsByUser(userID string) ([]models.ChatRoom, error) {
 	return s.roomStore.GetRoomsByUser(userID)
 }
 
-// AddUserToRoom adds a user to a room
-func (s *ChatService) AddUserToRoom(roomID, userID string) error {
-	return s.roomStore.AddUserToRoom(roomID, userID)
+// AddUserToRoom adds a user to a room on behalf of actorID
+func (s *ChatService) AddUserToRoom(actorID, roomID, userID, ip string) error {
+	// Users cannot be added to rooms by someone they have blocked
+	blocked, err := s.hasBlocked(userID, actorID)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return ErrBlocked
+	}
+
+	if err := s.roomStore.AddUserToRoom(roomID, userID); err != nil {
+		return err
+	}
+
+	s.auditLog.Record(models.AuditEvent{
+		Action:   audit.ActionRoomMemberAdded,
+		ActorID:  actorID,
+		TargetID: userID,
+		IP:       ip,
+		Metadata: map[string]string{"room_id": roomID},
+	})
+	return nil
+}
+
+// RemoveUserFromRoom removes a user from a room on behalf of actorID
+func (s *ChatService) RemoveUserFromRoom(actorID, roomID, userID, ip string) error {
+	if err := s.roomStore.RemoveUserFromRoom(roomID, userID); err != nil {
+		return err
+	}
+
+	s.auditLog.Record(models.AuditEvent{
+		Action:   audit.ActionRoomMemberRemoved,
+		ActorID:  actorID,
+		TargetID: userID,
+		IP:       ip,
+		Metadata: map[string]string{"room_id": roomID},
+	})
+	return nil
+}
+
+// messageFormat validates a requested message format, defaulting to plain
+func messageFormat(format string) (string, error) {
+	switch format {
+	case "", models.MessageFormatPlain:
+		return models.MessageFormatPlain, nil
+	case models.MessageFormatMarkdown:
+		return format, nil
+	default:
+		return "", ErrInvalidMessageFormat
+	}
 }
 
-// RemoveUserFromRoom removes a user from a room
-func (s *ChatService) RemoveUserFromRoom(roomID, userID string) error {
-	return s.roomStore.RemoveUserFromRoom(roomID, userID)
+// renderContent returns the sanitized HTML of message content, or "" for plain text.
+// Rendering happens once, after moderation, so every client shows the same markup.
+func renderContent(format, content string) string {
+	if format != models.MessageFormatMarkdown {
+		return ""
+	}
+	return markdown.Render(content)
+}
+
+// validateEmail checks that email is a bare address (no display name) with a dotted domain
+func validateEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" || addr.Address != email {
+		return ErrInvalidEmail
+	}
+
+	domain := email[strings.LastIndex(email, "@")+1:]
+	if !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return ErrInvalidEmail
+	}
+
+	return nil
+}
+
+// sanitizeUsername keeps letters, digits, dots, dashes and underscores and limits the length
+func sanitizeUsername(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if b.Len() >= maxExternalUsernameLength {
+			break
+		}
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
 }
 
 // generateID generates a random hex ID
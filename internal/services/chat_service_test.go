@@ -1,9 +1,27 @@
 package services
 
 import (
+	"bytes"
+	"errors"
+	"go-chat-api/internal/audit"
 	"go-chat-api/internal/auth"
+	"go-chat-api/internal/mailer"
 	"go-chat-api/internal/models"
+	"go-chat-api/internal/moderation"
 	"go-chat-api/internal/storage"
+	"go-chat-api/internal/unfurl"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -260,7 +278,7 @@ func TestChatService_RefreshToken(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			refreshResp, err := service.RefreshToken(tt.token)
+			refreshResp, err := service.RefreshToken(tt.token, "")
 
 			if tt.wantErr {
 				if err == nil {
@@ -312,7 +330,7 @@ func TestChatService_LogoutUser(t *testing.T) {
 	}
 
 	// Test logout
-	err = service.LogoutUser(user.ID)
+	err = service.LogoutUser(user.ID, "")
 	if err != nil {
 		t.Errorf("LogoutUser() unexpected error = %v", err)
 	}
@@ -328,7 +346,7 @@ func TestChatService_LogoutUser(t *testing.T) {
 	}
 
 	// Test logout with invalid user ID
-	err = service.LogoutUser("nonexistent-id")
+	err = service.LogoutUser("nonexistent-id", "")
 	if err == nil {
 		t.Error("LogoutUser() should return error for nonexistent user")
 	}
@@ -445,7 +463,7 @@ func TestChatService_Integration_FullAuthFlow(t *testing.T) {
 	}
 
 	// 5. Logout user
-	err = service.LogoutUser(user.ID)
+	err = service.LogoutUser(user.ID, "")
 	if err != nil {
 		t.Fatalf("Integration test failed at logout: %v", err)
 	}
@@ -460,3 +478,2550 @@ func TestChatService_Integration_FullAuthFlow(t *testing.T) {
 		t.Error("User should be offline after logout")
 	}
 }
+
+func setupTestChatServiceWithMailer() (*ChatService, *auth.AuthService, *mailer.LogMailer) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour, auth.WithSessionStore(store))
+	mail := mailer.NewLogMailer(io.Discard)
+
+	service := NewChatService(store, store, store, authService,
+		WithTokenStore(store),
+		WithMailer(mail, "http://localhost:8080"),
+	)
+	return service, authService, mail
+}
+
+var mailTokenPattern = regexp.MustCompile(`token=([0-9a-f]+)`)
+
+func TestChatService_ChangePassword(t *testing.T) {
+	service, authService, _ := setupTestChatServiceWithMailer()
+
+	user, err := service.RegisterUser(models.RegisterRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	})
+	if err != nil {
+		t.Fatalf("Failed to register test user: %v", err)
+	}
+
+	current, err := service.AuthenticateUser(models.AuthRequest{Username: "testuser", Password: "password123"})
+	if err != nil {
+		t.Fatalf("Failed to authenticate test user: %v", err)
+	}
+	other, err := service.AuthenticateUser(models.AuthRequest{Username: "testuser", Password: "password123"})
+	if err != nil {
+		t.Fatalf("Failed to authenticate test user: %v", err)
+	}
+
+	claims, err := authService.ValidateToken(current.Token)
+	if err != nil {
+		t.Fatalf("Failed to validate token: %v", err)
+	}
+
+	err = service.ChangePassword(user.ID, claims.ID, models.ChangePasswordRequest{
+		CurrentPassword: "wrongpassword",
+		NewPassword:     "newpassword456",
+	})
+	if !errors.Is(err, ErrInvalidCurrentPassword) {
+		t.Errorf("ChangePassword() error = %v, want %v", err, ErrInvalidCurrentPassword)
+	}
+
+	err = service.ChangePassword(user.ID, claims.ID, models.ChangePasswordRequest{
+		CurrentPassword: "password123",
+		NewPassword:     "newpassword456",
+	})
+	if err != nil {
+		t.Fatalf("ChangePassword() unexpected error = %v", err)
+	}
+
+	if _, err := authService.ValidateToken(current.Token); err != nil {
+		t.Errorf("ChangePassword() should keep the current session, got %v", err)
+	}
+	if _, err := authService.ValidateToken(other.Token); err == nil {
+		t.Error("ChangePassword() should revoke other sessions")
+	}
+
+	if _, err := service.AuthenticateUser(models.AuthRequest{Username: "testuser", Password: "password123"}); err == nil {
+		t.Error("AuthenticateUser() should reject the old password")
+	}
+	if _, err := service.AuthenticateUser(models.AuthRequest{Username: "testuser", Password: "newpassword456"}); err != nil {
+		t.Errorf("AuthenticateUser() with new password unexpected error = %v", err)
+	}
+}
+
+func TestChatService_PasswordResetFlow(t *testing.T) {
+	service, authService, mail := setupTestChatServiceWithMailer()
+
+	_, err := service.RegisterUser(models.RegisterRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	})
+	if err != nil {
+		t.Fatalf("Failed to register test user: %v", err)
+	}
+
+	session, err := service.AuthenticateUser(models.AuthRequest{Username: "testuser", Password: "password123"})
+	if err != nil {
+		t.Fatalf("Failed to authenticate test user: %v", err)
+	}
+
+	// Registration already sent a verification email
+	sentBefore := len(mail.Sent())
+
+	// Unknown emails are silently ignored
+	if err := service.RequestPasswordReset("unknown@example.com"); err != nil {
+		t.Errorf("RequestPasswordReset() unknown email unexpected error = %v", err)
+	}
+	if len(mail.Sent()) != sentBefore {
+		t.Fatalf("RequestPasswordReset() sent %d emails for unknown address, want 0", len(mail.Sent())-sentBefore)
+	}
+
+	if err := service.RequestPasswordReset("test@example.com"); err != nil {
+		t.Fatalf("RequestPasswordReset() unexpected error = %v", err)
+	}
+
+	sent := mail.Sent()[sentBefore:]
+	if len(sent) != 1 || sent[0].To != "test@example.com" {
+		t.Fatalf("RequestPasswordReset() sent %+v, want one email to test@example.com", sent)
+	}
+	match := mailTokenPattern.FindStringSubmatch(sent[0].Body)
+	if match == nil {
+		t.Fatalf("Reset email does not contain a token: %q", sent[0].Body)
+	}
+	token := match[1]
+
+	_, err = service.ResetPassword(models.ResetPasswordRequest{Token: "bogus", NewPassword: "newpassword456"})
+	if !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("ResetPassword() bogus token error = %v, want %v", err, ErrInvalidResetToken)
+	}
+
+	userID, err := service.ResetPassword(models.ResetPasswordRequest{Token: token, NewPassword: "newpassword456"})
+	if err != nil {
+		t.Fatalf("ResetPassword() unexpected error = %v", err)
+	}
+	if userID != session.User.ID {
+		t.Errorf("ResetPassword() user ID = %q, want %q", userID, session.User.ID)
+	}
+
+	// Tokens are single-use
+	_, err = service.ResetPassword(models.ResetPasswordRequest{Token: token, NewPassword: "anotherpassword"})
+	if !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("ResetPassword() reused token error = %v, want %v", err, ErrInvalidResetToken)
+	}
+
+	if _, err := authService.ValidateToken(session.Token); err == nil {
+		t.Error("ResetPassword() should revoke existing sessions")
+	}
+	if _, err := service.AuthenticateUser(models.AuthRequest{Username: "testuser", Password: "newpassword456"}); err != nil {
+		t.Errorf("AuthenticateUser() with reset password unexpected error = %v", err)
+	}
+}
+
+func TestValidateEmail(t *testing.T) {
+	tests := []struct {
+		email   string
+		wantErr bool
+	}{
+		{email: "test@example.com", wantErr: false},
+		{email: "first.last+tag@sub.example.co.uk", wantErr: false},
+		{email: "", wantErr: true},
+		{email: "not-an-email", wantErr: true},
+		{email: "missing-domain@", wantErr: true},
+		{email: "@example.com", wantErr: true},
+		{email: "user@localhost", wantErr: true},
+		{email: "user@example.", wantErr: true},
+		{email: "Test User <test@example.com>", wantErr: true},
+		{email: " test@example.com", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.email, func(t *testing.T) {
+			err := validateEmail(tt.email)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateEmail(%q) error = %v, wantErr %v", tt.email, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestChatService_EmailVerification(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour)
+	mail := mailer.NewLogMailer(io.Discard)
+	service := NewChatService(store, store, store, authService,
+		WithTokenStore(store),
+		WithMailer(mail, "http://localhost:8080"),
+		WithVerifiedEmailRequired(true),
+	)
+
+	_, err := service.RegisterUser(models.RegisterRequest{
+		Username: "bademail",
+		Email:    "not-an-email",
+		Password: "password123",
+	})
+	if !errors.Is(err, ErrInvalidEmail) {
+		t.Errorf("RegisterUser() invalid email error = %v, want %v", err, ErrInvalidEmail)
+	}
+
+	user, err := service.RegisterUser(models.RegisterRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	})
+	if err != nil {
+		t.Fatalf("Failed to register test user: %v", err)
+	}
+	if user.Verified {
+		t.Error("RegisterUser() new users should not be verified")
+	}
+
+	_, err = service.SendMessage(models.MessageRequest{Sender: user.Username, Content: "Hello"})
+	if !errors.Is(err, ErrEmailNotVerified) {
+		t.Errorf("SendMessage() unverified error = %v, want %v", err, ErrEmailNotVerified)
+	}
+
+	sent := mail.Sent()
+	if len(sent) != 1 || !strings.Contains(sent[0].Body, "/api/auth/verify?token=") {
+		t.Fatalf("RegisterUser() sent %+v, want one verification email", sent)
+	}
+	token := mailTokenPattern.FindStringSubmatch(sent[0].Body)[1]
+
+	if _, err := service.VerifyEmail("bogus"); !errors.Is(err, ErrInvalidVerificationToken) {
+		t.Errorf("VerifyEmail() bogus token error = %v, want %v", err, ErrInvalidVerificationToken)
+	}
+
+	verified, err := service.VerifyEmail(token)
+	if err != nil {
+		t.Fatalf("VerifyEmail() unexpected error = %v", err)
+	}
+	if !verified.Verified {
+		t.Error("VerifyEmail() user should be verified")
+	}
+
+	if _, err := service.VerifyEmail(token); !errors.Is(err, ErrInvalidVerificationToken) {
+		t.Errorf("VerifyEmail() reused token error = %v, want %v", err, ErrInvalidVerificationToken)
+	}
+
+	if _, err := service.SendMessage(models.MessageRequest{Sender: user.Username, Content: "Hello"}); err != nil {
+		t.Errorf("SendMessage() verified user unexpected error = %v", err)
+	}
+
+	// Verified users are not sent further verification emails
+	if err := service.SendVerificationEmail(user.ID); err != nil {
+		t.Errorf("SendVerificationEmail() unexpected error = %v", err)
+	}
+	if len(mail.Sent()) != 1 {
+		t.Errorf("SendVerificationEmail() sent an email to an already verified user")
+	}
+}
+
+func TestChatService_LoginLockout(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour)
+	guard := auth.NewLoginGuard(store, auth.LockoutPolicy{
+		MaxAttempts:      2,
+		MaxAttemptsPerIP: 10,
+		BaseLockout:      time.Minute,
+		MaxLockout:       time.Hour,
+		ResetAfter:       time.Hour,
+	})
+	service := NewChatService(store, store, store, authService,
+		WithLoginGuard(guard),
+		WithAuditLogger(audit.NewLogger(store)),
+	)
+
+	user, err := service.RegisterUser(models.RegisterRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	})
+	if err != nil {
+		t.Fatalf("Failed to register test user: %v", err)
+	}
+
+	badReq := models.AuthRequest{Username: "testuser", Password: "wrongpassword", ClientIP: "203.0.113.7"}
+	if _, err := service.AuthenticateUser(badReq); err == nil || err.Error() != "invalid credentials" {
+		t.Errorf("AuthenticateUser() first failure error = %v, want invalid credentials", err)
+	}
+
+	var lockedErr *LoginLockedError
+	if _, err := service.AuthenticateUser(badReq); !errors.As(err, &lockedErr) {
+		t.Fatalf("AuthenticateUser() second failure error = %v, want LoginLockedError", err)
+	}
+	if lockedErr.RetryAfter != time.Minute {
+		t.Errorf("LoginLockedError.RetryAfter = %v, want %v", lockedErr.RetryAfter, time.Minute)
+	}
+
+	// The correct password is rejected while locked out
+	goodReq := models.AuthRequest{Username: "testuser", Password: "password123", ClientIP: "203.0.113.7"}
+	if _, err := service.AuthenticateUser(goodReq); !errors.As(err, &lockedErr) {
+		t.Errorf("AuthenticateUser() while locked error = %v, want LoginLockedError", err)
+	}
+
+	if err := service.UnlockUser("admin-id", user.ID, "198.51.100.1"); err != nil {
+		t.Fatalf("UnlockUser() unexpected error = %v", err)
+	}
+	if _, err := service.AuthenticateUser(goodReq); err != nil {
+		t.Errorf("AuthenticateUser() after unlock unexpected error = %v", err)
+	}
+
+	events, err := store.GetAuditEvents()
+	if err != nil {
+		t.Fatalf("GetAuditEvents() unexpected error = %v", err)
+	}
+	var actions []string
+	for _, event := range events {
+		actions = append(actions, event.Action)
+	}
+	wantActions := []string{
+		audit.ActionLoginFailed,
+		audit.ActionLoginFailed,
+		audit.ActionLoginLocked,
+		audit.ActionAccountUnlocked,
+		audit.ActionLoginSucceeded,
+	}
+	if strings.Join(actions, ",") != strings.Join(wantActions, ",") {
+		t.Errorf("audit actions = %v, want %v", actions, wantActions)
+	}
+	if events[0].IP != "203.0.113.7" {
+		t.Errorf("audit event IP = %q, want %q", events[0].IP, "203.0.113.7")
+	}
+}
+
+func TestChatService_AuthenticateExternal(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour)
+	service := NewChatService(store, store, store, authService,
+		WithIdentityStore(store),
+		WithAuditLogger(audit.NewLogger(store)),
+	)
+
+	// A local account with a verified email and one with an unverified email
+	verified, err := service.RegisterUser(models.RegisterRequest{Username: "alice", Email: "alice@example.com", Password: "password123"})
+	if err != nil {
+		t.Fatalf("Failed to register test user: %v", err)
+	}
+	if err := store.SetUserVerified(verified.ID, true); err != nil {
+		t.Fatalf("Failed to verify test user: %v", err)
+	}
+	if _, err := service.RegisterUser(models.RegisterRequest{Username: "bob", Email: "bob@example.com", Password: "password123"}); err != nil {
+		t.Fatalf("Failed to register test user: %v", err)
+	}
+
+	const issuer = "https://idp.example.com"
+	tests := []struct {
+		name         string
+		profile      models.ExternalProfile
+		wantErr      error
+		wantUserID   string
+		wantUsername string
+	}{
+		{
+			name:       "links to local account with verified email",
+			profile:    models.ExternalProfile{Provider: issuer, Subject: "sub-alice", Email: "alice@example.com", EmailVerified: true},
+			wantUserID: verified.ID,
+		},
+		{
+			name:       "uses existing link",
+			profile:    models.ExternalProfile{Provider: issuer, Subject: "sub-alice", Email: "changed@example.com"},
+			wantUserID: verified.ID,
+		},
+		{
+			name:    "refuses to link unverified provider email",
+			profile: models.ExternalProfile{Provider: issuer, Subject: "sub-mallory", Email: "alice@example.com", EmailVerified: false},
+			wantErr: ErrIdentityConflict,
+		},
+		{
+			name:    "refuses to link to unverified local account",
+			profile: models.ExternalProfile{Provider: issuer, Subject: "sub-bob", Email: "bob@example.com", EmailVerified: true},
+			wantErr: ErrIdentityConflict,
+		},
+		{
+			name:         "provisions new user with sanitized username",
+			profile:      models.ExternalProfile{Provider: issuer, Subject: "sub-carol", Email: "carol@example.com", EmailVerified: true, Username: "Carol Smith!"},
+			wantUsername: "CarolSmith",
+		},
+		{
+			name:         "adds suffix when username is taken",
+			profile:      models.ExternalProfile{Provider: issuer, Subject: "sub-alice2", Email: "alice@corp.example.com", EmailVerified: true, Username: "alice"},
+			wantUsername: "alice_",
+		},
+		{
+			name:    "requires email",
+			profile: models.ExternalProfile{Provider: issuer, Subject: "sub-noemail"},
+			wantErr: ErrExternalEmailRequired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := service.AuthenticateExternal(tt.profile)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("AuthenticateExternal() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("AuthenticateExternal() unexpected error = %v", err)
+			}
+
+			if resp.Token == "" {
+				t.Error("AuthenticateExternal() returned empty token")
+			}
+			if tt.wantUserID != "" && resp.User.ID != tt.wantUserID {
+				t.Errorf("AuthenticateExternal() user ID = %v, want %v", resp.User.ID, tt.wantUserID)
+			}
+			if tt.wantUsername != "" {
+				if !strings.HasPrefix(resp.User.Username, tt.wantUsername) {
+					t.Errorf("AuthenticateExternal() username = %v, want prefix %v", resp.User.Username, tt.wantUsername)
+				}
+				if resp.User.Verified != tt.profile.EmailVerified {
+					t.Errorf("AuthenticateExternal() verified = %v, want %v", resp.User.Verified, tt.profile.EmailVerified)
+				}
+			}
+		})
+	}
+
+	// Provisioned accounts have no password and cannot log in with one
+	if _, err := service.AuthenticateUser(models.AuthRequest{Username: "CarolSmith", Password: ""}); err == nil {
+		t.Error("AuthenticateUser() for provisioned account expected error, got nil")
+	}
+}
+
+func TestChatService_BotsAndAPIKeys(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour, auth.WithUserStore(store), auth.WithAPIKeyStore(store))
+	service := NewChatService(store, store, store, authService,
+		WithAPIKeyStore(store),
+		WithVerifiedEmailRequired(true),
+	)
+
+	owner, err := service.RegisterUser(models.RegisterRequest{Username: "owner", Email: "owner@example.com", Password: "password123"})
+	if err != nil {
+		t.Fatalf("Failed to register test user: %v", err)
+	}
+	other, err := service.RegisterUser(models.RegisterRequest{Username: "other", Email: "other@example.com", Password: "password123"})
+	if err != nil {
+		t.Fatalf("Failed to register test user: %v", err)
+	}
+
+	bot, err := service.CreateBot(owner.ID, models.CreateBotRequest{Username: "deploybot"})
+	if err != nil {
+		t.Fatalf("CreateBot() unexpected error = %v", err)
+	}
+	if !bot.IsBot || bot.OwnerID != owner.ID || bot.PasswordHash != "" {
+		t.Errorf("CreateBot() = %+v, want password-less bot owned by %s", bot, owner.ID)
+	}
+	if _, err := service.CreateBot(owner.ID, models.CreateBotRequest{Username: "deploybot"}); err == nil {
+		t.Error("CreateBot() with taken username expected error, got nil")
+	}
+
+	tests := []struct {
+		name    string
+		userID  string
+		req     models.CreateAPIKeyRequest
+		wantErr error
+	}{
+		{
+			name:   "key for own bot",
+			userID: owner.ID,
+			req:    models.CreateAPIKeyRequest{Name: "deploys", Scopes: []string{auth.ScopeMessagesWrite}, BotID: bot.ID},
+		},
+		{
+			name:    "unknown scope",
+			userID:  owner.ID,
+			req:     models.CreateAPIKeyRequest{Name: "admin", Scopes: []string{"admin"}},
+			wantErr: ErrInvalidScope,
+		},
+		{
+			name:    "expiry too long",
+			userID:  owner.ID,
+			req:     models.CreateAPIKeyRequest{Name: "forever", Scopes: []string{auth.ScopeRoomsRead}, ExpiresInDays: 1000},
+			wantErr: ErrInvalidAPIKeyExpiry,
+		},
+		{
+			name:    "key for someone else's bot",
+			userID:  other.ID,
+			req:     models.CreateAPIKeyRequest{Name: "stolen", Scopes: []string{auth.ScopeMessagesWrite}, BotID: bot.ID},
+			wantErr: ErrBotNotFound,
+		},
+	}
+
+	var botKey *models.CreateAPIKeyResponse
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := service.CreateAPIKey(tt.userID, tt.req)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("CreateAPIKey() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreateAPIKey() unexpected error = %v", err)
+			}
+			if !strings.HasPrefix(resp.Key, resp.APIKey.Prefix) || resp.APIKey.KeyHash == resp.Key {
+				t.Errorf("CreateAPIKey() key = %q, prefix = %q", resp.Key, resp.APIKey.Prefix)
+			}
+			if days := resp.APIKey.ExpiresAt.Sub(resp.APIKey.CreatedAt).Hours() / 24; days < 89 || days > 91 {
+				t.Errorf("CreateAPIKey() default expiry = %.0f days, want 90", days)
+			}
+			botKey = resp
+		})
+	}
+	if botKey == nil {
+		t.Fatal("no bot key was created")
+	}
+
+	// The bot can send messages without a verified email
+	claims, err := authService.Authenticate(botKey.Key)
+	if err != nil {
+		t.Fatalf("Authenticate() with bot key unexpected error = %v", err)
+	}
+	if _, err := service.SendMessage(models.MessageRequest{Sender: claims.Username, Content: "deployed"}); err != nil {
+		t.Errorf("SendMessage() from bot unexpected error = %v", err)
+	}
+
+	keys, err := service.ListAPIKeys(owner.ID)
+	if err != nil || len(keys) != 1 || keys[0].ID != botKey.APIKey.ID {
+		t.Errorf("ListAPIKeys() = %v, %v, want the bot key", keys, err)
+	}
+	if keys, _ := service.ListAPIKeys(other.ID); len(keys) != 0 {
+		t.Errorf("ListAPIKeys() for other user = %v, want none", keys)
+	}
+
+	if err := service.RevokeAPIKey(other.ID, botKey.APIKey.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("RevokeAPIKey() by other user error = %v, want %v", err, ErrAPIKeyNotFound)
+	}
+	if err := service.RevokeAPIKey(owner.ID, botKey.APIKey.ID); err != nil {
+		t.Fatalf("RevokeAPIKey() unexpected error = %v", err)
+	}
+	if _, err := authService.Authenticate(botKey.Key); err == nil {
+		t.Error("Authenticate() with revoked key expected error, got nil")
+	}
+
+	// Bots stop working while their owner is suspended, and suspension revokes their keys
+	next, err := service.CreateAPIKey(owner.ID, models.CreateAPIKeyRequest{Name: "deploys", Scopes: []string{auth.ScopeMessagesWrite}, BotID: bot.ID})
+	if err != nil {
+		t.Fatalf("CreateAPIKey() unexpected error = %v", err)
+	}
+	now := time.Now()
+	store.SetUserSuspended(owner.ID, &now, "spam")
+	if _, err := authService.Authenticate(next.Key); !errors.Is(err, auth.ErrAccountSuspended) {
+		t.Errorf("Authenticate() with the key of a suspended owner's bot error = %v, want %v", err, auth.ErrAccountSuspended)
+	}
+	store.SetUserSuspended(owner.ID, nil, "")
+	if _, err := authService.Authenticate(next.Key); err != nil {
+		t.Fatalf("Authenticate() after unsuspension unexpected error = %v", err)
+	}
+
+	if err := service.SuspendUser(other.ID, owner.ID, "spam", ""); err != nil {
+		t.Fatalf("SuspendUser() unexpected error = %v", err)
+	}
+	if key, _ := store.GetAPIKey(next.APIKey.ID); key == nil || key.RevokedAt == nil {
+		t.Errorf("bot key after the owner's suspension = %+v, want it revoked", key)
+	}
+	if err := service.UnsuspendUser(other.ID, owner.ID, ""); err != nil {
+		t.Fatalf("UnsuspendUser() unexpected error = %v", err)
+	}
+	if _, err := authService.Authenticate(next.Key); err == nil {
+		t.Error("Authenticate() with a key revoked by suspension expected error, got nil")
+	}
+}
+
+func TestChatService_SetUserRole(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour, auth.WithSessionStore(store))
+	service := NewChatService(store, store, store, authService, WithAuditLogger(audit.NewLogger(store)))
+
+	admin, _ := service.RegisterUser(models.RegisterRequest{Username: "admin", Email: "admin@example.com", Password: "password123"})
+	user, _ := service.RegisterUser(models.RegisterRequest{Username: "testuser", Email: "test@example.com", Password: "password123"})
+
+	if err := service.BootstrapAdmins([]string{admin.ID, "unknown-id"}); err != nil {
+		t.Fatalf("BootstrapAdmins() unexpected error = %v", err)
+	}
+	if got, _ := store.GetUser(admin.ID); got.Role != models.RoleAdmin {
+		t.Errorf("BootstrapAdmins() role = %q, want %q", got.Role, models.RoleAdmin)
+	}
+
+	login, err := service.AuthenticateUser(models.AuthRequest{Username: "testuser", Password: "password123"})
+	if err != nil {
+		t.Fatalf("AuthenticateUser() unexpected error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		actorID string
+		userID  string
+		role    string
+		wantErr error
+	}{
+		{name: "unknown role", actorID: admin.ID, userID: user.ID, role: "superuser", wantErr: ErrInvalidRole},
+		{name: "own role", actorID: admin.ID, userID: admin.ID, role: models.RoleUser, wantErr: ErrOwnRoleChange},
+		{name: "promote to moderator", actorID: admin.ID, userID: user.ID, role: models.RoleModerator},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := service.SetUserRole(tt.actorID, tt.userID, tt.role, "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("SetUserRole() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// The role change revokes tokens issued with the old scopes
+	if _, err := authService.ValidateToken(login.Token); err == nil {
+		t.Error("ValidateToken() after role change expected error, got nil")
+	}
+
+	login, err = service.AuthenticateUser(models.AuthRequest{Username: "testuser", Password: "password123"})
+	if err != nil {
+		t.Fatalf("AuthenticateUser() unexpected error = %v", err)
+	}
+	claims, err := authService.ValidateToken(login.Token)
+	if err != nil {
+		t.Fatalf("ValidateToken() unexpected error = %v", err)
+	}
+	if !auth.HasScope(claims.Scopes, auth.ScopeModerate) || auth.HasScope(claims.Scopes, auth.ScopeAdmin) {
+		t.Errorf("moderator scopes = %v", claims.Scopes)
+	}
+
+	events, _ := store.GetAuditEvents()
+	var roleChanges int
+	for _, event := range events {
+		if event.Action == audit.ActionRoleChanged {
+			roleChanges++
+		}
+	}
+	if roleChanges != 2 {
+		t.Errorf("recorded %d role changes, want 2", roleChanges)
+	}
+}
+
+func TestChatService_UserModeration(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour, auth.WithSessionStore(store), auth.WithUserStore(store))
+	mail := mailer.NewLogMailer(io.Discard)
+	service := NewChatService(store, store, store, authService,
+		WithTokenStore(store),
+		WithMailer(mail, "http://localhost:8080"),
+		WithAuditLogger(audit.NewLogger(store)),
+	)
+
+	admin, _ := service.RegisterUser(models.RegisterRequest{Username: "admin", Email: "admin@example.com", Password: "password123"})
+	otherAdmin, _ := service.RegisterUser(models.RegisterRequest{Username: "otheradmin", Email: "other@example.com", Password: "password123"})
+	user, _ := service.RegisterUser(models.RegisterRequest{Username: "testuser", Email: "test@example.com", Password: "password123"})
+	service.BootstrapAdmins([]string{admin.ID, otherAdmin.ID})
+
+	login := func() (*models.AuthResponse, error) {
+		return service.AuthenticateUser(models.AuthRequest{Username: "testuser", Password: "password123"})
+	}
+
+	t.Run("search", func(t *testing.T) {
+		suspended := false
+		users, total, err := service.SearchUsers(models.UserFilter{Query: "ADMIN", Suspended: &suspended, Limit: 1})
+		if err != nil {
+			t.Fatalf("SearchUsers() unexpected error = %v", err)
+		}
+		if total != 2 || len(users) != 1 {
+			t.Errorf("SearchUsers() = %d users of %d, want 1 of 2", len(users), total)
+		}
+
+		if _, _, err := service.SearchUsers(models.UserFilter{Role: "superuser"}); !errors.Is(err, ErrInvalidRole) {
+			t.Errorf("SearchUsers() with unknown role error = %v, want %v", err, ErrInvalidRole)
+		}
+	})
+
+	t.Run("protected accounts", func(t *testing.T) {
+		if err := service.SuspendUser(admin.ID, admin.ID, "", ""); !errors.Is(err, ErrOwnAccountModeration) {
+			t.Errorf("SuspendUser() own account error = %v, want %v", err, ErrOwnAccountModeration)
+		}
+		if err := service.DeleteUser(admin.ID, otherAdmin.ID, ""); !errors.Is(err, ErrProtectedAccount) {
+			t.Errorf("DeleteUser() administrator error = %v, want %v", err, ErrProtectedAccount)
+		}
+	})
+
+	t.Run("suspend and unsuspend", func(t *testing.T) {
+		session, err := login()
+		if err != nil {
+			t.Fatalf("AuthenticateUser() unexpected error = %v", err)
+		}
+
+		if err := service.SuspendUser(admin.ID, user.ID, "spam", ""); err != nil {
+			t.Fatalf("SuspendUser() unexpected error = %v", err)
+		}
+		if _, err := authService.ValidateToken(session.Token); err == nil {
+			t.Error("ValidateToken() after suspension expected error, got nil")
+		}
+		if _, err := login(); !errors.Is(err, ErrAccountSuspended) {
+			t.Errorf("AuthenticateUser() while suspended error = %v, want %v", err, ErrAccountSuspended)
+		}
+
+		if err := service.UnsuspendUser(admin.ID, user.ID, ""); err != nil {
+			t.Fatalf("UnsuspendUser() unexpected error = %v", err)
+		}
+		if _, err := login(); err != nil {
+			t.Errorf("AuthenticateUser() after unsuspension unexpected error = %v", err)
+		}
+	})
+
+	t.Run("force logout", func(t *testing.T) {
+		session, _ := login()
+		if err := service.ForceLogout(admin.ID, user.ID, ""); err != nil {
+			t.Fatalf("ForceLogout() unexpected error = %v", err)
+		}
+		if _, err := authService.ValidateToken(session.Token); err == nil {
+			t.Error("ValidateToken() after forced logout expected error, got nil")
+		}
+	})
+
+	t.Run("password reset", func(t *testing.T) {
+		if err := service.AdminResetPassword(admin.ID, user.ID, ""); err != nil {
+			t.Fatalf("AdminResetPassword() unexpected error = %v", err)
+		}
+		if _, err := login(); err == nil {
+			t.Error("AuthenticateUser() with old password expected error, got nil")
+		}
+
+		sent := mail.Sent()
+		last := sent[len(sent)-1]
+		if last.To != "test@example.com" || !strings.Contains(last.Body, "/reset-password?token=") {
+			t.Errorf("AdminResetPassword() sent %+v, want a reset link to test@example.com", last)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		service.SendMessage(models.MessageRequest{Sender: "testuser", Recipient: "admin", Content: "hello"})
+
+		if err := service.DeleteUser(admin.ID, user.ID, ""); err != nil {
+			t.Fatalf("DeleteUser() unexpected error = %v", err)
+		}
+		if got, _ := store.GetUserByUsername("testuser"); got != nil {
+			t.Error("DeleteUser() user still exists")
+		}
+		if messages, _ := store.GetMessagesBetweenUsers("testuser", "admin"); len(messages) != 0 {
+			t.Errorf("DeleteUser() left %d messages", len(messages))
+		}
+	})
+
+	events, _ := store.GetAuditEvents()
+	recorded := make(map[string]bool)
+	for _, event := range events {
+		recorded[event.Action] = true
+	}
+	for _, action := range []string{audit.ActionUserSuspended, audit.ActionUserUnsuspended, audit.ActionUserLoggedOut, audit.ActionPasswordReset, audit.ActionUserDeleted} {
+		if !recorded[action] {
+			t.Errorf("audit trail is missing %s", action)
+		}
+	}
+}
+
+func TestChatService_AuditTrail(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	// A short expiry makes fresh tokens eligible for refresh
+	authService := auth.NewAuthService("test-secret", 10*time.Minute)
+	service := NewChatService(store, store, store, authService, WithAuditLogger(audit.NewLogger(store)))
+
+	alice, _ := service.RegisterUser(models.RegisterRequest{Username: "alice", Email: "alice@example.com", Password: "password123"})
+	bob, _ := service.RegisterUser(models.RegisterRequest{Username: "bob", Email: "bob@example.com", Password: "password123"})
+	room, _ := service.CreateRoom(models.CreateRoomRequest{Name: "general"})
+
+	login, err := service.AuthenticateUser(models.AuthRequest{Username: "alice", Password: "password123", ClientIP: "203.0.113.7"})
+	if err != nil {
+		t.Fatalf("AuthenticateUser() unexpected error = %v", err)
+	}
+	if _, err := service.RefreshToken(login.Token, "203.0.113.7"); err != nil {
+		t.Fatalf("RefreshToken() unexpected error = %v", err)
+	}
+
+	if err := service.AddUserToRoom(alice.ID, room.ID, bob.ID, "203.0.113.7"); err != nil {
+		t.Fatalf("AddUserToRoom() unexpected error = %v", err)
+	}
+	if err := service.RemoveUserFromRoom(alice.ID, room.ID, bob.ID, "203.0.113.7"); err != nil {
+		t.Fatalf("RemoveUserFromRoom() unexpected error = %v", err)
+	}
+
+	message, _ := service.SendMessage(models.MessageRequest{Sender: "alice", RoomID: room.ID, Content: "hello"})
+
+	deletes := []struct {
+		name      string
+		actorID   string
+		messageID string
+		moderator bool
+		wantErr   error
+	}{
+		{name: "unknown message", actorID: alice.ID, messageID: "missing", wantErr: ErrMessageNotFound},
+		{name: "someone else's message", actorID: bob.ID, messageID: message.ID, wantErr: ErrNotMessageSender},
+		{name: "moderator", actorID: bob.ID, messageID: message.ID, moderator: true},
+	}
+	for _, tt := range deletes {
+		t.Run(tt.name, func(t *testing.T) {
+			err := service.DeleteMessage(tt.actorID, tt.messageID, tt.moderator, "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("DeleteMessage() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+	if messages, _ := service.GetMessagesByRoom(alice.ID, room.ID); len(messages) != 0 {
+		t.Errorf("GetMessagesByRoom() after delete = %d messages, want 0", len(messages))
+	}
+
+	for _, action := range []string{audit.ActionLoginSucceeded, audit.ActionTokenRefreshed, audit.ActionRoomMemberAdded, audit.ActionRoomMemberRemoved, audit.ActionMessageDeleted} {
+		events, total, err := service.SearchAuditEvents(models.AuditFilter{Action: action})
+		if err != nil {
+			t.Fatalf("SearchAuditEvents() unexpected error = %v", err)
+		}
+		if total != 1 || len(events) != 1 {
+			t.Errorf("SearchAuditEvents(%s) = %d events, want 1", action, total)
+		}
+	}
+
+	events, total, _ := service.SearchAuditEvents(models.AuditFilter{ActorID: alice.ID, IP: "203.0.113.7", Limit: 2})
+	if total != 4 || len(events) != 2 || events[0].Action != audit.ActionRoomMemberRemoved {
+		t.Errorf("SearchAuditEvents() = %d of %d events starting with %v, want 2 of 4 starting with %s",
+			len(events), total, events, audit.ActionRoomMemberRemoved)
+	}
+
+	if result, err := service.VerifyAuditLog(); err != nil || !result.Valid {
+		t.Errorf("VerifyAuditLog() = %+v, %v, want a valid chain", result, err)
+	}
+}
+
+// slowFilter is a moderation filter that allows every message after a delay
+type slowFilter struct{}
+
+func (slowFilter) Name() string { return "slow" }
+
+func (slowFilter) Check(moderation.Message, models.RoomModeration) (moderation.Result, error) {
+	time.Sleep(10 * time.Millisecond)
+	return moderation.Result{Action: moderation.Allow}, nil
+}
+
+func TestChatService_SlowModeConcurrentPosts(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour)
+	// The slow filter widens the gap between the slow-mode check and the insert
+	service := NewChatService(store, store, store, authService, WithModeration(moderation.NewPipeline(slowFilter{}), store))
+
+	service.RegisterUser(models.RegisterRequest{Username: "alice", Email: "alice@example.com", Password: "password123"})
+	room, _ := service.CreateRoom(models.CreateRoomRequest{Name: "general"})
+	store.UpdateRoomSettings(room.ID, 60, false, 0)
+
+	var wg sync.WaitGroup
+	var sent, limited atomic.Int32
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := service.SendMessage(models.MessageRequest{Sender: "alice", RoomID: room.ID, Content: "hello"})
+			var slowModeErr *SlowModeError
+			switch {
+			case err == nil:
+				sent.Add(1)
+			case errors.As(err, &slowModeErr):
+				limited.Add(1)
+			default:
+				t.Errorf("SendMessage() unexpected error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if sent.Load() != 1 || limited.Load() != 4 {
+		t.Errorf("concurrent posts: %d sent and %d limited, want 1 and 4", sent.Load(), limited.Load())
+	}
+}
+
+func TestChatService_RoomRestrictions(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour)
+	service := NewChatService(store, store, store, authService, WithAuditLogger(audit.NewLogger(store)))
+
+	admin, _ := service.RegisterUser(models.RegisterRequest{Username: "admin", Email: "admin@example.com", Password: "password123"})
+	moderator, _ := service.RegisterUser(models.RegisterRequest{Username: "moderator", Email: "moderator@example.com", Password: "password123"})
+	alice, _ := service.RegisterUser(models.RegisterRequest{Username: "alice", Email: "alice@example.com", Password: "password123"})
+	bob, _ := service.RegisterUser(models.RegisterRequest{Username: "bob", Email: "bob@example.com", Password: "password123"})
+	service.BootstrapAdmins([]string{admin.ID})
+	service.SetUserRole(admin.ID, moderator.ID, models.RoleModerator, "")
+	room, _ := service.CreateRoom(models.CreateRoomRequest{Name: "general"})
+
+	send := func(sender string) error {
+		_, err := service.SendMessage(models.MessageRequest{Sender: sender, RoomID: room.ID, Content: "hello"})
+		return err
+	}
+
+	t.Run("settings validation", func(t *testing.T) {
+		if _, err := service.UpdateRoomSettings(moderator.ID, room.ID, models.RoomSettingsRequest{SlowModeSeconds: -1}, ""); !errors.Is(err, ErrInvalidSlowMode) {
+			t.Errorf("UpdateRoomSettings() negative interval error = %v, want %v", err, ErrInvalidSlowMode)
+		}
+		if _, err := service.UpdateRoomSettings(moderator.ID, "missing", models.RoomSettingsRequest{}, ""); !errors.Is(err, ErrRoomNotFound) {
+			t.Errorf("UpdateRoomSettings() unknown room error = %v, want %v", err, ErrRoomNotFound)
+		}
+		if err := send("alice"); err != nil {
+			t.Errorf("SendMessage() without restrictions unexpected error = %v", err)
+		}
+	})
+
+	t.Run("slow mode", func(t *testing.T) {
+		updated, err := service.UpdateRoomSettings(moderator.ID, room.ID, models.RoomSettingsRequest{SlowModeSeconds: 60}, "")
+		if err != nil || updated.SlowModeSeconds != 60 {
+			t.Fatalf("UpdateRoomSettings() = %+v, %v, want a 60 second interval", updated, err)
+		}
+
+		// alice posted a moment ago, bob two minutes ago
+		var slowModeErr *SlowModeError
+		if err := send("alice"); !errors.As(err, &slowModeErr) {
+			t.Fatalf("SendMessage() during cooldown error = %v, want a SlowModeError", err)
+		}
+		if slowModeErr.RetryAfter <= 0 || slowModeErr.RetryAfter > time.Minute {
+			t.Errorf("SlowModeError.RetryAfter = %v, want at most a minute", slowModeErr.RetryAfter)
+		}
+
+		store.AddMessage(models.Message{ID: "earlier", Sender: "bob", RoomID: room.ID, Content: "hi", Timestamp: time.Now().Add(-2 * time.Minute)})
+		if err := send("bob"); err != nil {
+			t.Errorf("SendMessage() after cooldown unexpected error = %v", err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := send("moderator"); err != nil {
+				t.Errorf("SendMessage() as moderator unexpected error = %v", err)
+			}
+		}
+	})
+
+	t.Run("read-only", func(t *testing.T) {
+		service.UpdateRoomSettings(admin.ID, room.ID, models.RoomSettingsRequest{ReadOnly: true}, "")
+
+		for _, sender := range []string{"bob", "moderator"} {
+			if err := send(sender); !errors.Is(err, ErrRoomReadOnly) {
+				t.Errorf("SendMessage() as %s in read-only room error = %v, want %v", sender, err, ErrRoomReadOnly)
+			}
+		}
+		if err := send("admin"); err != nil {
+			t.Errorf("SendMessage() as admin in read-only room unexpected error = %v", err)
+		}
+
+		service.UpdateRoomSettings(admin.ID, room.ID, models.RoomSettingsRequest{}, "")
+	})
+
+	t.Run("mutes", func(t *testing.T) {
+		mutes := []struct {
+			name    string
+			actorID string
+			userID  string
+			req     models.MuteRequest
+			wantErr error
+		}{
+			{name: "negative duration", actorID: moderator.ID, userID: bob.ID, req: models.MuteRequest{DurationSeconds: -1}, wantErr: ErrInvalidMuteDuration},
+			{name: "own account", actorID: moderator.ID, userID: moderator.ID, wantErr: ErrProtectedMember},
+			{name: "administrator", actorID: moderator.ID, userID: admin.ID, wantErr: ErrProtectedMember},
+			{name: "until lifted", actorID: moderator.ID, userID: bob.ID, req: models.MuteRequest{Reason: "spam"}},
+			{name: "with expiry", actorID: moderator.ID, userID: alice.ID, req: models.MuteRequest{DurationSeconds: 600}},
+		}
+		for _, tt := range mutes {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := service.MuteRoomMember(tt.actorID, room.ID, tt.userID, tt.req, "")
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("MuteRoomMember() error = %v, want %v", err, tt.wantErr)
+				}
+			})
+		}
+
+		var mutedErr *MutedError
+		if err := send("bob"); !errors.As(err, &mutedErr) || mutedErr.Until != nil {
+			t.Errorf("SendMessage() while muted until lifted error = %v, want a MutedError without expiry", err)
+		}
+		if err := send("alice"); !errors.As(err, &mutedErr) || mutedErr.Until == nil {
+			t.Errorf("SendMessage() while muted with expiry error = %v, want a MutedError with expiry", err)
+		}
+		if active, _ := service.GetRoomMutes(room.ID); len(active) != 2 {
+			t.Errorf("GetRoomMutes() = %d mutes, want 2", len(active))
+		}
+
+		if err := service.UnmuteRoomMember(moderator.ID, room.ID, bob.ID, ""); err != nil {
+			t.Fatalf("UnmuteRoomMember() unexpected error = %v", err)
+		}
+		if err := service.UnmuteRoomMember(moderator.ID, room.ID, bob.ID, ""); err == nil {
+			t.Error("UnmuteRoomMember() of a member who is not muted should fail")
+		}
+		if err := send("bob"); err != nil {
+			t.Errorf("SendMessage() after unmute unexpected error = %v", err)
+		}
+
+		// Expired mutes no longer apply
+		expired := time.Now().Add(-time.Second)
+		store.SetRoomMute(models.RoomMute{RoomID: room.ID, UserID: alice.ID, CreatedAt: time.Now().Add(-time.Hour), ExpiresAt: &expired})
+		if err := send("alice"); err != nil {
+			t.Errorf("SendMessage() after mute expired unexpected error = %v", err)
+		}
+		if active, _ := service.GetRoomMutes(room.ID); len(active) != 0 {
+			t.Errorf("GetRoomMutes() after expiry = %d mutes, want 0", len(active))
+		}
+	})
+
+	if err := send("unknown"); err == nil {
+		t.Error("SendMessage() from an unknown sender should fail")
+	}
+	if _, err := service.SendMessage(models.MessageRequest{Sender: "alice", RoomID: "missing", Content: "hello"}); !errors.Is(err, ErrRoomNotFound) {
+		t.Errorf("SendMessage() to an unknown room error = %v, want %v", err, ErrRoomNotFound)
+	}
+
+	for _, action := range []string{audit.ActionRoomSettingsChanged, audit.ActionRoomMemberMuted, audit.ActionRoomMemberUnmuted} {
+		if _, total, _ := service.SearchAuditEvents(models.AuditFilter{Action: action}); total == 0 {
+			t.Errorf("audit trail is missing %s", action)
+		}
+	}
+}
+
+func TestChatService_Blocks(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour)
+	service := NewChatService(store, store, store, authService, WithBlockStore(store))
+
+	alice, _ := service.RegisterUser(models.RegisterRequest{Username: "alice", Email: "alice@example.com", Password: "password123"})
+	bob, _ := service.RegisterUser(models.RegisterRequest{Username: "bob", Email: "bob@example.com", Password: "password123"})
+	service.RegisterUser(models.RegisterRequest{Username: "carol", Email: "carol@example.com", Password: "password123"})
+	room, _ := service.CreateRoom(models.CreateRoomRequest{Name: "general"})
+
+	for _, sender := range []string{"alice", "bob", "carol"} {
+		service.SendMessage(models.MessageRequest{Sender: sender, RoomID: room.ID, Content: "hello from " + sender})
+	}
+	service.SendMessage(models.MessageRequest{Sender: "bob", Recipient: "alice", Content: "hi alice"})
+
+	blocks := []struct {
+		name      string
+		blockedID string
+		wantErr   bool
+	}{
+		{name: "own account", blockedID: alice.ID, wantErr: true},
+		{name: "unknown user", blockedID: "missing", wantErr: true},
+		{name: "block", blockedID: bob.ID},
+		{name: "block twice", blockedID: bob.ID},
+	}
+	for _, tt := range blocks {
+		t.Run(tt.name, func(t *testing.T) {
+			block, err := service.BlockUser(alice.ID, tt.blockedID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("BlockUser() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && block.BlockedUsername != "bob" {
+				t.Errorf("BlockUser() blocked username = %q, want %q", block.BlockedUsername, "bob")
+			}
+		})
+	}
+	if list, _ := service.GetBlockedUsers(alice.ID); len(list) != 1 || list[0].BlockedID != bob.ID {
+		t.Errorf("GetBlockedUsers() = %+v, want bob only", list)
+	}
+
+	t.Run("history", func(t *testing.T) {
+		messages, err := service.GetMessagesByRoom(alice.ID, room.ID)
+		if err != nil {
+			t.Fatalf("GetMessagesByRoom() unexpected error = %v", err)
+		}
+		if len(messages) != 2 {
+			t.Errorf("GetMessagesByRoom() as blocker = %d messages, want 2", len(messages))
+		}
+		for _, message := range messages {
+			if message.Sender == "bob" {
+				t.Errorf("GetMessagesByRoom() as blocker returned a message from bob")
+			}
+		}
+		if messages, _ := service.GetMessagesByRoom(bob.ID, room.ID); len(messages) != 3 {
+			t.Errorf("GetMessagesByRoom() as blocked user = %d messages, want 3", len(messages))
+		}
+		if messages, _ := service.GetMessagesBetweenUsers(alice.ID, "alice", "bob"); len(messages) != 0 {
+			t.Errorf("GetMessagesBetweenUsers() as blocker = %d messages, want 0", len(messages))
+		}
+	})
+
+	t.Run("contact", func(t *testing.T) {
+		if _, err := service.SendMessage(models.MessageRequest{Sender: "bob", Recipient: "alice", Content: "hello?"}); !errors.Is(err, ErrBlocked) {
+			t.Errorf("SendMessage() to blocker error = %v, want %v", err, ErrBlocked)
+		}
+		if _, err := service.SendMessage(models.MessageRequest{Sender: "alice", Recipient: "bob", Content: "bye"}); err != nil {
+			t.Errorf("SendMessage() from blocker unexpected error = %v", err)
+		}
+		if err := service.AddUserToRoom(bob.ID, room.ID, alice.ID, ""); !errors.Is(err, ErrBlocked) {
+			t.Errorf("AddUserToRoom() by blocked user error = %v, want %v", err, ErrBlocked)
+		}
+		if err := service.AddUserToRoom(alice.ID, room.ID, bob.ID, ""); err != nil {
+			t.Errorf("AddUserToRoom() by blocker unexpected error = %v", err)
+		}
+	})
+
+	t.Run("unblock", func(t *testing.T) {
+		if err := service.UnblockUser(alice.ID, bob.ID); err != nil {
+			t.Fatalf("UnblockUser() unexpected error = %v", err)
+		}
+		if err := service.UnblockUser(alice.ID, bob.ID); err == nil {
+			t.Error("UnblockUser() of a user who is not blocked should fail")
+		}
+		if _, err := service.SendMessage(models.MessageRequest{Sender: "bob", Recipient: "alice", Content: "hello again"}); err != nil {
+			t.Errorf("SendMessage() after unblock unexpected error = %v", err)
+		}
+		if messages, _ := service.GetMessagesByRoom(alice.ID, room.ID); len(messages) != 3 {
+			t.Errorf("GetMessagesByRoom() after unblock = %d messages, want 3", len(messages))
+		}
+	})
+}
+
+func TestChatService_Moderation(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour)
+	words, _ := moderation.NewWordFilter([]string{"darn"}, nil, moderation.Mask)
+	pipeline := moderation.NewPipeline(
+		words,
+		moderation.NewLinkFilter([]string{"spam.example"}, moderation.Reject),
+		moderation.NewSpamFilter(store, moderation.SpamPolicy{MaxMentions: 2}, moderation.Hold),
+	)
+	service := NewChatService(store, store, store, authService,
+		WithModeration(pipeline, store),
+		WithAuditLogger(audit.NewLogger(store)),
+	)
+
+	moderator, _ := service.RegisterUser(models.RegisterRequest{Username: "moderator", Email: "moderator@example.com", Password: "password123"})
+	service.RegisterUser(models.RegisterRequest{Username: "alice", Email: "alice@example.com", Password: "password123"})
+	room, _ := service.CreateRoom(models.CreateRoomRequest{Name: "general"})
+
+	t.Run("mask and reject", func(t *testing.T) {
+		message, err := service.SendMessage(models.MessageRequest{Sender: "alice", RoomID: room.ID, Content: "darn it"})
+		if err != nil || message.Content != "**** it" {
+			t.Errorf("SendMessage() = %+v, %v, want masked content", message, err)
+		}
+
+		var rejectedErr *MessageRejectedError
+		if _, err := service.SendMessage(models.MessageRequest{Sender: "alice", RoomID: room.ID, Content: "visit spam.example"}); !errors.As(err, &rejectedErr) {
+			t.Fatalf("SendMessage() with blocked link error = %v, want a MessageRejectedError", err)
+		}
+		if rejectedErr.Filter != moderation.FilterLinks {
+			t.Errorf("MessageRejectedError.Filter = %q, want %q", rejectedErr.Filter, moderation.FilterLinks)
+		}
+	})
+
+	t.Run("hold and review", func(t *testing.T) {
+		var heldErr *MessageHeldError
+		for i := 0; i < 2; i++ {
+			if _, err := service.SendMessage(models.MessageRequest{Sender: "alice", RoomID: room.ID, Content: "@a @b @c"}); !errors.As(err, &heldErr) {
+				t.Fatalf("SendMessage() mention bomb error = %v, want a MessageHeldError", err)
+			}
+		}
+
+		held, err := service.GetHeldMessages(room.ID)
+		if err != nil || len(held) != 2 {
+			t.Fatalf("GetHeldMessages() = %d messages, %v, want 2", len(held), err)
+		}
+		before, _ := service.GetMessagesByRoom(moderator.ID, room.ID)
+
+		approved, err := service.ApproveHeldMessage(moderator.ID, held[0].ID, "")
+		if err != nil || approved.ID != held[0].ID || approved.Content != "@a @b @c" {
+			t.Fatalf("ApproveHeldMessage() = %+v, %v, want the held message", approved, err)
+		}
+		if err := service.RejectHeldMessage(moderator.ID, held[1].ID, ""); err != nil {
+			t.Fatalf("RejectHeldMessage() unexpected error = %v", err)
+		}
+		if _, err := service.ApproveHeldMessage(moderator.ID, held[1].ID, ""); !errors.Is(err, ErrHeldMessageNotFound) {
+			t.Errorf("ApproveHeldMessage() of a reviewed message error = %v, want %v", err, ErrHeldMessageNotFound)
+		}
+
+		after, _ := service.GetMessagesByRoom(moderator.ID, room.ID)
+		if len(after) != len(before)+1 {
+			t.Errorf("GetMessagesByRoom() = %d messages after review, want %d", len(after), len(before)+1)
+		}
+		if held, _ := service.GetHeldMessages(""); len(held) != 0 {
+			t.Errorf("GetHeldMessages() after review = %d messages, want 0", len(held))
+		}
+	})
+
+	t.Run("mask then hold", func(t *testing.T) {
+		var heldErr *MessageHeldError
+		if _, err := service.SendMessage(models.MessageRequest{Sender: "alice", RoomID: room.ID, Content: "darn @a @b @c"}); !errors.As(err, &heldErr) {
+			t.Fatalf("SendMessage() masked mention bomb error = %v, want a MessageHeldError", err)
+		}
+		if heldErr.Held.Content != "**** @a @b @c" {
+			t.Errorf("HeldMessage.Content = %q, want the masked content", heldErr.Held.Content)
+		}
+
+		approved, err := service.ApproveHeldMessage(moderator.ID, heldErr.Held.ID, "")
+		if err != nil || approved.Content != "**** @a @b @c" {
+			t.Errorf("ApproveHeldMessage() = %+v, %v, want the masked content", approved, err)
+		}
+	})
+
+	t.Run("room settings", func(t *testing.T) {
+		invalid := []models.RoomModeration{
+			{DisabledFilters: []string{"unknown"}},
+			{Actions: map[string]string{moderation.FilterLinks: "ban"}},
+			{BlockedPatterns: []string{"("}},
+		}
+		for _, settings := range invalid {
+			if _, err := service.UpdateRoomModeration(moderator.ID, room.ID, settings, ""); !errors.Is(err, ErrInvalidModeration) {
+				t.Errorf("UpdateRoomModeration(%+v) error = %v, want %v", settings, err, ErrInvalidModeration)
+			}
+		}
+
+		settings, err := service.UpdateRoomModeration(moderator.ID, room.ID, models.RoomModeration{
+			DisabledFilters: []string{moderation.FilterLinks},
+			BlockedWords:    []string{"pineapple"},
+		}, "")
+		if err != nil || settings.UpdatedBy != moderator.ID {
+			t.Fatalf("UpdateRoomModeration() = %+v, %v", settings, err)
+		}
+
+		message, err := service.SendMessage(models.MessageRequest{Sender: "alice", RoomID: room.ID, Content: "pineapple at spam.example"})
+		if err != nil || message.Content != "********* at spam.example" {
+			t.Errorf("SendMessage() with room settings = %+v, %v, want the room word masked and the link allowed", message, err)
+		}
+
+		// Room settings do not apply to direct messages
+		if _, err := service.SendMessage(models.MessageRequest{Sender: "alice", Recipient: "moderator", Content: "spam.example"}); err == nil {
+			t.Error("SendMessage() direct message with blocked link should be rejected")
+		}
+	})
+}
+
+func TestChatService_Reports(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour)
+	service := NewChatService(store, store, store, authService,
+		WithReportStore(store),
+		WithAuditLogger(audit.NewLogger(store)),
+	)
+
+	admin, _ := service.RegisterUser(models.RegisterRequest{Username: "admin", Email: "admin@example.com", Password: "password123"})
+	moderator, _ := service.RegisterUser(models.RegisterRequest{Username: "moderator", Email: "moderator@example.com", Password: "password123"})
+	alice, _ := service.RegisterUser(models.RegisterRequest{Username: "alice", Email: "alice@example.com", Password: "password123"})
+	bob, _ := service.RegisterUser(models.RegisterRequest{Username: "bob", Email: "bob@example.com", Password: "password123"})
+	carol, _ := service.RegisterUser(models.RegisterRequest{Username: "carol", Email: "carol@example.com", Password: "password123"})
+	service.BootstrapAdmins([]string{admin.ID})
+	service.SetUserRole(admin.ID, moderator.ID, models.RoleModerator, "")
+	room, _ := service.CreateRoom(models.CreateRoomRequest{Name: "general"})
+
+	roomMessage, _ := service.SendMessage(models.MessageRequest{Sender: "bob", RoomID: room.ID, Content: "you are all idiots"})
+	directMessage, _ := service.SendMessage(models.MessageRequest{Sender: "bob", Recipient: "alice", Content: "psst"})
+
+	t.Run("create", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			reporter string
+			req      models.ReportRequest
+			wantErr  error
+		}{
+			{name: "missing reason", reporter: alice.ID, req: models.ReportRequest{UserID: bob.ID}, wantErr: ErrInvalidReport},
+			{name: "message and user", reporter: alice.ID, req: models.ReportRequest{MessageID: roomMessage.ID, UserID: bob.ID, Reason: "abuse"}, wantErr: ErrInvalidReport},
+			{name: "neither", reporter: alice.ID, req: models.ReportRequest{Reason: "abuse"}, wantErr: ErrInvalidReport},
+			{name: "own message", reporter: bob.ID, req: models.ReportRequest{MessageID: roomMessage.ID, Reason: "abuse"}, wantErr: ErrOwnReport},
+			{name: "someone else's direct message", reporter: carol.ID, req: models.ReportRequest{MessageID: directMessage.ID, Reason: "abuse"}, wantErr: ErrMessageNotFound},
+			{name: "unknown message", reporter: alice.ID, req: models.ReportRequest{MessageID: "missing", Reason: "abuse"}, wantErr: ErrMessageNotFound},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if _, err := service.CreateReport(tt.reporter, tt.req); !errors.Is(err, tt.wantErr) {
+					t.Errorf("CreateReport() error = %v, want %v", err, tt.wantErr)
+				}
+			})
+		}
+
+		report, err := service.CreateReport(alice.ID, models.ReportRequest{MessageID: roomMessage.ID, Reason: " insults "})
+		if err != nil {
+			t.Fatalf("CreateReport() unexpected error = %v", err)
+		}
+		if report.ReportedUserID != bob.ID || report.RoomID != room.ID || report.MessageContent != roomMessage.Content ||
+			report.Reason != "insults" || report.Status != models.ReportOpen {
+			t.Errorf("CreateReport() = %+v, want an open report against bob in the room", report)
+		}
+		if _, err := service.CreateReport(alice.ID, models.ReportRequest{MessageID: directMessage.ID, Reason: "creepy"}); err != nil {
+			t.Errorf("CreateReport() of a received direct message unexpected error = %v", err)
+		}
+	})
+
+	t.Run("notified moderators", func(t *testing.T) {
+		report := &models.Report{RoomID: room.ID}
+		if got, _ := service.GetReportModerators(report); len(got) != 2 {
+			t.Errorf("GetReportModerators() without room moderators = %v, want every moderator and admin", got)
+		}
+		service.AddUserToRoom(admin.ID, room.ID, moderator.ID, "")
+		if got, _ := service.GetReportModerators(report); len(got) != 1 || got[0] != moderator.ID {
+			t.Errorf("GetReportModerators() = %v, want the room's moderator", got)
+		}
+	})
+
+	t.Run("actions", func(t *testing.T) {
+		reports, total, err := service.GetReports(models.ReportFilter{Status: models.ReportOpen})
+		if err != nil || total != 2 {
+			t.Fatalf("GetReports() = %d reports, %v, want 2", total, err)
+		}
+		roomReport, dmReport := reports[0], reports[1]
+
+		if _, err := service.ActOnReport(moderator.ID, dmReport.ID, models.ReportActionRequest{Action: models.ReportActionMute}, false, ""); !errors.Is(err, ErrInvalidReportAction) {
+			t.Errorf("ActOnReport() mute outside a room error = %v, want %v", err, ErrInvalidReportAction)
+		}
+		if _, err := service.ActOnReport(moderator.ID, dmReport.ID, models.ReportActionRequest{Action: models.ReportActionSuspend}, false, ""); !errors.Is(err, ErrAdminRequired) {
+			t.Errorf("ActOnReport() suspend as moderator error = %v, want %v", err, ErrAdminRequired)
+		}
+
+		resolved, err := service.ActOnReport(moderator.ID, roomReport.ID, models.ReportActionRequest{Action: models.ReportActionDeleteMessage, Note: "removed"}, false, "")
+		if err != nil || resolved.Status != models.ReportActioned || resolved.ResolvedBy != moderator.ID {
+			t.Fatalf("ActOnReport() delete = %+v, %v", resolved, err)
+		}
+		if message, _ := store.GetMessage(roomMessage.ID); message != nil {
+			t.Error("ActOnReport() delete did not delete the reported message")
+		}
+		if _, err := service.DismissReport(moderator.ID, roomReport.ID, "", ""); !errors.Is(err, ErrReportResolved) {
+			t.Errorf("DismissReport() of an actioned report error = %v, want %v", err, ErrReportResolved)
+		}
+
+		if _, err := service.ActOnReport(admin.ID, dmReport.ID, models.ReportActionRequest{Action: models.ReportActionSuspend}, true, ""); err != nil {
+			t.Fatalf("ActOnReport() suspend as admin unexpected error = %v", err)
+		}
+		if user, _ := store.GetUser(bob.ID); !user.IsSuspended() || user.SuspensionReason != "creepy" {
+			t.Errorf("ActOnReport() suspend left bob = %+v, want suspended with the report reason", user)
+		}
+
+		if _, total, _ := service.GetReports(models.ReportFilter{Status: models.ReportOpen}); total != 0 {
+			t.Errorf("GetReports() open after review = %d, want 0", total)
+		}
+		if _, _, err := service.GetReports(models.ReportFilter{Status: "closed"}); !errors.Is(err, ErrInvalidReport) {
+			t.Errorf("GetReports() unknown status error = %v, want %v", err, ErrInvalidReport)
+		}
+	})
+
+	t.Run("mute and dismiss", func(t *testing.T) {
+		message, _ := service.SendMessage(models.MessageRequest{Sender: "carol", RoomID: room.ID, Content: "spam spam"})
+		report, _ := service.CreateReport(alice.ID, models.ReportRequest{MessageID: message.ID, Reason: "spam"})
+		if _, err := service.ActOnReport(moderator.ID, report.ID, models.ReportActionRequest{Action: models.ReportActionMute, DurationSeconds: 600}, false, ""); err != nil {
+			t.Fatalf("ActOnReport() mute unexpected error = %v", err)
+		}
+		if mute, _ := store.GetRoomMute(room.ID, carol.ID); mute == nil || mute.ExpiresAt == nil {
+			t.Errorf("ActOnReport() mute = %+v, want a ten minute mute", mute)
+		}
+
+		report, _ = service.CreateReport(carol.ID, models.ReportRequest{UserID: alice.ID, Reason: "reported me"})
+		dismissed, err := service.DismissReport(moderator.ID, report.ID, "retaliation", "")
+		if err != nil || dismissed.Status != models.ReportDismissed || dismissed.Note != "retaliation" {
+			t.Errorf("DismissReport() = %+v, %v", dismissed, err)
+		}
+	})
+}
+
+func TestChatService_Attachments(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour)
+	service := NewChatService(store, store, store, authService,
+		WithAttachments(store, store, AttachmentPolicy{
+			MaxBytes:     64 << 10,
+			AllowedTypes: []string{"image/png", "text/plain"},
+			URLSecret:    []byte("url-secret"),
+			URLTTL:       time.Minute,
+		}),
+	)
+
+	alice, _ := service.RegisterUser(models.RegisterRequest{Username: "alice", Email: "alice@example.com", Password: "password123"})
+	bob, _ := service.RegisterUser(models.RegisterRequest{Username: "bob", Email: "bob@example.com", Password: "password123"})
+	carol, _ := service.RegisterUser(models.RegisterRequest{Username: "carol", Email: "carol@example.com", Password: "password123"})
+
+	var picture bytes.Buffer
+	png.Encode(&picture, image.NewRGBA(image.Rect(0, 0, 640, 480)))
+
+	t.Run("upload", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			content []byte
+			wantErr error
+		}{
+			{name: "empty", content: nil, wantErr: ErrInvalidAttachments},
+			{name: "too large", content: bytes.Repeat([]byte("a"), 64<<10+1), wantErr: ErrAttachmentTooLarge},
+			{name: "type sniffed from content", content: []byte("<!DOCTYPE html><script>alert(1)</script>"), wantErr: ErrAttachmentType},
+			{name: "text", content: []byte("meeting notes")},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if _, err := service.UploadAttachment(alice.ID, "notes.png", bytes.NewReader(tt.content)); !errors.Is(err, tt.wantErr) {
+					t.Errorf("UploadAttachment() error = %v, want %v", err, tt.wantErr)
+				}
+			})
+		}
+
+		attachment, err := service.UploadAttachment(alice.ID, `C:\photos\"cat".png`, bytes.NewReader(picture.Bytes()))
+		if err != nil {
+			t.Fatalf("UploadAttachment() unexpected error = %v", err)
+		}
+		if attachment.ContentType != "image/png" || attachment.Filename != "cat.png" || attachment.Width != 640 || attachment.Height != 480 {
+			t.Errorf("UploadAttachment() = %+v, want a 640x480 image/png named cat.png", attachment)
+		}
+		if attachment.URL == "" || attachment.ThumbnailURL == "" {
+			t.Fatalf("UploadAttachment() links = %q, %q, want content and thumbnail links", attachment.URL, attachment.ThumbnailURL)
+		}
+
+		_, thumbnail, err := service.OpenAttachment(attachment.ID, AttachmentThumbnail, attachmentQuery(attachment.ThumbnailURL, "expires"), attachmentQuery(attachment.ThumbnailURL, "signature"))
+		if err != nil {
+			t.Fatalf("OpenAttachment() unexpected error = %v", err)
+		}
+		defer thumbnail.Close()
+		config, err := png.DecodeConfig(thumbnail)
+		if err != nil || config.Width != maxThumbnailSize || config.Height != 240 {
+			t.Errorf("thumbnail = %dx%d, %v, want %dx240", config.Width, config.Height, err, maxThumbnailSize)
+		}
+	})
+
+	t.Run("signed links", func(t *testing.T) {
+		attachment, _ := service.UploadAttachment(alice.ID, "notes.txt", strings.NewReader("meeting notes"))
+		expires := attachmentQuery(attachment.URL, "expires")
+		signature := attachmentQuery(attachment.URL, "signature")
+		past := strconv.FormatInt(time.Now().Add(-time.Second).Unix(), 10)
+
+		tests := []struct {
+			name      string
+			variant   string
+			expires   string
+			signature string
+			wantErr   error
+		}{
+			{name: "tampered signature", variant: AttachmentContent, expires: expires, signature: strings.Repeat("0", 64), wantErr: ErrInvalidAttachmentURL},
+			{name: "other variant", variant: AttachmentThumbnail, expires: expires, signature: signature, wantErr: ErrInvalidAttachmentURL},
+			{name: "extended expiry", variant: AttachmentContent, expires: expires + "0", signature: signature, wantErr: ErrInvalidAttachmentURL},
+			{name: "expired", variant: AttachmentContent, expires: past, signature: service.attachmentSignature(attachment.ID, AttachmentContent, time.Now().Add(-time.Second).Unix()), wantErr: ErrInvalidAttachmentURL},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if _, _, err := service.OpenAttachment(attachment.ID, tt.variant, tt.expires, tt.signature); !errors.Is(err, tt.wantErr) {
+					t.Errorf("OpenAttachment() error = %v, want %v", err, tt.wantErr)
+				}
+			})
+		}
+
+		_, content, err := service.OpenAttachment(attachment.ID, AttachmentContent, expires, signature)
+		if err != nil {
+			t.Fatalf("OpenAttachment() unexpected error = %v", err)
+		}
+		data, _ := io.ReadAll(content)
+		content.Close()
+		if string(data) != "meeting notes" {
+			t.Errorf("OpenAttachment() content = %q, want %q", data, "meeting notes")
+		}
+	})
+
+	t.Run("messages", func(t *testing.T) {
+		attachment, _ := service.UploadAttachment(alice.ID, "notes.txt", strings.NewReader("meeting notes"))
+		other, _ := service.UploadAttachment(bob.ID, "notes.txt", strings.NewReader("bob's notes"))
+
+		if _, err := service.GetAttachment(bob.ID, attachment.ID); !errors.Is(err, ErrAttachmentNotFound) {
+			t.Errorf("GetAttachment() of an unsent upload by someone else error = %v, want %v", err, ErrAttachmentNotFound)
+		}
+		if _, err := service.SendMessage(models.MessageRequest{Sender: "alice", Recipient: "bob", Content: "see", AttachmentIDs: []string{other.ID}}); !errors.Is(err, ErrInvalidAttachments) {
+			t.Errorf("SendMessage() with someone else's attachment error = %v, want %v", err, ErrInvalidAttachments)
+		}
+
+		message, err := service.SendMessage(models.MessageRequest{Sender: "alice", Recipient: "bob", Content: "see", AttachmentIDs: []string{attachment.ID}})
+		if err != nil {
+			t.Fatalf("SendMessage() unexpected error = %v", err)
+		}
+		if len(message.Attachments) != 1 || message.Attachments[0].URL == "" {
+			t.Errorf("SendMessage() attachments = %+v, want the signed attachment", message.Attachments)
+		}
+		if _, err := service.SendMessage(models.MessageRequest{Sender: "alice", Recipient: "bob", Content: "again", AttachmentIDs: []string{attachment.ID}}); !errors.Is(err, ErrInvalidAttachments) {
+			t.Errorf("SendMessage() with a sent attachment error = %v, want %v", err, ErrInvalidAttachments)
+		}
+
+		if _, err := service.GetAttachment(bob.ID, attachment.ID); err != nil {
+			t.Errorf("GetAttachment() by the recipient unexpected error = %v", err)
+		}
+		if _, err := service.GetAttachment(carol.ID, attachment.ID); !errors.Is(err, ErrAttachmentNotFound) {
+			t.Errorf("GetAttachment() by an outsider error = %v, want %v", err, ErrAttachmentNotFound)
+		}
+
+		messages, _ := service.GetMessagesBetweenUsers(bob.ID, "alice", "bob")
+		if len(messages) != 1 || len(messages[0].Attachments) != 1 {
+			t.Fatalf("GetMessagesBetweenUsers() = %+v, want the message with its attachment", messages)
+		}
+
+		if err := service.DeleteMessage(alice.ID, message.ID, false, ""); err != nil {
+			t.Fatalf("DeleteMessage() unexpected error = %v", err)
+		}
+		if _, err := store.GetBlob(attachmentKey(attachment.ID, AttachmentContent)); err == nil {
+			t.Error("GetBlob() after the message was deleted expected the content to be removed")
+		}
+		if _, err := service.GetAttachment(alice.ID, attachment.ID); !errors.Is(err, ErrAttachmentNotFound) {
+			t.Errorf("GetAttachment() after the message was deleted error = %v, want %v", err, ErrAttachmentNotFound)
+		}
+	})
+}
+
+func TestChatService_HeldMessageAttachments(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour)
+	words, _ := moderation.NewWordFilter([]string{"invoice"}, nil, moderation.Hold)
+	service := NewChatService(store, store, store, authService,
+		WithModeration(moderation.NewPipeline(words), store),
+		WithAttachments(store, store, AttachmentPolicy{
+			MaxBytes:     64 << 10,
+			AllowedTypes: []string{"text/plain"},
+			URLSecret:    []byte("url-secret"),
+			URLTTL:       time.Minute,
+		}),
+	)
+
+	moderator, _ := service.RegisterUser(models.RegisterRequest{Username: "moderator", Email: "moderator@example.com", Password: "password123"})
+	alice, _ := service.RegisterUser(models.RegisterRequest{Username: "alice", Email: "alice@example.com", Password: "password123"})
+	bob, _ := service.RegisterUser(models.RegisterRequest{Username: "bob", Email: "bob@example.com", Password: "password123"})
+	attachment, _ := service.UploadAttachment(alice.ID, "notes.txt", strings.NewReader("meeting notes"))
+
+	// hold sends a direct message with the attachment and returns the held message
+	hold := func() *models.HeldMessage {
+		var heldErr *MessageHeldError
+		_, err := service.SendMessage(models.MessageRequest{Sender: "alice", Recipient: "bob", Content: "the invoice", AttachmentIDs: []string{attachment.ID}})
+		if !errors.As(err, &heldErr) {
+			t.Fatalf("SendMessage() error = %v, want a MessageHeldError", err)
+		}
+		if !reflect.DeepEqual(heldErr.Held.AttachmentIDs, []string{attachment.ID}) {
+			t.Errorf("HeldMessage.AttachmentIDs = %v, want [%s]", heldErr.Held.AttachmentIDs, attachment.ID)
+		}
+		return heldErr.Held
+	}
+	first, second := hold(), hold()
+
+	message, err := service.ApproveHeldMessage(moderator.ID, first.ID, "")
+	if err != nil {
+		t.Fatalf("ApproveHeldMessage() unexpected error = %v", err)
+	}
+	if len(message.Attachments) != 1 || message.Attachments[0].ID != attachment.ID || message.Attachments[0].URL == "" {
+		t.Errorf("ApproveHeldMessage() attachments = %+v, want the signed attachment", message.Attachments)
+	}
+	messages, _ := service.GetMessagesBetweenUsers(bob.ID, "alice", "bob")
+	if len(messages) != 1 || len(messages[0].Attachments) != 1 {
+		t.Errorf("GetMessagesBetweenUsers() = %+v, want the approved message with its attachment", messages)
+	}
+
+	// The attachment now belongs to the approved message, so the other copy stays pending
+	if _, err := service.ApproveHeldMessage(moderator.ID, second.ID, ""); !errors.Is(err, ErrInvalidAttachments) {
+		t.Errorf("ApproveHeldMessage() with a sent attachment error = %v, want %v", err, ErrInvalidAttachments)
+	}
+	if held, _ := service.GetHeldMessages(""); len(held) != 1 || held[0].ID != second.ID {
+		t.Errorf("GetHeldMessages() = %+v, want the second message still pending", held)
+	}
+}
+
+// attachmentQuery returns a query parameter of a signed attachment link
+func attachmentQuery(link, name string) string {
+	parsed, _ := url.Parse(link)
+	return parsed.Query().Get(name)
+}
+
+func TestChatService_LinkPreviews(t *testing.T) {
+	var hits atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/article", func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, `<html><head><meta property="og:title" content="Go release notes"><meta property="og:image" content="/card.png"></head></html>`)
+	})
+	mux.HandleFunc("/bare", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, `<html><body>nothing to preview</body></html>`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	updates := make(chan *models.Message, 4)
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour)
+	service := NewChatService(store, store, store, authService,
+		WithLinkPreviews(store, unfurl.NewFetcher(unfurl.Config{Timeout: time.Second, AllowLoopback: true}), PreviewPolicy{
+			MaxLinks: 2,
+			CacheTTL: time.Hour,
+		}, func(message *models.Message) { updates <- message }),
+	)
+
+	service.RegisterUser(models.RegisterRequest{Username: "alice", Email: "alice@example.com", Password: "password123"})
+	service.RegisterUser(models.RegisterRequest{Username: "bob", Email: "bob@example.com", Password: "password123"})
+
+	// send posts a direct message and waits for its previews, if any
+	send := func(content string) (*models.Message, *models.Message) {
+		message, err := service.SendMessage(models.MessageRequest{Sender: "alice", Recipient: "bob", Content: content})
+		if err != nil {
+			t.Fatalf("SendMessage() unexpected error = %v", err)
+		}
+		select {
+		case updated := <-updates:
+			return message, updated
+		case <-time.After(time.Second):
+			return message, nil
+		}
+	}
+
+	message, updated := send("read " + server.URL + "/article and " + server.URL + "/bare, or http://10.0.0.1/")
+	if len(message.Previews) != 0 {
+		t.Errorf("SendMessage() previews = %v, want them fetched in the background", message.Previews)
+	}
+	if updated == nil {
+		t.Fatal("expected a message update with the previews")
+	}
+	if updated.ID != message.ID || len(updated.Previews) != 1 {
+		t.Fatalf("updated message = %+v, want one preview of %s", updated, message.ID)
+	}
+	preview := updated.Previews[0]
+	if preview.URL != server.URL+"/article" || preview.Title != "Go release notes" || preview.ImageURL != server.URL+"/card.png" {
+		t.Errorf("preview = %+v, want the page's OpenGraph metadata", preview)
+	}
+
+	messages, err := service.GetMessagesBetweenUsers("", "alice", "bob")
+	if err != nil || len(messages) != 1 || len(messages[0].Previews) != 1 {
+		t.Fatalf("GetMessagesBetweenUsers() = %+v, %v, want the stored preview", messages, err)
+	}
+
+	// A second message linking the same page uses the cached preview
+	_, updated = send("again: " + server.URL + "/article")
+	if updated == nil || len(updated.Previews) != 1 || updated.Previews[0].Title != "Go release notes" {
+		t.Fatalf("updated message = %+v, want the cached preview", updated)
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("page fetched %d times, want 1", n)
+	}
+
+	// Messages without previewable links get no update
+	if _, updated = send("no links here, just " + server.URL + "/bare"); updated != nil {
+		t.Errorf("updated message = %+v, want no update", updated)
+	}
+}
+
+func TestChatService_MessageFormats(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour)
+	words, _ := moderation.NewWordFilter([]string{"darn"}, nil, moderation.Mask)
+	pipeline := moderation.NewPipeline(words, moderation.NewSpamFilter(store, moderation.SpamPolicy{MaxMentions: 2}, moderation.Hold))
+	service := NewChatService(store, store, store, authService, WithModeration(pipeline, store))
+
+	moderator, _ := service.RegisterUser(models.RegisterRequest{Username: "moderator", Email: "moderator@example.com", Password: "password123"})
+	service.RegisterUser(models.RegisterRequest{Username: "alice", Email: "alice@example.com", Password: "password123"})
+	service.RegisterUser(models.RegisterRequest{Username: "bob", Email: "bob@example.com", Password: "password123"})
+
+	tests := []struct {
+		name        string
+		format      string
+		content     string
+		wantFormat  string
+		wantHTML    string
+		wantContent string
+		wantErr     error
+	}{
+		{name: "default", content: "**hi**", wantFormat: models.MessageFormatPlain, wantContent: "**hi**"},
+		{name: "plain", format: "plain", content: "<b>hi</b>", wantFormat: models.MessageFormatPlain, wantContent: "<b>hi</b>"},
+		{name: "markdown", format: "markdown", content: "**hi** <script>alert(1)</script>", wantFormat: models.MessageFormatMarkdown,
+			wantHTML: "<p><strong>hi</strong> &lt;script&gt;alert(1)&lt;/script&gt;</p>", wantContent: "**hi** <script>alert(1)</script>"},
+		{name: "rendered after masking", format: "markdown", content: "darn, *really*", wantFormat: models.MessageFormatMarkdown,
+			wantHTML: "<p>****, <em>really</em></p>", wantContent: "****, *really*"},
+		{name: "unknown", format: "html", content: "<b>hi</b>", wantErr: ErrInvalidMessageFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, err := service.SendMessage(models.MessageRequest{Sender: "alice", Recipient: "bob", Content: tt.content, Format: tt.format})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SendMessage() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if message.Format != tt.wantFormat || message.HTML != tt.wantHTML || message.Content != tt.wantContent {
+				t.Errorf("SendMessage() = %q/%q/%q, want %q/%q/%q", message.Format, message.Content, message.HTML,
+					tt.wantFormat, tt.wantContent, tt.wantHTML)
+			}
+
+			stored, _ := store.GetMessage(message.ID)
+			if stored == nil || stored.Format != message.Format || stored.HTML != message.HTML {
+				t.Errorf("stored message = %+v, want the source and rendered forms", stored)
+			}
+		})
+	}
+
+	t.Run("held messages keep their format", func(t *testing.T) {
+		var heldErr *MessageHeldError
+		_, err := service.SendMessage(models.MessageRequest{Sender: "alice", Recipient: "bob", Content: "@a @b @c **now**", Format: "markdown"})
+		if !errors.As(err, &heldErr) {
+			t.Fatalf("SendMessage() error = %v, want a MessageHeldError", err)
+		}
+
+		message, err := service.ApproveHeldMessage(moderator.ID, heldErr.Held.ID, "")
+		if err != nil {
+			t.Fatalf("ApproveHeldMessage() unexpected error = %v", err)
+		}
+		if message.Format != models.MessageFormatMarkdown || message.HTML != "<p>@a @b @c <strong>now</strong></p>" {
+			t.Errorf("ApproveHeldMessage() = %q/%q, want the rendered Markdown", message.Format, message.HTML)
+		}
+	})
+}
+
+// onlineUsers is a Presence reporting the given usernames as connected
+type onlineUsers map[string]bool
+
+func (o onlineUsers) IsUserOnline(username string) bool {
+	return o[username]
+}
+
+func TestChatService_Mentions(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour)
+
+	// events collects the mention events delivered to each user ID
+	events := make(map[string][]map[string]interface{})
+	service := NewChatService(store, store, store, authService,
+		WithBlockStore(store),
+		WithMentions(store, onlineUsers{"carol": true, "dave": true}, func(userIDs []string, event map[string]interface{}) {
+			for _, id := range userIDs {
+				events[id] = append(events[id], event)
+			}
+		}),
+	)
+
+	register := func(name string) *models.User {
+		user, err := service.RegisterUser(models.RegisterRequest{Username: name, Email: name + "@example.com", Password: "password123"})
+		if err != nil {
+			t.Fatalf("RegisterUser(%s) unexpected error = %v", name, err)
+		}
+		return user
+	}
+	alice, bob, carol, _, eve, frank := register("alice"), register("bob"), register("carol"), register("dave"), register("eve"), register("frank")
+
+	room, _ := service.CreateRoom(models.CreateRoomRequest{Name: "general"})
+	for _, member := range []*models.User{alice, bob, carol, eve, frank} {
+		store.AddUserToRoom(room.ID, member.ID)
+	}
+	if _, err := service.MuteRoomMember(bob.ID, room.ID, frank.ID, models.MuteRequest{}, ""); err != nil {
+		t.Fatalf("MuteRoomMember() unexpected error = %v", err)
+	}
+	if _, err := service.BlockUser(eve.ID, alice.ID); err != nil {
+		t.Fatalf("BlockUser() unexpected error = %v", err)
+	}
+
+	// mentioned sends a message as alice and returns the kind of mention each user got
+	mentioned := func(req models.MessageRequest) map[string]string {
+		events = make(map[string][]map[string]interface{})
+		req.Sender = "alice"
+		if _, err := service.SendMessage(req); err != nil {
+			t.Fatalf("SendMessage() unexpected error = %v", err)
+		}
+		kinds := make(map[string]string)
+		for id, list := range events {
+			for _, event := range list {
+				mention := event["mention"].(models.Mention)
+				if event["type"] != "mention" || event["priority"] != "high" || mention.UserID != id || mention.Message == nil {
+					t.Errorf("mention event = %+v, want a high-priority mention with its message", event)
+				}
+				kinds[id] = mention.Kind
+			}
+		}
+		return kinds
+	}
+
+	tests := []struct {
+		name string
+		req  models.MessageRequest
+		want map[string]string
+	}{
+		{
+			name: "users who can read the room",
+			req:  models.MessageRequest{RoomID: room.ID, Content: "@bob, @dave and @alice: mail bob@example.com or @nobody"},
+			want: map[string]string{bob.ID: models.MentionUser},
+		},
+		{
+			name: "here reaches online members",
+			req:  models.MessageRequest{RoomID: room.ID, Content: "@here standup"},
+			want: map[string]string{carol.ID: models.MentionHere},
+		},
+		{
+			name: "room reaches muted members but not those who blocked the sender",
+			req:  models.MessageRequest{RoomID: room.ID, Content: "@room release is out, thanks @bob"},
+			want: map[string]string{bob.ID: models.MentionUser, carol.ID: models.MentionRoom, frank.ID: models.MentionRoom},
+		},
+		{
+			name: "direct messages only mention the recipient",
+			req:  models.MessageRequest{Recipient: "bob", Content: "@bob ask @carol, @room"},
+			want: map[string]string{bob.ID: models.MentionUser},
+		},
+		{
+			name: "no mentions",
+			req:  models.MessageRequest{RoomID: room.ID, Content: "hello everyone"},
+			want: map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mentioned(tt.req); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mentions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("inbox", func(t *testing.T) {
+		mentions, err := service.GetMentions(bob.ID)
+		if err != nil || len(mentions) != 3 {
+			t.Fatalf("GetMentions() = %d mentions, %v, want 3", len(mentions), err)
+		}
+		if mentions[0].Message == nil || mentions[0].Message.Recipient != "bob" {
+			t.Errorf("GetMentions() first = %+v, want the newest mention with its message", mentions[0])
+		}
+
+		if marked, err := service.MarkMentionsRead(bob.ID, []string{mentions[0].ID, "missing"}); err != nil || marked != 1 {
+			t.Errorf("MarkMentionsRead() = %d, %v, want 1", marked, err)
+		}
+		if marked, _ := service.MarkMentionsRead(carol.ID, []string{mentions[1].ID}); marked != 0 {
+			t.Errorf("MarkMentionsRead() of another user's mention = %d, want 0", marked)
+		}
+		if marked, err := service.MarkMentionsRead(bob.ID, nil); err != nil || marked != 2 {
+			t.Errorf("MarkMentionsRead() of all = %d, %v, want 2", marked, err)
+		}
+		if mentions, _ := service.GetMentions(bob.ID); len(mentions) != 0 {
+			t.Errorf("GetMentions() after reading = %d mentions, want none", len(mentions))
+		}
+
+		// Mentions in rooms the user left, or by users they blocked, are hidden
+		if mentions, _ := service.GetMentions(carol.ID); len(mentions) != 2 {
+			t.Fatalf("GetMentions() = %d mentions, want 2", len(mentions))
+		}
+		store.RemoveUserFromRoom(room.ID, carol.ID)
+		if mentions, _ := service.GetMentions(carol.ID); len(mentions) != 0 {
+			t.Errorf("GetMentions() after leaving the room = %d mentions, want none", len(mentions))
+		}
+		service.BlockUser(frank.ID, alice.ID)
+		if mentions, _ := service.GetMentions(frank.ID); len(mentions) != 0 {
+			t.Errorf("GetMentions() after blocking the sender = %d mentions, want none", len(mentions))
+		}
+	})
+
+	if _, err := NewChatService(store, store, store, authService).GetMentions(bob.ID); !errors.Is(err, ErrMentionsDisabled) {
+		t.Errorf("GetMentions() without a mention store error = %v, want %v", err, ErrMentionsDisabled)
+	}
+}
+
+func TestChatService_PinsAndSavedMessages(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour)
+
+	var events []map[string]interface{}
+	var recipients [][]string
+	service := NewChatService(store, store, store, authService,
+		WithBlockStore(store),
+		WithAuditLogger(audit.NewLogger(store)),
+		WithPins(store, 2, func(userIDs []string, event map[string]interface{}) {
+			recipients = append(recipients, userIDs)
+			events = append(events, event)
+		}),
+		WithSavedMessages(store),
+	)
+
+	register := func(name string) *models.User {
+		user, err := service.RegisterUser(models.RegisterRequest{Username: name, Email: name + "@example.com", Password: "password123"})
+		if err != nil {
+			t.Fatalf("RegisterUser(%s) unexpected error = %v", name, err)
+		}
+		return user
+	}
+	moderator, alice, bob, carol, dave := register("moderator"), register("alice"), register("bob"), register("carol"), register("dave")
+
+	room, _ := service.CreateRoom(models.CreateRoomRequest{Name: "general"})
+	other, _ := service.CreateRoom(models.CreateRoomRequest{Name: "other"})
+	for _, member := range []*models.User{alice, bob, dave} {
+		store.AddUserToRoom(room.ID, member.ID)
+	}
+	store.AddUserToRoom(other.ID, alice.ID)
+	if _, err := service.BlockUser(dave.ID, alice.ID); err != nil {
+		t.Fatalf("BlockUser() unexpected error = %v", err)
+	}
+
+	send := func(req models.MessageRequest) *models.Message {
+		req.Sender = "alice"
+		message, err := service.SendMessage(req)
+		if err != nil {
+			t.Fatalf("SendMessage() unexpected error = %v", err)
+		}
+		return message
+	}
+	first := send(models.MessageRequest{RoomID: room.ID, Content: "first"})
+	second := send(models.MessageRequest{RoomID: room.ID, Content: "second"})
+	third := send(models.MessageRequest{RoomID: room.ID, Content: "third"})
+	elsewhere := send(models.MessageRequest{RoomID: other.ID, Content: "elsewhere"})
+	direct := send(models.MessageRequest{Recipient: "bob", Content: "direct"})
+	global := send(models.MessageRequest{Content: "global"})
+
+	t.Run("pins", func(t *testing.T) {
+		pin, err := service.PinMessage(moderator.ID, room.ID, first.ID, "")
+		if err != nil {
+			t.Fatalf("PinMessage() unexpected error = %v", err)
+		}
+		if pin.PinnedBy != moderator.ID || pin.Message == nil || pin.Message.Content != "first" {
+			t.Errorf("PinMessage() = %+v, want the pinned message", pin)
+		}
+		if len(events) != 1 || events[0]["type"] != "pin_added" || !reflect.DeepEqual(recipients[0], []string{alice.ID, bob.ID}) {
+			t.Errorf("pin events = %v to %v, want pin_added to the members who did not block the sender", events, recipients)
+		}
+
+		// Pinning again changes nothing
+		if _, err := service.PinMessage(moderator.ID, room.ID, first.ID, ""); err != nil {
+			t.Errorf("PinMessage() of a pinned message unexpected error = %v", err)
+		}
+		if _, err := service.PinMessage(moderator.ID, room.ID, second.ID, ""); err != nil {
+			t.Fatalf("PinMessage() unexpected error = %v", err)
+		}
+
+		errorTests := []struct {
+			name      string
+			roomID    string
+			messageID string
+			want      error
+		}{
+			{name: "limit reached", roomID: room.ID, messageID: third.ID, want: ErrPinLimitReached},
+			{name: "message of another room", roomID: room.ID, messageID: elsewhere.ID, want: ErrMessageNotFound},
+			{name: "direct message", roomID: room.ID, messageID: direct.ID, want: ErrMessageNotFound},
+			{name: "unknown room", roomID: "missing", messageID: first.ID, want: ErrRoomNotFound},
+		}
+		for _, tt := range errorTests {
+			t.Run(tt.name, func(t *testing.T) {
+				if _, err := service.PinMessage(moderator.ID, tt.roomID, tt.messageID, ""); !errors.Is(err, tt.want) {
+					t.Errorf("PinMessage() error = %v, want %v", err, tt.want)
+				}
+			})
+		}
+
+		pins, err := service.GetPins(bob.ID, room.ID)
+		if err != nil || len(pins) != 2 || pins[0].MessageID != second.ID || pins[1].MessageID != first.ID {
+			t.Fatalf("GetPins() = %+v, %v, want the second and first messages", pins, err)
+		}
+		if _, err := service.GetPins(carol.ID, room.ID); !errors.Is(err, ErrNotRoomMember) {
+			t.Errorf("GetPins() by a non-member error = %v, want %v", err, ErrNotRoomMember)
+		}
+		if pins, _ := service.GetPins(dave.ID, room.ID); len(pins) != 0 {
+			t.Errorf("GetPins() by a member who blocked the sender = %d pins, want none", len(pins))
+		}
+
+		events = nil
+		if err := service.UnpinMessage(moderator.ID, room.ID, first.ID, ""); err != nil {
+			t.Fatalf("UnpinMessage() unexpected error = %v", err)
+		}
+		if len(events) != 1 || events[0]["type"] != "pin_removed" || events[0]["message_id"] != first.ID {
+			t.Errorf("unpin events = %v, want pin_removed", events)
+		}
+		if err := service.UnpinMessage(moderator.ID, room.ID, first.ID, ""); !errors.Is(err, ErrPinNotFound) {
+			t.Errorf("UnpinMessage() of an unpinned message error = %v, want %v", err, ErrPinNotFound)
+		}
+
+		// Deleted messages are unpinned
+		if err := service.DeleteMessage(alice.ID, second.ID, false, ""); err != nil {
+			t.Fatalf("DeleteMessage() unexpected error = %v", err)
+		}
+		if pins, _ := service.GetPins(bob.ID, room.ID); len(pins) != 0 {
+			t.Errorf("GetPins() after deleting the message = %d pins, want none", len(pins))
+		}
+
+		audited, _ := store.GetAuditEvents()
+		pinned := 0
+		for _, event := range audited {
+			if event.Action == audit.ActionMessagePinned || event.Action == audit.ActionMessageUnpinned {
+				pinned++
+			}
+		}
+		if pinned != 4 {
+			t.Errorf("pin audit events = %d, want 4", pinned)
+		}
+	})
+
+	t.Run("saved messages", func(t *testing.T) {
+		saved, err := service.SaveMessage(bob.ID, third.ID, "  read later ")
+		if err != nil || saved.Note != "read later" {
+			t.Fatalf("SaveMessage() = %+v, %v, want the trimmed note", saved, err)
+		}
+		createdAt := saved.CreatedAt
+		for _, id := range []string{direct.ID, global.ID} {
+			if _, err := service.SaveMessage(bob.ID, id, ""); err != nil {
+				t.Fatalf("SaveMessage() unexpected error = %v", err)
+			}
+		}
+
+		errorTests := []struct {
+			name      string
+			messageID string
+			note      string
+			want      error
+		}{
+			{name: "room message of a non-member", messageID: third.ID, want: ErrMessageNotFound},
+			{name: "direct message of others", messageID: direct.ID, want: ErrMessageNotFound},
+			{name: "unknown message", messageID: "missing", want: ErrMessageNotFound},
+			{name: "note too long", messageID: global.ID, note: strings.Repeat("a", maxSavedNoteLength+1), want: ErrNoteTooLong},
+		}
+		for _, tt := range errorTests {
+			t.Run(tt.name, func(t *testing.T) {
+				if _, err := service.SaveMessage(carol.ID, tt.messageID, tt.note); !errors.Is(err, tt.want) {
+					t.Errorf("SaveMessage() error = %v, want %v", err, tt.want)
+				}
+			})
+		}
+
+		// Saving again replaces the note and keeps the bookmark's place
+		saved, err = service.SaveMessage(bob.ID, third.ID, "updated")
+		if err != nil || saved.Note != "updated" || !saved.CreatedAt.Equal(createdAt) {
+			t.Errorf("SaveMessage() again = %+v, %v, want the new note and the original time", saved, err)
+		}
+
+		list, err := service.GetSavedMessages(bob.ID)
+		if err != nil || len(list) != 3 || list[0].MessageID != global.ID || list[2].MessageID != third.ID || list[2].Message.Content != "third" {
+			t.Fatalf("GetSavedMessages() = %+v, %v, want the global, direct and room messages", list, err)
+		}
+
+		// Bookmarks in rooms the user left are hidden until they rejoin
+		store.RemoveUserFromRoom(room.ID, bob.ID)
+		if list, _ := service.GetSavedMessages(bob.ID); len(list) != 2 {
+			t.Errorf("GetSavedMessages() after leaving the room = %d, want 2", len(list))
+		}
+		store.AddUserToRoom(room.ID, bob.ID)
+		if list, _ := service.GetSavedMessages(bob.ID); len(list) != 3 {
+			t.Errorf("GetSavedMessages() after rejoining the room = %d, want 3", len(list))
+		}
+
+		if err := service.UnsaveMessage(bob.ID, direct.ID); err != nil {
+			t.Fatalf("UnsaveMessage() unexpected error = %v", err)
+		}
+		if err := service.UnsaveMessage(bob.ID, direct.ID); !errors.Is(err, ErrSavedMessageNotFound) {
+			t.Errorf("UnsaveMessage() of an unsaved message error = %v, want %v", err, ErrSavedMessageNotFound)
+		}
+	})
+
+	disabled := NewChatService(store, store, store, authService)
+	if _, err := disabled.GetPins(bob.ID, room.ID); !errors.Is(err, ErrPinsDisabled) {
+		t.Errorf("GetPins() without a pin store error = %v, want %v", err, ErrPinsDisabled)
+	}
+	if _, err := disabled.GetSavedMessages(bob.ID); !errors.Is(err, ErrSavedMessagesDisabled) {
+		t.Errorf("GetSavedMessages() without a saved message store error = %v, want %v", err, ErrSavedMessagesDisabled)
+	}
+}
+
+func TestChatService_ScheduledMessages(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour)
+
+	var events []map[string]interface{}
+	service := NewChatService(store, store, store, authService,
+		WithBlockStore(store),
+		WithScheduling(store, func(userIDs []string, event map[string]interface{}) {
+			events = append(events, event)
+		}),
+	)
+
+	register := func(name string) *models.User {
+		user, err := service.RegisterUser(models.RegisterRequest{Username: name, Email: name + "@example.com", Password: "password123"})
+		if err != nil {
+			t.Fatalf("RegisterUser(%s) unexpected error = %v", name, err)
+		}
+		return user
+	}
+	alice, bob, carol := register("alice"), register("bob"), register("carol")
+
+	room, _ := service.CreateRoom(models.CreateRoomRequest{Name: "general"})
+	store.AddUserToRoom(room.ID, alice.ID)
+	store.AddUserToRoom(room.ID, bob.ID)
+	if _, err := service.BlockUser(carol.ID, alice.ID); err != nil {
+		t.Fatalf("BlockUser() unexpected error = %v", err)
+	}
+
+	later := time.Now().Add(time.Hour)
+
+	t.Run("schedule messages", func(t *testing.T) {
+		errorTests := []struct {
+			name string
+			req  models.ScheduleMessageRequest
+			want error
+		}{
+			{name: "empty content", req: models.ScheduleMessageRequest{RoomID: room.ID, SendAt: later}, want: ErrInvalidSchedule},
+			{name: "no target", req: models.ScheduleMessageRequest{Content: "hi", SendAt: later}, want: ErrInvalidSchedule},
+			{name: "room and recipient", req: models.ScheduleMessageRequest{Content: "hi", RoomID: room.ID, Recipient: "bob", SendAt: later}, want: ErrInvalidSchedule},
+			{name: "in the past", req: models.ScheduleMessageRequest{Content: "hi", RoomID: room.ID, SendAt: time.Now().Add(-time.Minute)}, want: ErrInvalidSchedule},
+			{name: "too far ahead", req: models.ScheduleMessageRequest{Content: "hi", RoomID: room.ID, SendAt: time.Now().Add(2 * maxScheduleAhead)}, want: ErrInvalidSchedule},
+			{name: "invalid format", req: models.ScheduleMessageRequest{Content: "hi", RoomID: room.ID, Format: "html", SendAt: later}, want: ErrInvalidMessageFormat},
+			{name: "unknown room", req: models.ScheduleMessageRequest{Content: "hi", RoomID: "missing", SendAt: later}, want: ErrRoomNotFound},
+			{name: "unknown recipient", req: models.ScheduleMessageRequest{Content: "hi", Recipient: "nobody", SendAt: later}, want: ErrInvalidSchedule},
+			{name: "blocked recipient", req: models.ScheduleMessageRequest{Content: "hi", Recipient: "carol", SendAt: later}, want: ErrBlocked},
+		}
+		for _, tt := range errorTests {
+			t.Run(tt.name, func(t *testing.T) {
+				if _, err := service.ScheduleMessage(alice.ID, tt.req); !errors.Is(err, tt.want) {
+					t.Errorf("ScheduleMessage() error = %v, want %v", err, tt.want)
+				}
+			})
+		}
+		if _, err := service.ScheduleMessage(carol.ID, models.ScheduleMessageRequest{Content: "hi", RoomID: room.ID, SendAt: later}); !errors.Is(err, ErrNotRoomMember) {
+			t.Errorf("ScheduleMessage() by a non-member error = %v, want %v", err, ErrNotRoomMember)
+		}
+
+		second, err := service.ScheduleMessage(alice.ID, models.ScheduleMessageRequest{Content: "second", RoomID: room.ID, SendAt: later.Add(time.Minute)})
+		if err != nil {
+			t.Fatalf("ScheduleMessage() unexpected error = %v", err)
+		}
+		first, err := service.ScheduleMessage(alice.ID, models.ScheduleMessageRequest{Content: "first", Recipient: "bob", SendAt: later})
+		if err != nil {
+			t.Fatalf("ScheduleMessage() unexpected error = %v", err)
+		}
+		if first.Status != models.SchedulePending || first.Format != models.MessageFormatPlain {
+			t.Errorf("ScheduleMessage() = %+v, want a pending plain message", first)
+		}
+
+		scheduled, err := service.GetScheduledMessages(alice.ID)
+		if err != nil || len(scheduled) != 2 || scheduled[0].ID != first.ID || scheduled[1].ID != second.ID {
+			t.Fatalf("GetScheduledMessages() = %+v, %v, want both messages soonest first", scheduled, err)
+		}
+		if scheduled, _ := service.GetScheduledMessages(bob.ID); scheduled == nil || len(scheduled) != 0 {
+			t.Errorf("GetScheduledMessages() of another user = %v, want an empty list", scheduled)
+		}
+
+		if err := service.CancelScheduledMessage(bob.ID, second.ID); !errors.Is(err, ErrScheduledMessageNotFound) {
+			t.Errorf("CancelScheduledMessage() by another user error = %v, want %v", err, ErrScheduledMessageNotFound)
+		}
+		if err := service.CancelScheduledMessage(alice.ID, second.ID); err != nil {
+			t.Fatalf("CancelScheduledMessage() unexpected error = %v", err)
+		}
+		if err := service.CancelScheduledMessage(alice.ID, second.ID); !errors.Is(err, ErrScheduledMessageNotFound) {
+			t.Errorf("CancelScheduledMessage() twice error = %v, want %v", err, ErrScheduledMessageNotFound)
+		}
+		if scheduled, _ := service.GetScheduledMessages(alice.ID); len(scheduled) != 1 {
+			t.Errorf("GetScheduledMessages() after cancelling = %d messages, want 1", len(scheduled))
+		}
+	})
+
+	t.Run("send scheduled messages", func(t *testing.T) {
+		message, err := service.SendScheduledMessage(models.ScheduledMessage{UserID: alice.ID, RoomID: room.ID, Content: "**hello**", Format: models.MessageFormatMarkdown})
+		if err != nil {
+			t.Fatalf("SendScheduledMessage() unexpected error = %v", err)
+		}
+		if message.Sender != "alice" || message.RoomID != room.ID || message.Format != models.MessageFormatMarkdown {
+			t.Errorf("SendScheduledMessage() = %+v, want a markdown room message from alice", message)
+		}
+
+		// Members who left the room since scheduling can no longer post to it
+		store.RemoveUserFromRoom(room.ID, bob.ID)
+		if _, err := service.SendScheduledMessage(models.ScheduledMessage{UserID: bob.ID, RoomID: room.ID, Content: "hi"}); !errors.Is(err, ErrNotRoomMember) {
+			t.Errorf("SendScheduledMessage() by a former member error = %v, want %v", err, ErrNotRoomMember)
+		}
+		store.AddUserToRoom(room.ID, bob.ID)
+	})
+
+	t.Run("reminders", func(t *testing.T) {
+		message, _ := service.SendMessage(models.MessageRequest{Sender: "alice", RoomID: room.ID, Content: "remember me"})
+
+		if _, err := service.SetReminder(carol.ID, message.ID, models.ReminderRequest{RemindAt: later}); !errors.Is(err, ErrMessageNotFound) {
+			t.Errorf("SetReminder() by a non-member error = %v, want %v", err, ErrMessageNotFound)
+		}
+		if _, err := service.SetReminder(bob.ID, message.ID, models.ReminderRequest{RemindAt: time.Now()}); !errors.Is(err, ErrInvalidSchedule) {
+			t.Errorf("SetReminder() in the past error = %v, want %v", err, ErrInvalidSchedule)
+		}
+		if _, err := service.SetReminder(bob.ID, message.ID, models.ReminderRequest{Note: strings.Repeat("x", maxSavedNoteLength+1), RemindAt: later}); !errors.Is(err, ErrNoteTooLong) {
+			t.Errorf("SetReminder() with a long note error = %v, want %v", err, ErrNoteTooLong)
+		}
+
+		reminder, err := service.SetReminder(bob.ID, message.ID, models.ReminderRequest{Note: " follow up ", RemindAt: later})
+		if err != nil {
+			t.Fatalf("SetReminder() unexpected error = %v", err)
+		}
+		if reminder.Note != "follow up" || reminder.Message == nil || reminder.Message.ID != message.ID {
+			t.Errorf("SetReminder() = %+v, want the trimmed note and the message", reminder)
+		}
+
+		reminders, err := service.GetReminders(bob.ID)
+		if err != nil || len(reminders) != 1 || reminders[0].Message == nil || reminders[0].Message.Content != "remember me" {
+			t.Fatalf("GetReminders() = %+v, %v, want the reminder with its message", reminders, err)
+		}
+
+		if err := service.SendReminder(*reminder); err != nil {
+			t.Fatalf("SendReminder() unexpected error = %v", err)
+		}
+		if len(events) != 1 || events[0]["type"] != "reminder" {
+			t.Errorf("reminder events = %v, want one reminder event", events)
+		}
+
+		// Reminders of messages the user can no longer read are not sent
+		store.RemoveUserFromRoom(room.ID, bob.ID)
+		if err := service.SendReminder(*reminder); !errors.Is(err, ErrMessageNotFound) {
+			t.Errorf("SendReminder() for a former member error = %v, want %v", err, ErrMessageNotFound)
+		}
+		store.AddUserToRoom(room.ID, bob.ID)
+
+		if err := service.CancelReminder(alice.ID, reminder.ID); !errors.Is(err, ErrReminderNotFound) {
+			t.Errorf("CancelReminder() by another user error = %v, want %v", err, ErrReminderNotFound)
+		}
+		if err := service.CancelReminder(bob.ID, reminder.ID); err != nil {
+			t.Fatalf("CancelReminder() unexpected error = %v", err)
+		}
+		if reminders, _ := service.GetReminders(bob.ID); len(reminders) != 0 {
+			t.Errorf("GetReminders() after cancelling = %d reminders, want none", len(reminders))
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		disabled := NewChatService(store, store, store, authService)
+		if _, err := disabled.ScheduleMessage(alice.ID, models.ScheduleMessageRequest{Content: "hi", RoomID: room.ID, SendAt: later}); !errors.Is(err, ErrSchedulingDisabled) {
+			t.Errorf("ScheduleMessage() error = %v, want %v", err, ErrSchedulingDisabled)
+		}
+		if _, err := disabled.GetReminders(alice.ID); !errors.Is(err, ErrSchedulingDisabled) {
+			t.Errorf("GetReminders() error = %v, want %v", err, ErrSchedulingDisabled)
+		}
+	})
+}
+
+func TestChatService_DisappearingMessages(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour)
+	service := NewChatService(store, store, store, authService,
+		WithAttachments(store, store, AttachmentPolicy{
+			MaxBytes:     64 << 10,
+			AllowedTypes: []string{"text/plain"},
+			URLSecret:    []byte("url-secret"),
+			URLTTL:       time.Minute,
+		}),
+	)
+
+	alice, _ := service.RegisterUser(models.RegisterRequest{Username: "alice", Email: "alice@example.com", Password: "password123"})
+	service.RegisterUser(models.RegisterRequest{Username: "bob", Email: "bob@example.com", Password: "password123"})
+	room, _ := service.CreateRoom(models.CreateRoomRequest{Name: "general"})
+	store.AddUserToRoom(room.ID, alice.ID)
+
+	send := func(req models.MessageRequest) *models.Message {
+		req.Sender = "alice"
+		req.Content = "hello"
+		message, err := service.SendMessage(req)
+		if err != nil {
+			t.Fatalf("SendMessage() unexpected error = %v", err)
+		}
+		return message
+	}
+
+	t.Run("validation", func(t *testing.T) {
+		for _, ttl := range []int{-1, maxMessageTTLSeconds + 1} {
+			if _, err := service.SendMessage(models.MessageRequest{Sender: "alice", Content: "hi", TTLSeconds: ttl}); !errors.Is(err, ErrInvalidMessageTTL) {
+				t.Errorf("SendMessage() with a %d second TTL error = %v, want %v", ttl, err, ErrInvalidMessageTTL)
+			}
+			if _, err := service.UpdateRoomSettings(alice.ID, room.ID, models.RoomSettingsRequest{MessageTTLSeconds: ttl}, ""); !errors.Is(err, ErrInvalidMessageTTL) {
+				t.Errorf("UpdateRoomSettings() with a %d second TTL error = %v, want %v", ttl, err, ErrInvalidMessageTTL)
+			}
+		}
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		kept := send(models.MessageRequest{RoomID: room.ID})
+		if kept.ExpiresAt != nil {
+			t.Errorf("SendMessage() without a TTL expires at %v, want never", kept.ExpiresAt)
+		}
+
+		direct := send(models.MessageRequest{Recipient: "bob", TTLSeconds: 60})
+		if direct.ExpiresAt == nil || !direct.ExpiresAt.Equal(direct.Timestamp.Add(time.Minute)) {
+			t.Errorf("SendMessage() with a 60 second TTL expires at %v, want a minute after it was sent", direct.ExpiresAt)
+		}
+
+		if _, err := service.UpdateRoomSettings(alice.ID, room.ID, models.RoomSettingsRequest{MessageTTLSeconds: 3600}, ""); err != nil {
+			t.Fatalf("UpdateRoomSettings() unexpected error = %v", err)
+		}
+		tests := []struct {
+			name string
+			ttl  int
+			want time.Duration
+		}{
+			{name: "room default", ttl: 0, want: time.Hour},
+			{name: "shorter than the room default", ttl: 60, want: time.Minute},
+			{name: "capped by the room default", ttl: 7200, want: time.Hour},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				message := send(models.MessageRequest{RoomID: room.ID, TTLSeconds: tt.ttl})
+				if message.ExpiresAt == nil || !message.ExpiresAt.Equal(message.Timestamp.Add(tt.want)) {
+					t.Errorf("SendMessage() expires at %v, want %v after it was sent", message.ExpiresAt, tt.want)
+				}
+			})
+		}
+		service.UpdateRoomSettings(alice.ID, room.ID, models.RoomSettingsRequest{}, "")
+	})
+
+	t.Run("expire messages", func(t *testing.T) {
+		attachment, err := service.UploadAttachment(alice.ID, "note.txt", strings.NewReader("secret note"))
+		if err != nil {
+			t.Fatalf("UploadAttachment() unexpected error = %v", err)
+		}
+		message := send(models.MessageRequest{RoomID: room.ID, TTLSeconds: 30, AttachmentIDs: []string{attachment.ID}})
+
+		if expired, err := service.ExpireMessages(time.Now(), 100); err != nil || len(expired) != 0 {
+			t.Fatalf("ExpireMessages() before the TTL = %v, %v, want nothing", expired, err)
+		}
+
+		// The earlier messages expire within an hour too
+		expired, err := service.ExpireMessages(time.Now().Add(2*time.Hour), 100)
+		if err != nil {
+			t.Fatalf("ExpireMessages() unexpected error = %v", err)
+		}
+		found := false
+		for _, m := range expired {
+			found = found || m.ID == message.ID
+		}
+		if len(expired) != 5 || !found {
+			t.Errorf("ExpireMessages() = %d messages, want the 5 disappearing messages", len(expired))
+		}
+		if deleted, _ := store.GetMessage(message.ID); deleted != nil {
+			t.Errorf("GetMessage() after expiry = %+v, want nil", deleted)
+		}
+		if _, err := service.GetAttachment(alice.ID, attachment.ID); !errors.Is(err, ErrAttachmentNotFound) {
+			t.Errorf("GetAttachment() after expiry error = %v, want %v", err, ErrAttachmentNotFound)
+		}
+		if _, err := store.GetBlob(attachmentKey(attachment.ID, AttachmentContent)); err == nil {
+			t.Errorf("GetBlob() after expiry found the attachment file, want it deleted")
+		}
+		if messages, _ := store.GetMessagesByRoom(room.ID); len(messages) != 1 {
+			t.Errorf("room messages after expiry = %d, want only the kept message", len(messages))
+		}
+	})
+}
+
+func TestChatService_Polls(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour)
+
+	var events []map[string]interface{}
+	var recipients [][]string
+	service := NewChatService(store, store, store, authService,
+		WithBlockStore(store),
+		WithPolls(store, func(userIDs []string, event map[string]interface{}) {
+			recipients = append(recipients, userIDs)
+			events = append(events, event)
+		}),
+	)
+
+	register := func(name string) *models.User {
+		user, err := service.RegisterUser(models.RegisterRequest{Username: name, Email: name + "@example.com", Password: "password123"})
+		if err != nil {
+			t.Fatalf("RegisterUser(%s) unexpected error = %v", name, err)
+		}
+		return user
+	}
+	alice, bob, carol, dave := register("alice"), register("bob"), register("carol"), register("dave")
+
+	room, _ := service.CreateRoom(models.CreateRoomRequest{Name: "general"})
+	for _, member := range []*models.User{alice, bob, dave} {
+		store.AddUserToRoom(room.ID, member.ID)
+	}
+	if _, err := service.BlockUser(dave.ID, alice.ID); err != nil {
+		t.Fatalf("BlockUser() unexpected error = %v", err)
+	}
+
+	message, err := service.CreatePoll(alice.ID, room.ID, models.CreatePollRequest{
+		Question: "  Lunch?  ",
+		Options:  []string{"Pizza", "Sushi", "Tacos"},
+	})
+	if err != nil {
+		t.Fatalf("CreatePoll() unexpected error = %v", err)
+	}
+	if message.Content != "Lunch?" || message.Sender != "alice" || message.Poll == nil || len(message.Poll.Options) != 3 {
+		t.Fatalf("CreatePoll() = %+v, want a message with the poll", message)
+	}
+	pollID := message.Poll.ID
+
+	t.Run("invalid polls", func(t *testing.T) {
+		past := time.Now().Add(-time.Minute)
+		tests := []struct {
+			name   string
+			userID string
+			roomID string
+			req    models.CreatePollRequest
+			want   error
+		}{
+			{name: "no question", userID: alice.ID, roomID: room.ID, req: models.CreatePollRequest{Options: []string{"a", "b"}}, want: ErrInvalidPoll},
+			{name: "single option", userID: alice.ID, roomID: room.ID, req: models.CreatePollRequest{Question: "q", Options: []string{"a"}}, want: ErrInvalidPoll},
+			{name: "empty option", userID: alice.ID, roomID: room.ID, req: models.CreatePollRequest{Question: "q", Options: []string{"a", " "}}, want: ErrInvalidPoll},
+			{name: "duplicate options", userID: alice.ID, roomID: room.ID, req: models.CreatePollRequest{Question: "q", Options: []string{"Yes", "yes"}}, want: ErrInvalidPoll},
+			{name: "close time in the past", userID: alice.ID, roomID: room.ID, req: models.CreatePollRequest{Question: "q", Options: []string{"a", "b"}, ClosesAt: &past}, want: ErrInvalidPoll},
+			{name: "not a member", userID: carol.ID, roomID: room.ID, req: models.CreatePollRequest{Question: "q", Options: []string{"a", "b"}}, want: ErrNotRoomMember},
+			{name: "unknown room", userID: alice.ID, roomID: "missing", req: models.CreatePollRequest{Question: "q", Options: []string{"a", "b"}}, want: ErrRoomNotFound},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if _, err := service.CreatePoll(tt.userID, tt.roomID, tt.req); !errors.Is(err, tt.want) {
+					t.Errorf("CreatePoll() error = %v, want %v", err, tt.want)
+				}
+			})
+		}
+	})
+
+	t.Run("votes", func(t *testing.T) {
+		poll, err := service.Vote(bob.ID, pollID, []int{1})
+		if err != nil {
+			t.Fatalf("Vote() unexpected error = %v", err)
+		}
+		if poll.Voters != 1 || poll.Options[1].Votes != 1 || !reflect.DeepEqual(poll.Options[1].Voters, []string{"bob"}) || !reflect.DeepEqual(poll.Voted, []int{1}) {
+			t.Errorf("Vote() = %+v, want bob's vote for the second option", poll)
+		}
+		if len(events) != 1 || events[0]["type"] != "poll_updated" || !reflect.DeepEqual(recipients[0], []string{alice.ID, bob.ID}) {
+			t.Errorf("poll events = %v to %v, want poll_updated to the members who did not block the creator", events, recipients)
+		}
+
+		// Changing a vote replaces it
+		if _, err := service.Vote(bob.ID, pollID, []int{0}); err != nil {
+			t.Fatalf("Vote() unexpected error = %v", err)
+		}
+		if _, err := service.Vote(alice.ID, pollID, []int{0}); err != nil {
+			t.Fatalf("Vote() unexpected error = %v", err)
+		}
+		poll, err = service.GetPoll(dave.ID, pollID)
+		if err != nil {
+			t.Fatalf("GetPoll() unexpected error = %v", err)
+		}
+		if poll.Voters != 2 || poll.Options[0].Votes != 2 || poll.Options[1].Votes != 0 || !reflect.DeepEqual(poll.Options[0].Voters, []string{"alice", "bob"}) || poll.Voted != nil {
+			t.Errorf("GetPoll() = %+v, want two votes for the first option", poll)
+		}
+
+		errorTests := []struct {
+			name    string
+			userID  string
+			pollID  string
+			options []int
+			want    error
+		}{
+			{name: "several options in a single choice poll", userID: bob.ID, pollID: pollID, options: []int{0, 1}, want: ErrInvalidVote},
+			{name: "unknown option", userID: bob.ID, pollID: pollID, options: []int{3}, want: ErrInvalidVote},
+			{name: "not a member", userID: carol.ID, pollID: pollID, options: []int{0}, want: ErrPollNotFound},
+			{name: "unknown poll", userID: bob.ID, pollID: "missing", options: []int{0}, want: ErrPollNotFound},
+		}
+		for _, tt := range errorTests {
+			t.Run(tt.name, func(t *testing.T) {
+				if _, err := service.Vote(tt.userID, tt.pollID, tt.options); !errors.Is(err, tt.want) {
+					t.Errorf("Vote() error = %v, want %v", err, tt.want)
+				}
+			})
+		}
+
+		// An empty vote withdraws it
+		poll, err = service.Vote(bob.ID, pollID, nil)
+		if err != nil || poll.Voters != 1 || poll.Options[0].Votes != 1 || poll.Voted != nil {
+			t.Errorf("Vote() withdrawing = %+v, %v, want only alice's vote left", poll, err)
+		}
+
+		messages, err := service.GetMessagesByRoom(bob.ID, room.ID)
+		if err != nil || len(messages) != 1 || messages[0].Poll == nil || messages[0].Poll.Options[0].Votes != 1 {
+			t.Errorf("GetMessagesByRoom() = %+v, %v, want the message with its tally", messages, err)
+		}
+	})
+
+	t.Run("anonymous multiple choice", func(t *testing.T) {
+		message, err := service.CreatePoll(bob.ID, room.ID, models.CreatePollRequest{
+			Question:       "Which days?",
+			Options:        []string{"Mon", "Tue", "Wed"},
+			MultipleChoice: true,
+			Anonymous:      true,
+		})
+		if err != nil {
+			t.Fatalf("CreatePoll() unexpected error = %v", err)
+		}
+
+		events = nil
+		poll, err := service.Vote(alice.ID, message.Poll.ID, []int{2, 0})
+		if err != nil {
+			t.Fatalf("Vote() unexpected error = %v", err)
+		}
+		if poll.Options[0].Votes != 1 || poll.Options[2].Votes != 1 || !reflect.DeepEqual(poll.Voted, []int{0, 2}) {
+			t.Errorf("Vote() = %+v, want votes for the first and third options", poll)
+		}
+		for _, option := range poll.Options {
+			if len(option.Voters) != 0 {
+				t.Errorf("Vote() option %q voters = %v, want them hidden", option.Text, option.Voters)
+			}
+		}
+		if len(events) != 1 || len(events[0]["poll"].(models.Poll).Options[0].Voters) != 0 {
+			t.Errorf("poll events = %v, want a tally without voters", events)
+		}
+		if _, err := service.Vote(alice.ID, message.Poll.ID, []int{1, 1}); !errors.Is(err, ErrInvalidVote) {
+			t.Errorf("Vote() with a repeated option error = %v, want %v", err, ErrInvalidVote)
+		}
+	})
+
+	t.Run("close", func(t *testing.T) {
+		if _, err := service.ClosePoll(bob.ID, pollID, false); !errors.Is(err, ErrNotPollCreator) {
+			t.Errorf("ClosePoll() by another member error = %v, want %v", err, ErrNotPollCreator)
+		}
+		poll, err := service.ClosePoll(bob.ID, pollID, true)
+		if err != nil || poll.ClosedAt == nil {
+			t.Fatalf("ClosePoll() by a moderator = %+v, %v, want a closed poll", poll, err)
+		}
+		if _, err := service.Vote(bob.ID, pollID, []int{1}); !errors.Is(err, ErrPollClosed) {
+			t.Errorf("Vote() in a closed poll error = %v, want %v", err, ErrPollClosed)
+		}
+		if _, err := service.ClosePoll(alice.ID, pollID, false); !errors.Is(err, ErrPollClosed) {
+			t.Errorf("ClosePoll() again error = %v, want %v", err, ErrPollClosed)
+		}
+
+		// Polls past their close time reject votes too
+		closesAt := time.Now().Add(time.Hour)
+		message, err := service.CreatePoll(alice.ID, room.ID, models.CreatePollRequest{Question: "Soon?", Options: []string{"a", "b"}, ClosesAt: &closesAt})
+		if err != nil {
+			t.Fatalf("CreatePoll() unexpected error = %v", err)
+		}
+		vote := models.PollVote{PollID: message.Poll.ID, UserID: bob.ID, Options: []int{0}, VotedAt: closesAt.Add(time.Second)}
+		if err := store.SetPollVote(vote); err == nil {
+			t.Errorf("SetPollVote() after the close time succeeded, want an error")
+		}
+
+		// Deleting the message deletes its poll
+		if err := service.DeleteMessage(alice.ID, message.ID, false, ""); err != nil {
+			t.Fatalf("DeleteMessage() unexpected error = %v", err)
+		}
+		if _, err := service.GetPoll(alice.ID, message.Poll.ID); !errors.Is(err, ErrPollNotFound) {
+			t.Errorf("GetPoll() after deleting the message error = %v, want %v", err, ErrPollNotFound)
+		}
+	})
+}
+
+func TestChatService_PollModeration(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	authService := auth.NewAuthService("test-secret", 24*time.Hour)
+	masked, _ := moderation.NewWordFilter([]string{"darn"}, nil, moderation.Mask)
+	service := NewChatService(store, store, store, authService,
+		WithModeration(moderation.NewPipeline(
+			masked,
+			moderation.NewLinkFilter([]string{"spam.example"}, moderation.Reject),
+			moderation.NewSpamFilter(store, moderation.SpamPolicy{MaxMentions: 2}, moderation.Hold),
+		), store),
+		WithPolls(store, nil),
+	)
+
+	alice, _ := service.RegisterUser(models.RegisterRequest{Username: "alice", Email: "alice@example.com", Password: "password123"})
+	room, _ := service.CreateRoom(models.CreateRoomRequest{Name: "general"})
+	store.AddUserToRoom(room.ID, alice.ID)
+
+	message, err := service.CreatePoll(alice.ID, room.ID, models.CreatePollRequest{
+		Question: "Which darn option?",
+		Options:  []string{"this darn one", "that one"},
+	})
+	if err != nil {
+		t.Fatalf("CreatePoll() unexpected error = %v", err)
+	}
+	if message.Content != "Which **** option?" || message.Poll.Question != message.Content {
+		t.Errorf("CreatePoll() question = %q/%q, want the masked question", message.Content, message.Poll.Question)
+	}
+	if message.Poll.Options[0].Text != "this **** one" || message.Poll.Options[1].Text != "that one" {
+		t.Errorf("CreatePoll() options = %+v, want the first option masked", message.Poll.Options)
+	}
+	stored, _ := service.GetPoll(alice.ID, message.Poll.ID)
+	if stored.Question != "Which **** option?" || stored.Options[0].Text != "this **** one" {
+		t.Errorf("GetPoll() = %q %+v, want the masked question and options", stored.Question, stored.Options)
+	}
+
+	before, _ := service.GetMessagesByRoom(alice.ID, room.ID)
+	for _, option := range []string{"visit spam.example", "@a @b @c"} {
+		var rejectedErr *MessageRejectedError
+		_, err := service.CreatePoll(alice.ID, room.ID, models.CreatePollRequest{Question: "Where?", Options: []string{"here", option}})
+		if !errors.As(err, &rejectedErr) {
+			t.Errorf("CreatePoll() with option %q error = %v, want a MessageRejectedError", option, err)
+		}
+	}
+	if held, _ := service.GetHeldMessages(""); len(held) != 0 {
+		t.Errorf("GetHeldMessages() = %d messages, want rejected options not to be held", len(held))
+	}
+	if after, _ := service.GetMessagesByRoom(alice.ID, room.ID); len(after) != len(before) {
+		t.Errorf("GetMessagesByRoom() = %d messages, want no question posted for rejected polls", len(after))
+	}
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"go-chat-api/internal/models"
+	"time"
+)
+
+// messageTTL returns the TTL, in seconds, of a message about to be sent: the one requested
+// by the sender, or the default of the room. Rooms with a default TTL cap the requested one,
+// so messages never outlive the room's setting.
+func (s *ChatService) messageTTL(req models.MessageRequest) (int, error) {
+	if req.TTLSeconds < 0 || req.TTLSeconds > maxMessageTTLSeconds {
+		return 0, ErrInvalidMessageTTL
+	}
+	if req.RoomID == "" {
+		return req.TTLSeconds, nil
+	}
+
+	room, err := s.roomStore.GetRoom(req.RoomID)
+	if err != nil || room == nil {
+		return 0, ErrRoomNotFound
+	}
+	if room.MessageTTLSeconds > 0 && (req.TTLSeconds == 0 || req.TTLSeconds > room.MessageTTLSeconds) {
+		return room.MessageTTLSeconds, nil
+	}
+	return req.TTLSeconds, nil
+}
+
+// messageExpiry returns when a message with the given TTL expires, or nil when it is kept
+func messageExpiry(from time.Time, ttlSeconds int) *time.Time {
+	if ttlSeconds <= 0 {
+		return nil
+	}
+	expiresAt := from.Add(time.Duration(ttlSeconds) * time.Second)
+	return &expiresAt
+}
+
+// ExpireMessages hard-deletes up to limit messages that expired at now, along with their
+// attachment files, and returns them so clients can be told to remove them
+func (s *ChatService) ExpireMessages(now time.Time, limit int) ([]models.Message, error) {
+	messages, err := s.messageStore.DeleteExpiredMessages(now, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.blobs != nil {
+		for _, message := range messages {
+			for _, attachment := range message.Attachments {
+				s.deleteAttachmentBlobs(attachment)
+			}
+		}
+	}
+	return messages, nil
+}
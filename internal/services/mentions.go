@@ -0,0 +1,255 @@
+package services
+
+import (
+	"errors"
+	"go-chat-api/internal/models"
+	"go-chat-api/internal/storage"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxMentionsListed limits the unread mentions returned at once
+const maxMentionsListed = 100
+
+// ErrMentionsDisabled is returned when no mention storage is configured
+var ErrMentionsDisabled = errors.New("mentions are not enabled")
+
+// mentionPattern matches @name at the start of the content or after a character that
+// cannot be part of an email address or another mention
+var mentionPattern = regexp.MustCompile(`(?:^|[^A-Za-z0-9._@-])@([A-Za-z0-9._-]+)`)
+
+// Presence reports which users have a live connection
+type Presence interface {
+	IsUserOnline(username string) bool
+}
+
+// WithMentions records @username, @room and @here mentions in new messages and calls
+// notify with a "mention" event for each mentioned user. presence decides who @here reaches.
+func WithMentions(store storage.MentionStore, presence Presence, notify func(userIDs []string, event map[string]interface{})) Option {
+	return func(s *ChatService) {
+		s.mentions = store
+		s.presence = presence
+		s.notifyMention = notify
+	}
+}
+
+// parseMentions returns the usernames mentioned in content, in order, and whether it
+// mentions @room or @here
+func parseMentions(content string) (usernames []string, room, here bool) {
+	seen := make(map[string]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		name := strings.TrimRight(match[1], ".-")
+		switch name {
+		case "":
+			continue
+		case "room":
+			room = true
+		case "here":
+			here = true
+		default:
+			if !seen[name] {
+				seen[name] = true
+				usernames = append(usernames, name)
+			}
+		}
+	}
+	return usernames, room, here
+}
+
+// recordMentions stores the mentions in a stored message and notifies the mentioned
+// users. @room and @here only apply to room messages, and users can only be mentioned
+// where they can read the message. Failures are logged rather than failing the send.
+func (s *ChatService) recordMentions(message models.Message) {
+	if s.mentions == nil {
+		return
+	}
+	usernames, room, here := parseMentions(message.Content)
+	if len(usernames) == 0 && !room && !here {
+		return
+	}
+
+	targets, err := s.mentionTargets(message, usernames, room, here)
+	if err != nil {
+		log.Printf("Failed to resolve mentions in message %s: %v", message.ID, err)
+		return
+	}
+	if len(targets) == 0 {
+		return
+	}
+
+	now := time.Now()
+	mentions := make([]models.Mention, 0, len(targets))
+	for _, target := range targets {
+		id, err := generateID()
+		if err != nil {
+			log.Printf("Failed to record mentions in message %s: %v", message.ID, err)
+			return
+		}
+		mentions = append(mentions, models.Mention{
+			ID:        id,
+			UserID:    target.userID,
+			MessageID: message.ID,
+			Kind:      target.kind,
+			CreatedAt: now,
+		})
+	}
+	if err := s.mentions.AddMentions(mentions); err != nil {
+		log.Printf("Failed to record mentions in message %s: %v", message.ID, err)
+		return
+	}
+
+	if s.notifyMention == nil {
+		return
+	}
+	// Mentions go straight to each user's connection, bypassing the room broadcast
+	for _, mention := range mentions {
+		mention.Message = &message
+		s.notifyMention([]string{mention.UserID}, map[string]interface{}{
+			"type":     "mention",
+			"priority": "high",
+			"mention":  mention,
+		})
+	}
+}
+
+// mentionTarget is a user reached by a mention
+type mentionTarget struct {
+	userID string
+	kind   string
+}
+
+// mentionTargets resolves mentions to the users who can read the message, excluding the
+// sender and users who blocked the sender. A user mentioned by name is recorded as such
+// even when @room or @here also reaches them.
+func (s *ChatService) mentionTargets(message models.Message, usernames []string, room, here bool) ([]mentionTarget, error) {
+	sender, err := s.userStore.GetUserByUsername(message.Sender)
+	if err != nil || sender == nil {
+		return nil, err
+	}
+
+	// readers holds the IDs of the users allowed to be mentioned, or nil for global messages
+	var readers map[string]bool
+	var members []string
+	switch {
+	case message.RoomID != "":
+		chatRoom, err := s.roomStore.GetRoom(message.RoomID)
+		if err != nil || chatRoom == nil {
+			return nil, err
+		}
+		members = chatRoom.Members
+		readers = make(map[string]bool, len(members))
+		for _, member := range members {
+			readers[member] = true
+		}
+	case message.Recipient != "":
+		recipient, err := s.userStore.GetUserByUsername(message.Recipient)
+		if err != nil || recipient == nil {
+			return nil, err
+		}
+		readers = map[string]bool{recipient.ID: true}
+	}
+
+	var targets []mentionTarget
+	mentioned := map[string]bool{sender.ID: true}
+	add := func(userID, kind string) error {
+		if mentioned[userID] || (readers != nil && !readers[userID]) {
+			return nil
+		}
+		mentioned[userID] = true
+		blocked, err := s.hasBlocked(userID, sender.ID)
+		if err != nil || blocked {
+			return err
+		}
+		targets = append(targets, mentionTarget{userID: userID, kind: kind})
+		return nil
+	}
+
+	for _, username := range usernames {
+		user, err := s.userStore.GetUserByUsername(username)
+		if err != nil {
+			return nil, err
+		}
+		if user != nil {
+			if err := add(user.ID, models.MentionUser); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if room || (here && s.presence != nil) {
+		for _, memberID := range members {
+			kind := models.MentionRoom
+			if !room {
+				// @here only reaches the members connected right now
+				member, err := s.userStore.GetUser(memberID)
+				if err != nil {
+					return nil, err
+				}
+				if member == nil || !s.presence.IsUserOnline(member.Username) {
+					continue
+				}
+				kind = models.MentionHere
+			}
+			if err := add(memberID, kind); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return targets, nil
+}
+
+// GetMentions returns a user's unread mentions, newest first, leaving out mentions by
+// users they have since blocked and messages they can no longer read
+func (s *ChatService) GetMentions(userID string) ([]models.Mention, error) {
+	if s.mentions == nil {
+		return nil, ErrMentionsDisabled
+	}
+
+	mentions, err := s.mentions.GetUnreadMentions(userID, maxMentionsListed)
+	if err != nil {
+		return nil, err
+	}
+
+	blocked, err := s.blockedUsernames(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rooms := make(map[string]bool)
+	visible := make([]models.Mention, 0, len(mentions))
+	for _, mention := range mentions {
+		message := mention.Message
+		if message == nil || blocked[message.Sender] {
+			continue
+		}
+		if message.RoomID != "" {
+			member, checked := rooms[message.RoomID]
+			if !checked {
+				room, err := s.roomStore.GetRoom(message.RoomID)
+				if err != nil {
+					return nil, err
+				}
+				member = room != nil && slices.Contains(room.Members, userID)
+				rooms[message.RoomID] = member
+			}
+			if !member {
+				continue
+			}
+		}
+		visible = append(visible, mention)
+	}
+	return visible, nil
+}
+
+// MarkMentionsRead marks the given mentions of a user as read, or all of them when ids
+// is empty, and returns how many were marked
+func (s *ChatService) MarkMentionsRead(userID string, ids []string) (int, error) {
+	if s.mentions == nil {
+		return 0, ErrMentionsDisabled
+	}
+	return s.mentions.MarkMentionsRead(userID, ids, time.Now())
+}
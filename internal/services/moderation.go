@@ -0,0 +1,343 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"go-chat-api/internal/audit"
+	"go-chat-api/internal/models"
+	"go-chat-api/internal/moderation"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// maxModerationEntries caps each list in a room's moderation settings
+const maxModerationEntries = 200
+
+var (
+	// ErrModerationDisabled is returned by the moderation API when no moderation store is configured
+	ErrModerationDisabled = errors.New("moderation is not enabled")
+
+	// ErrInvalidModeration is wrapped by the errors returned for invalid room moderation settings
+	ErrInvalidModeration = errors.New("invalid moderation settings")
+
+	// ErrHeldMessageNotFound is returned for held messages that do not exist or were already reviewed
+	ErrHeldMessageNotFound = errors.New("held message not found")
+)
+
+// MessageHeldError is returned when a moderation filter holds a message for review. The
+// message is not delivered unless a moderator approves it.
+type MessageHeldError struct {
+	Held *models.HeldMessage
+}
+
+func (e *MessageHeldError) Error() string {
+	return "message is held for moderator review"
+}
+
+// MessageRejectedError is returned when a moderation filter rejects a message
+type MessageRejectedError struct {
+	Filter string
+	Reason string
+}
+
+func (e *MessageRejectedError) Error() string {
+	return "message rejected: " + e.Reason
+}
+
+// moderate runs a message through the moderation pipeline and returns the content to
+// store. Held messages are queued for review.
+func (s *ChatService) moderate(req models.MessageRequest) (string, error) {
+	if s.pipeline == nil {
+		return req.Content, nil
+	}
+
+	now := time.Now()
+	verdict, err := s.moderationVerdict(req, now)
+	if err != nil {
+		return "", err
+	}
+
+	switch verdict.Action {
+	case moderation.Reject:
+		return "", &MessageRejectedError{Filter: verdict.Filter, Reason: verdict.Reason}
+	case moderation.Hold:
+		// Without a review queue there is nobody to approve the message
+		if s.moderation == nil {
+			return "", &MessageRejectedError{Filter: verdict.Filter, Reason: verdict.Reason}
+		}
+		id, err := generateID()
+		if err != nil {
+			return "", err
+		}
+		held := models.HeldMessage{
+			ID:            id,
+			Sender:        req.Sender,
+			Recipient:     req.Recipient,
+			RoomID:        req.RoomID,
+			Content:       verdict.Content, // masked, so approving it cannot reveal what a filter hid
+			Format:        req.Format,
+			TTLSeconds:    req.TTLSeconds,
+			AttachmentIDs: req.AttachmentIDs,
+			Filter:        verdict.Filter,
+			Reason:        verdict.Reason,
+			Status:        models.HeldMessagePending,
+			CreatedAt:     now,
+		}
+		if err := s.moderation.AddHeldMessage(held); err != nil {
+			return "", err
+		}
+		return "", &MessageHeldError{Held: &held}
+	}
+	return verdict.Content, nil
+}
+
+// moderationVerdict runs a message through the moderation pipeline with the moderation
+// settings of its room
+func (s *ChatService) moderationVerdict(req models.MessageRequest, now time.Time) (moderation.Verdict, error) {
+	var room models.RoomModeration
+	if req.RoomID != "" && s.moderation != nil {
+		settings, err := s.moderation.GetRoomModeration(req.RoomID)
+		if err != nil {
+			return moderation.Verdict{}, err
+		}
+		if settings != nil {
+			room = *settings
+		}
+	}
+
+	return s.pipeline.Run(moderation.Message{
+		Sender:    req.Sender,
+		Recipient: req.Recipient,
+		RoomID:    req.RoomID,
+		Content:   req.Content,
+		SentAt:    now,
+	}, room)
+}
+
+// GetRoomModeration returns the moderation settings of a room; rooms without settings
+// get empty ones
+func (s *ChatService) GetRoomModeration(roomID string) (*models.RoomModeration, error) {
+	if s.moderation == nil {
+		return nil, ErrModerationDisabled
+	}
+	if room, err := s.roomStore.GetRoom(roomID); err != nil || room == nil {
+		return nil, ErrRoomNotFound
+	}
+
+	settings, err := s.moderation.GetRoomModeration(roomID)
+	if err != nil {
+		return nil, err
+	}
+	if settings == nil {
+		settings = &models.RoomModeration{RoomID: roomID}
+	}
+	return normalizeRoomModeration(settings), nil
+}
+
+// UpdateRoomModeration replaces the moderation settings of a room on behalf of actorID
+func (s *ChatService) UpdateRoomModeration(actorID, roomID string, settings models.RoomModeration, ip string) (*models.RoomModeration, error) {
+	if s.moderation == nil {
+		return nil, ErrModerationDisabled
+	}
+	if err := s.validateRoomModeration(settings); err != nil {
+		return nil, err
+	}
+	if room, err := s.roomStore.GetRoom(roomID); err != nil || room == nil {
+		return nil, ErrRoomNotFound
+	}
+
+	now := time.Now()
+	settings.RoomID = roomID
+	settings.UpdatedBy = actorID
+	settings.UpdatedAt = &now
+	if err := s.moderation.SetRoomModeration(settings); err != nil {
+		return nil, err
+	}
+
+	s.auditLog.Record(models.AuditEvent{
+		Action:   audit.ActionRoomModerationChanged,
+		ActorID:  actorID,
+		TargetID: roomID,
+		IP:       ip,
+		Metadata: map[string]string{
+			"disabled_filters": strings.Join(settings.DisabledFilters, ","),
+			"blocked_words":    fmt.Sprint(len(settings.BlockedWords)),
+			"blocked_patterns": fmt.Sprint(len(settings.BlockedPatterns)),
+			"blocked_domains":  fmt.Sprint(len(settings.BlockedDomains)),
+		},
+	})
+	return normalizeRoomModeration(&settings), nil
+}
+
+// validateRoomModeration checks that room settings name known filters and actions and
+// that their patterns compile
+func (s *ChatService) validateRoomModeration(settings models.RoomModeration) error {
+	for _, list := range [][]string{settings.DisabledFilters, settings.BlockedWords, settings.BlockedPatterns, settings.BlockedDomains} {
+		if len(list) > maxModerationEntries {
+			return fmt.Errorf("%w: lists are limited to %d entries", ErrInvalidModeration, maxModerationEntries)
+		}
+	}
+	for _, name := range settings.DisabledFilters {
+		if !s.pipeline.Has(name) {
+			return fmt.Errorf("%w: unknown filter %q", ErrInvalidModeration, name)
+		}
+	}
+	for name, action := range settings.Actions {
+		if !s.pipeline.Has(name) {
+			return fmt.Errorf("%w: unknown filter %q", ErrInvalidModeration, name)
+		}
+		if _, err := moderation.ParseAction(action); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidModeration, err)
+		}
+	}
+	for _, pattern := range settings.BlockedPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("%w: invalid pattern %q", ErrInvalidModeration, pattern)
+		}
+	}
+	return nil
+}
+
+// normalizeRoomModeration replaces nil lists so they encode as empty JSON arrays
+func normalizeRoomModeration(settings *models.RoomModeration) *models.RoomModeration {
+	for _, list := range []*[]string{&settings.DisabledFilters, &settings.BlockedWords, &settings.BlockedPatterns, &settings.BlockedDomains} {
+		if *list == nil {
+			*list = []string{}
+		}
+	}
+	if settings.Actions == nil {
+		settings.Actions = map[string]string{}
+	}
+	return settings
+}
+
+// GetHeldMessages returns the messages awaiting review, oldest first, in a room or in
+// every room and conversation when roomID is empty
+func (s *ChatService) GetHeldMessages(roomID string) ([]models.HeldMessage, error) {
+	if s.moderation == nil {
+		return nil, ErrModerationDisabled
+	}
+
+	messages, err := s.moderation.GetHeldMessages(roomID)
+	if err != nil {
+		return nil, err
+	}
+	if messages == nil {
+		messages = []models.HeldMessage{}
+	}
+	return messages, nil
+}
+
+// ApproveHeldMessage stores a held message on behalf of actorID and returns it for delivery.
+// The message keeps the ID and time it was sent with; a disappearing message expires its
+// TTL after the approval. Its attachments are checked again, and a message whose
+// attachments were deleted or sent since it was held stays pending.
+func (s *ChatService) ApproveHeldMessage(actorID, id, ip string) (*models.Message, error) {
+	held, err := s.pendingHeldMessage(id)
+	if err != nil {
+		return nil, err
+	}
+	uploaderID, err := s.checkMessageAttachments(models.MessageRequest{
+		Sender:        held.Sender,
+		AttachmentIDs: held.AttachmentIDs,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.reviewHeldMessage(actorID, id, models.HeldMessageApproved); err != nil {
+		return nil, err
+	}
+
+	message := models.Message{
+		ID:        held.ID,
+		Sender:    held.Sender,
+		Recipient: held.Recipient,
+		Content:   held.Content,
+		Format:    held.Format,
+		HTML:      renderContent(held.Format, held.Content),
+		RoomID:    held.RoomID,
+		Timestamp: held.CreatedAt,
+		ExpiresAt: messageExpiry(time.Now(), held.TTLSeconds),
+	}
+	if err := s.messageStore.AddMessage(message); err != nil {
+		return nil, err
+	}
+
+	if len(held.AttachmentIDs) > 0 {
+		if err := s.attachments.AttachToMessage(message.ID, uploaderID, held.AttachmentIDs); err != nil {
+			// Another message claimed an attachment since it was checked
+			if deleteErr := s.messageStore.DeleteMessage(message.ID); deleteErr != nil {
+				log.Printf("Failed to remove message %s after attaching failed: %v", message.ID, deleteErr)
+			}
+			return nil, fmt.Errorf("%w: %v", ErrInvalidAttachments, err)
+		}
+		messages, err := s.populateAttachments([]models.Message{message})
+		if err != nil {
+			return nil, err
+		}
+		message = messages[0]
+	}
+
+	s.recordMentions(message)
+	s.schedulePreviews(message)
+
+	s.auditLog.Record(models.AuditEvent{
+		Action:   audit.ActionHeldMessageApproved,
+		ActorID:  actorID,
+		TargetID: id,
+		IP:       ip,
+		Metadata: map[string]string{"sender": held.Sender, "filter": held.Filter},
+	})
+	return &message, nil
+}
+
+// RejectHeldMessage discards a held message on behalf of actorID
+func (s *ChatService) RejectHeldMessage(actorID, id, ip string) error {
+	held, err := s.pendingHeldMessage(id)
+	if err != nil {
+		return err
+	}
+	if err := s.reviewHeldMessage(actorID, id, models.HeldMessageRejected); err != nil {
+		return err
+	}
+
+	s.auditLog.Record(models.AuditEvent{
+		Action:   audit.ActionHeldMessageRejected,
+		ActorID:  actorID,
+		TargetID: id,
+		IP:       ip,
+		Metadata: map[string]string{"sender": held.Sender, "filter": held.Filter},
+	})
+	return nil
+}
+
+// pendingHeldMessage fetches a held message that is awaiting review
+func (s *ChatService) pendingHeldMessage(id string) (*models.HeldMessage, error) {
+	if s.moderation == nil {
+		return nil, ErrModerationDisabled
+	}
+
+	held, err := s.moderation.GetHeldMessage(id)
+	if err != nil {
+		return nil, err
+	}
+	if held == nil || held.Status != models.HeldMessagePending {
+		return nil, ErrHeldMessageNotFound
+	}
+	return held, nil
+}
+
+// reviewHeldMessage moves a pending held message to status
+func (s *ChatService) reviewHeldMessage(actorID, id, status string) error {
+	// The update only succeeds while the message is pending, so concurrent reviews
+	// cannot both go through
+	if err := s.moderation.ReviewHeldMessage(id, status, actorID, time.Now()); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return ErrHeldMessageNotFound
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"errors"
+	"go-chat-api/internal/audit"
+	"go-chat-api/internal/models"
+	"go-chat-api/internal/storage"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrPinsDisabled is returned when no pin storage is configured
+	ErrPinsDisabled = errors.New("pinned messages are not enabled")
+
+	// ErrPinLimitReached is returned when a room already has as many pins as allowed
+	ErrPinLimitReached = errors.New("this room has reached its pinned message limit")
+
+	// ErrPinNotFound is returned when unpinning a message that is not pinned
+	ErrPinNotFound = errors.New("pin not found")
+
+	// ErrNotRoomMember is returned when someone outside a room reads its pins
+	ErrNotRoomMember = errors.New("you are not a member of this room")
+)
+
+// WithPins enables pinning messages to rooms, up to maxPins per room. notify delivers
+// "pin_added" and "pin_removed" events to the members of the room.
+func WithPins(store storage.PinStore, maxPins int, notify func(userIDs []string, event map[string]interface{})) Option {
+	return func(s *ChatService) {
+		s.pins = store
+		s.maxPins = maxPins
+		s.notifyPin = notify
+	}
+}
+
+// PinMessage pins a room message on behalf of actorID. Pinning a pinned message again
+// succeeds without changing it.
+func (s *ChatService) PinMessage(actorID, roomID, messageID, ip string) (*models.PinnedMessage, error) {
+	if s.pins == nil {
+		return nil, ErrPinsDisabled
+	}
+
+	room, err := s.roomStore.GetRoom(roomID)
+	if err != nil || room == nil {
+		return nil, ErrRoomNotFound
+	}
+	message, err := s.messageStore.GetMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message == nil || message.RoomID != roomID {
+		return nil, ErrMessageNotFound
+	}
+
+	pin := models.PinnedMessage{
+		RoomID:    roomID,
+		MessageID: messageID,
+		PinnedBy:  actorID,
+		PinnedAt:  time.Now(),
+	}
+	pinned, err := s.pins.AddPin(pin, s.maxPins)
+	if err != nil {
+		return nil, err
+	}
+	if !pinned {
+		return nil, ErrPinLimitReached
+	}
+
+	s.auditLog.Record(models.AuditEvent{
+		Action:   audit.ActionMessagePinned,
+		ActorID:  actorID,
+		TargetID: messageID,
+		IP:       ip,
+		Metadata: map[string]string{"room_id": roomID, "sender": message.Sender},
+	})
+
+	messages, err := s.populateMessages([]models.Message{*message})
+	if err != nil {
+		return nil, err
+	}
+	pin.Message = &messages[0]
+	s.notifyRoomPin(room, message.Sender, map[string]interface{}{
+		"type":    "pin_added",
+		"room_id": roomID,
+		"pin":     pin,
+	})
+	return &pin, nil
+}
+
+// UnpinMessage removes a pin from a room on behalf of actorID
+func (s *ChatService) UnpinMessage(actorID, roomID, messageID, ip string) error {
+	if s.pins == nil {
+		return ErrPinsDisabled
+	}
+
+	room, err := s.roomStore.GetRoom(roomID)
+	if err != nil || room == nil {
+		return ErrRoomNotFound
+	}
+	if err := s.pins.RemovePin(roomID, messageID); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return ErrPinNotFound
+		}
+		return err
+	}
+
+	s.auditLog.Record(models.AuditEvent{
+		Action:   audit.ActionMessageUnpinned,
+		ActorID:  actorID,
+		TargetID: messageID,
+		IP:       ip,
+		Metadata: map[string]string{"room_id": roomID},
+	})
+
+	s.notifyRoomPin(room, "", map[string]interface{}{
+		"type":       "pin_removed",
+		"room_id":    roomID,
+		"message_id": messageID,
+	})
+	return nil
+}
+
+// GetPins returns the pins of a room, most recently pinned first, for a member of the
+// room. Messages from users the viewer has blocked are left out.
+func (s *ChatService) GetPins(viewerID, roomID string) ([]models.PinnedMessage, error) {
+	if s.pins == nil {
+		return nil, ErrPinsDisabled
+	}
+
+	room, err := s.roomStore.GetRoom(roomID)
+	if err != nil || room == nil {
+		return nil, ErrRoomNotFound
+	}
+	if !slices.Contains(room.Members, viewerID) {
+		return nil, ErrNotRoomMember
+	}
+
+	pins, err := s.pins.GetPins(roomID)
+	if err != nil {
+		return nil, err
+	}
+	blocked, err := s.blockedUsernames(viewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	visible := make([]models.PinnedMessage, 0, len(pins))
+	messages := make([]models.Message, 0, len(pins))
+	for _, pin := range pins {
+		if pin.Message == nil || blocked[pin.Message.Sender] {
+			continue
+		}
+		visible = append(visible, pin)
+		messages = append(messages, *pin.Message)
+	}
+
+	messages, err = s.populateMessages(messages)
+	if err != nil {
+		return nil, err
+	}
+	for i := range visible {
+		visible[i].Message = &messages[i]
+	}
+	return visible, nil
+}
+
+// notifyRoomPin sends a pin event to the members of a room, skipping those who blocked
+// the sender of the pinned message when it is known
+func (s *ChatService) notifyRoomPin(room *models.ChatRoom, sender string, event map[string]interface{}) {
+	if s.notifyPin == nil {
+		return
+	}
+	if recipients := s.roomAudience(room, sender); len(recipients) > 0 {
+		s.notifyPin(recipients, event)
+	}
+}
+
+// roomAudience returns the members of a room who should hear about a message from sender,
+// leaving out those who blocked the sender. An empty sender reaches every member.
+func (s *ChatService) roomAudience(room *models.ChatRoom, sender string) []string {
+	var senderID string
+	if sender != "" {
+		if user, err := s.userStore.GetUserByUsername(sender); err == nil && user != nil {
+			senderID = user.ID
+		}
+	}
+
+	recipients := make([]string, 0, len(room.Members))
+	for _, member := range room.Members {
+		if senderID != "" {
+			if blocked, err := s.hasBlocked(member, senderID); err != nil || blocked {
+				continue
+			}
+		}
+		recipients = append(recipients, member)
+	}
+	return recipients
+}
@@ -0,0 +1,373 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"go-chat-api/internal/models"
+	"go-chat-api/internal/moderation"
+	"go-chat-api/internal/storage"
+	"log"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// maxPollOptions limits the number of answers a poll offers
+	maxPollOptions = 10
+
+	// maxPollQuestionLength limits the length of a poll question, in characters
+	maxPollQuestionLength = 300
+
+	// maxPollOptionLength limits the length of a poll answer, in characters
+	maxPollOptionLength = 100
+)
+
+var (
+	// ErrPollsDisabled is returned when no poll storage is configured
+	ErrPollsDisabled = errors.New("polls are not enabled")
+
+	// ErrInvalidPoll is returned for polls without a question, with too few or too many
+	// options, or with a close time in the past
+	ErrInvalidPoll = errors.New("invalid poll")
+
+	// ErrPollNotFound is returned for polls that do not exist or that the user cannot see
+	ErrPollNotFound = errors.New("poll not found")
+
+	// ErrPollClosed is returned when voting in, or closing, a poll that is already closed
+	ErrPollClosed = errors.New("poll is closed")
+
+	// ErrInvalidVote is returned for votes naming unknown options, or several options in a
+	// single choice poll
+	ErrInvalidVote = errors.New("invalid vote")
+
+	// ErrNotPollCreator is returned when a user without moderation rights closes someone else's poll
+	ErrNotPollCreator = errors.New("only the creator or a moderator can close this poll")
+)
+
+// WithPolls enables polls in rooms. notify delivers "poll_updated" events with the new
+// tally to the members of the room.
+func WithPolls(store storage.PollStore, notify func(userIDs []string, event map[string]interface{})) Option {
+	return func(s *ChatService) {
+		s.polls = store
+		s.notifyPoll = notify
+	}
+}
+
+// CreatePoll posts a poll in a room on behalf of userID. The question is sent as the
+// message content, through the same checks as any room message, and the message is
+// returned with its poll. Questions held for review fail with a MessageHeldError, and are
+// posted without the poll once approved. Options go through the content filters too: they
+// may be masked, and an option the filters would hold or reject fails the poll with a
+// MessageRejectedError.
+func (s *ChatService) CreatePoll(userID, roomID string, req models.CreatePollRequest) (*models.Message, error) {
+	if s.polls == nil {
+		return nil, ErrPollsDisabled
+	}
+
+	now := time.Now()
+	question, options, err := validatePoll(req, now)
+	if err != nil {
+		return nil, err
+	}
+
+	room, err := s.roomStore.GetRoom(roomID)
+	if err != nil || room == nil {
+		return nil, ErrRoomNotFound
+	}
+	if !slices.Contains(room.Members, userID) {
+		return nil, ErrNotRoomMember
+	}
+	user, err := s.userStore.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("user not found")
+	}
+
+	options, err = s.moderatePollOptions(user.Username, roomID, options)
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := s.SendMessage(models.MessageRequest{
+		Sender:  user.Username,
+		Content: question,
+		RoomID:  roomID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := generateID()
+	if err != nil {
+		return nil, err
+	}
+	poll := models.Poll{
+		ID:             id,
+		MessageID:      message.ID,
+		RoomID:         roomID,
+		CreatedBy:      userID,
+		Question:       message.Content, // as masked by the content filters
+		Options:        make([]models.PollOption, len(options)),
+		MultipleChoice: req.MultipleChoice,
+		Anonymous:      req.Anonymous,
+		ClosesAt:       req.ClosesAt,
+		CreatedAt:      message.Timestamp,
+	}
+	for i, option := range options {
+		poll.Options[i] = models.PollOption{Text: option}
+	}
+
+	if err := s.polls.AddPoll(poll); err != nil {
+		// A question without its poll would be a plain message
+		if deleteErr := s.messageStore.DeleteMessage(message.ID); deleteErr != nil {
+			log.Printf("Failed to remove message %s after adding its poll failed: %v", message.ID, deleteErr)
+		}
+		return nil, err
+	}
+
+	message.Poll = &poll
+	return message, nil
+}
+
+// validatePoll checks a poll request and returns its trimmed question and options
+func validatePoll(req models.CreatePollRequest, now time.Time) (string, []string, error) {
+	question := strings.TrimSpace(req.Question)
+	if question == "" {
+		return "", nil, fmt.Errorf("%w: question is required", ErrInvalidPoll)
+	}
+	if utf8.RuneCountInString(question) > maxPollQuestionLength {
+		return "", nil, fmt.Errorf("%w: question is longer than %d characters", ErrInvalidPoll, maxPollQuestionLength)
+	}
+	if len(req.Options) < 2 || len(req.Options) > maxPollOptions {
+		return "", nil, fmt.Errorf("%w: polls need between 2 and %d options", ErrInvalidPoll, maxPollOptions)
+	}
+
+	options := make([]string, len(req.Options))
+	seen := make(map[string]bool, len(req.Options))
+	for i, option := range req.Options {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			return "", nil, fmt.Errorf("%w: options cannot be empty", ErrInvalidPoll)
+		}
+		if utf8.RuneCountInString(option) > maxPollOptionLength {
+			return "", nil, fmt.Errorf("%w: options are limited to %d characters", ErrInvalidPoll, maxPollOptionLength)
+		}
+		if seen[strings.ToLower(option)] {
+			return "", nil, fmt.Errorf("%w: duplicate option %q", ErrInvalidPoll, option)
+		}
+		seen[strings.ToLower(option)] = true
+		options[i] = option
+	}
+
+	if req.ClosesAt != nil && !req.ClosesAt.After(now) {
+		return "", nil, fmt.Errorf("%w: close time must be in the future", ErrInvalidPoll)
+	}
+	return question, options, nil
+}
+
+// moderatePollOptions runs the options of a poll through the moderation pipeline and
+// returns them as masked by the filters. Options cannot be held for review on their own,
+// so options the filters would hold are rejected.
+func (s *ChatService) moderatePollOptions(sender, roomID string, options []string) ([]string, error) {
+	if s.pipeline == nil {
+		return options, nil
+	}
+
+	now := time.Now()
+	moderated := make([]string, len(options))
+	seen := make(map[string]bool, len(options))
+	for i, option := range options {
+		verdict, err := s.moderationVerdict(models.MessageRequest{Sender: sender, RoomID: roomID, Content: option}, now)
+		if err != nil {
+			return nil, err
+		}
+		switch verdict.Action {
+		case moderation.Reject, moderation.Hold:
+			return nil, &MessageRejectedError{Filter: verdict.Filter, Reason: verdict.Reason}
+		}
+
+		// Masking may make different options read the same
+		if seen[strings.ToLower(verdict.Content)] {
+			return nil, fmt.Errorf("%w: duplicate option %q", ErrInvalidPoll, verdict.Content)
+		}
+		seen[strings.ToLower(verdict.Content)] = true
+		moderated[i] = verdict.Content
+	}
+	return moderated, nil
+}
+
+// Vote records the options userID chose in a poll, replacing any earlier vote; no options
+// withdraws the vote. The updated tally is sent to the room and returned.
+func (s *ChatService) Vote(userID, pollID string, options []int) (*models.Poll, error) {
+	poll, room, err := s.roomPoll(userID, pollID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if poll.IsClosed(now) {
+		return nil, ErrPollClosed
+	}
+	if len(options) > 1 && !poll.MultipleChoice {
+		return nil, fmt.Errorf("%w: this poll allows a single option", ErrInvalidVote)
+	}
+	chosen := make(map[int]bool, len(options))
+	for _, index := range options {
+		if index < 0 || index >= len(poll.Options) {
+			return nil, fmt.Errorf("%w: unknown option %d", ErrInvalidVote, index)
+		}
+		if chosen[index] {
+			return nil, fmt.Errorf("%w: option %d chosen twice", ErrInvalidVote, index)
+		}
+		chosen[index] = true
+	}
+
+	vote := models.PollVote{PollID: pollID, UserID: userID, Options: options, VotedAt: now}
+	if err := s.polls.SetPollVote(vote); err != nil {
+		return nil, pollStoreError(err)
+	}
+
+	return s.pollUpdated(userID, pollID, room)
+}
+
+// ClosePoll stops a poll from accepting votes on behalf of actorID. Users may close their
+// own polls; moderators may close any poll.
+func (s *ChatService) ClosePoll(actorID, pollID string, moderator bool) (*models.Poll, error) {
+	poll, room, err := s.roomPoll(actorID, pollID)
+	if err != nil {
+		return nil, err
+	}
+	if poll.CreatedBy != actorID && !moderator {
+		return nil, ErrNotPollCreator
+	}
+
+	if err := s.polls.ClosePoll(pollID, time.Now()); err != nil {
+		return nil, pollStoreError(err)
+	}
+
+	return s.pollUpdated(actorID, pollID, room)
+}
+
+// GetPoll returns a poll and its tally to a member of its room, with the options the
+// viewer chose
+func (s *ChatService) GetPoll(viewerID, pollID string) (*models.Poll, error) {
+	poll, _, err := s.roomPoll(viewerID, pollID)
+	if err != nil {
+		return nil, err
+	}
+	return s.viewerPoll(viewerID, *poll)
+}
+
+// roomPoll fetches a poll and its room for a member of the room. Polls in rooms the user
+// is not in are reported as not found.
+func (s *ChatService) roomPoll(userID, pollID string) (*models.Poll, *models.ChatRoom, error) {
+	if s.polls == nil {
+		return nil, nil, ErrPollsDisabled
+	}
+
+	poll, err := s.polls.GetPoll(pollID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if poll == nil {
+		return nil, nil, ErrPollNotFound
+	}
+	room, err := s.roomStore.GetRoom(poll.RoomID)
+	if err != nil || room == nil || !slices.Contains(room.Members, userID) {
+		return nil, nil, ErrPollNotFound
+	}
+	return poll, room, nil
+}
+
+// pollUpdated sends the new tally of a poll to the members of its room and returns it as
+// seen by userID
+func (s *ChatService) pollUpdated(userID, pollID string, room *models.ChatRoom) (*models.Poll, error) {
+	poll, err := s.polls.GetPoll(pollID)
+	if err != nil {
+		return nil, err
+	}
+	if poll == nil {
+		return nil, ErrPollNotFound
+	}
+	hidePollVoters(poll)
+
+	if s.notifyPoll != nil {
+		var creator string
+		if message, err := s.messageStore.GetMessage(poll.MessageID); err == nil && message != nil {
+			creator = message.Sender
+		}
+		if recipients := s.roomAudience(room, creator); len(recipients) > 0 {
+			s.notifyPoll(recipients, map[string]interface{}{
+				"type":    "poll_updated",
+				"room_id": poll.RoomID,
+				"poll":    *poll,
+			})
+		}
+	}
+
+	return s.viewerPoll(userID, *poll)
+}
+
+// viewerPoll returns a copy of a poll with the options chosen by viewerID
+func (s *ChatService) viewerPoll(viewerID string, poll models.Poll) (*models.Poll, error) {
+	hidePollVoters(&poll)
+	vote, err := s.polls.GetPollVote(poll.ID, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	if vote != nil {
+		poll.Voted = vote.Options
+	}
+	return &poll, nil
+}
+
+// populatePolls sets the polls of the given messages
+func (s *ChatService) populatePolls(messages []models.Message) ([]models.Message, error) {
+	if s.polls == nil || len(messages) == 0 {
+		return messages, nil
+	}
+
+	ids := make([]string, len(messages))
+	for i, message := range messages {
+		ids[i] = message.ID
+	}
+	polls, err := s.polls.GetMessagePolls(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range messages {
+		if poll, ok := polls[messages[i].ID]; ok {
+			hidePollVoters(&poll)
+			messages[i].Poll = &poll
+		}
+	}
+	return messages, nil
+}
+
+// hidePollVoters removes who voted for each option from anonymous polls, keeping the counts
+func hidePollVoters(poll *models.Poll) {
+	if !poll.Anonymous {
+		return
+	}
+	options := make([]models.PollOption, len(poll.Options))
+	for i, option := range poll.Options {
+		options[i] = models.PollOption{Text: option.Text, Votes: option.Votes}
+	}
+	poll.Options = options
+}
+
+// pollStoreError maps poll storage errors to service errors
+func pollStoreError(err error) error {
+	switch {
+	case strings.Contains(err.Error(), "not found"):
+		return ErrPollNotFound
+	case strings.Contains(err.Error(), "closed"):
+		return ErrPollClosed
+	}
+	return err
+}
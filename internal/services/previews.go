@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"go-chat-api/internal/models"
+	"go-chat-api/internal/storage"
+	"go-chat-api/internal/unfurl"
+	"log"
+	"time"
+)
+
+// PreviewPolicy limits link preview fetching
+type PreviewPolicy struct {
+	// MaxLinks is the number of links previewed per message
+	MaxLinks int
+
+	// CacheTTL is how long a fetched preview is reused for other messages linking the same URL
+	CacheTTL time.Duration
+
+	// Workers limits the messages unfurled concurrently; messages sent while all workers
+	// are busy get no previews
+	Workers int
+}
+
+// WithLinkPreviews fetches previews of the links in new messages in the background,
+// caching them in store. notify is called with the message once its previews are stored,
+// so connected clients can be updated.
+func WithLinkPreviews(store storage.PreviewStore, fetcher *unfurl.Fetcher, policy PreviewPolicy, notify func(*models.Message)) Option {
+	return func(s *ChatService) {
+		if policy.MaxLinks <= 0 {
+			policy.MaxLinks = 3
+		}
+		if policy.Workers <= 0 {
+			policy.Workers = 8
+		}
+		s.previews = store
+		s.unfurler = fetcher
+		s.previewPolicy = policy
+		s.previewSlots = make(chan struct{}, policy.Workers)
+		s.notifyUpdate = notify
+	}
+}
+
+// schedulePreviews unfurls the links in a stored message in the background
+func (s *ChatService) schedulePreviews(message models.Message) {
+	if s.previews == nil {
+		return
+	}
+	urls := unfurl.ExtractURLs(message.Content, s.previewPolicy.MaxLinks)
+	if len(urls) == 0 {
+		return
+	}
+
+	select {
+	case s.previewSlots <- struct{}{}:
+	default:
+		log.Printf("Link preview workers are busy, skipping message %s", message.ID)
+		return
+	}
+
+	go func() {
+		defer func() { <-s.previewSlots }()
+		s.unfurlMessage(message, urls)
+	}()
+}
+
+// unfurlMessage stores the previews of the given links and links them to the message
+func (s *ChatService) unfurlMessage(message models.Message, urls []string) {
+	var previews []models.LinkPreview
+	for _, url := range urls {
+		preview, err := s.linkPreview(url)
+		if err != nil {
+			log.Printf("Failed to preview link in message %s: %v", message.ID, err)
+			continue
+		}
+		if preview == nil {
+			continue
+		}
+
+		if err := s.previews.AddMessagePreview(message.ID, url, len(previews)); err != nil {
+			// The message was deleted while its links were fetched
+			log.Printf("Failed to add link preview to message %s: %v", message.ID, err)
+			return
+		}
+		previews = append(previews, *preview)
+	}
+
+	if len(previews) == 0 || s.notifyUpdate == nil {
+		return
+	}
+	messages, err := s.populateAttachments([]models.Message{message})
+	if err != nil {
+		log.Printf("Failed to load attachments of message %s: %v", message.ID, err)
+		return
+	}
+	message = messages[0]
+	message.Previews = previews
+	s.notifyUpdate(&message)
+}
+
+// linkPreview returns the preview of a URL from the cache, fetching and storing it when
+// missing or stale. It returns nil for pages without preview metadata.
+func (s *ChatService) linkPreview(url string) (*models.LinkPreview, error) {
+	cached, err := s.previews.GetLinkPreview(url)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil && time.Since(cached.FetchedAt) < s.previewPolicy.CacheTTL {
+		return cached, nil
+	}
+
+	metadata, err := s.unfurler.Fetch(context.Background(), url)
+	if err != nil {
+		if cached != nil {
+			// Keep showing the stale preview while the page is unreachable
+			return cached, nil
+		}
+		return nil, err
+	}
+	if metadata.Empty() {
+		return nil, nil
+	}
+
+	// Previews are keyed by the URL in the message, which may differ from the final URL
+	// after redirects
+	preview := models.LinkPreview{
+		URL:         url,
+		Title:       metadata.Title,
+		Description: metadata.Description,
+		SiteName:    metadata.SiteName,
+		ImageURL:    metadata.ImageURL,
+		FetchedAt:   time.Now(),
+	}
+	if err := s.previews.SaveLinkPreview(preview); err != nil {
+		return nil, err
+	}
+	return &preview, nil
+}
+
+// populatePreviews sets the link previews of the given messages
+func (s *ChatService) populatePreviews(messages []models.Message) ([]models.Message, error) {
+	if s.previews == nil || len(messages) == 0 {
+		return messages, nil
+	}
+
+	ids := make([]string, len(messages))
+	for i, message := range messages {
+		ids[i] = message.ID
+	}
+	previews, err := s.previews.GetMessagePreviews(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range messages {
+		messages[i].Previews = previews[messages[i].ID]
+	}
+	return messages, nil
+}
+
+// populateMessages sets the attachments, link previews and polls of fetched messages
+func (s *ChatService) populateMessages(messages []models.Message) ([]models.Message, error) {
+	messages, err := s.populateAttachments(messages)
+	if err != nil {
+		return nil, err
+	}
+	messages, err = s.populatePreviews(messages)
+	if err != nil {
+		return nil, err
+	}
+	return s.populatePolls(messages)
+}
@@ -0,0 +1,304 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"go-chat-api/internal/audit"
+	"go-chat-api/internal/models"
+	"strings"
+	"time"
+)
+
+const (
+	// maxReportReasonLength limits the reason users give for a report
+	maxReportReasonLength = 1000
+
+	// defaultReportPageSize is used when a report search does not specify a limit
+	defaultReportPageSize = 50
+
+	// maxReportPageSize is the largest page of reports a search can return
+	maxReportPageSize = 200
+)
+
+var (
+	// ErrInvalidReport is wrapped by the errors returned for malformed reports
+	ErrInvalidReport = errors.New("invalid report")
+
+	// ErrOwnReport is returned when a user reports themselves or their own message
+	ErrOwnReport = errors.New("you cannot report yourself")
+
+	// ErrReportNotFound is returned for reports that do not exist
+	ErrReportNotFound = errors.New("report not found")
+
+	// ErrReportResolved is returned when a moderator acts on a report that was already actioned or dismissed
+	ErrReportResolved = errors.New("report has already been resolved")
+
+	// ErrInvalidReportAction is returned for unknown actions and actions that do not apply to a report
+	ErrInvalidReportAction = errors.New("invalid action for this report")
+
+	// ErrAdminRequired is returned when a moderator takes an action reserved for administrators
+	ErrAdminRequired = errors.New("only administrators can suspend users")
+)
+
+// CreateReport files a report by reporterID about a message or a user
+func (s *ChatService) CreateReport(reporterID string, req models.ReportRequest) (*models.Report, error) {
+	if s.reports == nil {
+		return nil, errors.New("reporting is not enabled")
+	}
+
+	reason := strings.TrimSpace(req.Reason)
+	switch {
+	case reason == "":
+		return nil, fmt.Errorf("%w: a reason is required", ErrInvalidReport)
+	case len(reason) > maxReportReasonLength:
+		return nil, fmt.Errorf("%w: the reason is limited to %d characters", ErrInvalidReport, maxReportReasonLength)
+	case (req.MessageID == "") == (req.UserID == ""):
+		return nil, fmt.Errorf("%w: report either a message or a user", ErrInvalidReport)
+	}
+
+	reporter, err := s.userStore.GetUser(reporterID)
+	if err != nil {
+		return nil, err
+	}
+	if reporter == nil {
+		return nil, errors.New("user not found")
+	}
+
+	id, err := generateID()
+	if err != nil {
+		return nil, err
+	}
+	report := models.Report{
+		ID:             id,
+		ReporterID:     reporterID,
+		ReportedUserID: req.UserID,
+		Reason:         reason,
+		Status:         models.ReportOpen,
+		CreatedAt:      time.Now(),
+	}
+
+	if req.MessageID != "" {
+		message, err := s.messageStore.GetMessage(req.MessageID)
+		if err != nil {
+			return nil, err
+		}
+		// Direct messages can only be reported by the people in the conversation
+		if message == nil || (message.Recipient != "" && message.Sender != reporter.Username && message.Recipient != reporter.Username) {
+			return nil, ErrMessageNotFound
+		}
+		sender, err := s.userStore.GetUserByUsername(message.Sender)
+		if err != nil {
+			return nil, err
+		}
+		if sender == nil {
+			return nil, ErrMessageNotFound
+		}
+
+		report.MessageID = message.ID
+		report.MessageContent = message.Content
+		report.ReportedUserID = sender.ID
+		report.RoomID = message.RoomID
+	} else {
+		user, err := s.userStore.GetUser(req.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil {
+			return nil, errors.New("user not found")
+		}
+	}
+
+	if report.ReportedUserID == reporterID {
+		return nil, ErrOwnReport
+	}
+
+	if err := s.reports.AddReport(report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// GetReports returns a page of the report queue, oldest first, and the total number of matches
+func (s *ChatService) GetReports(filter models.ReportFilter) ([]models.Report, int, error) {
+	if s.reports == nil {
+		return nil, 0, errors.New("reporting is not enabled")
+	}
+	switch filter.Status {
+	case "", models.ReportOpen, models.ReportActioned, models.ReportDismissed:
+	default:
+		return nil, 0, fmt.Errorf("%w: unknown status %q", ErrInvalidReport, filter.Status)
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = defaultReportPageSize
+	}
+	if filter.Limit > maxReportPageSize {
+		filter.Limit = maxReportPageSize
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	reports, total, err := s.reports.GetReports(filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	if reports == nil {
+		reports = []models.Report{}
+	}
+	return reports, total, nil
+}
+
+// GetReport returns a report by ID
+func (s *ChatService) GetReport(id string) (*models.Report, error) {
+	if s.reports == nil {
+		return nil, errors.New("reporting is not enabled")
+	}
+
+	report, err := s.reports.GetReport(id)
+	if err != nil {
+		return nil, err
+	}
+	if report == nil {
+		return nil, ErrReportNotFound
+	}
+	return report, nil
+}
+
+// ActOnReport takes an action against the reported message or user on behalf of actorID
+// and marks the report actioned. Suspensions require admin.
+func (s *ChatService) ActOnReport(actorID, reportID string, req models.ReportActionRequest, admin bool, ip string) (*models.Report, error) {
+	report, err := s.openReport(reportID)
+	if err != nil {
+		return nil, err
+	}
+
+	note := strings.TrimSpace(req.Note)
+	reason := note
+	if reason == "" {
+		reason = report.Reason
+	}
+
+	switch req.Action {
+	case models.ReportActionDeleteMessage:
+		if report.MessageID == "" {
+			return nil, ErrInvalidReportAction
+		}
+		// The message may already have been deleted by its sender or another moderator
+		err := s.DeleteMessage(actorID, report.MessageID, true, ip)
+		if err != nil && !errors.Is(err, ErrMessageNotFound) {
+			return nil, err
+		}
+	case models.ReportActionMute:
+		if report.RoomID == "" {
+			return nil, ErrInvalidReportAction
+		}
+		mute := models.MuteRequest{DurationSeconds: req.DurationSeconds, Reason: reason}
+		if _, err := s.MuteRoomMember(actorID, report.RoomID, report.ReportedUserID, mute, ip); err != nil {
+			return nil, err
+		}
+	case models.ReportActionSuspend:
+		if !admin {
+			return nil, ErrAdminRequired
+		}
+		if err := s.SuspendUser(actorID, report.ReportedUserID, reason, ip); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrInvalidReportAction
+	}
+
+	return s.resolveReport(actorID, report, models.ReportActioned, req.Action, note, ip)
+}
+
+// DismissReport closes a report without action on behalf of actorID
+func (s *ChatService) DismissReport(actorID, reportID, note, ip string) (*models.Report, error) {
+	report, err := s.openReport(reportID)
+	if err != nil {
+		return nil, err
+	}
+	return s.resolveReport(actorID, report, models.ReportDismissed, "", strings.TrimSpace(note), ip)
+}
+
+// openReport returns a report that has not been resolved yet
+func (s *ChatService) openReport(id string) (*models.Report, error) {
+	report, err := s.GetReport(id)
+	if err != nil {
+		return nil, err
+	}
+	if report.Status != models.ReportOpen {
+		return nil, ErrReportResolved
+	}
+	return report, nil
+}
+
+// resolveReport records the outcome of a report and returns the updated report
+func (s *ChatService) resolveReport(actorID string, report *models.Report, status, action, note, ip string) (*models.Report, error) {
+	now := time.Now()
+	if err := s.reports.ResolveReport(report.ID, status, action, note, actorID, now); err != nil {
+		// Another moderator resolved the report first
+		if strings.Contains(err.Error(), "not found") {
+			return nil, ErrReportResolved
+		}
+		return nil, err
+	}
+
+	auditAction := audit.ActionReportDismissed
+	metadata := map[string]string{"reported_user_id": report.ReportedUserID}
+	if status == models.ReportActioned {
+		auditAction = audit.ActionReportActioned
+		metadata["action"] = action
+	}
+	s.auditLog.Record(models.AuditEvent{
+		Action:   auditAction,
+		ActorID:  actorID,
+		TargetID: report.ID,
+		IP:       ip,
+		Metadata: metadata,
+	})
+
+	report.Status = status
+	report.Action = action
+	report.Note = note
+	report.ResolvedBy = actorID
+	report.ResolvedAt = &now
+	return report, nil
+}
+
+// GetReportModerators returns the IDs of the moderators and administrators to notify of a
+// report: those who are members of the report's room, or every moderator and administrator
+// when the report is not about a room message or the room has none among its members
+func (s *ChatService) GetReportModerators(report *models.Report) ([]string, error) {
+	users, err := s.userStore.GetAllUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	var moderators []string
+	for _, user := range users {
+		if (user.Role == models.RoleModerator || user.Role == models.RoleAdmin) && !user.IsSuspended() {
+			moderators = append(moderators, user.ID)
+		}
+	}
+	if report.RoomID == "" {
+		return moderators, nil
+	}
+
+	room, err := s.roomStore.GetRoom(report.RoomID)
+	if err != nil || room == nil {
+		return moderators, nil
+	}
+	members := make(map[string]bool, len(room.Members))
+	for _, member := range room.Members {
+		members[member] = true
+	}
+	var roomModerators []string
+	for _, id := range moderators {
+		if members[id] {
+			roomModerators = append(roomModerators, id)
+		}
+	}
+	if len(roomModerators) == 0 {
+		return moderators, nil
+	}
+	return roomModerators, nil
+}
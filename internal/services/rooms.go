@@ -0,0 +1,236 @@
+package services
+
+import (
+	"errors"
+	"go-chat-api/internal/audit"
+	"go-chat-api/internal/models"
+	"hash/fnv"
+	"strconv"
+	"time"
+)
+
+// maxSlowModeSeconds is the longest slow-mode interval a room can use (six hours)
+const maxSlowModeSeconds = 6 * 60 * 60
+
+// postingLockStripes is the number of locks serializing room posts by sender
+const postingLockStripes = 64
+
+// maxMessageTTLSeconds is the longest time disappearing messages can be kept (30 days)
+const maxMessageTTLSeconds = 30 * 24 * 60 * 60
+
+var (
+	// ErrRoomNotFound is returned for rooms that do not exist
+	ErrRoomNotFound = errors.New("room not found")
+
+	// ErrRoomReadOnly is returned when a user without the global admin role posts in a
+	// read-only room
+	ErrRoomReadOnly = errors.New("only administrators can post in this room")
+
+	// ErrInvalidSlowMode is returned for slow-mode intervals outside 0 to maxSlowModeSeconds
+	ErrInvalidSlowMode = errors.New("slow mode interval must be between 0 and 21600 seconds")
+
+	// ErrInvalidMessageTTL is returned for message TTLs outside 0 to maxMessageTTLSeconds
+	ErrInvalidMessageTTL = errors.New("message TTL must be between 0 and 2592000 seconds")
+
+	// ErrInvalidMuteDuration is returned for negative mute durations
+	ErrInvalidMuteDuration = errors.New("mute duration cannot be negative")
+
+	// ErrProtectedMember is returned when a moderator tries to mute themselves or an administrator
+	ErrProtectedMember = errors.New("administrators and your own account cannot be muted")
+)
+
+// SlowModeError is returned when a member posts again before the slow-mode interval of
+// the room has passed
+type SlowModeError struct {
+	RetryAfter time.Duration
+}
+
+func (e *SlowModeError) Error() string {
+	return "slow mode is enabled in this room, wait before sending another message"
+}
+
+// MutedError is returned when a muted member posts in a room
+type MutedError struct {
+	Until *time.Time // nil when the mute lasts until it is lifted
+}
+
+func (e *MutedError) Error() string {
+	return "you are muted in this room"
+}
+
+// lockRoomPosting serializes the room posts of a sender, so that concurrent messages cannot
+// all pass the slow-mode check before the first one is stored. It returns the unlock function.
+func (s *ChatService) lockRoomPosting(roomID, username string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(roomID))
+	h.Write([]byte{0})
+	h.Write([]byte(username))
+
+	mu := &s.postingLocks[h.Sum32()%postingLockStripes]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// checkRoomPosting enforces the posting restrictions of a room for a sender, identified
+// by username. Only users with the global admin role may post in read-only rooms; admins
+// and global moderators are not subject to slow mode.
+func (s *ChatService) checkRoomPosting(roomID, username string) error {
+	room, err := s.roomStore.GetRoom(roomID)
+	if err != nil || room == nil {
+		return ErrRoomNotFound
+	}
+
+	sender, err := s.userStore.GetUserByUsername(username)
+	if err != nil {
+		return err
+	}
+	if sender == nil {
+		return errors.New("user not found")
+	}
+
+	if room.ReadOnly && sender.Role != models.RoleAdmin {
+		return ErrRoomReadOnly
+	}
+
+	now := time.Now()
+	mute, err := s.roomStore.GetRoomMute(roomID, sender.ID)
+	if err != nil {
+		return err
+	}
+	if mute != nil && mute.IsActive(now) {
+		return &MutedError{Until: mute.ExpiresAt}
+	}
+
+	if room.SlowModeSeconds > 0 && sender.Role != models.RoleAdmin && sender.Role != models.RoleModerator {
+		last, err := s.messageStore.GetLastMessageTime(roomID, username)
+		if err != nil {
+			return err
+		}
+		if last != nil {
+			if wait := last.Add(time.Duration(room.SlowModeSeconds) * time.Second).Sub(now); wait > 0 {
+				return &SlowModeError{RetryAfter: wait}
+			}
+		}
+	}
+
+	return nil
+}
+
+// UpdateRoomSettings changes the slow-mode interval, read-only flag and default message TTL
+// of a room on behalf of actorID
+func (s *ChatService) UpdateRoomSettings(actorID, roomID string, req models.RoomSettingsRequest, ip string) (*models.ChatRoom, error) {
+	if req.SlowModeSeconds < 0 || req.SlowModeSeconds > maxSlowModeSeconds {
+		return nil, ErrInvalidSlowMode
+	}
+	if req.MessageTTLSeconds < 0 || req.MessageTTLSeconds > maxMessageTTLSeconds {
+		return nil, ErrInvalidMessageTTL
+	}
+
+	if room, err := s.roomStore.GetRoom(roomID); err != nil || room == nil {
+		return nil, ErrRoomNotFound
+	}
+	if err := s.roomStore.UpdateRoomSettings(roomID, req.SlowModeSeconds, req.ReadOnly, req.MessageTTLSeconds); err != nil {
+		return nil, err
+	}
+
+	s.auditLog.Record(models.AuditEvent{
+		Action:   audit.ActionRoomSettingsChanged,
+		ActorID:  actorID,
+		TargetID: roomID,
+		IP:       ip,
+		Metadata: map[string]string{
+			"slow_mode_seconds": strconv.Itoa(req.SlowModeSeconds),
+			"read_only":         strconv.FormatBool(req.ReadOnly),
+			"message_ttl":       strconv.Itoa(req.MessageTTLSeconds),
+		},
+	})
+	return s.roomStore.GetRoom(roomID)
+}
+
+// MuteRoomMember stops a user from posting in a room on behalf of actorID, for
+// req.DurationSeconds or until the mute is lifted when the duration is zero
+func (s *ChatService) MuteRoomMember(actorID, roomID, userID string, req models.MuteRequest, ip string) (*models.RoomMute, error) {
+	if req.DurationSeconds < 0 {
+		return nil, ErrInvalidMuteDuration
+	}
+	if actorID == userID {
+		return nil, ErrProtectedMember
+	}
+
+	if room, err := s.roomStore.GetRoom(roomID); err != nil || room == nil {
+		return nil, ErrRoomNotFound
+	}
+	user, err := s.userStore.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("user not found")
+	}
+	if user.Role == models.RoleAdmin {
+		return nil, ErrProtectedMember
+	}
+
+	mute := models.RoomMute{
+		RoomID:    roomID,
+		UserID:    userID,
+		MutedBy:   actorID,
+		Reason:    req.Reason,
+		CreatedAt: time.Now(),
+	}
+	if req.DurationSeconds > 0 {
+		expiresAt := mute.CreatedAt.Add(time.Duration(req.DurationSeconds) * time.Second)
+		mute.ExpiresAt = &expiresAt
+	}
+	if err := s.roomStore.SetRoomMute(mute); err != nil {
+		return nil, err
+	}
+
+	metadata := map[string]string{"room_id": roomID}
+	if mute.ExpiresAt != nil {
+		metadata["expires_at"] = mute.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	if req.Reason != "" {
+		metadata["reason"] = req.Reason
+	}
+	s.auditLog.Record(models.AuditEvent{
+		Action:   audit.ActionRoomMemberMuted,
+		ActorID:  actorID,
+		TargetID: userID,
+		IP:       ip,
+		Metadata: metadata,
+	})
+	return &mute, nil
+}
+
+// UnmuteRoomMember lifts the mute of a room member on behalf of actorID
+func (s *ChatService) UnmuteRoomMember(actorID, roomID, userID, ip string) error {
+	if err := s.roomStore.DeleteRoomMute(roomID, userID); err != nil {
+		return err
+	}
+
+	s.auditLog.Record(models.AuditEvent{
+		Action:   audit.ActionRoomMemberUnmuted,
+		ActorID:  actorID,
+		TargetID: userID,
+		IP:       ip,
+		Metadata: map[string]string{"room_id": roomID},
+	})
+	return nil
+}
+
+// GetRoomMutes returns the active mutes of a room
+func (s *ChatService) GetRoomMutes(roomID string) ([]models.RoomMute, error) {
+	if room, err := s.roomStore.GetRoom(roomID); err != nil || room == nil {
+		return nil, ErrRoomNotFound
+	}
+
+	mutes, err := s.roomStore.GetRoomMutes(roomID)
+	if err != nil {
+		return nil, err
+	}
+	if mutes == nil {
+		mutes = []models.RoomMute{}
+	}
+	return mutes, nil
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"go-chat-api/internal/models"
+	"go-chat-api/internal/storage"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxSavedNoteLength limits the private note of a saved message, in characters
+const maxSavedNoteLength = 1000
+
+var (
+	// ErrSavedMessagesDisabled is returned when no saved message storage is configured
+	ErrSavedMessagesDisabled = errors.New("saved messages are not enabled")
+
+	// ErrSavedMessageNotFound is returned when removing a message that is not saved
+	ErrSavedMessageNotFound = errors.New("saved message not found")
+
+	// ErrNoteTooLong is returned for saved message notes over maxSavedNoteLength characters
+	ErrNoteTooLong = fmt.Errorf("note cannot be longer than %d characters", maxSavedNoteLength)
+)
+
+// WithSavedMessages enables private per-user bookmarks of messages
+func WithSavedMessages(store storage.SavedMessageStore) Option {
+	return func(s *ChatService) {
+		s.saved = store
+	}
+}
+
+// SaveMessage bookmarks a message the user can read, or replaces the note of a message
+// they already saved
+func (s *ChatService) SaveMessage(userID, messageID, note string) (*models.SavedMessage, error) {
+	if s.saved == nil {
+		return nil, ErrSavedMessagesDisabled
+	}
+	note = strings.TrimSpace(note)
+	if utf8.RuneCountInString(note) > maxSavedNoteLength {
+		return nil, ErrNoteTooLong
+	}
+
+	// Messages the user cannot read are reported as missing, so their existence is not revealed
+	visible, err := s.canViewMessage(userID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if !visible {
+		return nil, ErrMessageNotFound
+	}
+
+	now := time.Now()
+	return s.saved.SaveMessage(models.SavedMessage{
+		UserID:    userID,
+		MessageID: messageID,
+		Note:      note,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+}
+
+// UnsaveMessage removes a user's bookmark of a message
+func (s *ChatService) UnsaveMessage(userID, messageID string) error {
+	if s.saved == nil {
+		return ErrSavedMessagesDisabled
+	}
+	if err := s.saved.DeleteSavedMessage(userID, messageID); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return ErrSavedMessageNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// GetSavedMessages returns a user's saved messages, most recently saved first. Messages
+// the user can no longer read, such as those in rooms they left, and messages from users
+// they blocked are left out but stay saved.
+func (s *ChatService) GetSavedMessages(userID string) ([]models.SavedMessage, error) {
+	if s.saved == nil {
+		return nil, ErrSavedMessagesDisabled
+	}
+
+	list, err := s.saved.GetSavedMessages(userID)
+	if err != nil {
+		return nil, err
+	}
+	blocked, err := s.blockedUsernames(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	visible := make([]models.SavedMessage, 0, len(list))
+	messages := make([]models.Message, 0, len(list))
+	for _, saved := range list {
+		if saved.Message == nil || blocked[saved.Message.Sender] {
+			continue
+		}
+		readable, err := s.canReadMessage(userID, *saved.Message)
+		if err != nil {
+			return nil, err
+		}
+		if !readable {
+			continue
+		}
+		visible = append(visible, saved)
+		messages = append(messages, *saved.Message)
+	}
+
+	messages, err = s.populateMessages(messages)
+	if err != nil {
+		return nil, err
+	}
+	for i := range visible {
+		visible[i].Message = &messages[i]
+	}
+	return visible, nil
+}
@@ -0,0 +1,316 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"go-chat-api/internal/models"
+	"go-chat-api/internal/storage"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// maxScheduleAhead is how far in the future messages and reminders can be scheduled
+	maxScheduleAhead = 365 * 24 * time.Hour
+
+	// maxPendingScheduled limits the pending scheduled messages, and separately the
+	// pending reminders, of each user
+	maxPendingScheduled = 100
+)
+
+var (
+	// ErrSchedulingDisabled is returned when no schedule storage is configured
+	ErrSchedulingDisabled = errors.New("scheduled messages are not enabled")
+
+	// ErrInvalidSchedule is wrapped by the errors returned for invalid scheduled messages and reminders
+	ErrInvalidSchedule = errors.New("invalid schedule")
+
+	// ErrScheduledMessageNotFound is returned for scheduled messages that do not exist or are no longer pending
+	ErrScheduledMessageNotFound = errors.New("scheduled message not found")
+
+	// ErrReminderNotFound is returned for reminders that do not exist or are no longer pending
+	ErrReminderNotFound = errors.New("reminder not found")
+)
+
+// WithScheduling enables scheduled messages and reminders. notifyReminder delivers
+// "reminder" events to users when their reminders are due.
+func WithScheduling(store storage.ScheduleStore, notifyReminder func(userIDs []string, event map[string]interface{})) Option {
+	return func(s *ChatService) {
+		s.schedules = store
+		s.notifyReminder = notifyReminder
+	}
+}
+
+// validateScheduleTime checks that a scheduled time is in the future and not too far ahead
+func validateScheduleTime(at, now time.Time) error {
+	if !at.After(now) {
+		return fmt.Errorf("%w: the time must be in the future", ErrInvalidSchedule)
+	}
+	if at.After(now.Add(maxScheduleAhead)) {
+		return fmt.Errorf("%w: the time must be within a year", ErrInvalidSchedule)
+	}
+	return nil
+}
+
+// ScheduleMessage stores a message from userID to be sent to a room or a user at
+// req.SendAt. The room, recipient and format are checked now; posting restrictions
+// and moderation apply when the message is sent.
+func (s *ChatService) ScheduleMessage(userID string, req models.ScheduleMessageRequest) (*models.ScheduledMessage, error) {
+	if s.schedules == nil {
+		return nil, ErrSchedulingDisabled
+	}
+
+	format, err := messageFormat(req.Format)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		return nil, fmt.Errorf("%w: content is required", ErrInvalidSchedule)
+	}
+	if (req.RoomID == "") == (req.Recipient == "") {
+		return nil, fmt.Errorf("%w: either a room or a recipient is required", ErrInvalidSchedule)
+	}
+	now := time.Now()
+	if err := validateScheduleTime(req.SendAt, now); err != nil {
+		return nil, err
+	}
+
+	user, err := s.userStore.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("user not found")
+	}
+
+	if req.RoomID != "" {
+		room, err := s.roomStore.GetRoom(req.RoomID)
+		if err != nil || room == nil {
+			return nil, ErrRoomNotFound
+		}
+		if !slices.Contains(room.Members, userID) {
+			return nil, ErrNotRoomMember
+		}
+	} else {
+		recipient, err := s.userStore.GetUserByUsername(req.Recipient)
+		if err != nil {
+			return nil, err
+		}
+		if recipient == nil {
+			return nil, fmt.Errorf("%w: recipient not found", ErrInvalidSchedule)
+		}
+		if err := s.checkDirectMessage(user.Username, req.Recipient); err != nil {
+			return nil, err
+		}
+	}
+
+	pending, err := s.schedules.GetScheduledMessages(userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) >= maxPendingScheduled {
+		return nil, fmt.Errorf("%w: at most %d messages can be scheduled", ErrInvalidSchedule, maxPendingScheduled)
+	}
+
+	id, err := generateID()
+	if err != nil {
+		return nil, err
+	}
+	message := models.ScheduledMessage{
+		ID:        id,
+		UserID:    userID,
+		Recipient: req.Recipient,
+		RoomID:    req.RoomID,
+		Content:   req.Content,
+		Format:    format,
+		SendAt:    req.SendAt,
+		Status:    models.SchedulePending,
+		CreatedAt: now,
+	}
+	if err := s.schedules.AddScheduledMessage(message); err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// GetScheduledMessages returns a user's pending scheduled messages, soonest first
+func (s *ChatService) GetScheduledMessages(userID string) ([]models.ScheduledMessage, error) {
+	if s.schedules == nil {
+		return nil, ErrSchedulingDisabled
+	}
+
+	messages, err := s.schedules.GetScheduledMessages(userID)
+	if err != nil {
+		return nil, err
+	}
+	if messages == nil {
+		messages = []models.ScheduledMessage{}
+	}
+	return messages, nil
+}
+
+// CancelScheduledMessage cancels a pending scheduled message of a user
+func (s *ChatService) CancelScheduledMessage(userID, id string) error {
+	if s.schedules == nil {
+		return ErrSchedulingDisabled
+	}
+	if err := s.schedules.CancelScheduledMessage(userID, id); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return ErrScheduledMessageNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// SendScheduledMessage sends a due scheduled message through SendMessage, as its author.
+// The author must still be a member of the room the message is scheduled for.
+func (s *ChatService) SendScheduledMessage(scheduled models.ScheduledMessage) (*models.Message, error) {
+	user, err := s.userStore.GetUser(scheduled.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("user not found")
+	}
+
+	if scheduled.RoomID != "" {
+		room, err := s.roomStore.GetRoom(scheduled.RoomID)
+		if err != nil || room == nil {
+			return nil, ErrRoomNotFound
+		}
+		if !slices.Contains(room.Members, user.ID) {
+			return nil, ErrNotRoomMember
+		}
+	}
+
+	return s.SendMessage(models.MessageRequest{
+		Sender:    user.Username,
+		Recipient: scheduled.Recipient,
+		RoomID:    scheduled.RoomID,
+		Content:   scheduled.Content,
+		Format:    scheduled.Format,
+	})
+}
+
+// SetReminder reminds a user of a message they can read at req.RemindAt
+func (s *ChatService) SetReminder(userID, messageID string, req models.ReminderRequest) (*models.Reminder, error) {
+	if s.schedules == nil {
+		return nil, ErrSchedulingDisabled
+	}
+
+	note := strings.TrimSpace(req.Note)
+	if utf8.RuneCountInString(note) > maxSavedNoteLength {
+		return nil, ErrNoteTooLong
+	}
+	now := time.Now()
+	if err := validateScheduleTime(req.RemindAt, now); err != nil {
+		return nil, err
+	}
+
+	message, err := s.messageStore.GetMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message == nil {
+		return nil, ErrMessageNotFound
+	}
+	readable, err := s.canReadMessage(userID, *message)
+	if err != nil {
+		return nil, err
+	}
+	if !readable {
+		return nil, ErrMessageNotFound
+	}
+
+	pending, err := s.schedules.GetReminders(userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) >= maxPendingScheduled {
+		return nil, fmt.Errorf("%w: at most %d reminders can be set", ErrInvalidSchedule, maxPendingScheduled)
+	}
+
+	id, err := generateID()
+	if err != nil {
+		return nil, err
+	}
+	reminder := models.Reminder{
+		ID:        id,
+		UserID:    userID,
+		MessageID: messageID,
+		Note:      note,
+		RemindAt:  req.RemindAt,
+		Status:    models.SchedulePending,
+		CreatedAt: now,
+	}
+	if err := s.schedules.AddReminder(reminder); err != nil {
+		return nil, err
+	}
+	reminder.Message = message
+	return &reminder, nil
+}
+
+// GetReminders returns a user's pending reminders with their messages, soonest first
+func (s *ChatService) GetReminders(userID string) ([]models.Reminder, error) {
+	if s.schedules == nil {
+		return nil, ErrSchedulingDisabled
+	}
+
+	reminders, err := s.schedules.GetReminders(userID)
+	if err != nil {
+		return nil, err
+	}
+	if reminders == nil {
+		reminders = []models.Reminder{}
+	}
+	return reminders, nil
+}
+
+// CancelReminder cancels a pending reminder of a user
+func (s *ChatService) CancelReminder(userID, id string) error {
+	if s.schedules == nil {
+		return ErrSchedulingDisabled
+	}
+	if err := s.schedules.CancelReminder(userID, id); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return ErrReminderNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// SendReminder notifies a user of a due reminder with a "reminder" event. It fails with
+// ErrMessageNotFound when the user can no longer read the message.
+func (s *ChatService) SendReminder(reminder models.Reminder) error {
+	message, err := s.messageStore.GetMessage(reminder.MessageID)
+	if err != nil {
+		return err
+	}
+	if message == nil {
+		return ErrMessageNotFound
+	}
+	readable, err := s.canReadMessage(reminder.UserID, *message)
+	if err != nil {
+		return err
+	}
+	if !readable {
+		return ErrMessageNotFound
+	}
+
+	messages, err := s.populateMessages([]models.Message{*message})
+	if err != nil {
+		return err
+	}
+	reminder.Message = &messages[0]
+	if s.notifyReminder != nil {
+		s.notifyReminder([]string{reminder.UserID}, map[string]interface{}{
+			"type":     "reminder",
+			"reminder": reminder,
+		})
+	}
+	return nil
+}
@@ -1,6 +1,10 @@
 package storage
 
-import "go-chat-api/internal/models"
+import (
+	"go-chat-api/internal/models"
+	"io"
+	"time"
+)
 
 // MessageStore defines the interface for message storage operations
 type MessageStore interface {
@@ -8,6 +12,15 @@ type MessageStore interface {
 	GetMessages() ([]models.Message, error)
 	GetMessagesByRoom(roomID string) ([]models.Message, error)
 	GetMessagesBetweenUsers(user1, user2 string) ([]models.Message, error)
+	GetMessage(messageID string) (*models.Message, error)
+	DeleteMessage(messageID string) error
+	// GetLastMessageTime returns when sender last posted in a room, or nil if they never did
+	GetLastMessageTime(roomID, sender string) (*time.Time, error)
+	// GetMessagesBySender returns the messages sent by a user since the given time, oldest first
+	GetMessagesBySender(sender string, since time.Time) ([]models.Message, error)
+	// DeleteExpiredMessages deletes up to limit messages that expired at now, soonest first,
+	// and returns them with their attachments, whose records are deleted with them
+	DeleteExpiredMessages(now time.Time, limit int) ([]models.Message, error)
 }
 
 // UserStore defines the interface for user storage operations
@@ -18,6 +31,13 @@ type UserStore interface {
 	GetUserByEmail(email string) (*models.User, error)
 	UpdateUserStatus(userID string, isOnline bool) error
 	GetAllUsers() ([]models.User, error)
+	UpdateUserPassword(userID, passwordHash string) error
+	SetUserVerified(userID string, verified bool) error
+	SetUserRole(userID, role string) error
+	GetBotsByOwner(ownerID string) ([]models.User, error)
+	SearchUsers(filter models.UserFilter) ([]models.User, int, error)
+	SetUserSuspended(userID string, suspendedAt *time.Time, reason string) error
+	DeleteUser(userID string) error
 }
 
 // RoomStore defines the interface for chat room storage operations
@@ -27,4 +47,213 @@ type RoomStore interface {
 	GetRoomsByUser(userID string) ([]models.ChatRoom, error)
 	AddUserToRoom(roomID, userID string) error
 	RemoveUserFromRoom(roomID, userID string) error
+	UpdateRoomSettings(roomID string, slowModeSeconds int, readOnly bool, messageTTLSeconds int) error
+	// SetRoomMute creates or replaces the mute of a room member
+	SetRoomMute(mute models.RoomMute) error
+	// GetRoomMute returns the mute of a room member, or nil if there is none. Expired mutes are returned too.
+	GetRoomMute(roomID, userID string) (*models.RoomMute, error)
+	// GetRoomMutes returns the mutes of a room that have not expired
+	GetRoomMutes(roomID string) ([]models.RoomMute, error)
+	DeleteRoomMute(roomID, userID string) error
+}
+
+// SessionStore defines the interface for authentication session storage operations
+type SessionStore interface {
+	CreateSession(session models.Session) error
+	GetSession(sessionID string) (*models.Session, error)
+	RevokeSession(sessionID string) error
+	RevokeUserSessions(userID, exceptSessionID string) error
+}
+
+// TokenStore defines the interface for single-use user token storage operations
+type TokenStore interface {
+	AddUserToken(token models.UserToken) error
+	// ConsumeUserToken marks an unused, unexpired token as used and returns it.
+	// It returns nil if no such token exists.
+	ConsumeUserToken(tokenHash, purpose string) (*models.UserToken, error)
+}
+
+// TicketStore defines the interface for single-use WebSocket ticket storage operations
+type TicketStore interface {
+	AddWebSocketTicket(ticket models.WebSocketTicket) error
+	// ConsumeWebSocketTicket removes a ticket and returns it if it had not expired.
+	// It returns nil if no such ticket exists.
+	ConsumeWebSocketTicket(ticketHash string) (*models.WebSocketTicket, error)
+}
+
+// LoginAttemptStore defines the interface for failed login tracking operations
+type LoginAttemptStore interface {
+	GetLoginThrottle(key string) (*models.LoginThrottle, error)
+	// IncrementLoginFailures records a failure at the given time. Counters whose last
+	// failure happened before resetBefore start over from one.
+	IncrementLoginFailures(key string, at, resetBefore time.Time) (*models.LoginThrottle, error)
+	SetLoginLockout(key string, until time.Time) error
+	ClearLoginThrottle(key string) error
+}
+
+// AuditStore defines the interface for audit trail storage operations
+type AuditStore interface {
+	// AddAuditEvent appends an event, assigning its sequence number and chain hashes
+	AddAuditEvent(event models.AuditEvent) error
+	GetAuditEvents() ([]models.AuditEvent, error)
+	SearchAuditEvents(filter models.AuditFilter) ([]models.AuditEvent, int, error)
+}
+
+// IdentityStore defines the interface for external identity link storage operations
+type IdentityStore interface {
+	AddUserIdentity(identity models.UserIdentity) error
+	GetUserIdentity(provider, subject string) (*models.UserIdentity, error)
+}
+
+// APIKeyStore defines the interface for API key storage operations
+type APIKeyStore interface {
+	AddAPIKey(key models.APIKey) error
+	GetAPIKey(keyID string) (*models.APIKey, error)
+	GetAPIKeyByHash(keyHash string) (*models.APIKey, error)
+	GetAPIKeysByUser(userID string) ([]models.APIKey, error)
+	RevokeAPIKey(keyID string) error
+	TouchAPIKey(keyID string, usedAt time.Time) error
+}
+
+// BlockStore defines the interface for user block list storage operations
+type BlockStore interface {
+	// AddUserBlock records a block; blocking a user twice is not an error
+	AddUserBlock(block models.UserBlock) error
+	DeleteUserBlock(userID, blockedID string) error
+	// GetUserBlocks returns the users blocked by userID along with their usernames
+	GetUserBlocks(userID string) ([]models.UserBlock, error)
+	IsUserBlocked(userID, blockedID string) (bool, error)
+}
+
+// ModerationStore defines the interface for room filter settings and the held message review queue
+type ModerationStore interface {
+	// GetRoomModeration returns the filter settings of a room, or nil if it has none
+	GetRoomModeration(roomID string) (*models.RoomModeration, error)
+	SetRoomModeration(settings models.RoomModeration) error
+	AddHeldMessage(message models.HeldMessage) error
+	GetHeldMessage(id string) (*models.HeldMessage, error)
+	// GetHeldMessages returns the pending held messages, oldest first, of a room or of all rooms when roomID is empty
+	GetHeldMessages(roomID string) ([]models.HeldMessage, error)
+	// ReviewHeldMessage moves a pending held message to the approved or rejected status.
+	// It fails if the message is not pending, so each message is reviewed once.
+	ReviewHeldMessage(id, status, reviewerID string, at time.Time) error
+}
+
+// ReportStore defines the interface for user report storage operations
+type ReportStore interface {
+	AddReport(report models.Report) error
+	GetReport(id string) (*models.Report, error)
+	// GetReports returns a page of reports matching the filter, oldest first, and the total number of matches
+	GetReports(filter models.ReportFilter) ([]models.Report, int, error)
+	// ResolveReport moves an open report to the actioned or dismissed status. It fails if
+	// the report is not open, so each report is resolved once.
+	ResolveReport(id, status, action, note, resolverID string, at time.Time) error
+}
+
+// AttachmentStore defines the interface for message attachment metadata storage operations
+type AttachmentStore interface {
+	AddAttachment(attachment models.Attachment) error
+	GetAttachment(id string) (*models.Attachment, error)
+	// AttachToMessage links uploaded attachments to a message. It fails unless every
+	// attachment belongs to uploaderID and is not linked to a message yet.
+	AttachToMessage(messageID, uploaderID string, ids []string) error
+	// GetMessageAttachments returns the attachments of the given messages, oldest first, by message ID
+	GetMessageAttachments(messageIDs []string) (map[string][]models.Attachment, error)
+}
+
+// MentionStore defines the interface for mention storage operations
+type MentionStore interface {
+	// AddMentions records mentions; a user is mentioned at most once per message
+	AddMentions(mentions []models.Mention) error
+	// GetUnreadMentions returns a user's unread mentions with their messages, newest first
+	GetUnreadMentions(userID string, limit int) ([]models.Mention, error)
+	// MarkMentionsRead marks the given unread mentions of a user as read, or all of them
+	// when ids is empty, and returns how many were marked
+	MarkMentionsRead(userID string, ids []string, readAt time.Time) (int, error)
+}
+
+// PinStore defines the interface for pinned message storage operations
+type PinStore interface {
+	// AddPin pins a message to its room unless the room already has max pins, and reports
+	// whether the message is pinned. Pinning a pinned message again changes nothing.
+	AddPin(pin models.PinnedMessage, max int) (bool, error)
+	// RemovePin unpins a message. It fails with a "pin not found" error when it is not pinned.
+	RemovePin(roomID, messageID string) error
+	// GetPins returns the pins of a room with their messages, most recently pinned first
+	GetPins(roomID string) ([]models.PinnedMessage, error)
+}
+
+// SavedMessageStore defines the interface for bookmarked message storage operations
+type SavedMessageStore interface {
+	// SaveMessage bookmarks a message for a user, replacing the note of an existing bookmark,
+	// and returns the stored bookmark
+	SaveMessage(saved models.SavedMessage) (*models.SavedMessage, error)
+	// DeleteSavedMessage removes a bookmark. It fails with a "saved message not found" error when there is none.
+	DeleteSavedMessage(userID, messageID string) error
+	// GetSavedMessages returns a user's bookmarks with their messages, most recently saved first
+	GetSavedMessages(userID string) ([]models.SavedMessage, error)
+}
+
+// ScheduleStore defines the interface for scheduled message and reminder storage operations
+type ScheduleStore interface {
+	AddScheduledMessage(message models.ScheduledMessage) error
+	// GetScheduledMessages returns a user's pending scheduled messages, soonest first
+	GetScheduledMessages(userID string) ([]models.ScheduledMessage, error)
+	// CancelScheduledMessage cancels a pending scheduled message of a user. It fails with
+	// a "scheduled message not found" error when there is none.
+	CancelScheduledMessage(userID, id string) error
+	// ClaimDueScheduledMessages marks up to limit pending messages due at now as sending
+	// and returns them, soonest first. Messages claimed before staleBefore that were never
+	// completed are claimed again. Concurrent callers never claim the same message.
+	ClaimDueScheduledMessages(now, staleBefore time.Time, limit int) ([]models.ScheduledMessage, error)
+	// CompleteScheduledMessage records the outcome of a claimed message
+	CompleteScheduledMessage(id, status, messageID, failure string) error
+
+	AddReminder(reminder models.Reminder) error
+	// GetReminders returns a user's pending reminders with their messages, soonest first
+	GetReminders(userID string) ([]models.Reminder, error)
+	// CancelReminder cancels a pending reminder of a user. It fails with a "reminder not
+	// found" error when there is none.
+	CancelReminder(userID, id string) error
+	// ClaimDueReminders claims due reminders like ClaimDueScheduledMessages
+	ClaimDueReminders(now, staleBefore time.Time, limit int) ([]models.Reminder, error)
+	// CompleteReminder records the outcome of a claimed reminder
+	CompleteReminder(id, status string) error
+}
+
+// PreviewStore defines the interface for link preview storage operations
+type PreviewStore interface {
+	GetLinkPreview(url string) (*models.LinkPreview, error)
+	// SaveLinkPreview stores the preview of a URL, replacing an older one
+	SaveLinkPreview(preview models.LinkPreview) error
+	// AddMessagePreview links a stored preview to a message at the given position.
+	// It fails with a "message not found" error when the message was deleted.
+	AddMessagePreview(messageID, url string, position int) error
+	// GetMessagePreviews returns the previews of the given messages in position order, by message ID
+	GetMessagePreviews(messageIDs []string) (map[string][]models.LinkPreview, error)
+}
+
+// BlobStore defines the interface for binary object storage, such as attachment files
+type BlobStore interface {
+	PutBlob(key string, data io.Reader, size int64, contentType string) error
+	// GetBlob returns the content of a blob. It fails with a "blob not found" error for unknown keys.
+	GetBlob(key string) (io.ReadCloser, error)
+	// DeleteBlob removes a blob; deleting a missing blob is not an error
+	DeleteBlob(key string) error
+}
+
+// PollStore defines the interface for poll and vote storage operations. Polls are returned
+// with their tallies, including the usernames of the voters.
+type PollStore interface {
+	AddPoll(poll models.Poll) error
+	GetPoll(id string) (*models.Poll, error)
+	// GetMessagePolls returns the polls asked by the given messages, keyed by message ID
+	GetMessagePolls(messageIDs []string) (map[string]models.Poll, error)
+	// GetPollVote returns the options a user chose in a poll, or nil if they did not vote
+	GetPollVote(pollID, userID string) (*models.PollVote, error)
+	// SetPollVote replaces the vote of a user; a vote without options is removed. It fails
+	// if the poll is closed at vote.VotedAt, so no vote is counted after a poll closes.
+	SetPollVote(vote models.PollVote) error
+	// ClosePoll closes an open poll at the given time
+	ClosePoll(id string, at time.Time) error
 }
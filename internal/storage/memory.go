@@ -1,27 +1,77 @@
 package storage
 
 import (
+	"bytes"
 	"errors"
 	"go-chat-api/internal/models"
+	"io"
+	"slices"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
 // InMemoryStorage implements all storage interfaces using in-memory data structures
 type InMemoryStorage struct {
-	mu       sync.RWMutex
-	messages []models.Message
-	users    map[string]models.User
-	rooms    map[string]models.ChatRoom
+	mu         sync.RWMutex
+	messages   []models.Message
+	users      map[string]models.User
+	rooms      map[string]models.ChatRoom
+	sessions   map[string]models.Session
+	tokens     map[string]models.UserToken
+	logins     map[string]models.LoginThrottle
+	audit      []models.AuditEvent
+	identities map[string]models.UserIdentity
+	apiKeys    map[string]models.APIKey
+	tickets    map[string]models.WebSocketTicket
+	mutes      map[string]models.RoomMute
+	blocks     map[string]models.UserBlock
+	moderation map[string]models.RoomModeration
+	held       map[string]models.HeldMessage
+	reports    map[string]models.Report
+	files      map[string]models.Attachment
+	blobs      map[string][]byte
+	previews   map[string]models.LinkPreview
+	unfurled   map[string][]string // message ID to preview URLs in position order
+	mentions   map[string]models.Mention
+	pins       map[string]models.PinnedMessage // keyed by room and message ID
+	saved      map[string]models.SavedMessage  // keyed by user and message ID
+	scheduled  map[string]models.ScheduledMessage
+	reminders  map[string]models.Reminder
+	polls      map[string]models.Poll
+	votes      map[string]models.PollVote // keyed by poll and user ID
 }
 
 // NewInMemoryStorage creates a new in-memory storage instance
 func NewInMemoryStorage() *InMemoryStorage {
 	return &InMemoryStorage{
-		messages: make([]models.Message, 0),
-		users:    make(map[string]models.User),
-		rooms:    make(map[string]models.ChatRoom),
+		messages:   make([]models.Message, 0),
+		users:      make(map[string]models.User),
+		rooms:      make(map[string]models.ChatRoom),
+		sessions:   make(map[string]models.Session),
+		tokens:     make(map[string]models.UserToken),
+		logins:     make(map[string]models.LoginThrottle),
+		audit:      make([]models.AuditEvent, 0),
+		identities: make(map[string]models.UserIdentity),
+		apiKeys:    make(map[string]models.APIKey),
+		tickets:    make(map[string]models.WebSocketTicket),
+		mutes:      make(map[string]models.RoomMute),
+		blocks:     make(map[string]models.UserBlock),
+		moderation: make(map[string]models.RoomModeration),
+		held:       make(map[string]models.HeldMessage),
+		reports:    make(map[string]models.Report),
+		files:      make(map[string]models.Attachment),
+		blobs:      make(map[string][]byte),
+		previews:   make(map[string]models.LinkPreview),
+		unfurled:   make(map[string][]string),
+		mentions:   make(map[string]models.Mention),
+		pins:       make(map[string]models.PinnedMessage),
+		saved:      make(map[string]models.SavedMessage),
+		scheduled:  make(map[string]models.ScheduledMessage),
+		reminders:  make(map[string]models.Reminder),
+		polls:      make(map[string]models.Poll),
+		votes:      make(map[string]models.PollVote),
 	}
 }
 
@@ -86,6 +136,142 @@ func (s *InMemoryStorage) GetMessagesBetweenUsers(user1, user2 string) ([]models
 	return userMessages, nil
 }
 
+func (s *InMemoryStorage) GetMessage(messageID string) (*models.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, msg := range s.messages {
+		if msg.ID == messageID {
+			return &msg, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (s *InMemoryStorage) DeleteMessage(messageID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, msg := range s.messages {
+		if msg.ID == messageID {
+			s.messages = append(s.messages[:i], s.messages[i+1:]...)
+			s.deleteMessageRecords(messageID)
+			return nil
+		}
+	}
+
+	return errors.New("message not found")
+}
+
+func (s *InMemoryStorage) DeleteExpiredMessages(now time.Time, limit int) ([]models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expired []models.Message
+	for _, msg := range s.messages {
+		if msg.ExpiresAt != nil && !msg.ExpiresAt.After(now) {
+			expired = append(expired, msg)
+		}
+	}
+
+	sort.Slice(expired, func(i, j int) bool {
+		return expired[i].ExpiresAt.Before(*expired[j].ExpiresAt)
+	})
+	if len(expired) > limit {
+		expired = expired[:limit]
+	}
+
+	deleted := make(map[string]bool, len(expired))
+	for i := range expired {
+		deleted[expired[i].ID] = true
+		for _, file := range s.files {
+			if file.MessageID == expired[i].ID {
+				expired[i].Attachments = append(expired[i].Attachments, file)
+			}
+		}
+		s.deleteMessageRecords(expired[i].ID)
+	}
+
+	kept := s.messages[:0]
+	for _, msg := range s.messages {
+		if !deleted[msg.ID] {
+			kept = append(kept, msg)
+		}
+	}
+	s.messages = kept
+	return expired, nil
+}
+
+// deleteMessageRecords removes the records that belong to a deleted message. The caller
+// must hold s.mu.
+func (s *InMemoryStorage) deleteMessageRecords(messageID string) {
+	for id, file := range s.files {
+		if file.MessageID == messageID {
+			delete(s.files, id)
+		}
+	}
+	delete(s.unfurled, messageID)
+	for id, mention := range s.mentions {
+		if mention.MessageID == messageID {
+			delete(s.mentions, id)
+		}
+	}
+	for key, pin := range s.pins {
+		if pin.MessageID == messageID {
+			delete(s.pins, key)
+		}
+	}
+	for key, saved := range s.saved {
+		if saved.MessageID == messageID {
+			delete(s.saved, key)
+		}
+	}
+	for id, reminder := range s.reminders {
+		if reminder.MessageID == messageID {
+			delete(s.reminders, id)
+		}
+	}
+	for id, poll := range s.polls {
+		if poll.MessageID == messageID {
+			s.deletePoll(id)
+		}
+	}
+}
+
+func (s *InMemoryStorage) GetLastMessageTime(roomID, sender string) (*time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var last *time.Time
+	for _, msg := range s.messages {
+		if msg.RoomID == roomID && msg.Sender == sender && (last == nil || msg.Timestamp.After(*last)) {
+			timestamp := msg.Timestamp
+			last = &timestamp
+		}
+	}
+
+	return last, nil
+}
+
+func (s *InMemoryStorage) GetMessagesBySender(sender string, since time.Time) ([]models.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var messages []models.Message
+	for _, msg := range s.messages {
+		if msg.Sender == sender && !msg.Timestamp.Before(since) {
+			messages = append(messages, msg)
+		}
+	}
+
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].Timestamp.Before(messages[j].Timestamp)
+	})
+
+	return messages, nil
+}
+
 // User Store Implementation
 func (s *InMemoryStorage) AddUser(user models.User) error {
 	s.mu.Lock()
@@ -121,7 +307,7 @@ func (s *InMemoryStorage) GetUserByUsername(username string) (*models.User, erro
 		}
 	}
 
-	return nil, errors.New("user not found")
+	return nil, nil
 }
 
 func (s *InMemoryStorage) GetUserByEmail(email string) (*models.User, error) {
@@ -134,7 +320,7 @@ func (s *InMemoryStorage) GetUserByEmail(email string) (*models.User, error) {
 		}
 	}
 
-	return nil, errors.New("user not found")
+	return nil, nil
 }
 
 func (s *InMemoryStorage) UpdateUserStatus(userID string, isOnline bool) error {
@@ -163,6 +349,265 @@ func (s *InMemoryStorage) GetAllUsers() ([]models.User, error) {
 	return users, nil
 }
 
+func (s *InMemoryStorage) UpdateUserPassword(userID, passwordHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		return errors.New("user not found")
+	}
+
+	user.PasswordHash = passwordHash
+	s.users[userID] = user
+	return nil
+}
+
+func (s *InMemoryStorage) SetUserVerified(userID string, verified bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		return errors.New("user not found")
+	}
+
+	user.Verified = verified
+	s.users[userID] = user
+	return nil
+}
+
+func (s *InMemoryStorage) SetUserRole(userID, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		return errors.New("user not found")
+	}
+
+	user.Role = role
+	s.users[userID] = user
+	return nil
+}
+
+func (s *InMemoryStorage) GetBotsByOwner(ownerID string) ([]models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var bots []models.User
+	for _, user := range s.users {
+		if user.IsBot && user.OwnerID == ownerID {
+			bots = append(bots, user)
+		}
+	}
+
+	return bots, nil
+}
+
+func (s *InMemoryStorage) SearchUsers(filter models.UserFilter) ([]models.User, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	query := strings.ToLower(filter.Query)
+	var users []models.User
+	for _, user := range s.users {
+		if query != "" && !strings.Contains(strings.ToLower(user.Username), query) &&
+			!strings.Contains(strings.ToLower(user.Email), query) {
+			continue
+		}
+		if filter.Role != "" && user.Role != filter.Role {
+			continue
+		}
+		if filter.Suspended != nil && user.IsSuspended() != *filter.Suspended {
+			continue
+		}
+		if filter.IsBot != nil && user.IsBot != *filter.IsBot {
+			continue
+		}
+		if filter.Verified != nil && user.Verified != *filter.Verified {
+			continue
+		}
+		users = append(users, user)
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].CreatedAt.Equal(users[j].CreatedAt) {
+			return users[i].ID < users[j].ID
+		}
+		return users[i].CreatedAt.Before(users[j].CreatedAt)
+	})
+
+	total := len(users)
+	if filter.Offset >= total {
+		return nil, total, nil
+	}
+	users = users[filter.Offset:]
+	if filter.Limit > 0 && filter.Limit < len(users) {
+		users = users[:filter.Limit]
+	}
+
+	return users, total, nil
+}
+
+func (s *InMemoryStorage) SetUserSuspended(userID string, suspendedAt *time.Time, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		return errors.New("user not found")
+	}
+
+	user.SuspendedAt = suspendedAt
+	user.SuspensionReason = reason
+	s.users[userID] = user
+	return nil
+}
+
+func (s *InMemoryStorage) DeleteUser(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.users[userID]; !exists {
+		return errors.New("user not found")
+	}
+
+	// Mirror the cascades of the database schema: bots go with their owner
+	deleted := make(map[string]bool)
+	usernames := make(map[string]bool)
+	for id, user := range s.users {
+		if id == userID || (user.IsBot && user.OwnerID == userID) {
+			deleted[id] = true
+			usernames[user.Username] = true
+			delete(s.users, id)
+		}
+	}
+
+	messages := s.messages[:0]
+	removed := make(map[string]bool)
+	for _, msg := range s.messages {
+		if !usernames[msg.Sender] && !usernames[msg.Recipient] {
+			messages = append(messages, msg)
+		} else {
+			removed[msg.ID] = true
+		}
+	}
+	s.messages = messages
+
+	for id, file := range s.files {
+		if deleted[file.UploaderID] || removed[file.MessageID] {
+			delete(s.files, id)
+		}
+	}
+	for id := range removed {
+		delete(s.unfurled, id)
+	}
+	for id, mention := range s.mentions {
+		if deleted[mention.UserID] || removed[mention.MessageID] {
+			delete(s.mentions, id)
+		}
+	}
+	for key, pin := range s.pins {
+		if removed[pin.MessageID] {
+			delete(s.pins, key)
+		} else if deleted[pin.PinnedBy] {
+			pin.PinnedBy = ""
+			s.pins[key] = pin
+		}
+	}
+	for key, saved := range s.saved {
+		if deleted[saved.UserID] || removed[saved.MessageID] {
+			delete(s.saved, key)
+		}
+	}
+	for id, scheduled := range s.scheduled {
+		if deleted[scheduled.UserID] {
+			delete(s.scheduled, id)
+		}
+	}
+	for id, reminder := range s.reminders {
+		if deleted[reminder.UserID] || removed[reminder.MessageID] {
+			delete(s.reminders, id)
+		}
+	}
+	for id, poll := range s.polls {
+		if removed[poll.MessageID] {
+			s.deletePoll(id)
+		} else if deleted[poll.CreatedBy] {
+			poll.CreatedBy = ""
+			s.polls[id] = poll
+		}
+	}
+	for key, vote := range s.votes {
+		if deleted[vote.UserID] {
+			delete(s.votes, key)
+		}
+	}
+
+	for id, room := range s.rooms {
+		members := make([]string, 0, len(room.Members))
+		for _, member := range room.Members {
+			if !deleted[member] {
+				members = append(members, member)
+			}
+		}
+		room.Members = members
+		s.rooms[id] = room
+	}
+
+	for id, session := range s.sessions {
+		if deleted[session.UserID] {
+			delete(s.sessions, id)
+		}
+	}
+	for hash, token := range s.tokens {
+		if deleted[token.UserID] {
+			delete(s.tokens, hash)
+		}
+	}
+	for key, identity := range s.identities {
+		if deleted[identity.UserID] {
+			delete(s.identities, key)
+		}
+	}
+	for id, key := range s.apiKeys {
+		if deleted[key.UserID] {
+			delete(s.apiKeys, id)
+		}
+	}
+	for hash, ticket := range s.tickets {
+		if deleted[ticket.UserID] {
+			delete(s.tickets, hash)
+		}
+	}
+	for key, mute := range s.mutes {
+		if deleted[mute.UserID] {
+			delete(s.mutes, key)
+		}
+	}
+	for key, block := range s.blocks {
+		if deleted[block.UserID] || deleted[block.BlockedID] {
+			delete(s.blocks, key)
+		}
+	}
+	for id, held := range s.held {
+		if usernames[held.Sender] || usernames[held.Recipient] {
+			delete(s.held, id)
+		}
+	}
+	for id, report := range s.reports {
+		if deleted[report.ReporterID] || deleted[report.ReportedUserID] {
+			delete(s.reports, id)
+		} else if deleted[report.ResolvedBy] {
+			report.ResolvedBy = ""
+			s.reports[id] = report
+		}
+	}
+
+	return nil
+}
+
 // Room Store Implementation
 func (s *InMemoryStorage) CreateRoom(room models.ChatRoom) error {
 	s.mu.Lock()
@@ -246,3 +691,1292 @@ func (s *InMemoryStorage) RemoveUserFromRoom(roomID, userID string) error {
 
 	return errors.New("user not found in room")
 }
+
+func (s *InMemoryStorage) UpdateRoomSettings(roomID string, slowModeSeconds int, readOnly bool, messageTTLSeconds int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	room, exists := s.rooms[roomID]
+	if !exists {
+		return errors.New("room not found")
+	}
+
+	room.SlowModeSeconds = slowModeSeconds
+	room.ReadOnly = readOnly
+	room.MessageTTLSeconds = messageTTLSeconds
+	s.rooms[roomID] = room
+	return nil
+}
+
+func (s *InMemoryStorage) SetRoomMute(mute models.RoomMute) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.rooms[mute.RoomID]; !exists {
+		return errors.New("room not found")
+	}
+
+	s.mutes[mute.RoomID+":"+mute.UserID] = mute
+	return nil
+}
+
+func (s *InMemoryStorage) GetRoomMute(roomID, userID string) (*models.RoomMute, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	mute, exists := s.mutes[roomID+":"+userID]
+	if !exists {
+		return nil, nil
+	}
+
+	return &mute, nil
+}
+
+func (s *InMemoryStorage) GetRoomMutes(roomID string) ([]models.RoomMute, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	var mutes []models.RoomMute
+	for _, mute := range s.mutes {
+		if mute.RoomID == roomID && mute.IsActive(now) {
+			mutes = append(mutes, mute)
+		}
+	}
+
+	sort.Slice(mutes, func(i, j int) bool {
+		return mutes[i].CreatedAt.Before(mutes[j].CreatedAt)
+	})
+
+	return mutes, nil
+}
+
+func (s *InMemoryStorage) DeleteRoomMute(roomID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := roomID + ":" + userID
+	if _, exists := s.mutes[key]; !exists {
+		return errors.New("mute not found")
+	}
+
+	delete(s.mutes, key)
+	return nil
+}
+
+// Session Store Implementation
+func (s *InMemoryStorage) CreateSession(session models.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.sessions[session.ID]; exists {
+		return errors.New("session already exists")
+	}
+
+	s.sessions[session.ID] = session
+	return nil
+}
+
+func (s *InMemoryStorage) GetSession(sessionID string) (*models.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, exists := s.sessions[sessionID]
+	if !exists {
+		return nil, nil
+	}
+
+	return &session, nil
+}
+
+func (s *InMemoryStorage) RevokeSession(sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, exists := s.sessions[sessionID]
+	if !exists {
+		return errors.New("session not found")
+	}
+
+	if session.RevokedAt == nil {
+		now := time.Now()
+		session.RevokedAt = &now
+		s.sessions[sessionID] = session
+	}
+	return nil
+}
+
+func (s *InMemoryStorage) RevokeUserSessions(userID, exceptSessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, session := range s.sessions {
+		if session.UserID != userID || id == exceptSessionID || session.RevokedAt != nil {
+			continue
+		}
+		session.RevokedAt = &now
+		s.sessions[id] = session
+	}
+	return nil
+}
+
+// Token Store Implementation
+func (s *InMemoryStorage) AddUserToken(token models.UserToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.tokens[token.TokenHash]; exists {
+		return errors.New("token already exists")
+	}
+
+	s.tokens[token.TokenHash] = token
+	return nil
+}
+
+func (s *InMemoryStorage) ConsumeUserToken(tokenHash, purpose string) (*models.UserToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, exists := s.tokens[tokenHash]
+	if !exists || token.Purpose != purpose || token.UsedAt != nil || time.Now().After(token.ExpiresAt) {
+		return nil, nil
+	}
+
+	now := time.Now()
+	token.UsedAt = &now
+	s.tokens[tokenHash] = token
+	return &token, nil
+}
+
+// Ticket Store Implementation
+func (s *InMemoryStorage) AddWebSocketTicket(ticket models.WebSocketTicket) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop tickets that were never redeemed
+	now := time.Now()
+	for hash, existing := range s.tickets {
+		if now.After(existing.ExpiresAt) {
+			delete(s.tickets, hash)
+		}
+	}
+
+	s.tickets[ticket.TicketHash] = ticket
+	return nil
+}
+
+func (s *InMemoryStorage) ConsumeWebSocketTicket(ticketHash string) (*models.WebSocketTicket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ticket, exists := s.tickets[ticketHash]
+	if !exists {
+		return nil, nil
+	}
+	delete(s.tickets, ticketHash)
+
+	if time.Now().After(ticket.ExpiresAt) {
+		return nil, nil
+	}
+	return &ticket, nil
+}
+
+// Login Attempt Store Implementation
+func (s *InMemoryStorage) GetLoginThrottle(key string) (*models.LoginThrottle, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	throttle, exists := s.logins[key]
+	if !exists {
+		return nil, nil
+	}
+
+	return &throttle, nil
+}
+
+func (s *InMemoryStorage) IncrementLoginFailures(key string, at, resetBefore time.Time) (*models.LoginThrottle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	throttle, exists := s.logins[key]
+	if !exists || throttle.LastFailureAt.Before(resetBefore) {
+		throttle = models.LoginThrottle{Key: key, LockedUntil: throttle.LockedUntil}
+	}
+
+	throttle.Failures++
+	throttle.LastFailureAt = at
+	s.logins[key] = throttle
+	return &throttle, nil
+}
+
+func (s *InMemoryStorage) SetLoginLockout(key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	throttle, exists := s.logins[key]
+	if !exists {
+		throttle = models.LoginThrottle{Key: key}
+	}
+
+	throttle.LockedUntil = until
+	s.logins[key] = throttle
+	return nil
+}
+
+func (s *InMemoryStorage) ClearLoginThrottle(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.logins, key)
+	return nil
+}
+
+// Audit Store Implementation
+func (s *InMemoryStorage) AddAuditEvent(event models.AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event.Seq = 1
+	event.PrevHash = ""
+	if len(s.audit) > 0 {
+		last := s.audit[len(s.audit)-1]
+		event.Seq = last.Seq + 1
+		event.PrevHash = last.Hash
+	}
+	event.Hash = event.ComputeHash()
+
+	s.audit = append(s.audit, event)
+	return nil
+}
+
+func (s *InMemoryStorage) GetAuditEvents() ([]models.AuditEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := make([]models.AuditEvent, len(s.audit))
+	copy(events, s.audit)
+	return events, nil
+}
+
+func (s *InMemoryStorage) SearchAuditEvents(filter models.AuditFilter) ([]models.AuditEvent, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Newest first
+	var events []models.AuditEvent
+	for i := len(s.audit) - 1; i >= 0; i-- {
+		event := s.audit[i]
+		if filter.Action != "" && event.Action != filter.Action {
+			continue
+		}
+		if filter.ActorID != "" && event.ActorID != filter.ActorID {
+			continue
+		}
+		if filter.TargetID != "" && event.TargetID != filter.TargetID {
+			continue
+		}
+		if filter.IP != "" && event.IP != filter.IP {
+			continue
+		}
+		if !filter.Since.IsZero() && event.CreatedAt.Before(filter.Since) {
+			continue
+		}
+		if !filter.Until.IsZero() && !event.CreatedAt.Before(filter.Until) {
+			continue
+		}
+		events = append(events, event)
+	}
+
+	total := len(events)
+	if filter.Offset >= total {
+		return nil, total, nil
+	}
+	events = events[filter.Offset:]
+	if filter.Limit > 0 && filter.Limit < len(events) {
+		events = events[:filter.Limit]
+	}
+
+	return events, total, nil
+}
+
+// Identity Store Implementation
+func (s *InMemoryStorage) AddUserIdentity(identity models.UserIdentity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := identity.Provider + "|" + identity.Subject
+	if _, exists := s.identities[key]; exists {
+		return errors.New("identity already exists")
+	}
+
+	s.identities[key] = identity
+	return nil
+}
+
+func (s *InMemoryStorage) GetUserIdentity(provider, subject string) (*models.UserIdentity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	identity, exists := s.identities[provider+"|"+subject]
+	if !exists {
+		return nil, nil
+	}
+
+	return &identity, nil
+}
+
+// API Key Store Implementation
+func (s *InMemoryStorage) AddAPIKey(key models.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.apiKeys[key.ID]; exists {
+		return errors.New("api key already exists")
+	}
+
+	s.apiKeys[key.ID] = key
+	return nil
+}
+
+func (s *InMemoryStorage) GetAPIKey(keyID string) (*models.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, exists := s.apiKeys[keyID]
+	if !exists {
+		return nil, nil
+	}
+
+	return &key, nil
+}
+
+func (s *InMemoryStorage) GetAPIKeyByHash(keyHash string) (*models.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, key := range s.apiKeys {
+		if key.KeyHash == keyHash {
+			return &key, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (s *InMemoryStorage) GetAPIKeysByUser(userID string) ([]models.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var keys []models.APIKey
+	for _, key := range s.apiKeys {
+		if key.UserID == userID {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+func (s *InMemoryStorage) RevokeAPIKey(keyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, exists := s.apiKeys[keyID]
+	if !exists {
+		return errors.New("api key not found")
+	}
+
+	if key.RevokedAt == nil {
+		now := time.Now()
+		key.RevokedAt = &now
+		s.apiKeys[keyID] = key
+	}
+	return nil
+}
+
+func (s *InMemoryStorage) TouchAPIKey(keyID string, usedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, exists := s.apiKeys[keyID]
+	if !exists {
+		return errors.New("api key not found")
+	}
+
+	key.LastUsedAt = &usedAt
+	s.apiKeys[keyID] = key
+	return nil
+}
+
+// Block Store Implementation
+func (s *InMemoryStorage) AddUserBlock(block models.UserBlock) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := block.UserID + ":" + block.BlockedID
+	if _, exists := s.blocks[key]; !exists {
+		s.blocks[key] = block
+	}
+	return nil
+}
+
+func (s *InMemoryStorage) DeleteUserBlock(userID, blockedID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := userID + ":" + blockedID
+	if _, exists := s.blocks[key]; !exists {
+		return errors.New("block not found")
+	}
+
+	delete(s.blocks, key)
+	return nil
+}
+
+func (s *InMemoryStorage) GetUserBlocks(userID string) ([]models.UserBlock, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var blocks []models.UserBlock
+	for _, block := range s.blocks {
+		if block.UserID == userID {
+			block.BlockedUsername = s.users[block.BlockedID].Username
+			blocks = append(blocks, block)
+		}
+	}
+
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].CreatedAt.Before(blocks[j].CreatedAt)
+	})
+
+	return blocks, nil
+}
+
+func (s *InMemoryStorage) IsUserBlocked(userID, blockedID string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, exists := s.blocks[userID+":"+blockedID]
+	return exists, nil
+}
+
+// Moderation Store Implementation
+func (s *InMemoryStorage) GetRoomModeration(roomID string) (*models.RoomModeration, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	settings, exists := s.moderation[roomID]
+	if !exists {
+		return nil, nil
+	}
+
+	return &settings, nil
+}
+
+func (s *InMemoryStorage) SetRoomModeration(settings models.RoomModeration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.rooms[settings.RoomID]; !exists {
+		return errors.New("room not found")
+	}
+
+	s.moderation[settings.RoomID] = settings
+	return nil
+}
+
+func (s *InMemoryStorage) AddHeldMessage(message models.HeldMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.held[message.ID]; exists {
+		return errors.New("held message already exists")
+	}
+
+	message.AttachmentIDs = slices.Clone(message.AttachmentIDs)
+	s.held[message.ID] = message
+	return nil
+}
+
+func (s *InMemoryStorage) GetHeldMessage(id string) (*models.HeldMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	message, exists := s.held[id]
+	if !exists {
+		return nil, nil
+	}
+
+	return &message, nil
+}
+
+func (s *InMemoryStorage) GetHeldMessages(roomID string) ([]models.HeldMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var messages []models.HeldMessage
+	for _, message := range s.held {
+		if message.Status == models.HeldMessagePending && (roomID == "" || message.RoomID == roomID) {
+			messages = append(messages, message)
+		}
+	}
+
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].CreatedAt.Before(messages[j].CreatedAt)
+	})
+
+	return messages, nil
+}
+
+func (s *InMemoryStorage) ReviewHeldMessage(id, status, reviewerID string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message, exists := s.held[id]
+	if !exists || message.Status != models.HeldMessagePending {
+		return errors.New("held message not found")
+	}
+
+	message.Status = status
+	message.ReviewedBy = reviewerID
+	message.ReviewedAt = &at
+	s.held[id] = message
+	return nil
+}
+
+// Report Store Implementation
+func (s *InMemoryStorage) AddReport(report models.Report) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reports[report.ID] = report
+	return nil
+}
+
+func (s *InMemoryStorage) GetReport(id string) (*models.Report, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	report, exists := s.reports[id]
+	if !exists {
+		return nil, nil
+	}
+
+	return &report, nil
+}
+
+func (s *InMemoryStorage) GetReports(filter models.ReportFilter) ([]models.Report, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var reports []models.Report
+	for _, report := range s.reports {
+		if filter.Status != "" && report.Status != filter.Status {
+			continue
+		}
+		if filter.RoomID != "" && report.RoomID != filter.RoomID {
+			continue
+		}
+		reports = append(reports, report)
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].CreatedAt.Equal(reports[j].CreatedAt) {
+			return reports[i].ID < reports[j].ID
+		}
+		return reports[i].CreatedAt.Before(reports[j].CreatedAt)
+	})
+
+	total := len(reports)
+	if filter.Offset >= total {
+		return nil, total, nil
+	}
+	reports = reports[filter.Offset:]
+	if filter.Limit > 0 && filter.Limit < len(reports) {
+		reports = reports[:filter.Limit]
+	}
+
+	return reports, total, nil
+}
+
+func (s *InMemoryStorage) ResolveReport(id, status, action, note, resolverID string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report, exists := s.reports[id]
+	if !exists || report.Status != models.ReportOpen {
+		return errors.New("report not found")
+	}
+
+	report.Status = status
+	report.Action = action
+	report.Note = note
+	report.ResolvedBy = resolverID
+	report.ResolvedAt = &at
+	s.reports[id] = report
+	return nil
+}
+
+// Attachment Store Implementation
+func (s *InMemoryStorage) AddAttachment(attachment models.Attachment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.files[attachment.ID] = attachment
+	return nil
+}
+
+func (s *InMemoryStorage) GetAttachment(id string) (*models.Attachment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	attachment, exists := s.files[id]
+	if !exists {
+		return nil, nil
+	}
+
+	return &attachment, nil
+}
+
+func (s *InMemoryStorage) AttachToMessage(messageID, uploaderID string, ids []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		attachment, exists := s.files[id]
+		if !exists || attachment.UploaderID != uploaderID || attachment.MessageID != "" {
+			return errors.New("attachment not found")
+		}
+	}
+
+	for _, id := range ids {
+		attachment := s.files[id]
+		attachment.MessageID = messageID
+		s.files[id] = attachment
+	}
+	return nil
+}
+
+func (s *InMemoryStorage) GetMessageAttachments(messageIDs []string) (map[string][]models.Attachment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	wanted := make(map[string]bool, len(messageIDs))
+	for _, id := range messageIDs {
+		wanted[id] = true
+	}
+
+	attachments := make(map[string][]models.Attachment)
+	for _, attachment := range s.files {
+		if attachment.MessageID != "" && wanted[attachment.MessageID] {
+			attachments[attachment.MessageID] = append(attachments[attachment.MessageID], attachment)
+		}
+	}
+	for _, list := range attachments {
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].CreatedAt.Equal(list[j].CreatedAt) {
+				return list[i].ID < list[j].ID
+			}
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		})
+	}
+
+	return attachments, nil
+}
+
+// Mention Store Implementation
+func (s *InMemoryStorage) AddMentions(mentions []models.Mention) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, mention := range mentions {
+		duplicate := false
+		for _, existing := range s.mentions {
+			if existing.MessageID == mention.MessageID && existing.UserID == mention.UserID {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			s.mentions[mention.ID] = mention
+		}
+	}
+	return nil
+}
+
+func (s *InMemoryStorage) GetUnreadMentions(userID string, limit int) ([]models.Mention, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	messages := make(map[string]models.Message, len(s.messages))
+	for _, msg := range s.messages {
+		messages[msg.ID] = msg
+	}
+
+	var mentions []models.Mention
+	for _, mention := range s.mentions {
+		if mention.UserID != userID || mention.ReadAt != nil {
+			continue
+		}
+		if msg, exists := messages[mention.MessageID]; exists {
+			mention.Message = &msg
+		}
+		mentions = append(mentions, mention)
+	}
+
+	sort.Slice(mentions, func(i, j int) bool {
+		return mentions[i].CreatedAt.After(mentions[j].CreatedAt)
+	})
+	if limit > 0 && len(mentions) > limit {
+		mentions = mentions[:limit]
+	}
+	return mentions, nil
+}
+
+func (s *InMemoryStorage) MarkMentionsRead(userID string, ids []string, readAt time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	selected := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		selected[id] = true
+	}
+
+	marked := 0
+	for id, mention := range s.mentions {
+		if mention.UserID != userID || mention.ReadAt != nil || (len(ids) > 0 && !selected[id]) {
+			continue
+		}
+		mention.ReadAt = &readAt
+		s.mentions[id] = mention
+		marked++
+	}
+	return marked, nil
+}
+
+// Pin Store Implementation
+func (s *InMemoryStorage) AddPin(pin models.PinnedMessage, max int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := pin.RoomID + ":" + pin.MessageID
+	if _, exists := s.pins[key]; exists {
+		return true, nil
+	}
+
+	count := 0
+	for _, existing := range s.pins {
+		if existing.RoomID == pin.RoomID {
+			count++
+		}
+	}
+	if count >= max {
+		return false, nil
+	}
+
+	s.pins[key] = pin
+	return true, nil
+}
+
+func (s *InMemoryStorage) RemovePin(roomID, messageID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := roomID + ":" + messageID
+	if _, exists := s.pins[key]; !exists {
+		return errors.New("pin not found")
+	}
+
+	delete(s.pins, key)
+	return nil
+}
+
+func (s *InMemoryStorage) GetPins(roomID string) ([]models.PinnedMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var pins []models.PinnedMessage
+	for _, pin := range s.pins {
+		if pin.RoomID != roomID {
+			continue
+		}
+		for _, msg := range s.messages {
+			if msg.ID == pin.MessageID {
+				pin.Message = &msg
+				break
+			}
+		}
+		pins = append(pins, pin)
+	}
+
+	sort.Slice(pins, func(i, j int) bool {
+		return pins[i].PinnedAt.After(pins[j].PinnedAt)
+	})
+	return pins, nil
+}
+
+// Saved Message Store Implementation
+func (s *InMemoryStorage) SaveMessage(saved models.SavedMessage) (*models.SavedMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := saved.UserID + ":" + saved.MessageID
+	if existing, exists := s.saved[key]; exists {
+		saved.CreatedAt = existing.CreatedAt
+	}
+
+	s.saved[key] = saved
+	return &saved, nil
+}
+
+func (s *InMemoryStorage) DeleteSavedMessage(userID, messageID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := userID + ":" + messageID
+	if _, exists := s.saved[key]; !exists {
+		return errors.New("saved message not found")
+	}
+
+	delete(s.saved, key)
+	return nil
+}
+
+func (s *InMemoryStorage) GetSavedMessages(userID string) ([]models.SavedMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var list []models.SavedMessage
+	for _, saved := range s.saved {
+		if saved.UserID != userID {
+			continue
+		}
+		for _, msg := range s.messages {
+			if msg.ID == saved.MessageID {
+				saved.Message = &msg
+				break
+			}
+		}
+		list = append(list, saved)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list, nil
+}
+
+// Schedule Store Implementation
+func (s *InMemoryStorage) AddScheduledMessage(message models.ScheduledMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.scheduled[message.ID] = message
+	return nil
+}
+
+func (s *InMemoryStorage) GetScheduledMessages(userID string) ([]models.ScheduledMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var messages []models.ScheduledMessage
+	for _, message := range s.scheduled {
+		if message.UserID == userID && message.Status == models.SchedulePending {
+			messages = append(messages, message)
+		}
+	}
+
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].SendAt.Before(messages[j].SendAt)
+	})
+	return messages, nil
+}
+
+func (s *InMemoryStorage) CancelScheduledMessage(userID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message, exists := s.scheduled[id]
+	if !exists || message.UserID != userID || message.Status != models.SchedulePending {
+		return errors.New("scheduled message not found")
+	}
+
+	message.Status = models.ScheduleCancelled
+	s.scheduled[id] = message
+	return nil
+}
+
+func (s *InMemoryStorage) ClaimDueScheduledMessages(now, staleBefore time.Time, limit int) ([]models.ScheduledMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []models.ScheduledMessage
+	for _, message := range s.scheduled {
+		if !message.SendAt.After(now) && claimable(message.Status, message.ClaimedAt, staleBefore) {
+			due = append(due, message)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].SendAt.Before(due[j].SendAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	for i := range due {
+		due[i].Status = models.ScheduleSending
+		due[i].ClaimedAt = &now
+		s.scheduled[due[i].ID] = due[i]
+	}
+	return due, nil
+}
+
+func (s *InMemoryStorage) CompleteScheduledMessage(id, status, messageID, failure string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message, exists := s.scheduled[id]
+	if !exists {
+		return errors.New("scheduled message not found")
+	}
+
+	message.Status = status
+	message.MessageID = messageID
+	message.Error = failure
+	s.scheduled[id] = message
+	return nil
+}
+
+func (s *InMemoryStorage) AddReminder(reminder models.Reminder) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reminders[reminder.ID] = reminder
+	return nil
+}
+
+func (s *InMemoryStorage) GetReminders(userID string) ([]models.Reminder, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var reminders []models.Reminder
+	for _, reminder := range s.reminders {
+		if reminder.UserID != userID || reminder.Status != models.SchedulePending {
+			continue
+		}
+		for _, msg := range s.messages {
+			if msg.ID == reminder.MessageID {
+				reminder.Message = &msg
+				break
+			}
+		}
+		reminders = append(reminders, reminder)
+	}
+
+	sort.Slice(reminders, func(i, j int) bool {
+		return reminders[i].RemindAt.Before(reminders[j].RemindAt)
+	})
+	return reminders, nil
+}
+
+func (s *InMemoryStorage) CancelReminder(userID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reminder, exists := s.reminders[id]
+	if !exists || reminder.UserID != userID || reminder.Status != models.SchedulePending {
+		return errors.New("reminder not found")
+	}
+
+	reminder.Status = models.ScheduleCancelled
+	s.reminders[id] = reminder
+	return nil
+}
+
+func (s *InMemoryStorage) ClaimDueReminders(now, staleBefore time.Time, limit int) ([]models.Reminder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []models.Reminder
+	for _, reminder := range s.reminders {
+		if !reminder.RemindAt.After(now) && claimable(reminder.Status, reminder.ClaimedAt, staleBefore) {
+			due = append(due, reminder)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].RemindAt.Before(due[j].RemindAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	for i := range due {
+		due[i].Status = models.ScheduleSending
+		due[i].ClaimedAt = &now
+		s.reminders[due[i].ID] = due[i]
+	}
+	return due, nil
+}
+
+func (s *InMemoryStorage) CompleteReminder(id, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reminder, exists := s.reminders[id]
+	if !exists {
+		return errors.New("reminder not found")
+	}
+
+	reminder.Status = status
+	s.reminders[id] = reminder
+	return nil
+}
+
+// claimable reports whether a scheduled job can be claimed: it is pending, or its claim
+// expired without the job completing
+func claimable(status string, claimedAt *time.Time, staleBefore time.Time) bool {
+	return status == models.SchedulePending ||
+		(status == models.ScheduleSending && claimedAt != nil && claimedAt.Before(staleBefore))
+}
+
+// Preview Store Implementation
+func (s *InMemoryStorage) GetLinkPreview(url string) (*models.LinkPreview, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	preview, exists := s.previews[url]
+	if !exists {
+		return nil, nil
+	}
+
+	return &preview, nil
+}
+
+func (s *InMemoryStorage) SaveLinkPreview(preview models.LinkPreview) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.previews[preview.URL] = preview
+	return nil
+}
+
+func (s *InMemoryStorage) AddMessagePreview(messageID, url string, position int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found := false
+	for _, msg := range s.messages {
+		if msg.ID == messageID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return errors.New("message not found")
+	}
+	if _, exists := s.previews[url]; !exists {
+		return errors.New("link preview not found")
+	}
+
+	urls := s.unfurled[messageID]
+	for _, existing := range urls {
+		if existing == url {
+			return nil
+		}
+	}
+	// Positions are assigned in increasing order, so appending keeps them sorted
+	s.unfurled[messageID] = append(urls, url)
+	return nil
+}
+
+func (s *InMemoryStorage) GetMessagePreviews(messageIDs []string) (map[string][]models.LinkPreview, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	previews := make(map[string][]models.LinkPreview)
+	for _, id := range messageIDs {
+		for _, url := range s.unfurled[id] {
+			if preview, exists := s.previews[url]; exists {
+				previews[id] = append(previews[id], preview)
+			}
+		}
+	}
+
+	return previews, nil
+}
+
+// Blob Store Implementation
+func (s *InMemoryStorage) PutBlob(key string, data io.Reader, size int64, contentType string) error {
+	content, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	if int64(len(content)) != size {
+		return errors.New("blob size mismatch")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.blobs[key] = content
+	return nil
+}
+
+func (s *InMemoryStorage) GetBlob(key string) (io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	content, exists := s.blobs[key]
+	if !exists {
+		return nil, errors.New("blob not found")
+	}
+
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+func (s *InMemoryStorage) DeleteBlob(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.blobs, key)
+	return nil
+}
+
+// Poll Store Implementation
+func (s *InMemoryStorage) AddPoll(poll models.Poll) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.polls[poll.ID]; exists {
+		return errors.New("poll already exists")
+	}
+
+	poll.Options = slices.Clone(poll.Options)
+	s.polls[poll.ID] = poll
+	return nil
+}
+
+func (s *InMemoryStorage) GetPoll(id string) (*models.Poll, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	poll, exists := s.polls[id]
+	if !exists {
+		return nil, nil
+	}
+
+	poll = s.tallyPoll(poll)
+	return &poll, nil
+}
+
+func (s *InMemoryStorage) GetMessagePolls(messageIDs []string) (map[string]models.Poll, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	wanted := make(map[string]bool, len(messageIDs))
+	for _, id := range messageIDs {
+		wanted[id] = true
+	}
+
+	polls := make(map[string]models.Poll)
+	for _, poll := range s.polls {
+		if wanted[poll.MessageID] {
+			polls[poll.MessageID] = s.tallyPoll(poll)
+		}
+	}
+	return polls, nil
+}
+
+func (s *InMemoryStorage) GetPollVote(pollID, userID string) (*models.PollVote, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	vote, exists := s.votes[pollID+":"+userID]
+	if !exists {
+		return nil, nil
+	}
+	return &vote, nil
+}
+
+func (s *InMemoryStorage) SetPollVote(vote models.PollVote) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	poll, exists := s.polls[vote.PollID]
+	if !exists {
+		return errors.New("poll not found")
+	}
+	if poll.IsClosed(vote.VotedAt) {
+		return errors.New("poll closed")
+	}
+
+	key := vote.PollID + ":" + vote.UserID
+	if len(vote.Options) == 0 {
+		delete(s.votes, key)
+		return nil
+	}
+	vote.Options = slices.Clone(vote.Options)
+	sort.Ints(vote.Options)
+	s.votes[key] = vote
+	return nil
+}
+
+func (s *InMemoryStorage) ClosePoll(id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	poll, exists := s.polls[id]
+	if !exists {
+		return errors.New("poll not found")
+	}
+	if poll.IsClosed(at) {
+		return errors.New("poll closed")
+	}
+
+	poll.ClosedAt = &at
+	s.polls[id] = poll
+	return nil
+}
+
+// tallyPoll returns a copy of a poll with its vote counts and voters, listed by username.
+// The caller must hold s.mu.
+func (s *InMemoryStorage) tallyPoll(poll models.Poll) models.Poll {
+	options := make([]models.PollOption, len(poll.Options))
+	for i, option := range poll.Options {
+		options[i] = models.PollOption{Text: option.Text}
+	}
+	poll.Options = options
+	poll.Voters = 0
+
+	for _, vote := range s.votes {
+		if vote.PollID != poll.ID {
+			continue
+		}
+		poll.Voters++
+		username := s.users[vote.UserID].Username
+		for _, index := range vote.Options {
+			if index >= 0 && index < len(options) {
+				options[index].Votes++
+				options[index].Voters = append(options[index].Voters, username)
+			}
+		}
+	}
+	for i := range options {
+		sort.Strings(options[i].Voters)
+	}
+	return poll
+}
+
+// deletePoll removes a poll and its votes. The caller must hold s.mu.
+func (s *InMemoryStorage) deletePoll(id string) {
+	delete(s.polls, id)
+	for key, vote := range s.votes {
+		if vote.PollID == id {
+			delete(s.votes, key)
+		}
+	}
+}
@@ -2,9 +2,11 @@ package storage
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"go-chat-api/internal/models"
 	"strings"
+	"time"
 
 	"github.com/lib/pq"
 	_ "github.com/lib/pq"
@@ -48,23 +50,51 @@ func (p *PostgresDB) createTables() error {
 		`CREATE TABLE IF NOT EXISTS users (
 			id VARCHAR(255) PRIMARY KEY,
 			username VARCHAR(255) UNIQUE NOT NULL,
-			email VARCHAR(255) UNIQUE NOT NULL,
+			email VARCHAR(255) UNIQUE,
 			password_hash VARCHAR(255) NOT NULL,
 			is_online BOOLEAN DEFAULT false,
-			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+			verified BOOLEAN NOT NULL DEFAULT false,
+			role VARCHAR(20) NOT NULL DEFAULT 'user',
+			is_bot BOOLEAN NOT NULL DEFAULT false,
+			owner_id VARCHAR(255) REFERENCES users(id) ON DELETE CASCADE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			suspended_at TIMESTAMP WITH TIME ZONE,
+			suspension_reason TEXT
 		)`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS verified BOOLEAN NOT NULL DEFAULT false`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'user'`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS is_bot BOOLEAN NOT NULL DEFAULT false`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS owner_id VARCHAR(255) REFERENCES users(id) ON DELETE CASCADE`,
+		`ALTER TABLE users ALTER COLUMN email DROP NOT NULL`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS suspended_at TIMESTAMP WITH TIME ZONE`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS suspension_reason TEXT`,
 		`CREATE TABLE IF NOT EXISTS chat_rooms (
 			id VARCHAR(255) PRIMARY KEY,
 			name VARCHAR(255) NOT NULL,
 			description TEXT,
-			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			slow_mode_seconds INTEGER NOT NULL DEFAULT 0,
+			read_only BOOLEAN NOT NULL DEFAULT false,
+			message_ttl_seconds INTEGER NOT NULL DEFAULT 0
 		)`,
+		`ALTER TABLE chat_rooms ADD COLUMN IF NOT EXISTS slow_mode_seconds INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE chat_rooms ADD COLUMN IF NOT EXISTS read_only BOOLEAN NOT NULL DEFAULT false`,
+		`ALTER TABLE chat_rooms ADD COLUMN IF NOT EXISTS message_ttl_seconds INTEGER NOT NULL DEFAULT 0`,
 		`CREATE TABLE IF NOT EXISTS room_members (
 			room_id VARCHAR(255) REFERENCES chat_rooms(id) ON DELETE CASCADE,
 			user_id VARCHAR(255) REFERENCES users(id) ON DELETE CASCADE,
 			joined_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
 			PRIMARY KEY (room_id, user_id)
 		)`,
+		`CREATE TABLE IF NOT EXISTS room_mutes (
+			room_id VARCHAR(255) REFERENCES chat_rooms(id) ON DELETE CASCADE,
+			user_id VARCHAR(255) REFERENCES users(id) ON DELETE CASCADE,
+			muted_by VARCHAR(255) REFERENCES users(id) ON DELETE SET NULL,
+			reason TEXT,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			expires_at TIMESTAMP WITH TIME ZONE,
+			PRIMARY KEY (room_id, user_id)
+		)`,
 		`CREATE TABLE IF NOT EXISTS messages (
 			id VARCHAR(255) PRIMARY KEY,
 			sender VARCHAR(255) NOT NULL REFERENCES users(username),
@@ -73,12 +103,254 @@ func (p *PostgresDB) createTables() error {
 			timestamp TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
 			room_id VARCHAR(255) REFERENCES chat_rooms(id) ON DELETE SET NULL
 		)`,
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS format VARCHAR(16) NOT NULL DEFAULT 'plain'`,
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS content_html TEXT`,
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP WITH TIME ZONE`,
+		`CREATE TABLE IF NOT EXISTS sessions (
+			id VARCHAR(255) PRIMARY KEY,
+			user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			revoked_at TIMESTAMP WITH TIME ZONE
+		)`,
+		`CREATE TABLE IF NOT EXISTS user_tokens (
+			token_hash VARCHAR(64) PRIMARY KEY,
+			user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			purpose VARCHAR(50) NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			used_at TIMESTAMP WITH TIME ZONE
+		)`,
+		`CREATE TABLE IF NOT EXISTS ws_tickets (
+			ticket_hash VARCHAR(64) PRIMARY KEY,
+			user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			username VARCHAR(255) NOT NULL,
+			role VARCHAR(20) NOT NULL DEFAULT '',
+			session_id VARCHAR(255),
+			api_key_id VARCHAR(255),
+			scopes TEXT[] NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS login_throttles (
+			key VARCHAR(255) PRIMARY KEY,
+			failures INTEGER NOT NULL DEFAULT 0,
+			last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			locked_until TIMESTAMP WITH TIME ZONE
+		)`,
+		`CREATE TABLE IF NOT EXISTS audit_events (
+			id VARCHAR(255) PRIMARY KEY,
+			action VARCHAR(100) NOT NULL,
+			actor_id VARCHAR(255),
+			target_id VARCHAR(255),
+			ip VARCHAR(64),
+			metadata JSONB,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			seq BIGINT UNIQUE,
+			prev_hash VARCHAR(64),
+			hash VARCHAR(64)
+		)`,
+		`ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS seq BIGINT UNIQUE`,
+		`ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS prev_hash VARCHAR(64)`,
+		`ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS hash VARCHAR(64)`,
+		// The audit trail is append-only: updates, deletes and truncation are rejected
+		`CREATE OR REPLACE FUNCTION reject_audit_event_change() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'audit_events is append-only';
+		END;
+		$$ LANGUAGE plpgsql`,
+		`DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events`,
+		`CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+			FOR EACH ROW EXECUTE FUNCTION reject_audit_event_change()`,
+		`DROP TRIGGER IF EXISTS audit_events_no_truncate ON audit_events`,
+		`CREATE TRIGGER audit_events_no_truncate BEFORE TRUNCATE ON audit_events
+			FOR EACH STATEMENT EXECUTE FUNCTION reject_audit_event_change()`,
+		`CREATE TABLE IF NOT EXISTS user_identities (
+			provider VARCHAR(255) NOT NULL,
+			subject VARCHAR(255) NOT NULL,
+			user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			email VARCHAR(255),
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			PRIMARY KEY (provider, subject)
+		)`,
+		`CREATE TABLE IF NOT EXISTS api_keys (
+			id VARCHAR(255) PRIMARY KEY,
+			user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name VARCHAR(255) NOT NULL,
+			prefix VARCHAR(32) NOT NULL,
+			key_hash VARCHAR(64) UNIQUE NOT NULL,
+			scopes TEXT[] NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			last_used_at TIMESTAMP WITH TIME ZONE,
+			revoked_at TIMESTAMP WITH TIME ZONE
+		)`,
+		`CREATE TABLE IF NOT EXISTS user_blocks (
+			user_id VARCHAR(255) REFERENCES users(id) ON DELETE CASCADE,
+			blocked_id VARCHAR(255) REFERENCES users(id) ON DELETE CASCADE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			PRIMARY KEY (user_id, blocked_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS room_moderation (
+			room_id VARCHAR(255) PRIMARY KEY REFERENCES chat_rooms(id) ON DELETE CASCADE,
+			disabled_filters TEXT[] NOT NULL DEFAULT '{}',
+			blocked_words TEXT[] NOT NULL DEFAULT '{}',
+			blocked_patterns TEXT[] NOT NULL DEFAULT '{}',
+			blocked_domains TEXT[] NOT NULL DEFAULT '{}',
+			actions JSONB,
+			updated_by VARCHAR(255) REFERENCES users(id) ON DELETE SET NULL,
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+		`CREATE TABLE IF NOT EXISTS held_messages (
+			id VARCHAR(255) PRIMARY KEY,
+			sender VARCHAR(255) NOT NULL REFERENCES users(username) ON DELETE CASCADE,
+			recipient VARCHAR(255) REFERENCES users(username) ON DELETE CASCADE,
+			room_id VARCHAR(255) REFERENCES chat_rooms(id) ON DELETE CASCADE,
+			content TEXT NOT NULL,
+			filter VARCHAR(100) NOT NULL,
+			reason TEXT,
+			status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			reviewed_by VARCHAR(255) REFERENCES users(id) ON DELETE SET NULL,
+			reviewed_at TIMESTAMP WITH TIME ZONE
+		)`,
+		`ALTER TABLE held_messages ADD COLUMN IF NOT EXISTS format VARCHAR(16) NOT NULL DEFAULT 'plain'`,
+		`ALTER TABLE held_messages ADD COLUMN IF NOT EXISTS ttl_seconds INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE held_messages ADD COLUMN IF NOT EXISTS attachment_ids TEXT[] NOT NULL DEFAULT '{}'`,
+		`CREATE TABLE IF NOT EXISTS reports (
+			id VARCHAR(255) PRIMARY KEY,
+			reporter_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			message_id VARCHAR(255),
+			message_content TEXT,
+			reported_user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			room_id VARCHAR(255) REFERENCES chat_rooms(id) ON DELETE CASCADE,
+			reason TEXT NOT NULL,
+			status VARCHAR(20) NOT NULL DEFAULT 'open',
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			action VARCHAR(50),
+			note TEXT,
+			resolved_by VARCHAR(255) REFERENCES users(id) ON DELETE SET NULL,
+			resolved_at TIMESTAMP WITH TIME ZONE
+		)`,
+		`CREATE TABLE IF NOT EXISTS attachments (
+			id VARCHAR(255) PRIMARY KEY,
+			uploader_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			message_id VARCHAR(255) REFERENCES messages(id) ON DELETE CASCADE,
+			filename VARCHAR(255) NOT NULL,
+			content_type VARCHAR(255) NOT NULL,
+			size BIGINT NOT NULL,
+			width INTEGER NOT NULL DEFAULT 0,
+			height INTEGER NOT NULL DEFAULT 0,
+			has_thumbnail BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+		`CREATE TABLE IF NOT EXISTS link_previews (
+			url TEXT PRIMARY KEY,
+			title TEXT,
+			description TEXT,
+			site_name TEXT,
+			image_url TEXT,
+			fetched_at TIMESTAMP WITH TIME ZONE NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS message_previews (
+			message_id VARCHAR(255) REFERENCES messages(id) ON DELETE CASCADE,
+			url TEXT REFERENCES link_previews(url) ON DELETE CASCADE,
+			position INTEGER NOT NULL,
+			PRIMARY KEY (message_id, url)
+		)`,
+		`CREATE TABLE IF NOT EXISTS mentions (
+			id VARCHAR(255) PRIMARY KEY,
+			user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			message_id VARCHAR(255) NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+			kind VARCHAR(20) NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			read_at TIMESTAMP WITH TIME ZONE,
+			UNIQUE (message_id, user_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS pinned_messages (
+			room_id VARCHAR(255) REFERENCES chat_rooms(id) ON DELETE CASCADE,
+			message_id VARCHAR(255) REFERENCES messages(id) ON DELETE CASCADE,
+			pinned_by VARCHAR(255) REFERENCES users(id) ON DELETE SET NULL,
+			pinned_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			PRIMARY KEY (room_id, message_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS saved_messages (
+			user_id VARCHAR(255) REFERENCES users(id) ON DELETE CASCADE,
+			message_id VARCHAR(255) REFERENCES messages(id) ON DELETE CASCADE,
+			note TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			PRIMARY KEY (user_id, message_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS scheduled_messages (
+			id VARCHAR(255) PRIMARY KEY,
+			user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			recipient VARCHAR(255),
+			room_id VARCHAR(255) REFERENCES chat_rooms(id) ON DELETE CASCADE,
+			content TEXT NOT NULL,
+			format VARCHAR(20) NOT NULL DEFAULT 'plain',
+			send_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			message_id VARCHAR(255),
+			error TEXT,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			claimed_at TIMESTAMP WITH TIME ZONE
+		)`,
+		`CREATE TABLE IF NOT EXISTS reminders (
+			id VARCHAR(255) PRIMARY KEY,
+			user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			message_id VARCHAR(255) NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+			note TEXT,
+			remind_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			claimed_at TIMESTAMP WITH TIME ZONE
+		)`,
+		`CREATE TABLE IF NOT EXISTS polls (
+			id VARCHAR(255) PRIMARY KEY,
+			message_id VARCHAR(255) NOT NULL UNIQUE REFERENCES messages(id) ON DELETE CASCADE,
+			room_id VARCHAR(255) NOT NULL REFERENCES chat_rooms(id) ON DELETE CASCADE,
+			created_by VARCHAR(255) REFERENCES users(id) ON DELETE SET NULL,
+			question TEXT NOT NULL,
+			options TEXT[] NOT NULL,
+			multiple_choice BOOLEAN NOT NULL DEFAULT FALSE,
+			anonymous BOOLEAN NOT NULL DEFAULT FALSE,
+			closes_at TIMESTAMP WITH TIME ZONE,
+			closed_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+		`CREATE TABLE IF NOT EXISTS poll_votes (
+			poll_id VARCHAR(255) NOT NULL REFERENCES polls(id) ON DELETE CASCADE,
+			user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			option_index INTEGER NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			PRIMARY KEY (poll_id, user_id, option_index)
+		)`,
 		`CREATE INDEX IF NOT EXISTS idx_messages_sender ON messages(sender)`,
 		`CREATE INDEX IF NOT EXISTS idx_messages_recipient ON messages(recipient)`,
 		`CREATE INDEX IF NOT EXISTS idx_messages_room_id ON messages(room_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_messages_timestamp ON messages(timestamp)`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_room_sender ON messages(room_id, sender, timestamp)`,
 		`CREATE INDEX IF NOT EXISTS idx_users_username ON users(username)`,
 		`CREATE INDEX IF NOT EXISTS idx_users_email ON users(email)`,
+		`CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_user_tokens_user_id ON user_tokens(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events(created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_users_owner_id ON users(owner_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_ws_tickets_expires_at ON ws_tickets(expires_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_held_messages_status ON held_messages(status, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_expires_at ON messages(expires_at) WHERE expires_at IS NOT NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_reports_status ON reports(status, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_attachments_message_id ON attachments(message_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_mentions_unread ON mentions(user_id, created_at) WHERE read_at IS NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_saved_messages_user_id ON saved_messages(user_id, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_scheduled_messages_due ON scheduled_messages(status, send_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_scheduled_messages_user_id ON scheduled_messages(user_id, send_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_reminders_due ON reminders(status, remind_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_reminders_user_id ON reminders(user_id, remind_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_poll_votes_user_id ON poll_votes(user_id)`,
 	}
 
 	for _, query := range queries {
@@ -92,11 +364,27 @@ func (p *PostgresDB) createTables() error {
 
 // MessageStore implementation
 
+// messageColumns lists the messages table columns in the order expected by scanMessage
+const messageColumns = `id, sender, COALESCE(recipient, ''), content, timestamp, COALESCE(room_id, ''),
+	format, COALESCE(content_html, ''), expires_at`
+
+// scanMessage scans a messages row selected with messageColumns
+func scanMessage(row rowScanner) (models.Message, error) {
+	var message models.Message
+	var expiresAt sql.NullTime
+	err := row.Scan(&message.ID, &message.Sender, &message.Recipient, &message.Content, &message.Timestamp,
+		&message.RoomID, &message.Format, &message.HTML, &expiresAt)
+	if expiresAt.Valid {
+		message.ExpiresAt = &expiresAt.Time
+	}
+	return message, err
+}
+
 // AddMessage adds a new message to the database
 func (p *PostgresDB) AddMessage(message models.Message) error {
 	query := `
-		INSERT INTO messages (id, sender, recipient, content, timestamp, room_id)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO messages (id, sender, recipient, content, timestamp, room_id, format, content_html, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, COALESCE(NULLIF($7, ''), 'plain'), NULLIF($8, ''), $9)
 	`
 	var roomID interface{}
 	if message.RoomID == "" {
@@ -113,15 +401,17 @@ func (p *PostgresDB) AddMessage(message models.Message) error {
 	}
 
 	_, err := p.db.Exec(query, message.ID, message.Sender, recipient,
-		message.Content, message.Timestamp, roomID)
+		message.Content, message.Timestamp, roomID, message.Format, message.HTML, message.ExpiresAt)
 	if err != nil {
 		return fmt.Errorf("failed to add message: %w", err)
 	}
 	return nil
-} // GetMessages retrieves all messages from the database
+}
+
+// GetMessages retrieves all messages from the database
 func (p *PostgresDB) GetMessages() ([]models.Message, error) {
 	query := `
-		SELECT id, sender, COALESCE(recipient, '') as recipient, content, timestamp, COALESCE(room_id, '') as room_id
+		SELECT ` + messageColumns + `
 		FROM messages
 		ORDER BY timestamp ASC
 	`
@@ -133,9 +423,8 @@ func (p *PostgresDB) GetMessages() ([]models.Message, error) {
 
 	var messages []models.Message
 	for rows.Next() {
-		var message models.Message
-		if err := rows.Scan(&message.ID, &message.Sender, &message.Recipient,
-			&message.Content, &message.Timestamp, &message.RoomID); err != nil {
+		message, err := scanMessage(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan message: %w", err)
 		}
 		messages = append(messages, message)
@@ -151,7 +440,7 @@ func (p *PostgresDB) GetMessages() ([]models.Message, error) {
 // GetMessagesByRoom retrieves messages for a specific room
 func (p *PostgresDB) GetMessagesByRoom(roomID string) ([]models.Message, error) {
 	query := `
-		SELECT id, sender, recipient, content, timestamp, COALESCE(room_id, '') as room_id
+		SELECT ` + messageColumns + `
 		FROM messages
 		WHERE room_id = $1
 		ORDER BY timestamp ASC
@@ -164,9 +453,8 @@ func (p *PostgresDB) GetMessagesByRoom(roomID string) ([]models.Message, error)
 
 	var messages []models.Message
 	for rows.Next() {
-		var message models.Message
-		if err := rows.Scan(&message.ID, &message.Sender, &message.Recipient,
-			&message.Content, &message.Timestamp, &message.RoomID); err != nil {
+		message, err := scanMessage(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan message: %w", err)
 		}
 		messages = append(messages, message)
@@ -182,7 +470,7 @@ func (p *PostgresDB) GetMessagesByRoom(roomID string) ([]models.Message, error)
 // GetMessagesBetweenUsers retrieves messages between two users
 func (p *PostgresDB) GetMessagesBetweenUsers(user1, user2 string) ([]models.Message, error) {
 	query := `
-		SELECT id, sender, recipient, content, timestamp, COALESCE(room_id, '') as room_id
+		SELECT ` + messageColumns + `
 		FROM messages
 		WHERE (sender = $1 AND recipient = $2) OR (sender = $2 AND recipient = $1)
 		ORDER BY timestamp ASC
@@ -195,9 +483,75 @@ func (p *PostgresDB) GetMessagesBetweenUsers(user1, user2 string) ([]models.Mess
 
 	var messages []models.Message
 	for rows.Next() {
-		var message models.Message
-		if err := rows.Scan(&message.ID, &message.Sender, &message.Recipient,
-			&message.Content, &message.Timestamp, &message.RoomID); err != nil {
+		message, err := scanMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		messages = append(messages, message)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating messages: %w", err)
+	}
+
+	return messages, nil
+}
+
+// GetMessage retrieves a message by ID
+func (p *PostgresDB) GetMessage(messageID string) (*models.Message, error) {
+	query := `
+		SELECT ` + messageColumns + `
+		FROM messages
+		WHERE id = $1
+	`
+	message, err := scanMessage(p.db.QueryRow(query, messageID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get message: %w", err)
+	}
+
+	return &message, nil
+}
+
+// DeleteMessage permanently deletes a message
+func (p *PostgresDB) DeleteMessage(messageID string) error {
+	result, err := p.db.Exec(`DELETE FROM messages WHERE id = $1`, messageID)
+	if err != nil {
+		return fmt.Errorf("failed to delete message: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("message not found")
+	}
+
+	return nil
+}
+
+// GetMessagesBySender returns the messages sent by a user since the given time, oldest first
+func (p *PostgresDB) GetMessagesBySender(sender string, since time.Time) ([]models.Message, error) {
+	query := `
+		SELECT ` + messageColumns + `
+		FROM messages
+		WHERE sender = $1 AND timestamp >= $2
+		ORDER BY timestamp ASC
+	`
+	rows, err := p.db.Query(query, sender, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get messages by sender: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []models.Message
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan message: %w", err)
 		}
 		messages = append(messages, message)
@@ -210,16 +564,127 @@ func (p *PostgresDB) GetMessagesBetweenUsers(user1, user2 string) ([]models.Mess
 	return messages, nil
 }
 
+// DeleteExpiredMessages deletes up to limit messages that expired at now, soonest first,
+// and returns them with their attachments. Messages being deleted by another instance are
+// skipped.
+func (p *PostgresDB) DeleteExpiredMessages(now time.Time, limit int) ([]models.Message, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT ` + messageColumns + `
+		FROM messages
+		WHERE expires_at <= $1
+		ORDER BY expires_at ASC
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	`
+	rows, err := tx.Query(query, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get expired messages: %w", err)
+	}
+	var messages []models.Message
+	var ids []string
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		messages = append(messages, message)
+		ids = append(ids, message.ID)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return nil, fmt.Errorf("error iterating messages: %w", err)
+	}
+	rows.Close()
+	if len(messages) == 0 {
+		return nil, nil
+	}
+
+	// Read the attachments before their records are deleted with the messages
+	rows, err = tx.Query(`SELECT `+attachmentColumns+` FROM attachments WHERE message_id = ANY($1) ORDER BY created_at ASC, id ASC`,
+		pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attachments: %w", err)
+	}
+	attachments := make(map[string][]models.Attachment)
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan attachment: %w", err)
+		}
+		attachments[attachment.MessageID] = append(attachments[attachment.MessageID], attachment)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return nil, fmt.Errorf("error iterating attachments: %w", err)
+	}
+	rows.Close()
+
+	if _, err := tx.Exec(`DELETE FROM messages WHERE id = ANY($1)`, pq.Array(ids)); err != nil {
+		return nil, fmt.Errorf("failed to delete expired messages: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit expired messages: %w", err)
+	}
+
+	for i := range messages {
+		messages[i].Attachments = attachments[messages[i].ID]
+	}
+	return messages, nil
+}
+
+// GetLastMessageTime returns when sender last posted in a room, or nil if they never did
+func (p *PostgresDB) GetLastMessageTime(roomID, sender string) (*time.Time, error) {
+	query := `SELECT MAX(timestamp) FROM messages WHERE room_id = $1 AND sender = $2`
+	var last sql.NullTime
+	if err := p.db.QueryRow(query, roomID, sender).Scan(&last); err != nil {
+		return nil, fmt.Errorf("failed to get last message time: %w", err)
+	}
+	if !last.Valid {
+		return nil, nil
+	}
+	return &last.Time, nil
+}
+
 // UserStore implementation
 
+// userColumns lists the users table columns in the order expected by scanUser.
+// Bot accounts have no email address.
+const userColumns = `id, username, COALESCE(email, ''), password_hash, is_online, verified, role, is_bot, COALESCE(owner_id, ''), created_at, suspended_at, COALESCE(suspension_reason, '')`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUser scans a users row selected with userColumns
+func scanUser(row rowScanner) (models.User, error) {
+	var user models.User
+	var suspendedAt sql.NullTime
+	err := row.Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash,
+		&user.IsOnline, &user.Verified, &user.Role, &user.IsBot, &user.OwnerID, &user.CreatedAt,
+		&suspendedAt, &user.SuspensionReason)
+	if suspendedAt.Valid {
+		user.SuspendedAt = &suspendedAt.Time
+	}
+	return user, err
+}
+
 // AddUser adds a new user to the database
 func (p *PostgresDB) AddUser(user models.User) error {
 	query := `
-		INSERT INTO users (id, username, email, password_hash, is_online, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO users (id, username, email, password_hash, is_online, verified, role, is_bot, owner_id, created_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, COALESCE(NULLIF($7, ''), 'user'), $8, NULLIF($9, ''), $10)
 	`
 	_, err := p.db.Exec(query, user.ID, user.Username, user.Email,
-		user.PasswordHash, user.IsOnline, user.CreatedAt)
+		user.PasswordHash, user.IsOnline, user.Verified, user.Role, user.IsBot, user.OwnerID, user.CreatedAt)
 	if err != nil {
 		if pqErr, ok := err.(*pq.Error); ok {
 			switch pqErr.Code {
@@ -239,16 +704,8 @@ func (p *PostgresDB) AddUser(user models.User) error {
 
 // GetUser retrieves a user by ID
 func (p *PostgresDB) GetUser(userID string) (*models.User, error) {
-	query := `
-		SELECT id, username, email, password_hash, is_online, created_at
-		FROM users
-		WHERE id = $1
-	`
-	var user models.User
-	err := p.db.QueryRow(query, userID).Scan(
-		&user.ID, &user.Username, &user.Email, &user.PasswordHash,
-		&user.IsOnline, &user.CreatedAt,
-	)
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
+	user, err := scanUser(p.db.QueryRow(query, userID))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -260,16 +717,8 @@ func (p *PostgresDB) GetUser(userID string) (*models.User, error) {
 
 // GetUserByUsername retrieves a user by username
 func (p *PostgresDB) GetUserByUsername(username string) (*models.User, error) {
-	query := `
-		SELECT id, username, email, password_hash, is_online, created_at
-		FROM users
-		WHERE username = $1
-	`
-	var user models.User
-	err := p.db.QueryRow(query, username).Scan(
-		&user.ID, &user.Username, &user.Email, &user.PasswordHash,
-		&user.IsOnline, &user.CreatedAt,
-	)
+	query := `SELECT ` + userColumns + ` FROM users WHERE username = $1`
+	user, err := scanUser(p.db.QueryRow(query, username))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -281,16 +730,8 @@ func (p *PostgresDB) GetUserByUsername(username string) (*models.User, error) {
 
 // GetUserByEmail retrieves a user by email
 func (p *PostgresDB) GetUserByEmail(email string) (*models.User, error) {
-	query := `
-		SELECT id, username, email, password_hash, is_online, created_at
-		FROM users
-		WHERE email = $1
-	`
-	var user models.User
-	err := p.db.QueryRow(query, email).Scan(
-		&user.ID, &user.Username, &user.Email, &user.PasswordHash,
-		&user.IsOnline, &user.CreatedAt,
-	)
+	query := `SELECT ` + userColumns + ` FROM users WHERE email = $1`
+	user, err := scanUser(p.db.QueryRow(query, email))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -322,11 +763,7 @@ func (p *PostgresDB) UpdateUserStatus(userID string, isOnline bool) error {
 
 // GetAllUsers retrieves all users
 func (p *PostgresDB) GetAllUsers() ([]models.User, error) {
-	query := `
-		SELECT id, username, email, password_hash, is_online, created_at
-		FROM users
-		ORDER BY created_at ASC
-	`
+	query := `SELECT ` + userColumns + ` FROM users ORDER BY created_at ASC`
 	rows, err := p.db.Query(query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get all users: %w", err)
@@ -335,9 +772,8 @@ func (p *PostgresDB) GetAllUsers() ([]models.User, error) {
 
 	var users []models.User
 	for rows.Next() {
-		var user models.User
-		if err := rows.Scan(&user.ID, &user.Username, &user.Email,
-			&user.PasswordHash, &user.IsOnline, &user.CreatedAt); err != nil {
+		user, err := scanUser(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan user: %w", err)
 		}
 		users = append(users, user)
@@ -350,96 +786,315 @@ func (p *PostgresDB) GetAllUsers() ([]models.User, error) {
 	return users, nil
 }
 
-// RoomStore implementation
+// UpdateUserPassword replaces a user's password hash
+func (p *PostgresDB) UpdateUserPassword(userID, passwordHash string) error {
+	query := `UPDATE users SET password_hash = $1 WHERE id = $2`
+	result, err := p.db.Exec(query, passwordHash, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update user password: %w", err)
+	}
 
-// CreateRoom creates a new chat room
-func (p *PostgresDB) CreateRoom(room models.ChatRoom) error {
-	tx, err := p.db.Begin()
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return fmt.Errorf("failed to get rows affected: %w", err)
 	}
-	defer tx.Rollback()
 
-	// Create the room
-	query := `
-		INSERT INTO chat_rooms (id, name, description, created_at)
-		VALUES ($1, $2, $3, $4)
-	`
-	_, err = tx.Exec(query, room.ID, room.Name, room.Description, room.CreatedAt)
+	if rowsAffected == 0 {
+		return fmt.Errorf("user not found")
+	}
+
+	return nil
+}
+
+// SetUserVerified updates whether a user's email address has been verified
+func (p *PostgresDB) SetUserVerified(userID string, verified bool) error {
+	query := `UPDATE users SET verified = $1 WHERE id = $2`
+	result, err := p.db.Exec(query, verified, userID)
 	if err != nil {
-		return fmt.Errorf("failed to create room: %w", err)
+		return fmt.Errorf("failed to update user verification: %w", err)
 	}
 
-	// Add members to the room
-	if len(room.Members) > 0 {
-		memberQuery := `INSERT INTO room_members (room_id, user_id) VALUES ($1, $2)`
-		for _, memberID := range room.Members {
-			_, err = tx.Exec(memberQuery, room.ID, memberID)
-			if err != nil {
-				return fmt.Errorf("failed to add member to room: %w", err)
-			}
-		}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
 	}
 
-	if err = tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+	if rowsAffected == 0 {
+		return fmt.Errorf("user not found")
 	}
 
 	return nil
 }
 
-// GetRoom retrieves a room by ID
-func (p *PostgresDB) GetRoom(roomID string) (*models.ChatRoom, error) {
-	// Get room details
-	query := `
-		SELECT id, name, description, created_at
-		FROM chat_rooms
-		WHERE id = $1
-	`
-	var room models.ChatRoom
-	err := p.db.QueryRow(query, roomID).Scan(
-		&room.ID, &room.Name, &room.Description, &room.CreatedAt,
-	)
+// SetUserRole changes a user's global role
+func (p *PostgresDB) SetUserRole(userID, role string) error {
+	query := `UPDATE users SET role = $1 WHERE id = $2`
+	result, err := p.db.Exec(query, role, userID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
-		}
-		return nil, fmt.Errorf("failed to get room: %w", err)
+		return fmt.Errorf("failed to update user role: %w", err)
 	}
 
-	// Get room members
-	memberQuery := `
-		SELECT user_id
-		FROM room_members
-		WHERE room_id = $1
-	`
-	rows, err := p.db.Query(memberQuery, roomID)
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get room members: %w", err)
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("user not found")
+	}
+
+	return nil
+}
+
+// GetBotsByOwner retrieves the bot accounts managed by a user
+func (p *PostgresDB) GetBotsByOwner(ownerID string) ([]models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE is_bot AND owner_id = $1 ORDER BY created_at ASC`
+	rows, err := p.db.Query(query, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bots: %w", err)
 	}
 	defer rows.Close()
 
-	var members []string
+	var bots []models.User
 	for rows.Next() {
-		var memberID string
-		if err := rows.Scan(&memberID); err != nil {
-			return nil, fmt.Errorf("failed to scan member: %w", err)
+		bot, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan bot: %w", err)
 		}
-		members = append(members, memberID)
+		bots = append(bots, bot)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating members: %w", err)
+		return nil, fmt.Errorf("error iterating bots: %w", err)
 	}
 
-	room.Members = members
+	return bots, nil
+}
+
+// SearchUsers retrieves a page of users matching the filter along with the total number of matches
+func (p *PostgresDB) SearchUsers(filter models.UserFilter) ([]models.User, int, error) {
+	var conditions []string
+	var args []interface{}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.Query != "" {
+		addCondition("(username ILIKE $%[1]d OR email ILIKE $%[1]d)", "%"+escapeLike(filter.Query)+"%")
+	}
+	if filter.Role != "" {
+		addCondition("role = $%d", filter.Role)
+	}
+	if filter.Suspended != nil {
+		addCondition("(suspended_at IS NOT NULL) = $%d", *filter.Suspended)
+	}
+	if filter.IsBot != nil {
+		addCondition("is_bot = $%d", *filter.IsBot)
+	}
+	if filter.Verified != nil {
+		addCondition("verified = $%d", *filter.Verified)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := p.db.QueryRow(`SELECT COUNT(*) FROM users`+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count users: %w", err)
+	}
+
+	query := `SELECT ` + userColumns + ` FROM users` + where + ` ORDER BY created_at ASC, id ASC`
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	if filter.Offset > 0 {
+		args = append(args, filter.Offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+
+	rows, err := p.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating users: %w", err)
+	}
+
+	return users, total, nil
+}
+
+// escapeLike escapes the wildcard characters of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// SetUserSuspended suspends a user, or lifts the suspension when suspendedAt is nil
+func (p *PostgresDB) SetUserSuspended(userID string, suspendedAt *time.Time, reason string) error {
+	query := `UPDATE users SET suspended_at = $1, suspension_reason = NULLIF($2, '') WHERE id = $3`
+	result, err := p.db.Exec(query, suspendedAt, reason, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update user suspension: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("user not found")
+	}
+
+	return nil
+}
+
+// DeleteUser permanently deletes a user together with their bots and messages.
+// Sessions, tokens, identities, API keys and room memberships are removed by cascade.
+func (p *PostgresDB) DeleteUser(userID string) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Messages reference usernames without a cascade, so remove those of the user and their bots first
+	query := `
+		DELETE FROM messages
+		WHERE sender IN (SELECT username FROM users WHERE id = $1 OR owner_id = $1)
+		   OR recipient IN (SELECT username FROM users WHERE id = $1 OR owner_id = $1)
+	`
+	if _, err := tx.Exec(query, userID); err != nil {
+		return fmt.Errorf("failed to delete user messages: %w", err)
+	}
+
+	result, err := tx.Exec(`DELETE FROM users WHERE id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("user not found")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// RoomStore implementation
+
+// CreateRoom creates a new chat room
+func (p *PostgresDB) CreateRoom(room models.ChatRoom) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Create the room
+	query := `
+		INSERT INTO chat_rooms (id, name, description, created_at, slow_mode_seconds, read_only, message_ttl_seconds)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+	_, err = tx.Exec(query, room.ID, room.Name, room.Description, room.CreatedAt, room.SlowModeSeconds, room.ReadOnly,
+		room.MessageTTLSeconds)
+	if err != nil {
+		return fmt.Errorf("failed to create room: %w", err)
+	}
+
+	// Add members to the room
+	if len(room.Members) > 0 {
+		memberQuery := `INSERT INTO room_members (room_id, user_id) VALUES ($1, $2)`
+		for _, memberID := range room.Members {
+			_, err = tx.Exec(memberQuery, room.ID, memberID)
+			if err != nil {
+				return fmt.Errorf("failed to add member to room: %w", err)
+			}
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// GetRoom retrieves a room by ID
+func (p *PostgresDB) GetRoom(roomID string) (*models.ChatRoom, error) {
+	// Get room details
+	query := `
+		SELECT id, name, description, created_at, slow_mode_seconds, read_only, message_ttl_seconds
+		FROM chat_rooms
+		WHERE id = $1
+	`
+	var room models.ChatRoom
+	err := p.db.QueryRow(query, roomID).Scan(
+		&room.ID, &room.Name, &room.Description, &room.CreatedAt, &room.SlowModeSeconds, &room.ReadOnly,
+		&room.MessageTTLSeconds,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get room: %w", err)
+	}
+
+	// Get room members
+	memberQuery := `
+		SELECT user_id
+		FROM room_members
+		WHERE room_id = $1
+	`
+	rows, err := p.db.Query(memberQuery, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get room members: %w", err)
+	}
+	defer rows.Close()
+
+	var members []string
+	for rows.Next() {
+		var memberID string
+		if err := rows.Scan(&memberID); err != nil {
+			return nil, fmt.Errorf("failed to scan member: %w", err)
+		}
+		members = append(members, memberID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating members: %w", err)
+	}
+
+	room.Members = members
 	return &room, nil
 }
 
 // GetRoomsByUser retrieves rooms that a user is a member of
 func (p *PostgresDB) GetRoomsByUser(userID string) ([]models.ChatRoom, error) {
 	query := `
-		SELECT r.id, r.name, r.description, r.created_at
+		SELECT r.id, r.name, r.description, r.created_at, r.slow_mode_seconds, r.read_only, r.message_ttl_seconds
 		FROM chat_rooms r
 		INNER JOIN room_members rm ON r.id = rm.room_id
 		WHERE rm.user_id = $1
@@ -454,7 +1109,8 @@ func (p *PostgresDB) GetRoomsByUser(userID string) ([]models.ChatRoom, error) {
 	var rooms []models.ChatRoom
 	for rows.Next() {
 		var room models.ChatRoom
-		if err := rows.Scan(&room.ID, &room.Name, &room.Description, &room.CreatedAt); err != nil {
+		if err := rows.Scan(&room.ID, &room.Name, &room.Description, &room.CreatedAt, &room.SlowModeSeconds, &room.ReadOnly,
+			&room.MessageTTLSeconds); err != nil {
 			return nil, fmt.Errorf("failed to scan room: %w", err)
 		}
 
@@ -524,3 +1180,2007 @@ func (p *PostgresDB) RemoveUserFromRoom(roomID, userID string) error {
 
 	return nil
 }
+
+// UpdateRoomSettings changes the slow-mode interval, read-only flag and message TTL of a room
+func (p *PostgresDB) UpdateRoomSettings(roomID string, slowModeSeconds int, readOnly bool, messageTTLSeconds int) error {
+	query := `UPDATE chat_rooms SET slow_mode_seconds = $1, read_only = $2, message_ttl_seconds = $3 WHERE id = $4`
+	result, err := p.db.Exec(query, slowModeSeconds, readOnly, messageTTLSeconds, roomID)
+	if err != nil {
+		return fmt.Errorf("failed to update room settings: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("room not found")
+	}
+
+	return nil
+}
+
+// roomMuteColumns lists the room_mutes table columns in the order expected by scanRoomMute
+const roomMuteColumns = `room_id, user_id, COALESCE(muted_by, ''), COALESCE(reason, ''), created_at, expires_at`
+
+// scanRoomMute scans a room_mutes row selected with roomMuteColumns
+func scanRoomMute(row rowScanner) (models.RoomMute, error) {
+	var mute models.RoomMute
+	var expiresAt sql.NullTime
+	err := row.Scan(&mute.RoomID, &mute.UserID, &mute.MutedBy, &mute.Reason, &mute.CreatedAt, &expiresAt)
+	if expiresAt.Valid {
+		mute.ExpiresAt = &expiresAt.Time
+	}
+	return mute, err
+}
+
+// SetRoomMute creates or replaces the mute of a room member
+func (p *PostgresDB) SetRoomMute(mute models.RoomMute) error {
+	query := `
+		INSERT INTO room_mutes (room_id, user_id, muted_by, reason, created_at, expires_at)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5, $6)
+		ON CONFLICT (room_id, user_id) DO UPDATE
+		SET muted_by = EXCLUDED.muted_by, reason = EXCLUDED.reason,
+			created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at
+	`
+	_, err := p.db.Exec(query, mute.RoomID, mute.UserID, mute.MutedBy, mute.Reason, mute.CreatedAt, mute.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to set room mute: %w", err)
+	}
+	return nil
+}
+
+// GetRoomMute returns the mute of a room member, including expired mutes
+func (p *PostgresDB) GetRoomMute(roomID, userID string) (*models.RoomMute, error) {
+	query := `SELECT ` + roomMuteColumns + ` FROM room_mutes WHERE room_id = $1 AND user_id = $2`
+	mute, err := scanRoomMute(p.db.QueryRow(query, roomID, userID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get room mute: %w", err)
+	}
+	return &mute, nil
+}
+
+// GetRoomMutes returns the mutes of a room that have not expired
+func (p *PostgresDB) GetRoomMutes(roomID string) ([]models.RoomMute, error) {
+	query := `
+		SELECT ` + roomMuteColumns + `
+		FROM room_mutes
+		WHERE room_id = $1 AND (expires_at IS NULL OR expires_at > NOW())
+		ORDER BY created_at ASC
+	`
+	rows, err := p.db.Query(query, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get room mutes: %w", err)
+	}
+	defer rows.Close()
+
+	var mutes []models.RoomMute
+	for rows.Next() {
+		mute, err := scanRoomMute(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan room mute: %w", err)
+		}
+		mutes = append(mutes, mute)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating room mutes: %w", err)
+	}
+
+	return mutes, nil
+}
+
+// DeleteRoomMute lifts the mute of a room member
+func (p *PostgresDB) DeleteRoomMute(roomID, userID string) error {
+	result, err := p.db.Exec(`DELETE FROM room_mutes WHERE room_id = $1 AND user_id = $2`, roomID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete room mute: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("mute not found")
+	}
+
+	return nil
+}
+
+// SessionStore implementation
+
+// CreateSession records a newly issued authentication session
+func (p *PostgresDB) CreateSession(session models.Session) error {
+	query := `
+		INSERT INTO sessions (id, user_id, created_at, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`
+	_, err := p.db.Exec(query, session.ID, session.UserID, session.CreatedAt, session.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	return nil
+}
+
+// GetSession retrieves a session by ID
+func (p *PostgresDB) GetSession(sessionID string) (*models.Session, error) {
+	query := `
+		SELECT id, user_id, created_at, expires_at, revoked_at
+		FROM sessions
+		WHERE id = $1
+	`
+	var session models.Session
+	var revokedAt sql.NullTime
+	err := p.db.QueryRow(query, sessionID).Scan(
+		&session.ID, &session.UserID, &session.CreatedAt, &session.ExpiresAt, &revokedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	if revokedAt.Valid {
+		session.RevokedAt = &revokedAt.Time
+	}
+	return &session, nil
+}
+
+// RevokeSession marks a single session as revoked
+func (p *PostgresDB) RevokeSession(sessionID string) error {
+	query := `UPDATE sessions SET revoked_at = COALESCE(revoked_at, NOW()) WHERE id = $1`
+	result, err := p.db.Exec(query, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("session not found")
+	}
+
+	return nil
+}
+
+// RevokeUserSessions revokes every active session of a user except exceptSessionID
+func (p *PostgresDB) RevokeUserSessions(userID, exceptSessionID string) error {
+	query := `
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL
+	`
+	if _, err := p.db.Exec(query, userID, exceptSessionID); err != nil {
+		return fmt.Errorf("failed to revoke user sessions: %w", err)
+	}
+	return nil
+}
+
+// TokenStore implementation
+
+// AddUserToken stores the hash of a single-use user token
+func (p *PostgresDB) AddUserToken(token models.UserToken) error {
+	query := `
+		INSERT INTO user_tokens (token_hash, user_id, purpose, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+	_, err := p.db.Exec(query, token.TokenHash, token.UserID, token.Purpose, token.CreatedAt, token.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to add user token: %w", err)
+	}
+	return nil
+}
+
+// ConsumeUserToken atomically marks an unused, unexpired token as used and returns it
+func (p *PostgresDB) ConsumeUserToken(tokenHash, purpose string) (*models.UserToken, error) {
+	query := `
+		UPDATE user_tokens SET used_at = NOW()
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING token_hash, user_id, purpose, created_at, expires_at, used_at
+	`
+	var token models.UserToken
+	var usedAt time.Time
+	err := p.db.QueryRow(query, tokenHash, purpose).Scan(
+		&token.TokenHash, &token.UserID, &token.Purpose, &token.CreatedAt, &token.ExpiresAt, &usedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to consume user token: %w", err)
+	}
+	token.UsedAt = &usedAt
+	return &token, nil
+}
+
+// TicketStore implementation
+
+// AddWebSocketTicket stores a new WebSocket ticket and purges tickets that expired unredeemed
+func (p *PostgresDB) AddWebSocketTicket(ticket models.WebSocketTicket) error {
+	if _, err := p.db.Exec(`DELETE FROM ws_tickets WHERE expires_at < NOW()`); err != nil {
+		return fmt.Errorf("failed to purge expired websocket tickets: %w", err)
+	}
+
+	query := `
+		INSERT INTO ws_tickets (ticket_hash, user_id, username, role, session_id, api_key_id, scopes, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7, $8, $9)
+	`
+	_, err := p.db.Exec(query, ticket.TicketHash, ticket.UserID, ticket.Username, ticket.Role,
+		ticket.SessionID, ticket.APIKeyID, pq.Array(ticket.Scopes), ticket.CreatedAt, ticket.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to add websocket ticket: %w", err)
+	}
+	return nil
+}
+
+// ConsumeWebSocketTicket atomically deletes an unexpired ticket and returns it
+func (p *PostgresDB) ConsumeWebSocketTicket(ticketHash string) (*models.WebSocketTicket, error) {
+	query := `
+		DELETE FROM ws_tickets
+		WHERE ticket_hash = $1
+		RETURNING ticket_hash, user_id, username, role, COALESCE(session_id, ''), COALESCE(api_key_id, ''),
+			scopes, created_at, expires_at
+	`
+	var ticket models.WebSocketTicket
+	err := p.db.QueryRow(query, ticketHash).Scan(&ticket.TicketHash, &ticket.UserID, &ticket.Username,
+		&ticket.Role, &ticket.SessionID, &ticket.APIKeyID, pq.Array(&ticket.Scopes), &ticket.CreatedAt, &ticket.ExpiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to consume websocket ticket: %w", err)
+	}
+
+	if time.Now().After(ticket.ExpiresAt) {
+		return nil, nil
+	}
+	return &ticket, nil
+}
+
+// LoginAttemptStore implementation
+
+// GetLoginThrottle retrieves the failed login counter for a key
+func (p *PostgresDB) GetLoginThrottle(key string) (*models.LoginThrottle, error) {
+	query := `
+		SELECT key, failures, last_failure_at, locked_until
+		FROM login_throttles
+		WHERE key = $1
+	`
+	var throttle models.LoginThrottle
+	var lockedUntil sql.NullTime
+	err := p.db.QueryRow(query, key).Scan(
+		&throttle.Key, &throttle.Failures, &throttle.LastFailureAt, &lockedUntil,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get login throttle: %w", err)
+	}
+	throttle.LockedUntil = lockedUntil.Time
+	return &throttle, nil
+}
+
+// IncrementLoginFailures atomically records a failed login and returns the updated counter
+func (p *PostgresDB) IncrementLoginFailures(key string, at, resetBefore time.Time) (*models.LoginThrottle, error) {
+	query := `
+		INSERT INTO login_throttles (key, failures, last_failure_at)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE
+				WHEN login_throttles.last_failure_at < $3 THEN 1
+				ELSE login_throttles.failures + 1
+			END,
+			last_failure_at = $2
+		RETURNING key, failures, last_failure_at, locked_until
+	`
+	var throttle models.LoginThrottle
+	var lockedUntil sql.NullTime
+	err := p.db.QueryRow(query, key, at, resetBefore).Scan(
+		&throttle.Key, &throttle.Failures, &throttle.LastFailureAt, &lockedUntil,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to increment login failures: %w", err)
+	}
+	throttle.LockedUntil = lockedUntil.Time
+	return &throttle, nil
+}
+
+// SetLoginLockout locks a key until the given time
+func (p *PostgresDB) SetLoginLockout(key string, until time.Time) error {
+	query := `
+		INSERT INTO login_throttles (key, failures, last_failure_at, locked_until)
+		VALUES ($1, 0, NOW(), $2)
+		ON CONFLICT (key) DO UPDATE SET locked_until = $2
+	`
+	if _, err := p.db.Exec(query, key, until); err != nil {
+		return fmt.Errorf("failed to set login lockout: %w", err)
+	}
+	return nil
+}
+
+// ClearLoginThrottle removes the failed login counter and any lockout for a key
+func (p *PostgresDB) ClearLoginThrottle(key string) error {
+	if _, err := p.db.Exec(`DELETE FROM login_throttles WHERE key = $1`, key); err != nil {
+		return fmt.Errorf("failed to clear login throttle: %w", err)
+	}
+	return nil
+}
+
+// AuditStore implementation
+
+// auditEventColumns lists the audit_events columns in the order expected by scanAuditEvent
+const auditEventColumns = `id, action, COALESCE(actor_id, ''), COALESCE(target_id, ''), COALESCE(ip, ''), metadata, created_at,
+	COALESCE(seq, 0), COALESCE(prev_hash, ''), COALESCE(hash, '')`
+
+// scanAuditEvent scans an audit_events row selected with auditEventColumns
+func scanAuditEvent(row rowScanner) (models.AuditEvent, error) {
+	var event models.AuditEvent
+	var metadata []byte
+	if err := row.Scan(&event.ID, &event.Action, &event.ActorID, &event.TargetID, &event.IP,
+		&metadata, &event.CreatedAt, &event.Seq, &event.PrevHash, &event.Hash); err != nil {
+		return event, err
+	}
+	if len(metadata) > 0 {
+		if err := json.Unmarshal(metadata, &event.Metadata); err != nil {
+			return event, fmt.Errorf("failed to decode audit metadata: %w", err)
+		}
+	}
+	return event, nil
+}
+
+// AddAuditEvent appends an event to the audit trail, linking it to the previous event
+// in the hash chain. Writers are serialized so that the chain never forks.
+func (p *PostgresDB) AddAuditEvent(event models.AuditEvent) error {
+	metadata, err := json.Marshal(event.Metadata)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit metadata: %w", err)
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`LOCK TABLE audit_events IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		return fmt.Errorf("failed to lock audit trail: %w", err)
+	}
+
+	var prevSeq int64
+	var prevHash string
+	err = tx.QueryRow(`SELECT seq, hash FROM audit_events WHERE seq IS NOT NULL ORDER BY seq DESC LIMIT 1`).
+		Scan(&prevSeq, &prevHash)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to get previous audit event: %w", err)
+	}
+
+	// The hash must cover the timestamp as stored, and Postgres keeps microseconds
+	event.CreatedAt = event.CreatedAt.Truncate(time.Microsecond)
+	event.Seq = prevSeq + 1
+	event.PrevHash = prevHash
+	event.Hash = event.ComputeHash()
+
+	query := `
+		INSERT INTO audit_events (id, action, actor_id, target_id, ip, metadata, created_at, seq, prev_hash, hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''), $10)
+	`
+	_, err = tx.Exec(query, event.ID, event.Action, event.ActorID, event.TargetID,
+		event.IP, metadata, event.CreatedAt, event.Seq, event.PrevHash, event.Hash)
+	if err != nil {
+		return fmt.Errorf("failed to add audit event: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// GetAuditEvents retrieves all audit events in chain order. Events recorded before
+// hash chaining was introduced come first.
+func (p *PostgresDB) GetAuditEvents() ([]models.AuditEvent, error) {
+	query := `SELECT ` + auditEventColumns + ` FROM audit_events ORDER BY seq ASC NULLS FIRST, created_at ASC`
+	rows, err := p.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []models.AuditEvent
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan audit event: %w", err)
+		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating audit events: %w", err)
+	}
+
+	return events, nil
+}
+
+// SearchAuditEvents retrieves a page of audit events matching the filter, newest first,
+// along with the total number of matches
+func (p *PostgresDB) SearchAuditEvents(filter models.AuditFilter) ([]models.AuditEvent, int, error) {
+	var conditions []string
+	var args []interface{}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.Action != "" {
+		addCondition("action = $%d", filter.Action)
+	}
+	if filter.ActorID != "" {
+		addCondition("actor_id = $%d", filter.ActorID)
+	}
+	if filter.TargetID != "" {
+		addCondition("target_id = $%d", filter.TargetID)
+	}
+	if filter.IP != "" {
+		addCondition("ip = $%d", filter.IP)
+	}
+	if !filter.Since.IsZero() {
+		addCondition("created_at >= $%d", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		addCondition("created_at < $%d", filter.Until)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := p.db.QueryRow(`SELECT COUNT(*) FROM audit_events`+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count audit events: %w", err)
+	}
+
+	query := `SELECT ` + auditEventColumns + ` FROM audit_events` + where + ` ORDER BY seq DESC NULLS LAST, created_at DESC`
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	if filter.Offset > 0 {
+		args = append(args, filter.Offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+
+	rows, err := p.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search audit events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []models.AuditEvent
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan audit event: %w", err)
+		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating audit events: %w", err)
+	}
+
+	return events, total, nil
+}
+
+// IdentityStore implementation
+
+// AddUserIdentity links a user to an external identity provider account
+func (p *PostgresDB) AddUserIdentity(identity models.UserIdentity) error {
+	query := `
+		INSERT INTO user_identities (provider, subject, user_id, email, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+	_, err := p.db.Exec(query, identity.Provider, identity.Subject, identity.UserID, identity.Email, identity.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to add user identity: %w", err)
+	}
+	return nil
+}
+
+// GetUserIdentity retrieves the identity link for a provider account
+func (p *PostgresDB) GetUserIdentity(provider, subject string) (*models.UserIdentity, error) {
+	query := `
+		SELECT provider, subject, user_id, COALESCE(email, ''), created_at
+		FROM user_identities
+		WHERE provider = $1 AND subject = $2
+	`
+	var identity models.UserIdentity
+	err := p.db.QueryRow(query, provider, subject).Scan(
+		&identity.Provider, &identity.Subject, &identity.UserID, &identity.Email, &identity.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get user identity: %w", err)
+	}
+	return &identity, nil
+}
+
+// APIKeyStore implementation
+
+// apiKeyColumns lists the api_keys table columns in the order expected by scanAPIKey
+const apiKeyColumns = `id, user_id, name, prefix, key_hash, scopes, created_at, expires_at, last_used_at, revoked_at`
+
+// scanAPIKey scans an api_keys row selected with apiKeyColumns
+func scanAPIKey(row rowScanner) (models.APIKey, error) {
+	var key models.APIKey
+	var lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.KeyHash, pq.Array(&key.Scopes),
+		&key.CreatedAt, &key.ExpiresAt, &lastUsedAt, &revokedAt)
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return key, err
+}
+
+// AddAPIKey stores a new API key
+func (p *PostgresDB) AddAPIKey(key models.APIKey) error {
+	query := `
+		INSERT INTO api_keys (id, user_id, name, prefix, key_hash, scopes, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	_, err := p.db.Exec(query, key.ID, key.UserID, key.Name, key.Prefix, key.KeyHash,
+		pq.Array(key.Scopes), key.CreatedAt, key.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to add api key: %w", err)
+	}
+	return nil
+}
+
+// GetAPIKey retrieves an API key by ID
+func (p *PostgresDB) GetAPIKey(keyID string) (*models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE id = $1`
+	key, err := scanAPIKey(p.db.QueryRow(query, keyID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get api key: %w", err)
+	}
+	return &key, nil
+}
+
+// GetAPIKeyByHash retrieves an API key by the hash of its secret
+func (p *PostgresDB) GetAPIKeyByHash(keyHash string) (*models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE key_hash = $1`
+	key, err := scanAPIKey(p.db.QueryRow(query, keyHash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get api key: %w", err)
+	}
+	return &key, nil
+}
+
+// GetAPIKeysByUser retrieves all API keys of a user, including revoked and expired ones
+func (p *PostgresDB) GetAPIKeysByUser(userID string) ([]models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE user_id = $1 ORDER BY created_at ASC`
+	rows, err := p.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get api keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan api key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating api keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey marks an API key as revoked
+func (p *PostgresDB) RevokeAPIKey(keyID string) error {
+	query := `UPDATE api_keys SET revoked_at = COALESCE(revoked_at, NOW()) WHERE id = $1`
+	result, err := p.db.Exec(query, keyID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("api key not found")
+	}
+
+	return nil
+}
+
+// TouchAPIKey records when an API key was last used
+func (p *PostgresDB) TouchAPIKey(keyID string, usedAt time.Time) error {
+	if _, err := p.db.Exec(`UPDATE api_keys SET last_used_at = $1 WHERE id = $2`, usedAt, keyID); err != nil {
+		return fmt.Errorf("failed to update api key usage: %w", err)
+	}
+	return nil
+}
+
+// BlockStore implementation
+
+// AddUserBlock records a block; blocking a user twice is not an error
+func (p *PostgresDB) AddUserBlock(block models.UserBlock) error {
+	query := `
+		INSERT INTO user_blocks (user_id, blocked_id, created_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, blocked_id) DO NOTHING
+	`
+	if _, err := p.db.Exec(query, block.UserID, block.BlockedID, block.CreatedAt); err != nil {
+		return fmt.Errorf("failed to add user block: %w", err)
+	}
+	return nil
+}
+
+// DeleteUserBlock lifts a block
+func (p *PostgresDB) DeleteUserBlock(userID, blockedID string) error {
+	result, err := p.db.Exec(`DELETE FROM user_blocks WHERE user_id = $1 AND blocked_id = $2`, userID, blockedID)
+	if err != nil {
+		return fmt.Errorf("failed to delete user block: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("block not found")
+	}
+
+	return nil
+}
+
+// GetUserBlocks returns the users blocked by userID along with their usernames
+func (p *PostgresDB) GetUserBlocks(userID string) ([]models.UserBlock, error) {
+	query := `
+		SELECT b.user_id, b.blocked_id, u.username, b.created_at
+		FROM user_blocks b
+		INNER JOIN users u ON u.id = b.blocked_id
+		WHERE b.user_id = $1
+		ORDER BY b.created_at ASC
+	`
+	rows, err := p.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user blocks: %w", err)
+	}
+	defer rows.Close()
+
+	var blocks []models.UserBlock
+	for rows.Next() {
+		var block models.UserBlock
+		if err := rows.Scan(&block.UserID, &block.BlockedID, &block.BlockedUsername, &block.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan user block: %w", err)
+		}
+		blocks = append(blocks, block)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating user blocks: %w", err)
+	}
+
+	return blocks, nil
+}
+
+// IsUserBlocked reports whether userID has blocked blockedID
+func (p *PostgresDB) IsUserBlocked(userID, blockedID string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM user_blocks WHERE user_id = $1 AND blocked_id = $2)`
+	var blocked bool
+	if err := p.db.QueryRow(query, userID, blockedID).Scan(&blocked); err != nil {
+		return false, fmt.Errorf("failed to check user block: %w", err)
+	}
+	return blocked, nil
+}
+
+// ModerationStore implementation
+
+// GetRoomModeration returns the filter settings of a room, or nil if it has none
+func (p *PostgresDB) GetRoomModeration(roomID string) (*models.RoomModeration, error) {
+	query := `
+		SELECT room_id, disabled_filters, blocked_words, blocked_patterns, blocked_domains, actions,
+			COALESCE(updated_by, ''), updated_at
+		FROM room_moderation
+		WHERE room_id = $1
+	`
+	var settings models.RoomModeration
+	var actions []byte
+	var updatedAt sql.NullTime
+	err := p.db.QueryRow(query, roomID).Scan(&settings.RoomID, pq.Array(&settings.DisabledFilters),
+		pq.Array(&settings.BlockedWords), pq.Array(&settings.BlockedPatterns), pq.Array(&settings.BlockedDomains),
+		&actions, &settings.UpdatedBy, &updatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get room moderation: %w", err)
+	}
+	if len(actions) > 0 {
+		if err := json.Unmarshal(actions, &settings.Actions); err != nil {
+			return nil, fmt.Errorf("failed to decode room moderation actions: %w", err)
+		}
+	}
+	if updatedAt.Valid {
+		settings.UpdatedAt = &updatedAt.Time
+	}
+	return &settings, nil
+}
+
+// SetRoomModeration creates or replaces the filter settings of a room
+func (p *PostgresDB) SetRoomModeration(settings models.RoomModeration) error {
+	actions, err := json.Marshal(settings.Actions)
+	if err != nil {
+		return fmt.Errorf("failed to encode room moderation actions: %w", err)
+	}
+
+	query := `
+		INSERT INTO room_moderation (room_id, disabled_filters, blocked_words, blocked_patterns, blocked_domains,
+			actions, updated_by, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8)
+		ON CONFLICT (room_id) DO UPDATE
+		SET disabled_filters = EXCLUDED.disabled_filters, blocked_words = EXCLUDED.blocked_words,
+			blocked_patterns = EXCLUDED.blocked_patterns, blocked_domains = EXCLUDED.blocked_domains,
+			actions = EXCLUDED.actions, updated_by = EXCLUDED.updated_by, updated_at = EXCLUDED.updated_at
+	`
+	_, err = p.db.Exec(query, settings.RoomID, pq.Array(settings.DisabledFilters), pq.Array(settings.BlockedWords),
+		pq.Array(settings.BlockedPatterns), pq.Array(settings.BlockedDomains), actions, settings.UpdatedBy, settings.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to set room moderation: %w", err)
+	}
+	return nil
+}
+
+// heldMessageColumns lists the held_messages table columns in the order expected by scanHeldMessage
+const heldMessageColumns = `id, sender, COALESCE(recipient, ''), COALESCE(room_id, ''), content, format, ttl_seconds,
+	attachment_ids, filter, COALESCE(reason, ''), status, created_at, COALESCE(reviewed_by, ''), reviewed_at`
+
+// scanHeldMessage scans a held_messages row selected with heldMessageColumns
+func scanHeldMessage(row rowScanner) (models.HeldMessage, error) {
+	var message models.HeldMessage
+	var reviewedAt sql.NullTime
+	err := row.Scan(&message.ID, &message.Sender, &message.Recipient, &message.RoomID, &message.Content, &message.Format,
+		&message.TTLSeconds, pq.Array(&message.AttachmentIDs), &message.Filter, &message.Reason, &message.Status, &message.CreatedAt, &message.ReviewedBy, &reviewedAt)
+	if reviewedAt.Valid {
+		message.ReviewedAt = &reviewedAt.Time
+	}
+	return message, err
+}
+
+// AddHeldMessage adds a message to the review queue
+func (p *PostgresDB) AddHeldMessage(message models.HeldMessage) error {
+	query := `
+		INSERT INTO held_messages (id, sender, recipient, room_id, content, format, ttl_seconds, attachment_ids, filter,
+			reason, status, created_at)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5, COALESCE(NULLIF($6, ''), 'plain'), $7,
+			COALESCE($8::TEXT[], '{}'), $9, NULLIF($10, ''), $11, $12)
+	`
+	_, err := p.db.Exec(query, message.ID, message.Sender, message.Recipient, message.RoomID, message.Content,
+		message.Format, message.TTLSeconds, pq.Array(message.AttachmentIDs), message.Filter, message.Reason,
+		message.Status, message.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to add held message: %w", err)
+	}
+	return nil
+}
+
+// GetHeldMessage retrieves a held message by ID
+func (p *PostgresDB) GetHeldMessage(id string) (*models.HeldMessage, error) {
+	query := `SELECT ` + heldMessageColumns + ` FROM held_messages WHERE id = $1`
+	message, err := scanHeldMessage(p.db.QueryRow(query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get held message: %w", err)
+	}
+	return &message, nil
+}
+
+// GetHeldMessages returns the pending held messages, oldest first, of a room or of all rooms when roomID is empty
+func (p *PostgresDB) GetHeldMessages(roomID string) ([]models.HeldMessage, error) {
+	query := `
+		SELECT ` + heldMessageColumns + `
+		FROM held_messages
+		WHERE status = $1 AND ($2 = '' OR room_id = $2)
+		ORDER BY created_at ASC
+	`
+	rows, err := p.db.Query(query, models.HeldMessagePending, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get held messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []models.HeldMessage
+	for rows.Next() {
+		message, err := scanHeldMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan held message: %w", err)
+		}
+		messages = append(messages, message)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating held messages: %w", err)
+	}
+
+	return messages, nil
+}
+
+// ReviewHeldMessage moves a pending held message to the approved or rejected status
+func (p *PostgresDB) ReviewHeldMessage(id, status, reviewerID string, at time.Time) error {
+	query := `
+		UPDATE held_messages SET status = $1, reviewed_by = $2, reviewed_at = $3
+		WHERE id = $4 AND status = $5
+	`
+	result, err := p.db.Exec(query, status, reviewerID, at, id, models.HeldMessagePending)
+	if err != nil {
+		return fmt.Errorf("failed to review held message: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("held message not found")
+	}
+
+	return nil
+}
+
+// ReportStore implementation
+
+// reportColumns lists the reports table columns in the order expected by scanReport
+const reportColumns = `id, reporter_id, COALESCE(message_id, ''), COALESCE(message_content, ''), reported_user_id,
+	COALESCE(room_id, ''), reason, status, created_at, COALESCE(action, ''), COALESCE(note, ''),
+	COALESCE(resolved_by, ''), resolved_at`
+
+// scanReport scans a reports row selected with reportColumns
+func scanReport(row rowScanner) (models.Report, error) {
+	var report models.Report
+	var resolvedAt sql.NullTime
+	err := row.Scan(&report.ID, &report.ReporterID, &report.MessageID, &report.MessageContent, &report.ReportedUserID,
+		&report.RoomID, &report.Reason, &report.Status, &report.CreatedAt, &report.Action, &report.Note,
+		&report.ResolvedBy, &resolvedAt)
+	if resolvedAt.Valid {
+		report.ResolvedAt = &resolvedAt.Time
+	}
+	return report, err
+}
+
+// AddReport adds a report to the moderator queue
+func (p *PostgresDB) AddReport(report models.Report) error {
+	query := `
+		INSERT INTO reports (id, reporter_id, message_id, message_content, reported_user_id, room_id, reason, status, created_at)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5, NULLIF($6, ''), $7, $8, $9)
+	`
+	_, err := p.db.Exec(query, report.ID, report.ReporterID, report.MessageID, report.MessageContent,
+		report.ReportedUserID, report.RoomID, report.Reason, report.Status, report.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to add report: %w", err)
+	}
+	return nil
+}
+
+// GetReport retrieves a report by ID
+func (p *PostgresDB) GetReport(id string) (*models.Report, error) {
+	query := `SELECT ` + reportColumns + ` FROM reports WHERE id = $1`
+	report, err := scanReport(p.db.QueryRow(query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get report: %w", err)
+	}
+	return &report, nil
+}
+
+// GetReports returns a page of reports matching the filter, oldest first, and the total number of matches
+func (p *PostgresDB) GetReports(filter models.ReportFilter) ([]models.Report, int, error) {
+	var conditions []string
+	var args []interface{}
+	if filter.Status != "" {
+		args = append(args, filter.Status)
+		conditions = append(conditions, fmt.Sprintf("status = $%d", len(args)))
+	}
+	if filter.RoomID != "" {
+		args = append(args, filter.RoomID)
+		conditions = append(conditions, fmt.Sprintf("room_id = $%d", len(args)))
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := p.db.QueryRow(`SELECT COUNT(*) FROM reports`+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count reports: %w", err)
+	}
+
+	query := `SELECT ` + reportColumns + ` FROM reports` + where + ` ORDER BY created_at ASC, id ASC`
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	if filter.Offset > 0 {
+		args = append(args, filter.Offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+
+	rows, err := p.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get reports: %w", err)
+	}
+	defer rows.Close()
+
+	var reports []models.Report
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan report: %w", err)
+		}
+		reports = append(reports, report)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating reports: %w", err)
+	}
+
+	return reports, total, nil
+}
+
+// ResolveReport moves an open report to the actioned or dismissed status
+func (p *PostgresDB) ResolveReport(id, status, action, note, resolverID string, at time.Time) error {
+	query := `
+		UPDATE reports SET status = $1, action = NULLIF($2, ''), note = NULLIF($3, ''), resolved_by = $4, resolved_at = $5
+		WHERE id = $6 AND status = $7
+	`
+	result, err := p.db.Exec(query, status, action, note, resolverID, at, id, models.ReportOpen)
+	if err != nil {
+		return fmt.Errorf("failed to resolve report: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("report not found")
+	}
+
+	return nil
+}
+
+// AttachmentStore implementation
+
+// attachmentColumns lists the attachments table columns in the order expected by scanAttachment
+const attachmentColumns = `id, uploader_id, COALESCE(message_id, ''), filename, content_type, size, width, height,
+	has_thumbnail, created_at`
+
+// scanAttachment scans an attachments row selected with attachmentColumns
+func scanAttachment(row rowScanner) (models.Attachment, error) {
+	var attachment models.Attachment
+	err := row.Scan(&attachment.ID, &attachment.UploaderID, &attachment.MessageID, &attachment.Filename,
+		&attachment.ContentType, &attachment.Size, &attachment.Width, &attachment.Height,
+		&attachment.HasThumbnail, &attachment.CreatedAt)
+	return attachment, err
+}
+
+// AddAttachment records an uploaded attachment
+func (p *PostgresDB) AddAttachment(attachment models.Attachment) error {
+	query := `
+		INSERT INTO attachments (id, uploader_id, message_id, filename, content_type, size, width, height, has_thumbnail, created_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7, $8, $9, $10)
+	`
+	_, err := p.db.Exec(query, attachment.ID, attachment.UploaderID, attachment.MessageID, attachment.Filename,
+		attachment.ContentType, attachment.Size, attachment.Width, attachment.Height, attachment.HasThumbnail,
+		attachment.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to add attachment: %w", err)
+	}
+	return nil
+}
+
+// GetAttachment retrieves an attachment by ID
+func (p *PostgresDB) GetAttachment(id string) (*models.Attachment, error) {
+	query := `SELECT ` + attachmentColumns + ` FROM attachments WHERE id = $1`
+	attachment, err := scanAttachment(p.db.QueryRow(query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get attachment: %w", err)
+	}
+	return &attachment, nil
+}
+
+// AttachToMessage links the uploader's unsent attachments to a message, all or none
+func (p *PostgresDB) AttachToMessage(messageID, uploaderID string, ids []string) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE attachments SET message_id = $1
+		WHERE id = ANY($2) AND uploader_id = $3 AND message_id IS NULL
+	`
+	result, err := tx.Exec(query, messageID, pq.Array(ids), uploaderID)
+	if err != nil {
+		return fmt.Errorf("failed to attach attachments: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected != int64(len(ids)) {
+		return fmt.Errorf("attachment not found")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// GetMessageAttachments returns the attachments of the given messages, oldest first, keyed by message ID
+func (p *PostgresDB) GetMessageAttachments(messageIDs []string) (map[string][]models.Attachment, error) {
+	attachments := make(map[string][]models.Attachment)
+	if len(messageIDs) == 0 {
+		return attachments, nil
+	}
+
+	query := `SELECT ` + attachmentColumns + ` FROM attachments WHERE message_id = ANY($1) ORDER BY created_at ASC, id ASC`
+	rows, err := p.db.Query(query, pq.Array(messageIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attachments: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan attachment: %w", err)
+		}
+		attachments[attachment.MessageID] = append(attachments[attachment.MessageID], attachment)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating attachments: %w", err)
+	}
+
+	return attachments, nil
+}
+
+// PreviewStore implementation
+
+// GetLinkPreview retrieves the cached preview of a URL
+func (p *PostgresDB) GetLinkPreview(url string) (*models.LinkPreview, error) {
+	query := `
+		SELECT url, COALESCE(title, ''), COALESCE(description, ''), COALESCE(site_name, ''), COALESCE(image_url, ''), fetched_at
+		FROM link_previews WHERE url = $1
+	`
+	var preview models.LinkPreview
+	err := p.db.QueryRow(query, url).Scan(&preview.URL, &preview.Title, &preview.Description,
+		&preview.SiteName, &preview.ImageURL, &preview.FetchedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get link preview: %w", err)
+	}
+	return &preview, nil
+}
+
+// SaveLinkPreview inserts or refreshes the cached preview of a URL
+func (p *PostgresDB) SaveLinkPreview(preview models.LinkPreview) error {
+	query := `
+		INSERT INTO link_previews (url, title, description, site_name, image_url, fetched_at)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''), $6)
+		ON CONFLICT (url) DO UPDATE SET
+			title = EXCLUDED.title,
+			description = EXCLUDED.description,
+			site_name = EXCLUDED.site_name,
+			image_url = EXCLUDED.image_url,
+			fetched_at = EXCLUDED.fetched_at
+	`
+	_, err := p.db.Exec(query, preview.URL, preview.Title, preview.Description, preview.SiteName,
+		preview.ImageURL, preview.FetchedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save link preview: %w", err)
+	}
+	return nil
+}
+
+// AddMessagePreview links a cached preview to a message
+func (p *PostgresDB) AddMessagePreview(messageID, url string, position int) error {
+	query := `
+		INSERT INTO message_previews (message_id, url, position)
+		SELECT id, $2, $3 FROM messages WHERE id = $1
+		ON CONFLICT (message_id, url) DO NOTHING
+	`
+	result, err := p.db.Exec(query, messageID, url, position)
+	if err != nil {
+		return fmt.Errorf("failed to add message preview: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		var exists bool
+		if err := p.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM messages WHERE id = $1)`, messageID).Scan(&exists); err != nil {
+			return fmt.Errorf("failed to check message: %w", err)
+		}
+		if !exists {
+			return fmt.Errorf("message not found")
+		}
+	}
+
+	return nil
+}
+
+// GetMessagePreviews returns the previews of the given messages in position order, keyed by message ID
+func (p *PostgresDB) GetMessagePreviews(messageIDs []string) (map[string][]models.LinkPreview, error) {
+	previews := make(map[string][]models.LinkPreview)
+	if len(messageIDs) == 0 {
+		return previews, nil
+	}
+
+	query := `
+		SELECT mp.message_id, lp.url, COALESCE(lp.title, ''), COALESCE(lp.description, ''), COALESCE(lp.site_name, ''),
+			COALESCE(lp.image_url, ''), lp.fetched_at
+		FROM message_previews mp
+		JOIN link_previews lp ON lp.url = mp.url
+		WHERE mp.message_id = ANY($1)
+		ORDER BY mp.message_id, mp.position ASC
+	`
+	rows, err := p.db.Query(query, pq.Array(messageIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message previews: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID string
+		var preview models.LinkPreview
+		if err := rows.Scan(&messageID, &preview.URL, &preview.Title, &preview.Description, &preview.SiteName,
+			&preview.ImageURL, &preview.FetchedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan message preview: %w", err)
+		}
+		previews[messageID] = append(previews[messageID], preview)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating message previews: %w", err)
+	}
+
+	return previews, nil
+}
+
+// MentionStore implementation
+
+// AddMentions records mentions in a single transaction, ignoring users already mentioned by the message
+func (p *PostgresDB) AddMentions(mentions []models.Mention) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO mentions (id, user_id, message_id, kind, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (message_id, user_id) DO NOTHING
+	`
+	for _, mention := range mentions {
+		if _, err := tx.Exec(query, mention.ID, mention.UserID, mention.MessageID, mention.Kind, mention.CreatedAt); err != nil {
+			return fmt.Errorf("failed to add mention: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit mentions: %w", err)
+	}
+	return nil
+}
+
+// GetUnreadMentions returns a user's unread mentions with their messages, newest first
+func (p *PostgresDB) GetUnreadMentions(userID string, limit int) ([]models.Mention, error) {
+	query := `
+		SELECT mn.id, mn.user_id, mn.message_id, mn.kind, mn.created_at,
+			m.id, m.sender, COALESCE(m.recipient, ''), m.content, m.timestamp, COALESCE(m.room_id, ''),
+			m.format, COALESCE(m.content_html, ''), m.expires_at
+		FROM mentions mn
+		JOIN messages m ON m.id = mn.message_id
+		WHERE mn.user_id = $1 AND mn.read_at IS NULL
+		ORDER BY mn.created_at DESC
+		LIMIT $2
+	`
+	rows, err := p.db.Query(query, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get unread mentions: %w", err)
+	}
+	defer rows.Close()
+
+	var mentions []models.Mention
+	for rows.Next() {
+		var mention models.Mention
+		var message models.Message
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&mention.ID, &mention.UserID, &mention.MessageID, &mention.Kind, &mention.CreatedAt,
+			&message.ID, &message.Sender, &message.Recipient, &message.Content, &message.Timestamp, &message.RoomID,
+			&message.Format, &message.HTML, &expiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan mention: %w", err)
+		}
+		if expiresAt.Valid {
+			message.ExpiresAt = &expiresAt.Time
+		}
+		mention.Message = &message
+		mentions = append(mentions, mention)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating mentions: %w", err)
+	}
+
+	return mentions, nil
+}
+
+// MarkMentionsRead marks the given unread mentions of a user as read, or all of them when ids is empty
+func (p *PostgresDB) MarkMentionsRead(userID string, ids []string, readAt time.Time) (int, error) {
+	query := `
+		UPDATE mentions SET read_at = $3
+		WHERE user_id = $1 AND read_at IS NULL AND (cardinality($2::text[]) = 0 OR id = ANY($2))
+	`
+	result, err := p.db.Exec(query, userID, pq.Array(ids), readAt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark mentions read: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return int(rowsAffected), nil
+}
+
+// PinStore implementation
+
+// AddPin pins a message to its room unless the room already has max pins. The room row
+// is locked so concurrent pins cannot exceed the limit.
+func (p *PostgresDB) AddPin(pin models.PinnedMessage, max int) (bool, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var roomID string
+	if err := tx.QueryRow(`SELECT id FROM chat_rooms WHERE id = $1 FOR UPDATE`, pin.RoomID).Scan(&roomID); err != nil {
+		if err == sql.ErrNoRows {
+			return false, fmt.Errorf("room not found")
+		}
+		return false, fmt.Errorf("failed to lock room: %w", err)
+	}
+
+	var pinned bool
+	var count int
+	err = tx.QueryRow(`
+		SELECT COALESCE(bool_or(message_id = $2), FALSE), COUNT(*)
+		FROM pinned_messages WHERE room_id = $1
+	`, pin.RoomID, pin.MessageID).Scan(&pinned, &count)
+	if err != nil {
+		return false, fmt.Errorf("failed to count pins: %w", err)
+	}
+	if pinned {
+		return true, nil
+	}
+	if count >= max {
+		return false, nil
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO pinned_messages (room_id, message_id, pinned_by, pinned_at)
+		VALUES ($1, $2, $3, $4)
+	`, pin.RoomID, pin.MessageID, pin.PinnedBy, pin.PinnedAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to add pin: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit pin: %w", err)
+	}
+	return true, nil
+}
+
+// RemovePin unpins a message from a room
+func (p *PostgresDB) RemovePin(roomID, messageID string) error {
+	result, err := p.db.Exec(`DELETE FROM pinned_messages WHERE room_id = $1 AND message_id = $2`, roomID, messageID)
+	if err != nil {
+		return fmt.Errorf("failed to remove pin: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("pin not found")
+	}
+	return nil
+}
+
+// GetPins returns the pins of a room with their messages, most recently pinned first
+func (p *PostgresDB) GetPins(roomID string) ([]models.PinnedMessage, error) {
+	query := `
+		SELECT pm.room_id, pm.message_id, COALESCE(pm.pinned_by, ''), pm.pinned_at,
+			m.id, m.sender, COALESCE(m.recipient, ''), m.content, m.timestamp, COALESCE(m.room_id, ''),
+			m.format, COALESCE(m.content_html, ''), m.expires_at
+		FROM pinned_messages pm
+		JOIN messages m ON m.id = pm.message_id
+		WHERE pm.room_id = $1
+		ORDER BY pm.pinned_at DESC
+	`
+	rows, err := p.db.Query(query, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pins: %w", err)
+	}
+	defer rows.Close()
+
+	var pins []models.PinnedMessage
+	for rows.Next() {
+		var pin models.PinnedMessage
+		var message models.Message
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&pin.RoomID, &pin.MessageID, &pin.PinnedBy, &pin.PinnedAt,
+			&message.ID, &message.Sender, &message.Recipient, &message.Content, &message.Timestamp, &message.RoomID,
+			&message.Format, &message.HTML, &expiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan pin: %w", err)
+		}
+		if expiresAt.Valid {
+			message.ExpiresAt = &expiresAt.Time
+		}
+		pin.Message = &message
+		pins = append(pins, pin)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating pins: %w", err)
+	}
+
+	return pins, nil
+}
+
+// SavedMessageStore implementation
+
+// SaveMessage bookmarks a message for a user, replacing the note of an existing bookmark,
+// and returns the stored bookmark
+func (p *PostgresDB) SaveMessage(saved models.SavedMessage) (*models.SavedMessage, error) {
+	query := `
+		INSERT INTO saved_messages (user_id, message_id, note, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, message_id) DO UPDATE SET note = EXCLUDED.note, updated_at = EXCLUDED.updated_at
+		RETURNING created_at
+	`
+	err := p.db.QueryRow(query, saved.UserID, saved.MessageID, saved.Note, saved.CreatedAt, saved.UpdatedAt).Scan(&saved.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save message: %w", err)
+	}
+	return &saved, nil
+}
+
+// DeleteSavedMessage removes a user's bookmark of a message
+func (p *PostgresDB) DeleteSavedMessage(userID, messageID string) error {
+	result, err := p.db.Exec(`DELETE FROM saved_messages WHERE user_id = $1 AND message_id = $2`, userID, messageID)
+	if err != nil {
+		return fmt.Errorf("failed to delete saved message: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("saved message not found")
+	}
+	return nil
+}
+
+// GetSavedMessages returns a user's bookmarks with their messages, most recently saved first
+func (p *PostgresDB) GetSavedMessages(userID string) ([]models.SavedMessage, error) {
+	query := `
+		SELECT sm.user_id, sm.message_id, sm.note, sm.created_at, sm.updated_at,
+			m.id, m.sender, COALESCE(m.recipient, ''), m.content, m.timestamp, COALESCE(m.room_id, ''),
+			m.format, COALESCE(m.content_html, ''), m.expires_at
+		FROM saved_messages sm
+		JOIN messages m ON m.id = sm.message_id
+		WHERE sm.user_id = $1
+		ORDER BY sm.created_at DESC
+	`
+	rows, err := p.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get saved messages: %w", err)
+	}
+	defer rows.Close()
+
+	var list []models.SavedMessage
+	for rows.Next() {
+		var saved models.SavedMessage
+		var message models.Message
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&saved.UserID, &saved.MessageID, &saved.Note, &saved.CreatedAt, &saved.UpdatedAt,
+			&message.ID, &message.Sender, &message.Recipient, &message.Content, &message.Timestamp, &message.RoomID,
+			&message.Format, &message.HTML, &expiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan saved message: %w", err)
+		}
+		if expiresAt.Valid {
+			message.ExpiresAt = &expiresAt.Time
+		}
+		saved.Message = &message
+		list = append(list, saved)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating saved messages: %w", err)
+	}
+
+	return list, nil
+}
+
+// ScheduleStore implementation
+
+// scheduledMessageColumns lists the scheduled_messages table columns in the order expected by scanScheduledMessage
+const scheduledMessageColumns = `id, user_id, COALESCE(recipient, ''), COALESCE(room_id, ''), content, format, send_at, status,
+	COALESCE(message_id, ''), COALESCE(error, ''), created_at, claimed_at`
+
+// scanScheduledMessage scans a scheduled_messages row selected with scheduledMessageColumns
+func scanScheduledMessage(row rowScanner) (models.ScheduledMessage, error) {
+	var message models.ScheduledMessage
+	var claimedAt sql.NullTime
+	err := row.Scan(&message.ID, &message.UserID, &message.Recipient, &message.RoomID, &message.Content, &message.Format,
+		&message.SendAt, &message.Status, &message.MessageID, &message.Error, &message.CreatedAt, &claimedAt)
+	if claimedAt.Valid {
+		message.ClaimedAt = &claimedAt.Time
+	}
+	return message, err
+}
+
+// AddScheduledMessage stores a message to be sent later
+func (p *PostgresDB) AddScheduledMessage(message models.ScheduledMessage) error {
+	query := `
+		INSERT INTO scheduled_messages (id, user_id, recipient, room_id, content, format, send_at, status, created_at)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5, COALESCE(NULLIF($6, ''), 'plain'), $7, $8, $9)
+	`
+	_, err := p.db.Exec(query, message.ID, message.UserID, message.Recipient, message.RoomID, message.Content,
+		message.Format, message.SendAt, message.Status, message.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to add scheduled message: %w", err)
+	}
+	return nil
+}
+
+// GetScheduledMessages returns a user's pending scheduled messages, soonest first
+func (p *PostgresDB) GetScheduledMessages(userID string) ([]models.ScheduledMessage, error) {
+	query := `
+		SELECT ` + scheduledMessageColumns + `
+		FROM scheduled_messages
+		WHERE user_id = $1 AND status = $2
+		ORDER BY send_at ASC
+	`
+	rows, err := p.db.Query(query, userID, models.SchedulePending)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get scheduled messages: %w", err)
+	}
+	return collectScheduledMessages(rows)
+}
+
+// CancelScheduledMessage cancels a pending scheduled message of a user
+func (p *PostgresDB) CancelScheduledMessage(userID, id string) error {
+	query := `UPDATE scheduled_messages SET status = $1 WHERE id = $2 AND user_id = $3 AND status = $4`
+	result, err := p.db.Exec(query, models.ScheduleCancelled, id, userID, models.SchedulePending)
+	if err != nil {
+		return fmt.Errorf("failed to cancel scheduled message: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("scheduled message not found")
+	}
+	return nil
+}
+
+// ClaimDueScheduledMessages marks up to limit due messages as sending and returns them.
+// Rows locked by another instance are skipped, so each message is claimed only once.
+func (p *PostgresDB) ClaimDueScheduledMessages(now, staleBefore time.Time, limit int) ([]models.ScheduledMessage, error) {
+	query := `
+		WITH claimed AS (
+			UPDATE scheduled_messages SET status = $1, claimed_at = $2
+			WHERE id IN (
+				SELECT id FROM scheduled_messages
+				WHERE send_at <= $2 AND (status = $3 OR (status = $1 AND claimed_at < $4))
+				ORDER BY send_at ASC
+				LIMIT $5
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING *
+		)
+		SELECT ` + scheduledMessageColumns + ` FROM claimed ORDER BY send_at ASC
+	`
+	rows, err := p.db.Query(query, models.ScheduleSending, now, models.SchedulePending, staleBefore, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim scheduled messages: %w", err)
+	}
+	return collectScheduledMessages(rows)
+}
+
+// collectScheduledMessages scans and closes rows selected with scheduledMessageColumns
+func collectScheduledMessages(rows *sql.Rows) ([]models.ScheduledMessage, error) {
+	defer rows.Close()
+
+	var messages []models.ScheduledMessage
+	for rows.Next() {
+		message, err := scanScheduledMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan scheduled message: %w", err)
+		}
+		messages = append(messages, message)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating scheduled messages: %w", err)
+	}
+
+	return messages, nil
+}
+
+// CompleteScheduledMessage records the outcome of a claimed message
+func (p *PostgresDB) CompleteScheduledMessage(id, status, messageID, failure string) error {
+	query := `UPDATE scheduled_messages SET status = $1, message_id = NULLIF($2, ''), error = NULLIF($3, '') WHERE id = $4`
+	result, err := p.db.Exec(query, status, messageID, failure, id)
+	if err != nil {
+		return fmt.Errorf("failed to complete scheduled message: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("scheduled message not found")
+	}
+	return nil
+}
+
+// reminderColumns lists the reminders table columns in the order expected by scanReminder
+const reminderColumns = `id, user_id, message_id, COALESCE(note, ''), remind_at, status, created_at, claimed_at`
+
+// scanReminder scans a reminders row selected with reminderColumns
+func scanReminder(row rowScanner) (models.Reminder, error) {
+	var reminder models.Reminder
+	var claimedAt sql.NullTime
+	err := row.Scan(&reminder.ID, &reminder.UserID, &reminder.MessageID, &reminder.Note, &reminder.RemindAt,
+		&reminder.Status, &reminder.CreatedAt, &claimedAt)
+	if claimedAt.Valid {
+		reminder.ClaimedAt = &claimedAt.Time
+	}
+	return reminder, err
+}
+
+// AddReminder stores a reminder of a message
+func (p *PostgresDB) AddReminder(reminder models.Reminder) error {
+	query := `
+		INSERT INTO reminders (id, user_id, message_id, note, remind_at, status, created_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7)
+	`
+	_, err := p.db.Exec(query, reminder.ID, reminder.UserID, reminder.MessageID, reminder.Note, reminder.RemindAt,
+		reminder.Status, reminder.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to add reminder: %w", err)
+	}
+	return nil
+}
+
+// GetReminders returns a user's pending reminders with their messages, soonest first
+func (p *PostgresDB) GetReminders(userID string) ([]models.Reminder, error) {
+	query := `
+		SELECT r.id, r.user_id, r.message_id, COALESCE(r.note, ''), r.remind_at, r.status, r.created_at,
+			m.id, m.sender, COALESCE(m.recipient, ''), m.content, m.timestamp, COALESCE(m.room_id, ''),
+			m.format, COALESCE(m.content_html, ''), m.expires_at
+		FROM reminders r
+		JOIN messages m ON m.id = r.message_id
+		WHERE r.user_id = $1 AND r.status = $2
+		ORDER BY r.remind_at ASC
+	`
+	rows, err := p.db.Query(query, userID, models.SchedulePending)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reminders: %w", err)
+	}
+	defer rows.Close()
+
+	var reminders []models.Reminder
+	for rows.Next() {
+		var reminder models.Reminder
+		var message models.Message
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&reminder.ID, &reminder.UserID, &reminder.MessageID, &reminder.Note, &reminder.RemindAt,
+			&reminder.Status, &reminder.CreatedAt,
+			&message.ID, &message.Sender, &message.Recipient, &message.Content, &message.Timestamp, &message.RoomID,
+			&message.Format, &message.HTML, &expiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan reminder: %w", err)
+		}
+		if expiresAt.Valid {
+			message.ExpiresAt = &expiresAt.Time
+		}
+		reminder.Message = &message
+		reminders = append(reminders, reminder)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating reminders: %w", err)
+	}
+
+	return reminders, nil
+}
+
+// CancelReminder cancels a pending reminder of a user
+func (p *PostgresDB) CancelReminder(userID, id string) error {
+	query := `UPDATE reminders SET status = $1 WHERE id = $2 AND user_id = $3 AND status = $4`
+	result, err := p.db.Exec(query, models.ScheduleCancelled, id, userID, models.SchedulePending)
+	if err != nil {
+		return fmt.Errorf("failed to cancel reminder: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("reminder not found")
+	}
+	return nil
+}
+
+// ClaimDueReminders marks up to limit due reminders as sending and returns them, skipping
+// rows locked by another instance
+func (p *PostgresDB) ClaimDueReminders(now, staleBefore time.Time, limit int) ([]models.Reminder, error) {
+	query := `
+		WITH claimed AS (
+			UPDATE reminders SET status = $1, claimed_at = $2
+			WHERE id IN (
+				SELECT id FROM reminders
+				WHERE remind_at <= $2 AND (status = $3 OR (status = $1 AND claimed_at < $4))
+				ORDER BY remind_at ASC
+				LIMIT $5
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING *
+		)
+		SELECT ` + reminderColumns + ` FROM claimed ORDER BY remind_at ASC
+	`
+	rows, err := p.db.Query(query, models.ScheduleSending, now, models.SchedulePending, staleBefore, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim reminders: %w", err)
+	}
+	defer rows.Close()
+
+	var reminders []models.Reminder
+	for rows.Next() {
+		reminder, err := scanReminder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan reminder: %w", err)
+		}
+		reminders = append(reminders, reminder)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating reminders: %w", err)
+	}
+
+	return reminders, nil
+}
+
+// CompleteReminder records the outcome of a claimed reminder
+func (p *PostgresDB) CompleteReminder(id, status string) error {
+	result, err := p.db.Exec(`UPDATE reminders SET status = $1 WHERE id = $2`, status, id)
+	if err != nil {
+		return fmt.Errorf("failed to complete reminder: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("reminder not found")
+	}
+	return nil
+}
+
+// PollStore implementation
+
+// pollColumns lists the polls table columns in the order expected by scanPoll
+const pollColumns = `id, message_id, room_id, COALESCE(created_by, ''), question, options, multiple_choice, anonymous, closes_at, closed_at, created_at`
+
+// scanPoll scans a polls row selected with pollColumns, without its tally
+func scanPoll(row rowScanner) (models.Poll, error) {
+	var poll models.Poll
+	var options []string
+	var closesAt, closedAt sql.NullTime
+	err := row.Scan(&poll.ID, &poll.MessageID, &poll.RoomID, &poll.CreatedBy, &poll.Question, pq.Array(&options),
+		&poll.MultipleChoice, &poll.Anonymous, &closesAt, &closedAt, &poll.CreatedAt)
+	poll.Options = make([]models.PollOption, len(options))
+	for i, text := range options {
+		poll.Options[i] = models.PollOption{Text: text}
+	}
+	if closesAt.Valid {
+		poll.ClosesAt = &closesAt.Time
+	}
+	if closedAt.Valid {
+		poll.ClosedAt = &closedAt.Time
+	}
+	return poll, err
+}
+
+// AddPoll stores a poll posted in a room
+func (p *PostgresDB) AddPoll(poll models.Poll) error {
+	options := make([]string, len(poll.Options))
+	for i, option := range poll.Options {
+		options[i] = option.Text
+	}
+
+	query := `
+		INSERT INTO polls (id, message_id, room_id, created_by, question, options, multiple_choice, anonymous, closes_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`
+	_, err := p.db.Exec(query, poll.ID, poll.MessageID, poll.RoomID, poll.CreatedBy, poll.Question, pq.Array(options),
+		poll.MultipleChoice, poll.Anonymous, poll.ClosesAt, poll.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to add poll: %w", err)
+	}
+	return nil
+}
+
+// GetPoll retrieves a poll with its tally
+func (p *PostgresDB) GetPoll(id string) (*models.Poll, error) {
+	poll, err := scanPoll(p.db.QueryRow(`SELECT `+pollColumns+` FROM polls WHERE id = $1`, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get poll: %w", err)
+	}
+
+	polls := map[string]*models.Poll{poll.ID: &poll}
+	if err := p.tallyPolls(polls); err != nil {
+		return nil, err
+	}
+	return &poll, nil
+}
+
+// GetMessagePolls returns the polls of the given messages with their tallies, keyed by message ID
+func (p *PostgresDB) GetMessagePolls(messageIDs []string) (map[string]models.Poll, error) {
+	polls := make(map[string]models.Poll)
+	if len(messageIDs) == 0 {
+		return polls, nil
+	}
+
+	rows, err := p.db.Query(`SELECT `+pollColumns+` FROM polls WHERE message_id = ANY($1)`, pq.Array(messageIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get polls: %w", err)
+	}
+	defer rows.Close()
+
+	byID := make(map[string]*models.Poll)
+	for rows.Next() {
+		poll, err := scanPoll(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan poll: %w", err)
+		}
+		byID[poll.ID] = &poll
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating polls: %w", err)
+	}
+
+	if err := p.tallyPolls(byID); err != nil {
+		return nil, err
+	}
+	for _, poll := range byID {
+		polls[poll.MessageID] = *poll
+	}
+	return polls, nil
+}
+
+// tallyPolls counts the votes of the given polls, keyed by poll ID, and lists their voters by username
+func (p *PostgresDB) tallyPolls(polls map[string]*models.Poll) error {
+	if len(polls) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(polls))
+	for id := range polls {
+		ids = append(ids, id)
+	}
+
+	query := `
+		SELECT v.poll_id, v.user_id, v.option_index, u.username
+		FROM poll_votes v
+		JOIN users u ON u.id = v.user_id
+		WHERE v.poll_id = ANY($1)
+		ORDER BY u.username ASC
+	`
+	rows, err := p.db.Query(query, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("failed to get poll votes: %w", err)
+	}
+	defer rows.Close()
+
+	voters := make(map[string]bool)
+	for rows.Next() {
+		var pollID, userID, username string
+		var index int
+		if err := rows.Scan(&pollID, &userID, &index, &username); err != nil {
+			return fmt.Errorf("failed to scan poll vote: %w", err)
+		}
+
+		poll := polls[pollID]
+		if !voters[pollID+":"+userID] {
+			voters[pollID+":"+userID] = true
+			poll.Voters++
+		}
+		if index >= 0 && index < len(poll.Options) {
+			poll.Options[index].Votes++
+			poll.Options[index].Voters = append(poll.Options[index].Voters, username)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating poll votes: %w", err)
+	}
+
+	return nil
+}
+
+// GetPollVote returns the options a user chose in a poll, or nil if they did not vote
+func (p *PostgresDB) GetPollVote(pollID, userID string) (*models.PollVote, error) {
+	query := `SELECT option_index, created_at FROM poll_votes WHERE poll_id = $1 AND user_id = $2 ORDER BY option_index ASC`
+	rows, err := p.db.Query(query, pollID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get poll vote: %w", err)
+	}
+	defer rows.Close()
+
+	var vote *models.PollVote
+	for rows.Next() {
+		var index int
+		var votedAt time.Time
+		if err := rows.Scan(&index, &votedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan poll vote: %w", err)
+		}
+		if vote == nil {
+			vote = &models.PollVote{PollID: pollID, UserID: userID, VotedAt: votedAt}
+		}
+		vote.Options = append(vote.Options, index)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating poll votes: %w", err)
+	}
+
+	return vote, nil
+}
+
+// SetPollVote replaces the vote of a user in a poll that is still open; a vote without
+// options is removed
+func (p *PostgresDB) SetPollVote(vote models.PollVote) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	poll, err := scanPoll(tx.QueryRow(`SELECT `+pollColumns+` FROM polls WHERE id = $1 FOR UPDATE`, vote.PollID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("poll not found")
+		}
+		return fmt.Errorf("failed to lock poll: %w", err)
+	}
+	if poll.IsClosed(vote.VotedAt) {
+		return fmt.Errorf("poll closed")
+	}
+
+	if _, err := tx.Exec(`DELETE FROM poll_votes WHERE poll_id = $1 AND user_id = $2`, vote.PollID, vote.UserID); err != nil {
+		return fmt.Errorf("failed to remove poll vote: %w", err)
+	}
+	for _, index := range vote.Options {
+		_, err := tx.Exec(`
+			INSERT INTO poll_votes (poll_id, user_id, option_index, created_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT DO NOTHING
+		`, vote.PollID, vote.UserID, index, vote.VotedAt)
+		if err != nil {
+			return fmt.Errorf("failed to add poll vote: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit poll vote: %w", err)
+	}
+	return nil
+}
+
+// ClosePoll stops a poll from accepting votes
+func (p *PostgresDB) ClosePoll(id string, at time.Time) error {
+	query := `
+		UPDATE polls SET closed_at = $1
+		WHERE id = $2 AND closed_at IS NULL AND (closes_at IS NULL OR closes_at > $1)
+	`
+	result, err := p.db.Exec(query, at, id)
+	if err != nil {
+		return fmt.Errorf("failed to close poll: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		var exists bool
+		if err := p.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM polls WHERE id = $1)`, id).Scan(&exists); err != nil {
+			return fmt.Errorf("failed to check poll: %w", err)
+		}
+		if !exists {
+			return fmt.Errorf("poll not found")
+		}
+		return fmt.Errorf("poll closed")
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package unfurl
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxTitleLength and maxDescriptionLength limit the stored preview text, in runes
+	maxTitleLength       = 300
+	maxDescriptionLength = 1000
+)
+
+var (
+	urlPattern       = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"]+`)
+	metaTagPattern   = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	attributePattern = regexp.MustCompile(`(?is)([a-z_:-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	titlePattern     = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	whitespace       = regexp.MustCompile(`\s+`)
+)
+
+// ExtractURLs returns the distinct http and https links in a message, in order, up to max
+func ExtractURLs(content string, max int) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	for _, match := range urlPattern.FindAllString(content, -1) {
+		if len(urls) >= max {
+			break
+		}
+		link := trimURL(match)
+		parsed, err := url.Parse(link)
+		if err != nil || checkURL(parsed) != nil || seen[link] {
+			continue
+		}
+		seen[link] = true
+		urls = append(urls, link)
+	}
+	return urls
+}
+
+// parseMetadata extracts OpenGraph and Twitter card metadata from a page, falling back to
+// the title element and the description meta tag. Relative image URLs are resolved
+// against base.
+func parseMetadata(page string, base *url.URL) Metadata {
+	meta := make(map[string]string)
+	for _, tag := range metaTagPattern.FindAllString(page, -1) {
+		attributes := make(map[string]string)
+		for _, match := range attributePattern.FindAllStringSubmatch(tag, -1) {
+			attributes[strings.ToLower(match[1])] = match[2] + match[3] + match[4]
+		}
+
+		key := attributes["property"]
+		if key == "" {
+			key = attributes["name"]
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		// The first occurrence wins, as with og:image arrays
+		if _, exists := meta[key]; key != "" && !exists {
+			meta[key] = attributes["content"]
+		}
+	}
+
+	first := func(keys ...string) string {
+		for _, key := range keys {
+			if value := clean(meta[key]); value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+
+	metadata := Metadata{
+		Title:       first("og:title", "twitter:title"),
+		Description: first("og:description", "twitter:description", "description"),
+		SiteName:    first("og:site_name", "application-name"),
+	}
+	if metadata.Title == "" {
+		if match := titlePattern.FindStringSubmatch(page); match != nil {
+			metadata.Title = clean(match[1])
+		}
+	}
+	metadata.Title = truncate(metadata.Title, maxTitleLength)
+	metadata.Description = truncate(metadata.Description, maxDescriptionLength)
+	metadata.SiteName = truncate(metadata.SiteName, maxTitleLength)
+
+	if image := first("og:image:secure_url", "og:image", "og:image:url", "twitter:image", "twitter:image:src"); image != "" {
+		if resolved, err := base.Parse(image); err == nil && checkURL(resolved) == nil {
+			metadata.ImageURL = resolved.String()
+		}
+	}
+	return metadata
+}
+
+// clean unescapes HTML entities and collapses whitespace
+func clean(value string) string {
+	return strings.TrimSpace(whitespace.ReplaceAllString(html.UnescapeString(value), " "))
+}
+
+// truncate shortens text to at most max runes, marking the cut with an ellipsis
+func truncate(text string, max int) string {
+	if utf8.RuneCountInString(text) <= max {
+		return text
+	}
+	runes := []rune(text)
+	return strings.TrimSpace(string(runes[:max-1])) + "…"
+}
+
+// trimURL removes punctuation that commonly follows a link in prose
+func trimURL(link string) string {
+	for len(link) > 0 {
+		last := link[len(link)-1]
+		switch {
+		case strings.IndexByte(".,;:!?'\"", last) >= 0:
+			link = link[:len(link)-1]
+		case last == ')' && strings.Count(link, "(") < strings.Count(link, ")"):
+			link = link[:len(link)-1]
+		case last == ']' && strings.Count(link, "[") < strings.Count(link, "]"):
+			link = link[:len(link)-1]
+		default:
+			return link
+		}
+	}
+	return link
+}
@@ -0,0 +1,231 @@
+// Package unfurl fetches web pages linked in messages and extracts their OpenGraph and
+// Twitter card metadata for link previews. Fetches are guarded against server-side
+// request forgery: only public addresses are dialed, and responses are limited in size
+// and time.
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+var (
+	// ErrBlockedAddress is returned when a link resolves to a loopback, private or otherwise
+	// non-public address
+	ErrBlockedAddress = errors.New("destination address is not allowed")
+
+	// ErrUnsupportedURL is returned for links that are not absolute http or https URLs
+	ErrUnsupportedURL = errors.New("unsupported URL")
+
+	// ErrNotHTML is returned when a link does not point to an HTML page
+	ErrNotHTML = errors.New("link is not an HTML page")
+)
+
+// Config limits the fetches made for previews
+type Config struct {
+	// Timeout bounds a whole fetch, including redirects and reading the body
+	Timeout time.Duration
+
+	// MaxBytes is the most of a page that is read; metadata after it is ignored
+	MaxBytes int64
+
+	// MaxRedirects is the number of redirects followed
+	MaxRedirects int
+
+	// UserAgent is sent with every request
+	UserAgent string
+
+	// AllowLoopback permits loopback addresses, for tests and local development only.
+	// Private and other non-public addresses are always blocked.
+	AllowLoopback bool
+}
+
+// Metadata is the preview information extracted from a page
+type Metadata struct {
+	URL         string // the final URL after redirects
+	Title       string
+	Description string
+	SiteName    string
+	ImageURL    string
+}
+
+// Empty reports whether no preview information was found
+func (m *Metadata) Empty() bool {
+	return m.Title == "" && m.Description == "" && m.ImageURL == ""
+}
+
+// Fetcher fetches pages for link previews
+type Fetcher struct {
+	config Config
+	client *http.Client
+}
+
+// NewFetcher creates a fetcher with the given limits, applying defaults of a 5 second
+// timeout, 1 MiB pages and 3 redirects
+func NewFetcher(config Config) *Fetcher {
+	if config.Timeout <= 0 {
+		config.Timeout = 5 * time.Second
+	}
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = 1 << 20
+	}
+	if config.MaxRedirects <= 0 {
+		config.MaxRedirects = 3
+	}
+	if config.UserAgent == "" {
+		config.UserAgent = "go-chat-api-unfurl/1.0"
+	}
+
+	f := &Fetcher{config: config}
+
+	// The address check runs after DNS resolution, on the address actually dialed, so
+	// it also covers redirects and hostnames that resolve to internal addresses
+	dialer := &net.Dialer{
+		Timeout: config.Timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if !f.allowed(net.ParseIP(host)) {
+				return ErrBlockedAddress
+			}
+			return nil
+		},
+	}
+
+	f.client = &http.Client{
+		Timeout: config.Timeout,
+		Transport: &http.Transport{
+			Proxy:                 nil, // a proxy would dial on our behalf and bypass the address check
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   config.Timeout,
+			ResponseHeaderTimeout: config.Timeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > config.MaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", config.MaxRedirects)
+			}
+			return checkURL(req.URL)
+		},
+	}
+	return f
+}
+
+// Fetch retrieves a page and extracts its preview metadata
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (*Metadata, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, ErrUnsupportedURL
+	}
+	if err := checkURL(target); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, f.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", f.config.UserAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		if errors.Is(err, ErrBlockedAddress) {
+			return nil, ErrBlockedAddress
+		}
+		return nil, fmt.Errorf("failed to fetch %s: %w", target.Host, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: unexpected status %d", target.Host, resp.StatusCode)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, ErrNotHTML
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.config.MaxBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", target.Host, err)
+	}
+
+	metadata := parseMetadata(string(body), resp.Request.URL)
+	metadata.URL = resp.Request.URL.String()
+	return &metadata, nil
+}
+
+// allowed reports whether an address may be dialed
+func (f *Fetcher) allowed(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() {
+		return f.config.AllowLoopback
+	}
+	return IsPublicIP(ip)
+}
+
+// checkURL accepts absolute http and https URLs without credentials
+func checkURL(u *url.URL) error {
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return ErrUnsupportedURL
+	}
+	return nil
+}
+
+// nonPublicNetworks are reserved ranges not covered by the net.IP classification methods
+var nonPublicNetworks = mustParseCIDRs(
+	"0.0.0.0/8",       // "this" network
+	"100.64.0.0/10",   // carrier-grade NAT
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // documentation
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // documentation
+	"203.0.113.0/24",  // documentation
+	"240.0.0.0/4",     // reserved, including broadcast
+	"64:ff9b::/96",    // NAT64, which can reach IPv4 private ranges
+	"2001:db8::/32",   // documentation
+	"2002::/16",       // 6to4, which can reach IPv4 private ranges
+)
+
+// IsPublicIP reports whether an address is publicly routable: not loopback, private,
+// link-local, multicast, unspecified or otherwise reserved
+func IsPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
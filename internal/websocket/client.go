@@ -3,10 +3,17 @@ package websocket
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"go-chat-api/internal/auth"
+	"go-chat-api/internal/config"
 	"go-chat-api/internal/models"
+	"go-chat-api/internal/ratelimit"
 	"go-chat-api/internal/services"
 	"log"
+	"math"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -24,21 +31,45 @@ const (
 
 	// Maximum message size allowed from peer
 	maxMessageSize = 512
+
+	// Maximum size of the "auth" and "reauth" frames, whose tokens alone can exceed
+	// maxMessageSize
+	maxAuthFrameSize = 4096
+
+	// Time allowed for a connection opened without credentials to send its "auth" frame
+	authWait = 10 * time.Second
 )
 
+// requiredScopes are the scopes a token or API key needs to open a WebSocket connection
+var requiredScopes = []string{auth.ScopeMessagesRead, auth.ScopeMessagesWrite}
+
 var (
 	newline = []byte{'\n'}
 	space   = []byte{' '}
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		// Allow connections from any origin
-		// In production, you should be more restrictive
-		return true
-	},
+// Settings configures the connections accepted by ServeWS
+type Settings struct {
+	// Origins are the browser origins allowed to open connections
+	Origins config.OriginPolicy
+
+	// FrameLimit throttles the frames a connection may send (disabled when zero)
+	FrameLimit ratelimit.Limit
+
+	// MaxThrottledFrames is how many throttled frames in a row close the connection
+	// (zero never closes it)
+	MaxThrottledFrames int
+}
+
+// newUpgrader creates an upgrader that only accepts connections from the allowed origins.
+// Browsers attach the jwt_token cookie to cross-site upgrade requests, so without this
+// check any website could open a connection as the signed-in user.
+func newUpgrader(origins config.OriginPolicy) *websocket.Upgrader {
+	return &websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     origins.AllowsRequest,
+	}
 }
 
 // Client is a middleman between the websocket connection and the hub
@@ -58,6 +89,34 @@ type Client struct {
 	// Username of the connected user
 	Username string
 
+	// Whether the client has authenticated and been registered with the hub
+	authenticated bool
+
+	// Guards the session and token expiry, which a "reauth" frame can replace, and the
+	// block list, which the hub updates
+	mu sync.Mutex
+
+	// Session of the token the client authenticated with (empty for API keys)
+	session string
+
+	// When the token the client authenticated with expires (zero if it never does)
+	expiresAt time.Time
+
+	// Fires when the token expires so the hub can close the connection
+	expiryTimer *time.Timer
+
+	// Close frame sent when the hub closes the connection
+	closeCode   int
+	closeReason string
+
+	// Usernames whose broadcasts the hub does not deliver to this client
+	blocked map[string]bool
+
+	// Frame throttling state, only touched by the read pump
+	settings  Settings
+	frames    *ratelimit.Bucket
+	throttled int
+
 	// Chat service for handling messages
 	chatService *services.ChatService
 }
@@ -65,25 +124,53 @@ type Client struct {
 // IncomingMessage represents a message received from the client
 type IncomingMessage struct {
 	Type      string `json:"type"`
+	Token     string `json:"token,omitempty"` // JWT or API key carried by an "auth" or "reauth" frame
 	Content   string `json:"content"`
 	Recipient string `json:"recipient,omitempty"`
 	RoomID    string `json:"room_id,omitempty"`
+	Format    string `json:"format,omitempty"` // "plain" (default) or "markdown"
+
+	// AttachmentIDs are attachments uploaded through POST /api/attachments to send with the message
+	AttachmentIDs []string `json:"attachment_ids,omitempty"`
+
+	// TTLSeconds makes the message disappear this long after it is sent
+	TTLSeconds int `json:"ttl_seconds,omitempty"`
+
+	// PollID and Options carry a "vote" frame: the positions of the chosen options, or none
+	// to withdraw the vote
+	PollID  string `json:"poll_id,omitempty"`
+	Options []int  `json:"options,omitempty"`
 }
 
 // readPump pumps messages from the websocket connection to the hub
 func (c *Client) readPump() {
 	defer func() {
+		if !c.authenticated {
+			// The hub never saw this client. Closing the send channel lets the write
+			// pump flush any error frame before it closes the connection.
+			close(c.send)
+			return
+		}
 		c.hub.unregister <- c
 		c.conn.Close()
 	}()
 
-	c.conn.SetReadLimit(maxMessageSize)
-	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	// Frames are read under the larger limit so "reauth" frames fit; other frames are
+	// held to maxMessageSize once their type is known
+	c.conn.SetReadLimit(maxAuthFrameSize)
 	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		if c.authenticated {
+			c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		}
 		return nil
 	})
 
+	if c.authenticated {
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	} else if !c.authenticate() {
+		return
+	}
+
 	for {
 		_, messageBytes, err := c.conn.ReadMessage()
 		if err != nil {
@@ -93,11 +180,21 @@ func (c *Client) readPump() {
 			break
 		}
 
+		if !c.allowFrame() {
+			continue
+		}
+
 		messageBytes = bytes.TrimSpace(bytes.Replace(messageBytes, newline, space, -1))
 
 		// Parse the incoming message
 		var incomingMsg IncomingMessage
-		if err := json.Unmarshal(messageBytes, &incomingMsg); err != nil {
+		err = json.Unmarshal(messageBytes, &incomingMsg)
+		if len(messageBytes) > maxMessageSize && (err != nil || incomingMsg.Type != "reauth") {
+			log.Printf("WebSocket client sent an oversized frame: user %s (%s)", c.Username, c.UserID)
+			c.hub.closeClient(c, websocket.CloseMessageTooBig, "message too large")
+			continue
+		}
+		if err != nil {
 			log.Printf("Error parsing message: %v", err)
 			continue
 		}
@@ -108,12 +205,220 @@ func (c *Client) readPump() {
 			c.handleMessage(incomingMsg)
 		case "ping":
 			c.handlePing()
+		case "reauth":
+			c.handleReauth(incomingMsg)
+		case "vote":
+			c.handleVote(incomingMsg)
 		default:
 			log.Printf("Unknown message type: %s", incomingMsg.Type)
 		}
 	}
 }
 
+// authenticate reads the "auth" frame that a connection opened without credentials must
+// send first, and registers the client with the hub once its token is accepted
+func (c *Client) authenticate() bool {
+	c.conn.SetReadDeadline(time.Now().Add(authWait))
+
+	_, messageBytes, err := c.conn.ReadMessage()
+	if err != nil {
+		return false
+	}
+
+	var msg IncomingMessage
+	if err := json.Unmarshal(bytes.TrimSpace(messageBytes), &msg); err != nil || msg.Type != "auth" || msg.Token == "" {
+		c.sendError(`Authentication required: the first frame must be {"type":"auth","token":"..."}`)
+		return false
+	}
+
+	claims, err := c.chatService.AuthenticateToken(msg.Token)
+	if err != nil {
+		c.sendError("Authentication failed")
+		return false
+	}
+	if scope := missingScope(claims.Scopes); scope != "" {
+		c.sendError("Missing required scope: " + scope)
+		return false
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.signIn(claims)
+	return true
+}
+
+// signIn registers the client with the hub as the holder of claims and schedules the
+// connection to close when the token expires
+func (c *Client) signIn(claims *models.Claims) {
+	c.UserID = claims.UserID
+	c.Username = claims.Username
+	c.authenticated = true
+
+	blocked, err := c.chatService.GetBlockedUsernames(c.UserID)
+	if err != nil {
+		log.Printf("Failed to load block list of user %s: %v", c.UserID, err)
+	}
+	c.setBlocked(blocked)
+	c.hub.register <- c
+
+	// Set the expiry after registering so the hub never sees the timer of an unknown client
+	c.setToken(claims)
+}
+
+// setBlocked replaces the usernames whose broadcasts the client does not receive
+func (c *Client) setBlocked(usernames []string) {
+	blocked := make(map[string]bool, len(usernames))
+	for _, username := range usernames {
+		blocked[username] = true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.blocked = blocked
+}
+
+// hasBlocked reports whether the client's user blocked the given username
+func (c *Client) hasBlocked(username string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.blocked[username]
+}
+
+// setToken records the session and expiry of the token the client is authenticated with,
+// replacing any previous expiry timer
+func (c *Client) setToken(claims *models.Claims) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.session = claims.ID
+	c.expiresAt = time.Time{}
+	if claims.ExpiresAt != nil {
+		c.expiresAt = claims.ExpiresAt.Time
+	}
+
+	if c.expiryTimer != nil {
+		c.expiryTimer.Stop()
+		c.expiryTimer = nil
+	}
+	if !c.expiresAt.IsZero() {
+		c.expiryTimer = time.AfterFunc(time.Until(c.expiresAt), func() {
+			c.hub.expire <- c
+		})
+	}
+}
+
+// stopExpiry cancels the token expiry timer once the client has left the hub
+func (c *Client) stopExpiry() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.expiryTimer != nil {
+		c.expiryTimer.Stop()
+		c.expiryTimer = nil
+	}
+}
+
+// tokenExpired reports whether the token the client is authenticated with has expired
+func (c *Client) tokenExpired() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.expiresAt.IsZero() && !time.Now().Before(c.expiresAt)
+}
+
+// sessionID returns the session of the token the client is authenticated with
+func (c *Client) sessionID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.session
+}
+
+// setCloseFrame sets the close frame the write pump sends once the hub closes the send channel
+func (c *Client) setCloseFrame(code int, reason string) {
+	c.closeCode = code
+	c.closeReason = reason
+}
+
+// handleReauth replaces the token of the connection with a fresh one for the same user,
+// pushing back the time the connection is closed
+func (c *Client) handleReauth(msg IncomingMessage) {
+	claims, err := c.chatService.AuthenticateToken(msg.Token)
+	if err != nil || claims.UserID != c.UserID {
+		c.sendError("Reauthentication failed")
+		return
+	}
+	if scope := missingScope(claims.Scopes); scope != "" {
+		c.sendError("Missing required scope: " + scope)
+		return
+	}
+
+	c.setToken(claims)
+
+	response := map[string]interface{}{
+		"type":   "reauth",
+		"status": "ok",
+	}
+	if claims.ExpiresAt != nil {
+		response["expires_at"] = claims.ExpiresAt.Unix()
+	}
+	if data, err := json.Marshal(response); err == nil {
+		select {
+		case c.send <- data:
+		default:
+		}
+	}
+}
+
+// allowFrame counts a frame against the connection's frame limit. Throttled frames are
+// dropped with an error frame, and too many in a row close the connection.
+func (c *Client) allowFrame() bool {
+	if !c.settings.FrameLimit.Enabled() {
+		return true
+	}
+
+	now := time.Now()
+	if c.frames == nil {
+		c.frames = ratelimit.NewBucket(c.settings.FrameLimit, now)
+	}
+	result := c.frames.Take(c.settings.FrameLimit, now)
+	if result.Allowed {
+		c.throttled = 0
+		return true
+	}
+
+	c.throttled++
+	if c.settings.MaxThrottledFrames > 0 && c.throttled >= c.settings.MaxThrottledFrames {
+		log.Printf("WebSocket client exceeded the frame limit: user %s (%s)", c.Username, c.UserID)
+		c.hub.closeClient(c, websocket.ClosePolicyViolation, "rate limit exceeded")
+		return false
+	}
+	c.sendError(fmt.Sprintf("Rate limit exceeded, retry in %dms", result.RetryAfter.Milliseconds()))
+	return false
+}
+
+// sendError queues an error frame for the client
+func (c *Client) sendError(text string) {
+	data, err := json.Marshal(map[string]interface{}{
+		"type":  "error",
+		"error": text,
+	})
+	if err != nil {
+		return
+	}
+	select {
+	case c.send <- data:
+	default:
+	}
+}
+
+// missingScope returns the first scope required for WebSocket access that is not granted
+func missingScope(granted []string) string {
+	for _, scope := range requiredScopes {
+		if !auth.HasScope(granted, scope) {
+			return scope
+		}
+	}
+	return ""
+}
+
 // writePump pumps messages from the hub to the websocket connection
 func (c *Client) writePump() {
 	ticker := time.NewTicker(pingPeriod)
@@ -128,7 +433,11 @@ func (c *Client) writePump() {
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
 				// The hub closed the channel
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				closeMessage := []byte{}
+				if c.closeCode != 0 {
+					closeMessage = websocket.FormatCloseMessage(c.closeCode, c.closeReason)
+				}
+				c.conn.WriteMessage(websocket.CloseMessage, closeMessage)
 				return
 			}
 
@@ -166,16 +475,61 @@ func (c *Client) handleMessage(msg IncomingMessage) {
 		Content:   msg.Content,
 		Recipient: msg.Recipient,
 		RoomID:    msg.RoomID,
+		Format:    msg.Format,
+
+		AttachmentIDs: msg.AttachmentIDs,
+		TTLSeconds:    msg.TTLSeconds,
 	}
 
 	// Save message using chat service
 	savedMessage, err := c.chatService.SendMessage(messageReq)
 	if err != nil {
+		// Held messages wait for a moderator; tell the sender instead of reporting an error
+		var heldErr *services.MessageHeldError
+		if errors.As(err, &heldErr) {
+			response := map[string]interface{}{
+				"type":   "message_held",
+				"id":     heldErr.Held.ID,
+				"reason": heldErr.Held.Reason,
+			}
+			if data, err := json.Marshal(response); err == nil {
+				// A full buffer drops the frame; the hub closes the channel
+				select {
+				case c.send <- data:
+				default:
+				}
+			}
+			return
+		}
+
 		log.Printf("Error saving message: %v", err)
 		// Send error response to client
+		errorText := "Failed to save message"
+		var slowModeErr *services.SlowModeError
+		var mutedErr *services.MutedError
+		var rejectedErr *services.MessageRejectedError
+		isSlowMode := errors.As(err, &slowModeErr)
+		isMuted := errors.As(err, &mutedErr)
+		isRejected := errors.As(err, &rejectedErr)
+		if isSlowMode || isMuted || isRejected || errors.Is(err, services.ErrEmailNotVerified) ||
+			errors.Is(err, services.ErrRoomReadOnly) || errors.Is(err, services.ErrRoomNotFound) || errors.Is(err, services.ErrBlocked) ||
+			errors.Is(err, services.ErrInvalidAttachments) || errors.Is(err, services.ErrAttachmentsDisabled) ||
+			errors.Is(err, services.ErrInvalidMessageFormat) || errors.Is(err, services.ErrInvalidMessageTTL) {
+			errorText = err.Error()
+		}
 		errorResponse := map[string]interface{}{
 			"type":  "error",
-			"error": "Failed to save message",
+			"error": errorText,
+		}
+		if isSlowMode {
+			// Seconds until the member may post in the room again
+			errorResponse["retry_after"] = int(math.Ceil(slowModeErr.RetryAfter.Seconds()))
+		}
+		if isMuted && mutedErr.Until != nil {
+			errorResponse["muted_until"] = mutedErr.Until.Unix()
+		}
+		if isRejected {
+			errorResponse["filter"] = rejectedErr.Filter
 		}
 		if data, err := json.Marshal(errorResponse); err == nil {
 			select {
@@ -202,6 +556,33 @@ func (c *Client) handleMessage(msg IncomingMessage) {
 	}
 }
 
+// handleVote records a vote in a poll. The new tally reaches the room through a
+// "poll_updated" event; the voter also gets a "vote_recorded" frame with their choice.
+func (c *Client) handleVote(msg IncomingMessage) {
+	poll, err := c.chatService.Vote(c.UserID, msg.PollID, msg.Options)
+	if err != nil {
+		log.Printf("Error recording vote: %v", err)
+		errorText := "Failed to record vote"
+		if errors.Is(err, services.ErrPollNotFound) || errors.Is(err, services.ErrPollClosed) ||
+			errors.Is(err, services.ErrInvalidVote) || errors.Is(err, services.ErrPollsDisabled) {
+			errorText = err.Error()
+		}
+		c.sendError(errorText)
+		return
+	}
+
+	response := map[string]interface{}{
+		"type": "vote_recorded",
+		"poll": poll,
+	}
+	if data, err := json.Marshal(response); err == nil {
+		select {
+		case c.send <- data:
+		default:
+		}
+	}
+}
+
 // handlePing responds to ping messages
 func (c *Client) handlePing() {
 	response := map[string]interface{}{
@@ -217,9 +598,17 @@ func (c *Client) handlePing() {
 	}
 }
 
-// ServeWS handles websocket requests from the peer
-func ServeWS(hub *Hub, chatService *services.ChatService, w http.ResponseWriter, r *http.Request, userID, username string) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+// ServeWS handles websocket requests from the peer. A connection opened without claims
+// must authenticate by sending an "auth" frame before anything else.
+func ServeWS(hub *Hub, chatService *services.ChatService, settings Settings, w http.ResponseWriter, r *http.Request, claims *models.Claims) {
+	if claims != nil {
+		if scope := missingScope(claims.Scopes); scope != "" {
+			http.Error(w, "Missing required scope: "+scope, http.StatusForbidden)
+			return
+		}
+	}
+
+	conn, err := newUpgrader(settings.Origins).Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade error: %v", err)
 		return
@@ -229,12 +618,13 @@ func ServeWS(hub *Hub, chatService *services.ChatService, w http.ResponseWriter,
 		conn:        conn,
 		send:        make(chan []byte, 256),
 		hub:         hub,
-		UserID:      userID,
-		Username:    username,
 		chatService: chatService,
+		settings:    settings,
 	}
 
-	client.hub.register <- client
+	if claims != nil {
+		client.signIn(claims)
+	}
 
 	// Allow collection of memory referenced by the caller by doing all work in new goroutines
 	go client.writePump()
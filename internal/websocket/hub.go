@@ -7,13 +7,43 @@ import (
 	"sync"
 )
 
+const (
+	// CloseTokenExpired is the close code sent when the token a connection authenticated
+	// with expires without being refreshed by a "reauth" frame
+	CloseTokenExpired = 4001
+
+	// CloseSessionRevoked is the close code sent when a connection's session is ended by a
+	// logout, a password change or an administrator
+	CloseSessionRevoked = 4002
+)
+
+// disconnectRequest selects connections to close and the close frame they receive
+type disconnectRequest struct {
+	match  func(*Client) bool
+	code   int
+	reason string
+}
+
+// outbound is a broadcast frame along with the username of the message sender, so the
+// hub can skip clients that blocked the sender
+type outbound struct {
+	sender string
+	data   []byte
+}
+
+// blockListUpdate replaces the block list of every connection of a user
+type blockListUpdate struct {
+	userID    string
+	usernames []string
+}
+
 // Hub maintains the set of active clients and broadcasts messages to them
 type Hub struct {
 	// Registered clients
 	clients map[*Client]bool
 
 	// Inbound messages from the clients
-	broadcast chan []byte
+	broadcast chan outbound
 
 	// Register requests from the clients
 	register chan *Client
@@ -21,6 +51,15 @@ type Hub struct {
 	// Unregister requests from clients
 	unregister chan *Client
 
+	// Requests to close a set of connections
+	disconnect chan disconnectRequest
+
+	// Clients whose token expiry timer fired
+	expire chan *Client
+
+	// Block list changes made while the user is connected
+	blockLists chan blockListUpdate
+
 	// User ID to client mapping for direct messaging
 	userClients map[string]*Client
 
@@ -35,9 +74,12 @@ type Hub struct {
 func NewHub() *Hub {
 	return &Hub{
 		clients:         make(map[*Client]bool),
-		broadcast:       make(chan []byte),
+		broadcast:       make(chan outbound),
 		register:        make(chan *Client),
 		unregister:      make(chan *Client),
+		disconnect:      make(chan disconnectRequest),
+		expire:          make(chan *Client),
+		blockLists:      make(chan blockListUpdate),
 		userClients:     make(map[string]*Client),
 		usernameClients: make(map[string]*Client),
 	}
@@ -74,21 +116,43 @@ func (h *Hub) Run() {
 
 		case client := <-h.unregister:
 			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				h.mutex.Lock()
-				delete(h.userClients, client.UserID)
-				delete(h.usernameClients, client.Username)
-				h.mutex.Unlock()
-				close(client.send)
-
+				h.removeClient(client)
 				log.Printf("WebSocket client disconnected: user %s (%s)", client.Username, client.UserID)
 			}
 
+		case req := <-h.disconnect:
+			for client := range h.clients {
+				if !req.match(client) {
+					continue
+				}
+				client.setCloseFrame(req.code, req.reason)
+				h.removeClient(client)
+				log.Printf("WebSocket client disconnected by server: user %s (%s)", client.Username, client.UserID)
+			}
+
+		case client := <-h.expire:
+			// The client may have refreshed its token after the timer fired
+			if _, ok := h.clients[client]; ok && client.tokenExpired() {
+				client.setCloseFrame(CloseTokenExpired, "token expired")
+				h.removeClient(client)
+				log.Printf("WebSocket client token expired: user %s (%s)", client.Username, client.UserID)
+			}
+
+		case update := <-h.blockLists:
+			for client := range h.clients {
+				if client.UserID == update.userID {
+					client.setBlocked(update.usernames)
+				}
+			}
+
 		case message := <-h.broadcast:
-			// Broadcast message to all connected clients
+			// Broadcast message to all connected clients except those that blocked the sender
 			for client := range h.clients {
+				if client.hasBlocked(message.sender) {
+					continue
+				}
 				select {
-				case client.send <- message:
+				case client.send <- message.data:
 				default:
 					close(client.send)
 					delete(h.clients, client)
@@ -101,6 +165,60 @@ func (h *Hub) Run() {
 	}
 }
 
+// removeClient drops a registered client and closes its send channel, which makes its
+// write pump send the close frame and close the connection
+func (h *Hub) removeClient(client *Client) {
+	delete(h.clients, client)
+	h.mutex.Lock()
+	if h.userClients[client.UserID] == client {
+		delete(h.userClients, client.UserID)
+	}
+	if h.usernameClients[client.Username] == client {
+		delete(h.usernameClients, client.Username)
+	}
+	h.mutex.Unlock()
+	client.stopExpiry()
+	close(client.send)
+}
+
+// DisconnectUser closes every WebSocket connection of a user
+func (h *Hub) DisconnectUser(userID string) {
+	h.DisconnectUserSessions(userID, "")
+}
+
+// DisconnectUserSessions closes the WebSocket connections of a user except those opened
+// with exceptSessionID (which may be empty)
+func (h *Hub) DisconnectUserSessions(userID, exceptSessionID string) {
+	h.disconnect <- disconnectRequest{
+		match: func(c *Client) bool {
+			return c.UserID == userID && (exceptSessionID == "" || c.sessionID() != exceptSessionID)
+		},
+		code:   CloseSessionRevoked,
+		reason: "session revoked",
+	}
+}
+
+// closeClient closes a single connection with the given close frame
+func (h *Hub) closeClient(client *Client, code int, reason string) {
+	h.disconnect <- disconnectRequest{
+		match:  func(c *Client) bool { return c == client },
+		code:   code,
+		reason: reason,
+	}
+}
+
+// DisconnectSession closes the WebSocket connections opened with a session
+func (h *Hub) DisconnectSession(sessionID string) {
+	if sessionID == "" {
+		return
+	}
+	h.disconnect <- disconnectRequest{
+		match:  func(c *Client) bool { return c.sessionID() == sessionID },
+		code:   CloseSessionRevoked,
+		reason: "logged out",
+	}
+}
+
 // BroadcastMessage broadcasts a message to all connected clients
 func (h *Hub) BroadcastMessage(message *models.Message) {
 	data, err := json.Marshal(map[string]interface{}{
@@ -113,12 +231,73 @@ func (h *Hub) BroadcastMessage(message *models.Message) {
 	}
 
 	select {
-	case h.broadcast <- data:
+	case h.broadcast <- outbound{sender: message.Sender, data: data}:
 	default:
 		log.Println("Broadcast channel is full, dropping message")
 	}
 }
 
+// UpdateMessage sends a message_updated frame for a message that changed after it was
+// delivered, such as when its link previews are ready. Direct messages go to the two
+// participants; other messages are broadcast like new ones.
+func (h *Hub) UpdateMessage(message *models.Message) {
+	data, err := json.Marshal(map[string]interface{}{
+		"type":    "message_updated",
+		"message": message,
+	})
+	if err != nil {
+		log.Printf("Error marshaling message update: %v", err)
+		return
+	}
+	h.sendAboutMessage(message, data)
+}
+
+// ExpireMessage sends a message_expired frame for a disappearing message that was deleted,
+// so clients remove it. It reaches the same clients as UpdateMessage.
+func (h *Hub) ExpireMessage(message *models.Message) {
+	event := map[string]interface{}{
+		"type":       "message_expired",
+		"message_id": message.ID,
+	}
+	if message.RoomID != "" {
+		event["room_id"] = message.RoomID
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error marshaling message expiry: %v", err)
+		return
+	}
+	h.sendAboutMessage(message, data)
+}
+
+// sendAboutMessage sends a frame about a delivered message to the participants of a direct
+// message, or broadcasts it for other messages
+func (h *Hub) sendAboutMessage(message *models.Message, data []byte) {
+	if message.Recipient != "" {
+		for _, username := range []string{message.Sender, message.Recipient} {
+			h.mutex.RLock()
+			client, exists := h.usernameClients[username]
+			h.mutex.RUnlock()
+			if exists {
+				h.send(client, data)
+			}
+		}
+		return
+	}
+
+	select {
+	case h.broadcast <- outbound{sender: message.Sender, data: data}:
+	default:
+		log.Println("Broadcast channel is full, dropping message frame")
+	}
+}
+
+// SetBlockedUsers replaces the block list of a user's connections after it changed, so
+// broadcasts from newly blocked users stop and those from unblocked users resume
+func (h *Hub) SetBlockedUsers(userID string, usernames []string) {
+	h.blockLists <- blockListUpdate{userID: userID, usernames: usernames}
+}
+
 // SendToUser sends a message to a specific user by UserID
 func (h *Hub) SendToUser(userID string, message *models.Message) bool {
 	h.mutex.RLock()
@@ -138,6 +317,11 @@ func (h *Hub) SendToUser(userID string, message *models.Message) bool {
 		return false
 	}
 
+	return h.send(client, data)
+}
+
+// send queues a frame on a client, removing the client when its send channel is full
+func (h *Hub) send(client *Client, data []byte) bool {
 	select {
 	case client.send <- data:
 		return true
@@ -177,6 +361,45 @@ func (h *Hub) SendToUsername(username string, message *models.Message) bool {
 	}
 }
 
+// SendEvent sends an event frame, such as a report notification, to the given users
+func (h *Hub) SendEvent(userIDs []string, event map[string]interface{}) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error marshaling event: %v", err)
+		return
+	}
+
+	for _, userID := range userIDs {
+		h.mutex.RLock()
+		client, exists := h.userClients[userID]
+		h.mutex.RUnlock()
+		if !exists {
+			continue
+		}
+
+		select {
+		case client.send <- data:
+		default:
+			// Client's send channel is full, remove the client
+			h.unregister <- client
+		}
+	}
+}
+
+// DeliverMessage delivers a new message the way it was addressed: to its room, to the
+// two participants of a direct message, or to everyone
+func (h *Hub) DeliverMessage(message *models.Message) {
+	switch {
+	case message.RoomID != "":
+		h.SendToRoom(message.RoomID, message)
+	case message.Recipient != "":
+		h.SendToUsername(message.Recipient, message)
+		h.SendToUsername(message.Sender, message)
+	default:
+		h.BroadcastMessage(message)
+	}
+}
+
 // SendToRoom sends a message to all users in a specific room
 func (h *Hub) SendToRoom(roomID string, message *models.Message) {
 	// For now, we'll broadcast to all clients
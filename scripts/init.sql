@@ -5,10 +5,16 @@
 CREATE TABLE IF NOT EXISTS users (
     id VARCHAR(255) PRIMARY KEY,
     username VARCHAR(255) UNIQUE NOT NULL,
-    email VARCHAR(255) UNIQUE NOT NULL,
+    email VARCHAR(255) UNIQUE,
     password_hash VARCHAR(255) NOT NULL,
     is_online BOOLEAN DEFAULT false,
-    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+    verified BOOLEAN NOT NULL DEFAULT false,
+    role VARCHAR(20) NOT NULL DEFAULT 'user',
+    is_bot BOOLEAN NOT NULL DEFAULT false,
+    owner_id VARCHAR(255) REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    suspended_at TIMESTAMP WITH TIME ZONE,
+    suspension_reason TEXT
 );
 
 -- Create chat_rooms table
@@ -16,7 +22,10 @@ CREATE TABLE IF NOT EXISTS chat_rooms (
     id VARCHAR(255) PRIMARY KEY,
     name VARCHAR(255) NOT NULL,
     description TEXT,
-    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    slow_mode_seconds INTEGER NOT NULL DEFAULT 0,
+    read_only BOOLEAN NOT NULL DEFAULT false,
+    message_ttl_seconds INTEGER NOT NULL DEFAULT 0
 );
 
 -- Create room_members table (many-to-many relationship between rooms and users)
@@ -27,6 +36,25 @@ CREATE TABLE IF NOT EXISTS room_members (
     PRIMARY KEY (room_id, user_id)
 );
 
+-- Create room_mutes table (members muted in a room, until expires_at or indefinitely when NULL)
+CREATE TABLE IF NOT EXISTS room_mutes (
+    room_id VARCHAR(255) REFERENCES chat_rooms(id) ON DELETE CASCADE,
+    user_id VARCHAR(255) REFERENCES users(id) ON DELETE CASCADE,
+    muted_by VARCHAR(255) REFERENCES users(id) ON DELETE SET NULL,
+    reason TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (room_id, user_id)
+);
+
+-- Create user_blocks table (users hidden from and unable to contact the blocker)
+CREATE TABLE IF NOT EXISTS user_blocks (
+    user_id VARCHAR(255) REFERENCES users(id) ON DELETE CASCADE,
+    blocked_id VARCHAR(255) REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (user_id, blocked_id)
+);
+
 -- Create messages table
 CREATE TABLE IF NOT EXISTS messages (
     id VARCHAR(255) PRIMARY KEY,
@@ -34,7 +62,264 @@ CREATE TABLE IF NOT EXISTS messages (
     recipient VARCHAR(255) REFERENCES users(username),
     content TEXT NOT NULL,
     timestamp TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
-    room_id VARCHAR(255) REFERENCES chat_rooms(id)
+    room_id VARCHAR(255) REFERENCES chat_rooms(id),
+    format VARCHAR(16) NOT NULL DEFAULT 'plain',
+    content_html TEXT,
+    expires_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Create room_moderation table (per-room content filter settings)
+CREATE TABLE IF NOT EXISTS room_moderation (
+    room_id VARCHAR(255) PRIMARY KEY REFERENCES chat_rooms(id) ON DELETE CASCADE,
+    disabled_filters TEXT[] NOT NULL DEFAULT '{}',
+    blocked_words TEXT[] NOT NULL DEFAULT '{}',
+    blocked_patterns TEXT[] NOT NULL DEFAULT '{}',
+    blocked_domains TEXT[] NOT NULL DEFAULT '{}',
+    actions JSONB,
+    updated_by VARCHAR(255) REFERENCES users(id) ON DELETE SET NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Create held_messages table (messages held by a content filter until a moderator reviews them)
+CREATE TABLE IF NOT EXISTS held_messages (
+    id VARCHAR(255) PRIMARY KEY,
+    sender VARCHAR(255) NOT NULL REFERENCES users(username) ON DELETE CASCADE,
+    recipient VARCHAR(255) REFERENCES users(username) ON DELETE CASCADE,
+    room_id VARCHAR(255) REFERENCES chat_rooms(id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    format VARCHAR(16) NOT NULL DEFAULT 'plain',
+    ttl_seconds INTEGER NOT NULL DEFAULT 0,
+    attachment_ids TEXT[] NOT NULL DEFAULT '{}',
+    filter VARCHAR(100) NOT NULL,
+    reason TEXT,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    reviewed_by VARCHAR(255) REFERENCES users(id) ON DELETE SET NULL,
+    reviewed_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Create reports table (user reports about messages and users, reviewed by moderators)
+CREATE TABLE IF NOT EXISTS reports (
+    id VARCHAR(255) PRIMARY KEY,
+    reporter_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    message_id VARCHAR(255),
+    message_content TEXT,
+    reported_user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    room_id VARCHAR(255) REFERENCES chat_rooms(id) ON DELETE CASCADE,
+    reason TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'open',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    action VARCHAR(50),
+    note TEXT,
+    resolved_by VARCHAR(255) REFERENCES users(id) ON DELETE SET NULL,
+    resolved_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Create attachments table (uploaded files; the content is kept in the blob store)
+CREATE TABLE IF NOT EXISTS attachments (
+    id VARCHAR(255) PRIMARY KEY,
+    uploader_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    message_id VARCHAR(255) REFERENCES messages(id) ON DELETE CASCADE,
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(255) NOT NULL,
+    size BIGINT NOT NULL,
+    width INTEGER NOT NULL DEFAULT 0,
+    height INTEGER NOT NULL DEFAULT 0,
+    has_thumbnail BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Create link_previews table (page metadata cached by URL for link previews)
+CREATE TABLE IF NOT EXISTS link_previews (
+    url TEXT PRIMARY KEY,
+    title TEXT,
+    description TEXT,
+    site_name TEXT,
+    image_url TEXT,
+    fetched_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- Create message_previews table (the link previews shown with each message)
+CREATE TABLE IF NOT EXISTS message_previews (
+    message_id VARCHAR(255) REFERENCES messages(id) ON DELETE CASCADE,
+    url TEXT REFERENCES link_previews(url) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    PRIMARY KEY (message_id, url)
+);
+
+-- Create mentions table (users notified by @username, @room and @here mentions)
+CREATE TABLE IF NOT EXISTS mentions (
+    id VARCHAR(255) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    message_id VARCHAR(255) NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    kind VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    read_at TIMESTAMP WITH TIME ZONE,
+    UNIQUE (message_id, user_id)
+);
+
+-- Create pinned messages table (messages pinned to the top of a room by moderators)
+CREATE TABLE IF NOT EXISTS pinned_messages (
+    room_id VARCHAR(255) REFERENCES chat_rooms(id) ON DELETE CASCADE,
+    message_id VARCHAR(255) REFERENCES messages(id) ON DELETE CASCADE,
+    pinned_by VARCHAR(255) REFERENCES users(id) ON DELETE SET NULL,
+    pinned_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (room_id, message_id)
+);
+
+-- Create saved messages table (private per-user bookmarks with notes)
+CREATE TABLE IF NOT EXISTS saved_messages (
+    user_id VARCHAR(255) REFERENCES users(id) ON DELETE CASCADE,
+    message_id VARCHAR(255) REFERENCES messages(id) ON DELETE CASCADE,
+    note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (user_id, message_id)
+);
+
+-- Create scheduled messages table (messages sent later by the scheduler)
+CREATE TABLE IF NOT EXISTS scheduled_messages (
+    id VARCHAR(255) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    recipient VARCHAR(255),
+    room_id VARCHAR(255) REFERENCES chat_rooms(id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    format VARCHAR(20) NOT NULL DEFAULT 'plain',
+    send_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    message_id VARCHAR(255),
+    error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    claimed_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Create reminders table (personal reminders of messages, fired by the scheduler)
+CREATE TABLE IF NOT EXISTS reminders (
+    id VARCHAR(255) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    message_id VARCHAR(255) NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    note TEXT,
+    remind_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    claimed_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Create polls table (poll messages posted in rooms; options are listed in order)
+CREATE TABLE IF NOT EXISTS polls (
+    id VARCHAR(255) PRIMARY KEY,
+    message_id VARCHAR(255) NOT NULL UNIQUE REFERENCES messages(id) ON DELETE CASCADE,
+    room_id VARCHAR(255) NOT NULL REFERENCES chat_rooms(id) ON DELETE CASCADE,
+    created_by VARCHAR(255) REFERENCES users(id) ON DELETE SET NULL,
+    question TEXT NOT NULL,
+    options TEXT[] NOT NULL,
+    multiple_choice BOOLEAN NOT NULL DEFAULT FALSE,
+    anonymous BOOLEAN NOT NULL DEFAULT FALSE,
+    closes_at TIMESTAMP WITH TIME ZONE,
+    closed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Create poll_votes table (one row per option chosen by a voter)
+CREATE TABLE IF NOT EXISTS poll_votes (
+    poll_id VARCHAR(255) NOT NULL REFERENCES polls(id) ON DELETE CASCADE,
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    option_index INTEGER NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (poll_id, user_id, option_index)
+);
+
+-- Create sessions table (issued authentication tokens, used for revocation)
+CREATE TABLE IF NOT EXISTS sessions (
+    id VARCHAR(255) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Create user_tokens table (hashed single-use tokens such as password resets)
+CREATE TABLE IF NOT EXISTS user_tokens (
+    token_hash VARCHAR(64) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Create ws_tickets table (hashed single-use WebSocket connection tickets)
+CREATE TABLE IF NOT EXISTS ws_tickets (
+    ticket_hash VARCHAR(64) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    username VARCHAR(255) NOT NULL,
+    role VARCHAR(20) NOT NULL DEFAULT '',
+    session_id VARCHAR(255),
+    api_key_id VARCHAR(255),
+    scopes TEXT[] NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- Create login_throttles table (failed login counters per username and client IP)
+CREATE TABLE IF NOT EXISTS login_throttles (
+    key VARCHAR(255) PRIMARY KEY,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    locked_until TIMESTAMP WITH TIME ZONE
+);
+
+-- Create audit_events table (append-only, hash-chained security-relevant events)
+CREATE TABLE IF NOT EXISTS audit_events (
+    id VARCHAR(255) PRIMARY KEY,
+    action VARCHAR(100) NOT NULL,
+    actor_id VARCHAR(255),
+    target_id VARCHAR(255),
+    ip VARCHAR(64),
+    metadata JSONB,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    seq BIGINT UNIQUE,
+    prev_hash VARCHAR(64),
+    hash VARCHAR(64)
+);
+
+-- The audit trail is append-only: updates, deletes and truncation are rejected
+CREATE OR REPLACE FUNCTION reject_audit_event_change() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION reject_audit_event_change();
+
+DROP TRIGGER IF EXISTS audit_events_no_truncate ON audit_events;
+CREATE TRIGGER audit_events_no_truncate BEFORE TRUNCATE ON audit_events
+    FOR EACH STATEMENT EXECUTE FUNCTION reject_audit_event_change();
+
+-- Create user_identities table (links to external identity provider accounts)
+CREATE TABLE IF NOT EXISTS user_identities (
+    provider VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (provider, subject)
+);
+
+-- Create api_keys table (scoped keys for integrations; only the key hash is stored)
+CREATE TABLE IF NOT EXISTS api_keys (
+    id VARCHAR(255) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    prefix VARCHAR(32) NOT NULL,
+    key_hash VARCHAR(64) UNIQUE NOT NULL,
+    scopes TEXT[] NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE
 );
 
 -- Create indexes for better performance
@@ -42,10 +327,29 @@ CREATE INDEX IF NOT EXISTS idx_messages_sender ON messages(sender);
 CREATE INDEX IF NOT EXISTS idx_messages_recipient ON messages(recipient);
 CREATE INDEX IF NOT EXISTS idx_messages_room_id ON messages(room_id);
 CREATE INDEX IF NOT EXISTS idx_messages_timestamp ON messages(timestamp);
+CREATE INDEX IF NOT EXISTS idx_messages_room_sender ON messages(room_id, sender, timestamp);
 CREATE INDEX IF NOT EXISTS idx_users_username ON users(username);
 CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
 CREATE INDEX IF NOT EXISTS idx_room_members_room_id ON room_members(room_id);
 CREATE INDEX IF NOT EXISTS idx_room_members_user_id ON room_members(user_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_user_tokens_user_id ON user_tokens(user_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events(created_at);
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities(user_id);
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys(user_id);
+CREATE INDEX IF NOT EXISTS idx_users_owner_id ON users(owner_id);
+CREATE INDEX IF NOT EXISTS idx_ws_tickets_expires_at ON ws_tickets(expires_at);
+CREATE INDEX IF NOT EXISTS idx_held_messages_status ON held_messages(status, created_at);
+CREATE INDEX IF NOT EXISTS idx_messages_expires_at ON messages(expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_reports_status ON reports(status, created_at);
+CREATE INDEX IF NOT EXISTS idx_attachments_message_id ON attachments(message_id);
+CREATE INDEX IF NOT EXISTS idx_mentions_unread ON mentions(user_id, created_at) WHERE read_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_saved_messages_user_id ON saved_messages(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_scheduled_messages_due ON scheduled_messages(status, send_at);
+CREATE INDEX IF NOT EXISTS idx_scheduled_messages_user_id ON scheduled_messages(user_id, send_at);
+CREATE INDEX IF NOT EXISTS idx_reminders_due ON reminders(status, remind_at);
+CREATE INDEX IF NOT EXISTS idx_reminders_user_id ON reminders(user_id, remind_at);
+CREATE INDEX IF NOT EXISTS idx_poll_votes_user_id ON poll_votes(user_id);
 
 -- Insert some sample data (optional)
 -- INSERT INTO users (id, username, email, password_hash, is_online, created_at) VALUES